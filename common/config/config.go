@@ -28,7 +28,7 @@ var GeminiUploadImageEnabled = false
 var HttpProxy = ""
 var QuotaForAddChannel = 0
 
-// random, polling, limit
+// random, weighted, round_robin (polling), least_inflight, lowest_latency, lowest_cost
 var PoolMode = ""
 
 // Any options with "Secret", "Token" in its key won't be return by GetOptions
@@ -99,6 +99,9 @@ var WeChatAccountQRCodeImageURL = ""
 var MessagePusherAddress = ""
 var MessagePusherToken = ""
 
+// an alert with the same event and subject is sent once in NotifyDedupSeconds
+var NotifyDedupSeconds = 300
+
 var TurnstileSiteKey = ""
 var TurnstileSecretKey = ""
 
@@ -113,6 +116,14 @@ var PreConsumedQuota int64 = 500
 var ApproximateTokenEnabled = false
 var RetryTimes = 0
 
+// circuit breaker of every channel and model: it opens after CircuitBreakerThreshold failures within CircuitBreakerWindowSeconds,
+// stays open for CircuitBreakerOpenSeconds, then lets CircuitBreakerHalfOpenProbes requests through and closes if they all succeed
+var CircuitBreakerEnabled = true
+var CircuitBreakerThreshold = 5
+var CircuitBreakerWindowSeconds = 60
+var CircuitBreakerOpenSeconds = 60
+var CircuitBreakerHalfOpenProbes = 1
+
 var RootUserEmail = ""
 
 var IsMasterNode = os.Getenv("NODE_TYPE") != "slave"
@@ -123,6 +134,10 @@ var RequestInterval = time.Duration(requestInterval) * time.Second
 var SyncFrequency = env.Int("SYNC_FREQUENCY", 10*60) // unit is second
 var ErrorCacheTimeout = env.Int("ERROR_CACHE_TIMEOUT", 600)
 
+// channel model sleep, the sleep time doubles every time until the max, the count restarts after the reset window
+var ChannelSleepMaxSeconds = env.Int("CHANNEL_SLEEP_MAX_SECONDS", 6*60*60)
+var ChannelSleepResetSeconds = env.Int("CHANNEL_SLEEP_RESET_SECONDS", 24*60*60)
+
 var BatchUpdateEnabled = false
 var BatchUpdateInterval = env.Int("BATCH_UPDATE_INTERVAL", 5)
 
@@ -164,12 +179,6 @@ var (
 
 var RateLimitKeyExpirationDuration = 20 * time.Minute
 
-var EnableMetric = env.Bool("ENABLE_METRIC", false)
-var MetricQueueSize = env.Int("METRIC_QUEUE_SIZE", 10)
-var MetricSuccessRateThreshold = env.Float64("METRIC_SUCCESS_RATE_THRESHOLD", 0.8)
-var MetricSuccessChanSize = env.Int("METRIC_SUCCESS_CHAN_SIZE", 1024)
-var MetricFailChanSize = env.Int("METRIC_FAIL_CHAN_SIZE", 128)
-
 var InitialRootToken = os.Getenv("INITIAL_ROOT_TOKEN")
 
 var InitialRootAccessToken = os.Getenv("INITIAL_ROOT_ACCESS_TOKEN")
@@ -183,3 +192,34 @@ var UserContentRequestProxy = env.String("USER_CONTENT_REQUEST_PROXY", "")
 var UserContentRequestTimeout = env.Int("USER_CONTENT_REQUEST_TIMEOUT", 30)
 
 var EnforceIncludeUsage = env.Bool("ENFORCE_INCLUDE_USAGE", false)
+
+// BatchRatio is the discount applied to the requests of /v1/batches
+var BatchRatio = 0.5
+var BatchWorkerCount = env.Int("BATCH_WORKER_COUNT", 4)
+var FileMaxSize = env.Int("FILE_MAX_SIZE", 100) // unit is MB
+
+// response cache of chat completions, completions and embeddings, hits are billed with CacheRatio
+var ResponseCacheEnabled = false
+var ResponseCacheTTL = 3600 // unit is second, overridden by the token or the group
+var CacheRatio = 0.1
+var ResponseCacheMaxSize = env.Int("RESPONSE_CACHE_MAX_SIZE", 1024) // unit is KB, larger responses are not cached
+
+// QuotaReservationTimeout is how long a quota reservation may stay open, the sweeper settles older ones at the reserved quota
+var QuotaReservationTimeout = env.Int("QUOTA_RESERVATION_TIMEOUT", 1800) // unit is second
+
+// observability, /metrics is served when MetricsEnabled, spans are exported to OTEL_EXPORTER_OTLP_ENDPOINT when TracingEnabled
+var MetricsEnabled = env.Bool("METRICS_ENABLED", false)
+var MetricsToken = env.String("METRICS_TOKEN", "") // bearer token required by /metrics if set
+var TracingEnabled = env.Bool("TRACING_ENABLED", false)
+
+// audit capture of the requests and responses, enabled per token or per group
+var AuditStorage = env.String("AUDIT_STORAGE", "db") // db saves the captures into LOG_DB, file saves them under AuditDir
+var AuditDir = env.String("AUDIT_DIR", "./audit")
+var AuditMaxSize = env.Int("AUDIT_MAX_SIZE", 1024) // unit is KB, the request and the response are truncated beyond it
+var AuditRetentionDays = 30
+
+// input moderation of the tokens with ModerationsEnable, the user content is sent to the /v1/moderations of ModerationChannelId
+var ModerationChannelId = 0 // 0 disables the moderation
+var ModerationModel = "omni-moderation-latest"
+var ModerationAction = "block"  // block rejects flagged requests, flag only records them
+var ModerationMaxViolations = 0 // the token is disabled after so many violations, 0 never disables it
@@ -29,4 +29,20 @@ const (
 	CustomContact     = "custom_contact"
 	ModerationsEnable = "moderations_enable"
 	RequestStartTime  = "request_start_time"
+	DiscountRatio     = "discount_ratio"
+	FirstResponseTime = "first_response_time"
+	CacheDisabled     = "cache_disabled"
+	CacheTTL          = "cache_ttl"
+	CacheHit          = "cache_hit"
+	AuditEnabled      = "audit_enabled"
+	AuditRequest      = "audit_request"
+	ModerationVerdict = "moderation_verdict"
+	OutputFilter      = "output_filter"
+	FallbackFrom      = "fallback_from"     // the model requested by the user
+	FallbackModel     = "fallback_model"    // the substitute model which is served
+	ChannelKeyId      = "channel_key_id"    // the key of a multi key channel, 0 for a single key channel
+	ChannelKeyIndex   = "channel_key_index" // the position of the key in its channel
+	OrgId             = "org_id"            // the organization of the token, 0 when the user pays
+	ChannelTpmLimit   = "channel_tpm"
+	ChannelDpmLimit   = "channel_dpm"
 )
@@ -0,0 +1,237 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+// 告警事件的类型，通知目标按事件类型路由
+const (
+	EventChannelDisabled = "channel_disabled"
+	EventChannelEnabled  = "channel_enabled"
+	EventChannelError    = "channel_error"
+	EventChannelTest     = "channel_test"
+	EventTokenAlert      = "token_alert"
+	EventBudgetWarning   = "budget_warning"
+	EventTest            = "test"
+)
+
+// 通知目标的类型
+const (
+	NotifierWebhook       = "webhook"
+	NotifierSlack         = "slack"
+	NotifierFeishu        = "feishu"
+	NotifierDingTalk      = "dingtalk"
+	NotifierTelegram      = "telegram"
+	NotifierEmail         = "email"
+	NotifierMessagePusher = "message_pusher"
+)
+
+// Notification is an alert sent to the notifiers, Key deduplicates it and defaults to the title
+type Notification struct {
+	Event     string `json:"event"`
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	Data      any    `json:"data,omitempty"`
+	Key       string `json:"-"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type Notifier interface {
+	Send(notification *Notification) error
+}
+
+// NotifyTarget is a configured notification channel and the events routed to it
+type NotifyTarget struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	URL      string   `json:"url,omitempty"`     // the webhook of the bot, or the api server of telegram
+	Secret   string   `json:"secret,omitempty"`  // the signing secret, or the token of the telegram bot
+	ChatId   string   `json:"chat_id,omitempty"` // telegram only
+	Email    string   `json:"email,omitempty"`   // email only, defaults to the root user
+	Events   []string `json:"events,omitempty"`  // empty or "*" receives all the events
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+func (target *NotifyTarget) accepts(event string) bool {
+	if target.Disabled {
+		return false
+	}
+	if len(target.Events) == 0 {
+		return true
+	}
+	for _, e := range target.Events {
+		if e == "*" || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// NewNotifier returns the notifier of the target
+func NewNotifier(target *NotifyTarget) (Notifier, error) {
+	switch target.Type {
+	case NotifierWebhook:
+		return &WebhookNotifier{URL: target.URL, Secret: target.Secret}, nil
+	case NotifierSlack:
+		return &SlackNotifier{URL: target.URL}, nil
+	case NotifierFeishu:
+		return &FeishuNotifier{URL: target.URL, Secret: target.Secret}, nil
+	case NotifierDingTalk:
+		return &DingTalkNotifier{URL: target.URL, Secret: target.Secret}, nil
+	case NotifierTelegram:
+		return &TelegramNotifier{APIServer: target.URL, BotToken: target.Secret, ChatId: target.ChatId}, nil
+	case NotifierEmail:
+		return &EmailNotifier{Email: target.Email}, nil
+	case NotifierMessagePusher:
+		return &MessagePusherNotifier{}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type: %s", target.Type)
+}
+
+var notifyTargets []*NotifyTarget
+var notifyTargetsLock sync.RWMutex
+
+func NotifyTargets2JSONString() string {
+	notifyTargetsLock.RLock()
+	defer notifyTargetsLock.RUnlock()
+	jsonBytes, err := json.Marshal(notifyTargets)
+	if err != nil {
+		logger.SysError("error marshalling notify targets: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// ParseNotifyTargets parses the json array of the notify targets
+func ParseNotifyTargets(jsonStr string) ([]*NotifyTarget, error) {
+	var targets []*NotifyTarget
+	if jsonStr != "" {
+		if err := json.Unmarshal([]byte(jsonStr), &targets); err != nil {
+			return nil, err
+		}
+	}
+	for _, target := range targets {
+		if _, err := NewNotifier(target); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+func UpdateNotifyTargetsByJSONString(jsonStr string) error {
+	targets, err := ParseNotifyTargets(jsonStr)
+	if err != nil {
+		return err
+	}
+	notifyTargetsLock.Lock()
+	defer notifyTargetsLock.Unlock()
+	notifyTargets = targets
+	return nil
+}
+
+// GetNotifyTarget returns the configured target with the name, nil when there is none
+func GetNotifyTarget(name string) *NotifyTarget {
+	notifyTargetsLock.RLock()
+	defer notifyTargetsLock.RUnlock()
+	for _, target := range notifyTargets {
+		if target.Name == name {
+			return target
+		}
+	}
+	return nil
+}
+
+func getRoutedTargets(event string) []*NotifyTarget {
+	notifyTargetsLock.RLock()
+	defer notifyTargetsLock.RUnlock()
+	var targets []*NotifyTarget
+	for _, target := range notifyTargets {
+		if target.accepts(event) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+var recentNotifications = make(map[string]int64)
+var recentNotificationsLock sync.Mutex
+
+// allowNotification reports whether the notification has not been sent in the last NotifyDedupSeconds,
+// so that a flapping channel sends one alert per window
+func allowNotification(notification *Notification) bool {
+	if config.NotifyDedupSeconds <= 0 {
+		return true
+	}
+	key := notification.Key
+	if key == "" {
+		key = notification.Title
+	}
+	key = fmt.Sprintf("notify:%s:%s", notification.Event, random.StrToMd5(key))
+	window := time.Duration(config.NotifyDedupSeconds) * time.Second
+	if common.RedisEnabled {
+		ok, err := common.RedisSetNx(key, "1", window)
+		return ok || err != nil
+	}
+	now := time.Now().Unix()
+	recentNotificationsLock.Lock()
+	defer recentNotificationsLock.Unlock()
+	if now < recentNotifications[key] {
+		return false
+	}
+	for k, expiresAt := range recentNotifications {
+		if expiresAt <= now {
+			delete(recentNotifications, k)
+		}
+	}
+	recentNotifications[key] = now + int64(config.NotifyDedupSeconds)
+	return true
+}
+
+// SendNotification sends the notification to the target right away, without routing nor deduplication
+func SendNotification(target *NotifyTarget, notification *Notification) error {
+	notifier, err := NewNotifier(target)
+	if err != nil {
+		return err
+	}
+	if notification.Timestamp == 0 {
+		notification.Timestamp = time.Now().Unix()
+	}
+	return notifier.Send(notification)
+}
+
+// Dispatch sends the notification asynchronously to the targets its event is routed to,
+// it returns false when no target receives the event
+func Dispatch(notification *Notification) bool {
+	targets := getRoutedTargets(notification.Event)
+	if len(targets) == 0 {
+		return false
+	}
+	if !allowNotification(notification) {
+		return true
+	}
+	if notification.Timestamp == 0 {
+		notification.Timestamp = time.Now().Unix()
+	}
+	for _, target := range targets {
+		go func(target *NotifyTarget) {
+			if err := SendNotification(target, notification); err != nil {
+				logger.SysError(fmt.Sprintf("failed to send %s notification to %s: %s", notification.Event, target.Name, err.Error()))
+			}
+		}(target)
+	}
+	return true
+}
+
+// NotifyAdmin dispatches an alert for the admins, without a routed target it is sent by email or message pusher as before
+func NotifyAdmin(event string, title string, content string, data any) {
+	if !Dispatch(&Notification{Event: event, Title: title, Content: content, Data: data}) {
+		SendMailToAdmin(title, content)
+	}
+}
@@ -0,0 +1,92 @@
+package message
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestWebhookNotifierSignature(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	err := SendNotification(&NotifyTarget{Type: NotifierWebhook, URL: server.URL, Secret: "secret"},
+		&Notification{Event: EventChannelDisabled, Title: "title", Content: "content"})
+	assert.Nil(t, err)
+	expected := hex.EncodeToString(hmacSHA256("secret", header.Get("X-Timestamp")+"."+string(body)))
+	assert.Equal(t, expected, header.Get("X-Signature"))
+	var notification Notification
+	assert.Nil(t, json.Unmarshal(body, &notification))
+	assert.Equal(t, EventChannelDisabled, notification.Event)
+	assert.NotZero(t, notification.Timestamp)
+}
+
+func TestDingTalkNotifier(t *testing.T) {
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		_, _ = w.Write([]byte(`{"errcode":310000,"errmsg":"sign not match"}`))
+	}))
+	defer server.Close()
+
+	err := SendNotification(&NotifyTarget{Type: NotifierDingTalk, URL: server.URL + "?access_token=a", Secret: "secret"}, &Notification{Title: "title"})
+	assert.ErrorContains(t, err, "sign not match")
+	assert.Equal(t, "a", query["access_token"][0])
+	assert.NotEmpty(t, query["sign"])
+	assert.NotEmpty(t, query["timestamp"])
+}
+
+func TestDispatch(t *testing.T) {
+	var lock sync.Mutex
+	received := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		received[r.URL.Path]++
+	}))
+	defer server.Close()
+	defer func() { _ = UpdateNotifyTargetsByJSONString("") }()
+
+	assert.NotNil(t, UpdateNotifyTargetsByJSONString(`[{"name":"a","type":"pager"}]`))
+	targets := []*NotifyTarget{
+		{Name: "channels", Type: NotifierWebhook, URL: server.URL + "/channels", Events: []string{EventChannelDisabled}},
+		{Name: "all", Type: NotifierSlack, URL: server.URL + "/all"},
+		{Name: "off", Type: NotifierSlack, URL: server.URL + "/off", Disabled: true},
+	}
+	data, _ := json.Marshal(targets)
+	assert.Nil(t, UpdateNotifyTargetsByJSONString(string(data)))
+	assert.Equal(t, "all", GetNotifyTarget("all").Name)
+
+	common.RedisEnabled = false
+	config.NotifyDedupSeconds = 300
+	// the second alert of a flapping channel is deduplicated
+	assert.True(t, Dispatch(&Notification{Event: EventChannelDisabled, Title: "channel #1 disabled"}))
+	assert.True(t, Dispatch(&Notification{Event: EventChannelDisabled, Title: "channel #1 disabled"}))
+	assert.True(t, Dispatch(&Notification{Event: EventTokenAlert, Title: "token #1"}))
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return received["/channels"] == 1 && received["/all"] == 2
+	}, time.Second, 10*time.Millisecond)
+	lock.Lock()
+	assert.Zero(t, received["/off"])
+	lock.Unlock()
+
+	assert.Nil(t, UpdateNotifyTargetsByJSONString(""))
+	assert.False(t, Dispatch(&Notification{Event: EventTokenAlert, Title: "token #2"}))
+}
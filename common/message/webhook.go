@@ -0,0 +1,245 @@
+package message
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// types of the webhook of a token
+const (
+	WebhookTypeJSON     = 1 // POST {"event": ..., "title": ..., "content": ..., "data": ..., "timestamp": ...}
+	WebhookTypeSlack    = 2
+	WebhookTypeFeishu   = 3
+	WebhookTypeDingTalk = 4
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func postJSON(url string, body any, header map[string]string) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
+
+func hmacSHA256(key string, message string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func plainText(notification *Notification) string {
+	return notification.Title + "\n" + notification.Content
+}
+
+// WebhookNotifier posts the notification as json, with a secret the body is signed:
+// X-Signature is the hex HMAC-SHA256 of "<X-Timestamp>.<body>"
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+}
+
+func (n *WebhookNotifier) Send(notification *Notification) error {
+	if n.URL == "" {
+		return errors.New("webhook is empty")
+	}
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	header := map[string]string{}
+	if n.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		header["X-Timestamp"] = timestamp
+		header["X-Signature"] = hex.EncodeToString(hmacSHA256(n.Secret, timestamp+"."+string(body)))
+	}
+	_, err = postJSON(n.URL, json.RawMessage(body), header)
+	return err
+}
+
+type SlackNotifier struct {
+	URL string
+}
+
+func (n *SlackNotifier) Send(notification *Notification) error {
+	_, err := postJSON(n.URL, map[string]any{
+		"text": fmt.Sprintf("*%s*\n%s", notification.Title, notification.Content),
+	}, nil)
+	return err
+}
+
+// FeishuNotifier sends a text message by the custom bot of Feishu/Lark, the secret enables the signature check
+type FeishuNotifier struct {
+	URL    string
+	Secret string
+}
+
+func (n *FeishuNotifier) Send(notification *Notification) error {
+	body := map[string]any{
+		"msg_type": "text",
+		"content":  map[string]string{"text": plainText(notification)},
+	}
+	if n.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		body["timestamp"] = timestamp
+		body["sign"] = base64.StdEncoding.EncodeToString(hmacSHA256(timestamp+"\n"+n.Secret, ""))
+	}
+	respBody, err := postJSON(n.URL, body, nil)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if json.Unmarshal(respBody, &resp) == nil && resp.Code != 0 {
+		return fmt.Errorf("feishu error %d: %s", resp.Code, resp.Msg)
+	}
+	return nil
+}
+
+// DingTalkNotifier sends a text message by the custom robot of DingTalk, the secret enables the signature check
+type DingTalkNotifier struct {
+	URL    string
+	Secret string
+}
+
+func (n *DingTalkNotifier) Send(notification *Notification) error {
+	webhook := n.URL
+	if n.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		sign := base64.StdEncoding.EncodeToString(hmacSHA256(n.Secret, timestamp+"\n"+n.Secret))
+		separator := "?"
+		if strings.Contains(webhook, "?") {
+			separator = "&"
+		}
+		webhook += separator + "timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+	}
+	respBody, err := postJSON(webhook, map[string]any{
+		"msgtype": "text",
+		"text":    map[string]string{"content": plainText(notification)},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if json.Unmarshal(respBody, &resp) == nil && resp.ErrCode != 0 {
+		return fmt.Errorf("dingtalk error %d: %s", resp.ErrCode, resp.ErrMsg)
+	}
+	return nil
+}
+
+type TelegramNotifier struct {
+	APIServer string // defaults to https://api.telegram.org
+	BotToken  string
+	ChatId    string
+}
+
+func (n *TelegramNotifier) Send(notification *Notification) error {
+	if n.BotToken == "" || n.ChatId == "" {
+		return errors.New("bot token and chat id of telegram are required")
+	}
+	server := n.APIServer
+	if server == "" {
+		server = "https://api.telegram.org"
+	}
+	respBody, err := postJSON(fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimSuffix(server, "/"), n.BotToken), map[string]any{
+		"chat_id": n.ChatId,
+		"text":    plainText(notification),
+	}, nil)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if json.Unmarshal(respBody, &resp) == nil && !resp.Ok {
+		return fmt.Errorf("telegram error: %s", resp.Description)
+	}
+	return nil
+}
+
+// EmailNotifier sends the notification to the email, or to the root user
+type EmailNotifier struct {
+	Email string
+}
+
+func (n *EmailNotifier) Send(notification *Notification) error {
+	email := n.Email
+	if email == "" {
+		email = config.RootUserEmail
+	}
+	if email == "" {
+		return errors.New("RootUserEmail is empty")
+	}
+	return SendEmail(notification.Title, email, notification.Content)
+}
+
+type MessagePusherNotifier struct{}
+
+func (n *MessagePusherNotifier) Send(notification *Notification) error {
+	return SendMessage(notification.Title, notification.Content, notification.Content)
+}
+
+// SendWebhook sends the notification to the webhook of a token
+func SendWebhook(webhookType int, url string, notification *Notification) error {
+	if url == "" {
+		return errors.New("webhook is empty")
+	}
+	if notification.Timestamp == 0 {
+		notification.Timestamp = time.Now().Unix()
+	}
+	var notifier Notifier
+	switch webhookType {
+	case WebhookTypeJSON:
+		notifier = &WebhookNotifier{URL: url}
+	case WebhookTypeSlack:
+		notifier = &SlackNotifier{URL: url}
+	case WebhookTypeFeishu:
+		notifier = &FeishuNotifier{URL: url}
+	case WebhookTypeDingTalk:
+		notifier = &DingTalkNotifier{URL: url}
+	default:
+		return fmt.Errorf("unknown webhook type: %d", webhookType)
+	}
+	return notifier.Send(notification)
+}
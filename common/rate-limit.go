@@ -1,21 +1,33 @@
 package common
 
 import (
+	"context"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/songquanpeng/one-api/common/logger"
 )
 
 type InMemoryRateLimiter struct {
 	store              map[string]*[]int64
+	windows            map[string]*window
 	mutex              sync.Mutex
 	expirationDuration time.Duration
 }
 
+// window is a fixed time window counter, used for weighted limits like tokens per minute
+type window struct {
+	start int64
+	used  int64
+}
+
 func (l *InMemoryRateLimiter) Init(expirationDuration time.Duration) {
 	if l.store == nil {
 		l.mutex.Lock()
 		if l.store == nil {
 			l.store = make(map[string]*[]int64)
+			l.windows = make(map[string]*window)
 			l.expirationDuration = expirationDuration
 			if expirationDuration > 0 {
 				go l.clearExpiredItems()
@@ -37,6 +49,11 @@ func (l *InMemoryRateLimiter) clearExpiredItems() {
 				delete(l.store, key)
 			}
 		}
+		for key, w := range l.windows {
+			if now-w.start > int64(l.expirationDuration.Seconds()) {
+				delete(l.windows, key)
+			}
+		}
 		l.mutex.Unlock()
 	}
 }
@@ -68,3 +85,115 @@ func (l *InMemoryRateLimiter) Request(key string, maxRequestNum int, duration in
 	}
 	return true
 }
+
+// Reserve adds amount to the current fixed window of key, the window is rejected and rolled back if it exceeds limit.
+// It returns the used amount after reservation and the start of the window, both duration and window are in seconds
+func (l *InMemoryRateLimiter) Reserve(key string, amount int64, limit int64, duration int64) (used int64, start int64, ok bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now().Unix()
+	start = now - now%duration
+	w, exists := l.windows[key]
+	if !exists || w.start != start {
+		w = &window{start: start}
+		l.windows[key] = w
+	}
+	if w.used+amount > limit {
+		return w.used, start, false
+	}
+	w.used += amount
+	return w.used, start, true
+}
+
+// Adjust corrects a reservation made by Reserve, it does nothing if the window has already passed
+func (l *InMemoryRateLimiter) Adjust(key string, start int64, delta int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	w, ok := l.windows[key]
+	if !ok || w.start != start {
+		return
+	}
+	w.used += delta
+	if w.used < 0 {
+		w.used = 0
+	}
+}
+
+// Used returns the amount used in the current window of key
+func (l *InMemoryRateLimiter) Used(key string, duration int64) int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now().Unix()
+	w, ok := l.windows[key]
+	if !ok || w.start != now-now%duration {
+		return 0
+	}
+	return w.used
+}
+
+var windowRateLimiter InMemoryRateLimiter
+
+// WindowReserve reserves amount in the fixed window of key, using Redis when enabled so all nodes share the window.
+// It returns the used amount after reservation and the window start which must be passed back to WindowAdjust
+func WindowReserve(key string, amount int64, limit int64, duration int64) (used int64, start int64, ok bool) {
+	if !RedisEnabled {
+		windowRateLimiter.Init(time.Duration(2*duration) * time.Second)
+		return windowRateLimiter.Reserve(key, amount, limit, duration)
+	}
+	ctx := context.Background()
+	now := time.Now().Unix()
+	start = now - now%duration
+	windowKey := windowRedisKey(key, start)
+	used, err := RDB.IncrBy(ctx, windowKey, amount).Result()
+	if err != nil {
+		// we don't block the request if redis is unavailable
+		logger.SysError("failed to reserve rate limit window: " + err.Error())
+		return 0, start, true
+	}
+	if used == amount {
+		RDB.Expire(ctx, windowKey, time.Duration(2*duration)*time.Second)
+	}
+	if used > limit {
+		RDB.DecrBy(ctx, windowKey, amount)
+		return used - amount, start, false
+	}
+	return used, start, true
+}
+
+// WindowAdjust corrects the amount reserved by WindowReserve, delta can be negative to release
+func WindowAdjust(key string, start int64, delta int64) {
+	if delta == 0 {
+		return
+	}
+	if !RedisEnabled {
+		windowRateLimiter.Adjust(key, start, delta)
+		return
+	}
+	ctx := context.Background()
+	windowKey := windowRedisKey(key, start)
+	// the key may have expired, don't recreate it without ttl
+	if exists, err := RDB.Exists(ctx, windowKey).Result(); err != nil || exists == 0 {
+		return
+	}
+	if err := RDB.IncrBy(ctx, windowKey, delta).Err(); err != nil {
+		logger.SysError("failed to adjust rate limit window: " + err.Error())
+	}
+}
+
+// WindowUsed returns the amount used in the current window of key without reserving anything
+func WindowUsed(key string, duration int64) int64 {
+	if !RedisEnabled {
+		windowRateLimiter.Init(time.Duration(2*duration) * time.Second)
+		return windowRateLimiter.Used(key, duration)
+	}
+	now := time.Now().Unix()
+	used, err := RDB.Get(context.Background(), windowRedisKey(key, now-now%duration)).Int64()
+	if err != nil {
+		return 0
+	}
+	return used
+}
+
+func windowRedisKey(key string, start int64) string {
+	return "rateLimit:" + key + ":" + strconv.FormatInt(start, 10)
+}
@@ -71,6 +71,18 @@ func RedisDel(key string) error {
 	return RDB.Del(ctx, key).Err()
 }
 
+// RedisIncrease increases the counter atomically and renews its expiration, it returns the new value
+func RedisIncrease(key string, expiration time.Duration) (int64, error) {
+	ctx := context.Background()
+	pipe := RDB.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, expiration)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
 func RedisDecrease(key string, value int64) error {
 	ctx := context.Background()
 	return RDB.DecrBy(ctx, key, value).Err()
@@ -149,3 +161,8 @@ func RedisHashDel(key string, field string) error {
 	ctx := context.Background()
 	return RDB.HDel(ctx, key, field).Err()
 }
+
+func RedisHashGetAll(key string) (map[string]string, error) {
+	ctx := context.Background()
+	return RDB.HGetAll(ctx, key).Result()
+}
@@ -0,0 +1,212 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// an encrypted value is enc:v1:<master key id>:<data key wrapped by the master key>:<value encrypted by the data key>
+const prefix = "enc:v1:"
+
+type masterKey struct {
+	id  string
+	key []byte
+}
+
+var (
+	current *masterKey
+	// old master keys are only used to decrypt, until the values are rotated to the current one
+	masterKeys = make(map[string]*masterKey)
+)
+
+func newMasterKey(raw string) *masterKey {
+	raw = strings.TrimSpace(raw)
+	// a base64 key of 32 bytes is used as it is, any other value is hashed into one
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) != 32 {
+		sum := sha256.Sum256([]byte(raw))
+		key = sum[:]
+	}
+	sum := sha256.Sum256(key)
+	return &masterKey{id: hex.EncodeToString(sum[:4]), key: key}
+}
+
+// Init loads the master key from SECRET_MASTER_KEY or from the file SECRET_MASTER_KEY_FILE,
+// and the previous master keys from SECRET_OLD_MASTER_KEYS, separated by commas.
+// Without a master key the secrets are stored in plaintext.
+func Init() error {
+	raw := os.Getenv("SECRET_MASTER_KEY")
+	if file := os.Getenv("SECRET_MASTER_KEY_FILE"); raw == "" && file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read the master key file: %w", err)
+		}
+		raw = string(data)
+	}
+	SetMasterKeys(raw, strings.Split(os.Getenv("SECRET_OLD_MASTER_KEYS"), ","))
+	return nil
+}
+
+// SetMasterKeys replaces the master keys, an empty current key disables the encryption
+func SetMasterKeys(currentKey string, oldKeys []string) {
+	current = nil
+	masterKeys = make(map[string]*masterKey)
+	for _, raw := range oldKeys {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		key := newMasterKey(raw)
+		masterKeys[key.id] = key
+	}
+	if strings.TrimSpace(currentKey) != "" {
+		current = newMasterKey(currentKey)
+		masterKeys[current.id] = current
+	}
+}
+
+func Enabled() bool {
+	return current != nil
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+}
+
+func format(key *masterKey, wrappedKey []byte, ciphertext []byte) string {
+	return prefix + key.id + ":" + base64.StdEncoding.EncodeToString(wrappedKey) + ":" + base64.StdEncoding.EncodeToString(ciphertext)
+}
+
+// Encrypt encrypts the value with a new data key, the value is returned as it is when the encryption is disabled
+func Encrypt(value string) (string, error) {
+	if current == nil || value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(current.key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return format(current, wrappedKey, ciphertext), nil
+}
+
+func parse(value string) (key *masterKey, wrappedKey []byte, ciphertext []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return nil, nil, nil, errors.New("malformed encrypted value")
+	}
+	key, ok := masterKeys[parts[0]]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("unknown master key %s", parts[0])
+	}
+	if wrappedKey, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+		return nil, nil, nil, err
+	}
+	if ciphertext, err = base64.StdEncoding.DecodeString(parts[2]); err != nil {
+		return nil, nil, nil, err
+	}
+	return key, wrappedKey, ciphertext, nil
+}
+
+// Decrypt returns the plaintext of an encrypted value, a value which is not encrypted is returned as it is
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	key, wrappedKey, ciphertext, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(key.key, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rotate encrypts a plaintext value, or wraps the data key of a value encrypted by an old master key with the current one.
+// changed is false when the value is already encrypted by the current master key.
+func Rotate(value string) (rotated string, changed bool, err error) {
+	if current == nil || value == "" {
+		return value, false, nil
+	}
+	if !IsEncrypted(value) {
+		rotated, err = Encrypt(value)
+		return rotated, err == nil, err
+	}
+	key, wrappedKey, ciphertext, err := parse(value)
+	if err != nil {
+		return "", false, err
+	}
+	if key == current {
+		return value, false, nil
+	}
+	dataKey, err := open(key.key, wrappedKey)
+	if err != nil {
+		return "", false, err
+	}
+	if wrappedKey, err = seal(current.key, dataKey); err != nil {
+		return "", false, err
+	}
+	return format(current, wrappedKey, ciphertext), true, nil
+}
+
+// Mask keeps the first and the last 4 characters of a secret
+func Mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	if len(value) <= 12 {
+		return "******"
+	}
+	return value[:4] + "******" + value[len(value)-4:]
+}
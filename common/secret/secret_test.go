@@ -0,0 +1,42 @@
+package secret
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncrypt(t *testing.T) {
+	SetMasterKeys("", nil)
+	defer SetMasterKeys("", nil)
+	value, err := Encrypt("sk-plain")
+	assert.Nil(t, err)
+	assert.Equal(t, "sk-plain", value)
+
+	SetMasterKeys("old master key", nil)
+	encrypted, err := Encrypt("sk-0123456789")
+	assert.Nil(t, err)
+	assert.True(t, IsEncrypted(encrypted))
+	assert.NotContains(t, encrypted, "sk-0123456789")
+	plaintext, err := Decrypt(encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "sk-0123456789", plaintext)
+	plaintext, _ = Decrypt("sk-legacy")
+	assert.Equal(t, "sk-legacy", plaintext)
+
+	// rotation wraps the data key with the new master key, the old one is still able to decrypt before it
+	SetMasterKeys("new master key", []string{"old master key"})
+	rotated, changed, err := Rotate(encrypted)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	_, changed, _ = Rotate(rotated)
+	assert.False(t, changed)
+	SetMasterKeys("new master key", nil)
+	plaintext, err = Decrypt(rotated)
+	assert.Nil(t, err)
+	assert.Equal(t, "sk-0123456789", plaintext)
+	_, err = Decrypt(encrypted)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, "sk-0******6789", Mask("sk-0123456789"))
+}
@@ -0,0 +1,109 @@
+package telemetry
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "one_api"
+
+// Registry holds the metrics of the relay pipeline, it is served by /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	relayRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_requests_total",
+		Help:      "Relay attempts by model, channel, group and status code.",
+	}, []string{"model", "channel", "group", "status"})
+	relayDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_request_duration_seconds",
+		Help:      "Duration of the relay attempts.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"model", "channel", "group"})
+	relayFirstToken = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_time_to_first_token_seconds",
+		Help:      "Time to the first token of the stream responses.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60},
+	}, []string{"model", "channel", "group"})
+	relayRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_retries_total",
+		Help:      "Retries of the relay requests on other channels.",
+	}, []string{"model", "group"})
+	relayTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_tokens_total",
+		Help:      "Billed tokens by model, channel, group and type.",
+	}, []string{"model", "channel", "group", "type"})
+	quotaConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_consumed_total",
+		Help:      "Consumed quota by model, channel and group.",
+	}, []string{"model", "channel", "group"})
+	channelEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "channel_events_total",
+		Help:      "Channels put to sleep, disabled and enabled.",
+	}, []string{"channel", "event"})
+)
+
+// channel events
+const (
+	ChannelEventSleep   = "sleep"
+	ChannelEventDisable = "disable"
+	ChannelEventEnable  = "enable"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		relayRequests, relayDuration, relayFirstToken, relayRetries, relayTokens, quotaConsumed, channelEvents,
+	)
+}
+
+// MetricsHandler serves the metrics in the prometheus text format
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// RecordRelayRequest records an attempt on a channel, statusCode is 200 for a success,
+// firstResponseTime is zero for the responses which are not streamed
+func RecordRelayRequest(modelName string, channelId int, group string, statusCode int, startTime time.Time, firstResponseTime time.Time) {
+	channel := strconv.Itoa(channelId)
+	relayRequests.WithLabelValues(modelName, channel, group, strconv.Itoa(statusCode)).Inc()
+	relayDuration.WithLabelValues(modelName, channel, group).Observe(time.Since(startTime).Seconds())
+	if firstResponseTime.After(startTime) {
+		relayFirstToken.WithLabelValues(modelName, channel, group).Observe(firstResponseTime.Sub(startTime).Seconds())
+	}
+}
+
+func RecordRetry(modelName string, group string) {
+	relayRetries.WithLabelValues(modelName, group).Inc()
+}
+
+// RecordConsumption records the billed tokens and quota of a request
+func RecordConsumption(modelName string, channelId int, group string, promptTokens int, completionTokens int, quota int64) {
+	channel := strconv.Itoa(channelId)
+	if promptTokens > 0 {
+		relayTokens.WithLabelValues(modelName, channel, group, "prompt").Add(float64(promptTokens))
+	}
+	if completionTokens > 0 {
+		relayTokens.WithLabelValues(modelName, channel, group, "completion").Add(float64(completionTokens))
+	}
+	if quota > 0 {
+		quotaConsumed.WithLabelValues(modelName, channel, group).Add(float64(quota))
+	}
+}
+
+func RecordChannelEvent(channelId int, event string) {
+	channelEvents.WithLabelValues(strconv.Itoa(channelId), event).Inc()
+}
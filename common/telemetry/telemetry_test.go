@@ -0,0 +1,61 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	_, err := InitTracing(context.Background(), false)
+	assert.Nil(t, err)
+
+	// the trace of the client is continued and passed to the upstream
+	incoming := http.Header{}
+	incoming.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, relaySpan := StartSpan(ExtractHeaders(context.Background(), incoming), "relay")
+	ctx, span := StartSpan(ctx, "DoRequest")
+	outgoing := http.Header{}
+	InjectHeaders(ctx, outgoing)
+	EndSpan(span, errors.New("connection refused"))
+	relaySpan.End()
+
+	assert.True(t, strings.HasPrefix(outgoing.Get("traceparent"), "00-4bf92f3577b34da6a3ce929d0e0e4736-"))
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "DoRequest", spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, "00f067aa0ba902b7", spans[1].Parent.SpanID().String())
+}
+
+func TestMetrics(t *testing.T) {
+	start := time.Now().Add(-2 * time.Second)
+	RecordRelayRequest("gpt-4o", 1, "default", http.StatusOK, start, start.Add(time.Second))
+	RecordRetry("gpt-4o", "default")
+	RecordConsumption("gpt-4o", 1, "default", 10, 20, 30)
+	RecordChannelEvent(1, ChannelEventDisable)
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+	assert.Contains(t, body, `one_api_relay_requests_total{channel="1",group="default",model="gpt-4o",status="200"} 1`)
+	assert.Contains(t, body, `one_api_relay_time_to_first_token_seconds_count{channel="1",group="default",model="gpt-4o"} 1`)
+	assert.Contains(t, body, `one_api_relay_retries_total{group="default",model="gpt-4o"} 1`)
+	assert.Contains(t, body, `one_api_relay_tokens_total{channel="1",group="default",model="gpt-4o",type="completion"} 20`)
+	assert.Contains(t, body, `one_api_quota_consumed_total{channel="1",group="default",model="gpt-4o"} 30`)
+	assert.Contains(t, body, `one_api_channel_events_total{channel="1",event="disable"} 1`)
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/audit"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// auditEnabled reports whether the requests of the token or of its group are captured
+func auditEnabled(c *gin.Context) bool {
+	if c.GetBool(ctxkey.AuditEnabled) {
+		return true
+	}
+	group, ok := dbmodel.GroupInfo[c.GetString(ctxkey.Group)]
+	return ok && group.AuditEnabled
+}
+
+// saveAuditCapture redacts and saves the request sent to the channel and the response sent to the client
+func saveAuditCapture(c *gin.Context, writer *audit.Writer, err *model.ErrorWithStatusCode) {
+	request, requestTruncated := audit.Truncate(c.GetString(ctxkey.AuditRequest))
+	response, responseTruncated := writer.Response()
+	capture := &dbmodel.AuditCapture{
+		RequestId:  c.GetString(helper.RequestIdKey),
+		UserId:     c.GetInt(ctxkey.Id),
+		TokenId:    c.GetInt(ctxkey.TokenId),
+		ChannelId:  c.GetInt(ctxkey.ChannelId),
+		ModelName:  c.GetString(ctxkey.OriginalModel),
+		StatusCode: writer.Status(),
+		Request:    audit.Redact(request),
+		Response:   audit.Redact(response),
+		Truncated:  requestTruncated || responseTruncated,
+	}
+	if err != nil {
+		// the error is sent to the client after the retries
+		capture.StatusCode = err.StatusCode
+		capture.Error = audit.Redact(err.Message)
+	}
+	ctx := c.Request.Context()
+	go func(ctx context.Context) {
+		if err := dbmodel.RecordAuditCapture(capture); err != nil {
+			logger.Errorf(ctx, "failed to save audit capture: %s", err.Error())
+		}
+	}(ctx)
+}
+
+// GetAuditCaptures 返回请求的审计记录
+func GetAuditCaptures(c *gin.Context) {
+	captures, err := dbmodel.GetAuditCaptures(c.Param("request_id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    captures,
+	})
+	return
+}
@@ -0,0 +1,441 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/middleware"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// the batch worker executes the requests of /v1/batches through the relay, as if they were sent by the batch's token.
+// all the state is in the database: a restarted worker picks up the pending requests where the previous one stopped
+
+const maxBatchRequests = 50000
+
+var batchPollInterval = 5 * time.Second
+
+type batchTask struct {
+	batch   *model.Batch
+	request *model.BatchRequest
+}
+
+// batchInFlight tracks the requests handed to the workers, so that they are not dispatched twice
+// and a batch is only finalized once all its requests are back.
+// backoff holds the batches whose token is at its limits until the time they can be dispatched again
+var batchInFlight = struct {
+	sync.Mutex
+	requests map[int]bool
+	batches  map[string]int
+	backoff  map[string]time.Time
+}{
+	requests: make(map[int]bool),
+	batches:  make(map[string]int),
+	backoff:  make(map[string]time.Time),
+}
+
+type batchLine struct {
+	CustomId string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	Url      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+type batchLineError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   any    `json:"param"`
+	Line    int    `json:"line"`
+}
+
+type batchResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestId  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type batchResult struct {
+	Id       string         `json:"id"`
+	CustomId string         `json:"custom_id"`
+	Response *batchResponse `json:"response"`
+	Error    any            `json:"error"`
+}
+
+func InitBatchWorker() {
+	tasks := make(chan batchTask)
+	for i := 0; i < config.BatchWorkerCount; i++ {
+		go runBatchTasks(tasks)
+	}
+	go dispatchBatches(tasks)
+	logger.SysLogf("batch worker started with %d workers", config.BatchWorkerCount)
+}
+
+func dispatchBatches(tasks chan<- batchTask) {
+	for {
+		batches, err := model.GetUnfinishedBatches()
+		if err != nil {
+			logger.SysError("failed to get unfinished batches: " + err.Error())
+		}
+		dispatched := false
+		for _, batch := range batches {
+			if dispatchBatch(batch, tasks) {
+				dispatched = true
+			}
+		}
+		if !dispatched {
+			time.Sleep(batchPollInterval)
+		}
+	}
+}
+
+func inFlightCount(batchId string) int {
+	batchInFlight.Lock()
+	defer batchInFlight.Unlock()
+	return batchInFlight.batches[batchId]
+}
+
+func backOffBatch(batchId string) {
+	batchInFlight.Lock()
+	defer batchInFlight.Unlock()
+	batchInFlight.backoff[batchId] = time.Now().Add(batchPollInterval)
+}
+
+func isBatchBackingOff(batchId string) bool {
+	batchInFlight.Lock()
+	defer batchInFlight.Unlock()
+	until, ok := batchInFlight.backoff[batchId]
+	if !ok {
+		return false
+	}
+	if time.Now().Before(until) {
+		return true
+	}
+	delete(batchInFlight.backoff, batchId)
+	return false
+}
+
+// dispatchBatch moves a batch one step forward, it reports whether requests were handed to the workers
+func dispatchBatch(batch *model.Batch, tasks chan<- batchTask) bool {
+	switch batch.Status {
+	case model.BatchStatusValidating:
+		validateBatch(batch)
+		return false
+	case model.BatchStatusFinalizing:
+		finalizeBatch(batch, model.BatchStatusCompleted)
+		return false
+	case model.BatchStatusCancelling:
+		if inFlightCount(batch.Id) == 0 {
+			finalizeBatch(batch, model.BatchStatusCancelled)
+		}
+		return false
+	}
+	inFlight := inFlightCount(batch.Id)
+	if batch.ExpiresAt > 0 && helper.GetTimestamp() > batch.ExpiresAt {
+		if inFlight == 0 {
+			finalizeBatch(batch, model.BatchStatusExpired)
+		}
+		return false
+	}
+	if isBatchBackingOff(batch.Id) {
+		return false
+	}
+	// each batch gets a share of the workers per round, so that a large batch doesn't hold back the others
+	requests, err := model.GetPendingBatchRequests(batch.Id, config.BatchWorkerCount+inFlight)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to get the requests of batch %s: %s", batch.Id, err.Error()))
+		return false
+	}
+	dispatched := false
+	for _, request := range requests {
+		batchInFlight.Lock()
+		if batchInFlight.requests[request.Id] {
+			batchInFlight.Unlock()
+			continue
+		}
+		batchInFlight.requests[request.Id] = true
+		batchInFlight.batches[batch.Id]++
+		batchInFlight.Unlock()
+		tasks <- batchTask{batch: batch, request: request}
+		dispatched = true
+	}
+	if len(requests) == 0 && inFlight == 0 {
+		ok, err := model.UpdateBatchStatus(batch.Id, []string{model.BatchStatusInProgress}, model.BatchStatusFinalizing, map[string]any{
+			"finalizing_at": helper.GetTimestamp(),
+		})
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to finalize batch %s: %s", batch.Id, err.Error()))
+		}
+		if ok {
+			finalizeBatch(batch, model.BatchStatusCompleted)
+		}
+	}
+	return dispatched
+}
+
+// validateBatch parses the input file into the requests of the batch
+func validateBatch(batch *model.Batch) {
+	content, err := model.GetUserFileContent(batch.InputFileId, batch.UserId)
+	if err != nil {
+		failBatch(batch, []batchLineError{{Code: "file_not_found", Message: "the input file is not available"}})
+		return
+	}
+	requests, lineErrors := parseBatchInput(batch, content)
+	if len(lineErrors) > 0 {
+		failBatch(batch, lineErrors)
+		return
+	}
+	if err := model.StartBatch(batch, requests); err != nil {
+		logger.SysError(fmt.Sprintf("failed to start batch %s: %s", batch.Id, err.Error()))
+	}
+}
+
+func parseBatchInput(batch *model.Batch, content []byte) ([]*model.BatchRequest, []batchLineError) {
+	var requests []*model.BatchRequest
+	var lineErrors []batchLineError
+	customIds := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var line batchLine
+		if err := json.Unmarshal([]byte(text), &line); err != nil {
+			lineErrors = append(lineErrors, batchLineError{Code: "invalid_json_line", Message: "This line is not parseable as valid JSON.", Line: lineNumber})
+			continue
+		}
+		var body map[string]any
+		switch {
+		case line.CustomId == "":
+			lineErrors = append(lineErrors, batchLineError{Code: "missing_required_parameter", Message: "custom_id is required.", Param: "custom_id", Line: lineNumber})
+		case customIds[line.CustomId]:
+			lineErrors = append(lineErrors, batchLineError{Code: "duplicate_custom_id", Message: "The custom_id for this request is a duplicate of another request.", Param: "custom_id", Line: lineNumber})
+		case line.Method != http.MethodPost:
+			lineErrors = append(lineErrors, batchLineError{Code: "invalid_method", Message: "Only POST requests are supported.", Param: "method", Line: lineNumber})
+		case line.Url != batch.Endpoint:
+			lineErrors = append(lineErrors, batchLineError{Code: "mismatched_endpoint", Message: fmt.Sprintf("The url of this request does not match the endpoint of the batch: %s.", batch.Endpoint), Param: "url", Line: lineNumber})
+		case json.Unmarshal(line.Body, &body) != nil || body == nil:
+			lineErrors = append(lineErrors, batchLineError{Code: "invalid_request", Message: "body must be a JSON object.", Param: "body", Line: lineNumber})
+		case body["stream"] == true:
+			lineErrors = append(lineErrors, batchLineError{Code: "invalid_request", Message: "Streaming is not supported in batches.", Param: "body.stream", Line: lineNumber})
+		default:
+			customIds[line.CustomId] = true
+			requests = append(requests, &model.BatchRequest{
+				BatchId:  batch.Id,
+				Line:     lineNumber,
+				CustomId: line.CustomId,
+				Body:     string(line.Body),
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		lineErrors = append(lineErrors, batchLineError{Code: "invalid_file", Message: err.Error(), Line: lineNumber})
+	}
+	if len(requests) == 0 && len(lineErrors) == 0 {
+		lineErrors = append(lineErrors, batchLineError{Code: "empty_file", Message: "The input file is empty."})
+	}
+	if len(requests) > maxBatchRequests {
+		lineErrors = append(lineErrors, batchLineError{Code: "too_many_requests", Message: fmt.Sprintf("A batch can have at most %d requests.", maxBatchRequests)})
+	}
+	return requests, lineErrors
+}
+
+func failBatch(batch *model.Batch, lineErrors []batchLineError) {
+	errorsJson, _ := json.Marshal(lineErrors)
+	_, err := model.UpdateBatchStatus(batch.Id, []string{model.BatchStatusValidating}, model.BatchStatusFailed, map[string]any{
+		"errors":    string(errorsJson),
+		"failed_at": helper.GetTimestamp(),
+	})
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.Id, err.Error()))
+	}
+}
+
+// finalizeBatch writes the results into the output and error files, in the order of the input file
+func finalizeBatch(batch *model.Batch, status string) {
+	results, err := model.GetFinishedBatchResults(batch.Id)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to get the results of batch %s: %s", batch.Id, err.Error()))
+		return
+	}
+	var output, errorOutput bytes.Buffer
+	for _, result := range results {
+		if result.Status == model.BatchRequestStatusDone {
+			output.WriteString(result.Result + "\n")
+		} else {
+			errorOutput.WriteString(result.Result + "\n")
+		}
+	}
+	var outputFile, errorFile *model.UserFile
+	if output.Len() > 0 {
+		outputFile = &model.UserFile{UserId: batch.UserId, Filename: batch.Id + "_output.jsonl", Purpose: "batch_output", Content: output.Bytes()}
+	}
+	if errorOutput.Len() > 0 {
+		errorFile = &model.UserFile{UserId: batch.UserId, Filename: batch.Id + "_error.jsonl", Purpose: "batch_output", Content: errorOutput.Bytes()}
+	}
+	if err := model.FinalizeBatch(batch, status, outputFile, errorFile); err != nil {
+		logger.SysError(fmt.Sprintf("failed to finalize batch %s: %s", batch.Id, err.Error()))
+		return
+	}
+	batchInFlight.Lock()
+	delete(batchInFlight.backoff, batch.Id)
+	batchInFlight.Unlock()
+	logger.SysLogf("batch %s %s, %d results", batch.Id, status, len(results))
+}
+
+func runBatchTasks(tasks <-chan batchTask) {
+	for task := range tasks {
+		runBatchTask(task)
+	}
+}
+
+func runBatchTask(task batchTask) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.SysError(fmt.Sprintf("panic in batch %s request %d: %v", task.batch.Id, task.request.Id, r))
+		}
+		batchInFlight.Lock()
+		delete(batchInFlight.requests, task.request.Id)
+		batchInFlight.batches[task.batch.Id]--
+		if batchInFlight.batches[task.batch.Id] <= 0 {
+			delete(batchInFlight.batches, task.batch.Id)
+		}
+		batchInFlight.Unlock()
+	}()
+	requestId := helper.GenRequestID()
+	statusCode, body, bizErr := relayBatchRequest(task.batch, task.request, requestId)
+	if billing.IsTokenLimitTransient(bizErr) {
+		// the request stays pending, the dispatcher holds the batch back until the token is likely below its limits
+		backOffBatch(task.batch.Id)
+		return
+	}
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	result := batchResult{
+		Id:       "batch_req_" + random.GetUUID(),
+		CustomId: task.request.CustomId,
+		Response: &batchResponse{
+			StatusCode: statusCode,
+			RequestId:  requestId,
+			Body:       body,
+		},
+	}
+	resultJson, err := json.Marshal(result)
+	if err != nil {
+		logger.SysError("failed to marshal batch result: " + err.Error())
+		return
+	}
+	status := model.BatchRequestStatusDone
+	if statusCode/100 != 2 {
+		status = model.BatchRequestStatusFailed
+	}
+	if err := model.FinishBatchRequest(task.request, status, string(resultJson)); err != nil {
+		logger.SysError(fmt.Sprintf("failed to save the result of batch %s request %d: %s", task.batch.Id, task.request.Id, err.Error()))
+	}
+}
+
+func batchErrorBody(bizErr *relaymodel.ErrorWithStatusCode) []byte {
+	body, _ := json.Marshal(gin.H{"error": bizErr.Error})
+	return body
+}
+
+// newBatchContext builds the context of a request of a batch as TokenAuth does for the token of the batch
+func newBatchContext(batch *model.Batch, request *model.BatchRequest, requestId string) (*gin.Context, *httptest.ResponseRecorder, *model.Token, *relaymodel.ErrorWithStatusCode) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	ctx := context.WithValue(context.Background(), helper.RequestIdKey, requestId)
+	c.Request, _ = http.NewRequestWithContext(ctx, http.MethodPost, batch.Endpoint, strings.NewReader(request.Body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set(helper.RequestIdKey, requestId)
+	c.Set(ctxkey.KeyRequestBody, []byte(request.Body))
+
+	token, err := model.GetTokenById(batch.TokenId)
+	if err == nil {
+		token, err = model.ValidateUserToken(c, token.Key)
+	}
+	if err != nil {
+		return nil, nil, nil, openai.ErrorWrapper(err, "invalid_token", http.StatusUnauthorized)
+	}
+	var modelRequest middleware.ModelRequest
+	_ = json.Unmarshal([]byte(request.Body), &modelRequest)
+	if token.Models != nil && *token.Models != "" && !isModelInTokenModels(modelRequest.Model, *token.Models) {
+		return nil, nil, nil, openai.ErrorWrapper(fmt.Errorf("该令牌无权使用模型：%s", modelRequest.Model), "model_not_allowed", http.StatusForbidden)
+	}
+	group, _ := model.CacheGetUserGroup(token.UserId)
+	middleware.SetupContextForToken(c, token)
+	c.Set(ctxkey.RequestModel, modelRequest.Model)
+	c.Set(ctxkey.Group, group)
+	c.Set(ctxkey.DiscountRatio, config.BatchRatio)
+	return c, w, token, nil
+}
+
+// relayBatchRequest sends one request of a batch through the relay, with the same channel retries as Relay
+func relayBatchRequest(batch *model.Batch, request *model.BatchRequest, requestId string) (int, []byte, *relaymodel.ErrorWithStatusCode) {
+	c, w, token, bizErr := newBatchContext(batch, request, requestId)
+	if bizErr != nil {
+		return bizErr.StatusCode, batchErrorBody(bizErr), bizErr
+	}
+	group := c.GetString(ctxkey.Group)
+	modelName := c.GetString(ctxkey.RequestModel)
+	relayMode := relaymode.GetByPath(batch.Endpoint)
+	for i := 0; i <= config.RetryTimes; i++ {
+		channel, err := model.CacheGetRandomSatisfiedChannel(group, modelName, i > 0)
+		if err != nil {
+			if bizErr == nil {
+				bizErr = openai.ErrorWrapper(errors.New("no available channel for model "+modelName), "no_available_channel", http.StatusServiceUnavailable)
+			}
+			break
+		}
+		middleware.SetupContextForSelectedChannel(c, channel, modelName)
+		c.Request.Body = io.NopCloser(strings.NewReader(request.Body))
+		w.Body.Reset()
+		bizErr = relayHelper(c, relayMode)
+		if bizErr == nil {
+			return w.Code, w.Body.Bytes(), nil
+		}
+		if billing.IsTokenLimitError(bizErr) {
+			break
+		}
+		channelErr := bizErr
+		go func(c *gin.Context) {
+			processChannelRelayError(c, token.UserId, channel.Id, channel.Name, token.Name, group, modelName, channel.Type, channelErr)
+		}(c.Copy())
+		if !shouldRetry(c, bizErr) {
+			break
+		}
+	}
+	return bizErr.StatusCode, batchErrorBody(bizErr), bizErr
+}
+
+func isModelInTokenModels(modelName string, models string) bool {
+	for _, allowed := range strings.Split(models, ",") {
+		if allowed == modelName {
+			return true
+		}
+	}
+	return false
+}
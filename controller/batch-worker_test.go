@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/middleware"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/billing"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/guardrail"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestParseBatchInput(t *testing.T) {
+	batch := &model.Batch{Id: "batch_1", Endpoint: "/v1/chat/completions"}
+	content := []byte(`{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini","messages":[]}}
+
+{"custom_id":"b","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini","messages":[]}}
+`)
+	requests, lineErrors := parseBatchInput(batch, content)
+	assert.Empty(t, lineErrors)
+	assert.Len(t, requests, 2)
+	assert.Equal(t, 3, requests[1].Line)
+	assert.Equal(t, "b", requests[1].CustomId)
+
+	content = []byte(`{"custom_id":"a","method":"POST","url":"/v1/embeddings","body":{}}
+{"custom_id":"b","method":"POST","url":"/v1/chat/completions","body":{"stream":true}}
+not json
+`)
+	_, lineErrors = parseBatchInput(batch, content)
+	assert.Len(t, lineErrors, 3)
+	assert.Equal(t, "mismatched_endpoint", lineErrors[0].Code)
+	assert.Equal(t, "body.stream", lineErrors[1].Param)
+	assert.Equal(t, 3, lineErrors[2].Line)
+}
+
+// setupBatchTestDB swaps in an in-memory database with the user of the batches, the returned function restores the database
+func setupBatchTestDB(t *testing.T) func() {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB, oldLogDB, oldRedisEnabled := model.DB, model.LOG_DB, common.RedisEnabled
+	model.DB, model.LOG_DB, common.RedisEnabled = db, db, false
+	assert.Nil(t, model.DB.AutoMigrate(&model.User{}, &model.Token{}, &model.Channel{}, &model.ChannelKey{}, &model.Ability{}, &model.Log{},
+		&model.Batch{}, &model.BatchRequest{}, &model.QuotaReservation{}, &model.QuotaLedger{}, &model.Budget{}, &model.Organization{}))
+	assert.Nil(t, model.DB.Create(&model.User{Id: 1, Username: "test", Group: "vip", Quota: 1000000}).Error)
+	return func() { model.DB, model.LOG_DB, common.RedisEnabled = oldDB, oldLogDB, oldRedisEnabled }
+}
+
+// createBatchToken saves the token of the batches, an enabled token of the user without quota limit with the options of the test
+func createBatchToken(t *testing.T, token model.Token) {
+	token.Id, token.UserId, token.Name = 1, 1, "batch"
+	token.Key = "batchbatchbatchbatchbatchbatchbatchbatchbatchbat"
+	token.Status, token.ExpiredTime, token.UnlimitedQuota = model.TokenStatusEnabled, -1, true
+	assert.Nil(t, model.DB.Create(&token).Error)
+}
+
+// createBatchChannel saves an openai channel of gpt-4o-mini in the group of the user, which sends to baseURL.
+// It doesn't count the prompt, the test has no tokenizer
+func createBatchChannel(t *testing.T, baseURL string) {
+	calcPrompt := false
+	assert.Nil(t, model.DB.Create(&model.Channel{Id: 1, Type: channeltype.OpenAI, Key: "batch", BaseURL: &baseURL, Status: model.ChannelStatusEnabled,
+		Models: "gpt-4o-mini", Group: "vip", CalcPrompt: &calcPrompt}).Error)
+	assert.Nil(t, model.DB.Create(&model.Ability{Group: "vip", Model: "gpt-4o-mini", ChannelId: 1, Enabled: true}).Error)
+}
+
+func TestNewBatchContext(t *testing.T) {
+	defer setupBatchTestDB(t)()
+	createBatchToken(t, model.Token{RpmLimit: 10, DpmLimit: 20, TpmLimit: 30, CacheDisabled: true, CacheTTL: 60, AuditEnabled: true})
+
+	// the requests of a batch get the same limits and options of the token as the requests of the relay
+	batch := &model.Batch{Id: "batch_1", TokenId: 1, Endpoint: "/v1/chat/completions"}
+	c, _, token, bizErr := newBatchContext(batch, &model.BatchRequest{Body: `{"model":"gpt-4o-mini","messages":[]}`}, "request")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, 1, token.Id)
+	assert.Equal(t, 1, c.GetInt(ctxkey.Id))
+	assert.Equal(t, 1, c.GetInt(ctxkey.UserId))
+	assert.Equal(t, 10, c.GetInt(ctxkey.RpmLimit))
+	assert.Equal(t, 20, c.GetInt(ctxkey.DpmLimit))
+	assert.Equal(t, 30, c.GetInt(ctxkey.TpmLimit))
+	assert.True(t, c.GetBool(ctxkey.CacheDisabled))
+	assert.Equal(t, 60, c.GetInt(ctxkey.CacheTTL))
+	assert.True(t, c.GetBool(ctxkey.AuditEnabled))
+	assert.Equal(t, "vip", c.GetString(ctxkey.Group))
+	assert.Equal(t, "gpt-4o-mini", c.GetString(ctxkey.RequestModel))
+}
+
+func TestBatchModeration(t *testing.T) {
+	defer setupBatchTestDB(t)()
+	client.Init()
+	moderations := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		moderations++
+		_, _ = w.Write([]byte(`{"results":[{"flagged":true,"categories":{"violence":true},"category_scores":{"violence":0.9}}]}`))
+	}))
+	defer server.Close()
+	createBatchChannel(t, server.URL)
+	createBatchToken(t, model.Token{ModerationsEnable: true})
+	oldChannelId, oldAction := config.ModerationChannelId, config.ModerationAction
+	config.ModerationChannelId, config.ModerationAction = 1, "block"
+	defer func() { config.ModerationChannelId, config.ModerationAction = oldChannelId, oldAction }()
+
+	// a batch request of a token with moderation is moderated and counted as a violation like a request of the relay
+	batch := &model.Batch{Id: "batch_1", TokenId: 1, Endpoint: "/v1/chat/completions"}
+	body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"flagged"}]}`
+	c, _, _, bizErr := newBatchContext(batch, &model.BatchRequest{Body: body}, "request")
+	assert.Nil(t, bizErr)
+	channel, err := model.GetChannelById(1, true)
+	assert.Nil(t, err)
+	middleware.SetupContextForSelectedChannel(c, channel, "gpt-4o-mini")
+	bizErr = relayHelper(c, relaymode.ChatCompletions)
+	assert.NotNil(t, bizErr)
+	assert.Equal(t, "content_policy_violation", bizErr.Code)
+	assert.Equal(t, 1, moderations)
+	token, err := model.GetTokenById(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, token.ModerationsNum)
+}
+
+func TestBatchOutputFilter(t *testing.T) {
+	defer setupBatchTestDB(t)()
+	client.Init()
+	assert.Nil(t, guardrail.UpdateRuleSetsByJSONString(`{"strict":[{"name":"secret","keywords":["password"],"action":"mask"}]}`))
+	defer func() { _ = guardrail.UpdateRuleSetsByJSONString("{}") }()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o-mini","choices":[{"index":0,"message":{"role":"assistant","content":"the password is 42"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":5,"total_tokens":10}}`))
+	}))
+	defer server.Close()
+	createBatchChannel(t, server.URL)
+	createBatchToken(t, model.Token{OutputFilter: "strict"})
+
+	// the output written for a batch request goes through the rule set of the token
+	batch := &model.Batch{Id: "batch_1", TokenId: 1, UserId: 1, Endpoint: "/v1/chat/completions"}
+	statusCode, body, bizErr := relayBatchRequest(batch, &model.BatchRequest{Body: `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hi"}]}`}, "request")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Contains(t, string(body), "the *** is 42")
+	assert.NotContains(t, string(body), "password")
+	// the request is billed in the background, wait for it before the database is restored
+	assert.Eventually(t, func() bool {
+		channel, err := model.GetChannelById(1, true)
+		return err == nil && channel.UsedQuota > 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBatchOrganizationToken(t *testing.T) {
+	defer setupBatchTestDB(t)()
+	assert.Nil(t, model.DB.Create(&model.Organization{Id: 1, Name: "team", Quota: 1000}).Error)
+	createBatchToken(t, model.Token{OrgId: 1})
+
+	// a batch request of a token of an organization is paid by the pool of the organization, not by the user
+	batch := &model.Batch{Id: "batch_1", TokenId: 1, Endpoint: "/v1/chat/completions"}
+	c, _, _, bizErr := newBatchContext(batch, &model.BatchRequest{Body: `{"model":"gpt-4o-mini","messages":[]}`}, "request")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, 1, c.GetInt(ctxkey.OrgId))
+	relayMeta := meta.GetByContext(c)
+	assert.Equal(t, 1, relayMeta.OrgId)
+	_, bizErr = billing.PreConsumeQuotaAmount(c.Request.Context(), 300, relayMeta)
+	assert.Nil(t, bizErr)
+	quota, err := model.GetOrganizationQuota(1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(700), quota)
+
+	model.RecordConsumeLog(c, false, time.Now(), 0, 1, 0, 0, 0, "gpt-4o-mini", "batch", 300, "", 1)
+	logs, err := model.GetOrganizationLogs([]int{1}, 0, 0, "", "", "", 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, logs, 1)
+}
+
+func TestBatchTokenLimit(t *testing.T) {
+	defer setupBatchTestDB(t)()
+	createBatchChannel(t, "")
+	createBatchToken(t, model.Token{MaxConcurrency: 1})
+	batch := &model.Batch{Id: "batch_limit", TokenId: 1, UserId: 1, Endpoint: "/v1/chat/completions", Status: model.BatchStatusInProgress}
+	assert.Nil(t, model.DB.Create(batch).Error)
+	request := &model.BatchRequest{BatchId: batch.Id, Line: 1, CustomId: "a", Body: `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hi"}]}`}
+	assert.Nil(t, model.DB.Create(request).Error)
+	getStatus := func() int {
+		var saved model.BatchRequest
+		model.DB.First(&saved, request.Id)
+		return saved.Status
+	}
+
+	// the token is at its max_concurrency: the request stays pending and the dispatcher holds the batch back
+	assert.Nil(t, model.ReserveQuota("running", 1, 1, 0))
+	runBatchTask(batchTask{batch: batch, request: request})
+	assert.Equal(t, model.BatchRequestStatusPending, getStatus())
+	assert.True(t, isBatchBackingOff(batch.Id))
+	assert.False(t, dispatchBatch(batch, make(chan batchTask)))
+	delete(batchInFlight.backoff, batch.Id)
+
+	// an exceeded budget lasts until the end of its period, the request fails
+	assert.Nil(t, model.ReleaseQuotaReservation("running"))
+	assert.Nil(t, model.DB.Create(&model.Budget{Scope: model.BudgetScopeToken, OwnerId: 1, Period: model.BudgetPeriodMonthly, Limit: 1,
+		PeriodStart: model.GetPeriodStart(model.BudgetPeriodMonthly, time.Now()).Unix()}).Error)
+	runBatchTask(batchTask{batch: batch, request: request})
+	assert.Equal(t, model.BatchRequestStatusFailed, getStatus())
+	assert.False(t, isBatchBackingOff(batch.Id))
+	var saved model.BatchRequest
+	model.DB.First(&saved, request.Id)
+	assert.Contains(t, saved.Result, billing.ErrorCodeBudgetExceeded)
+}
@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// https://platform.openai.com/docs/api-reference/batch
+
+// batchEndpoints are the endpoints a batch can target
+var batchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/responses":        true,
+}
+
+type createBatchRequest struct {
+	InputFileId      string         `json:"input_file_id"`
+	Endpoint         string         `json:"endpoint"`
+	CompletionWindow string         `json:"completion_window"`
+	Metadata         map[string]any `json:"metadata"`
+}
+
+func nullableTimestamp(timestamp int64) any {
+	if timestamp == 0 {
+		return nil
+	}
+	return timestamp
+}
+
+func nullableString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func batchObject(batch *model.Batch) gin.H {
+	object := gin.H{
+		"id":                batch.Id,
+		"object":            "batch",
+		"endpoint":          batch.Endpoint,
+		"errors":            nil,
+		"input_file_id":     batch.InputFileId,
+		"completion_window": batch.CompletionWindow,
+		"status":            batch.Status,
+		"output_file_id":    nullableString(batch.OutputFileId),
+		"error_file_id":     nullableString(batch.ErrorFileId),
+		"created_at":        batch.CreatedAt,
+		"in_progress_at":    nullableTimestamp(batch.InProgressAt),
+		"expires_at":        nullableTimestamp(batch.ExpiresAt),
+		"finalizing_at":     nullableTimestamp(batch.FinalizingAt),
+		"completed_at":      nullableTimestamp(batch.CompletedAt),
+		"failed_at":         nullableTimestamp(batch.FailedAt),
+		"expired_at":        nullableTimestamp(batch.ExpiredAt),
+		"cancelling_at":     nullableTimestamp(batch.CancellingAt),
+		"cancelled_at":      nullableTimestamp(batch.CancelledAt),
+		"request_counts": gin.H{
+			"total":     batch.TotalCount,
+			"completed": batch.CompletedCount,
+			"failed":    batch.FailedCount,
+		},
+		"metadata": nil,
+	}
+	if batch.Errors != "" {
+		var errors []any
+		if err := json.Unmarshal([]byte(batch.Errors), &errors); err == nil {
+			object["errors"] = gin.H{"object": "list", "data": errors}
+		}
+	}
+	if batch.Metadata != "" {
+		var metadata map[string]any
+		if err := json.Unmarshal([]byte(batch.Metadata), &metadata); err == nil {
+			object["metadata"] = metadata
+		}
+	}
+	return object
+}
+
+func CreateBatch(c *gin.Context) {
+	var request createBatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		renderOpenAIError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if !batchEndpoints[request.Endpoint] {
+		renderOpenAIError(c, http.StatusBadRequest, "invalid_endpoint", fmt.Sprintf("endpoint %s is not supported", request.Endpoint))
+		return
+	}
+	if request.CompletionWindow == "" {
+		request.CompletionWindow = "24h"
+	}
+	window, err := time.ParseDuration(request.CompletionWindow)
+	if err != nil || window <= 0 {
+		renderOpenAIError(c, http.StatusBadRequest, "invalid_completion_window", fmt.Sprintf("invalid completion_window: %s", request.CompletionWindow))
+		return
+	}
+	userId := c.GetInt(ctxkey.Id)
+	file, err := model.GetUserFileById(request.InputFileId, userId)
+	if err != nil {
+		renderOpenAIError(c, http.StatusBadRequest, "file_not_found", fmt.Sprintf("No such File object: %s", request.InputFileId))
+		return
+	}
+	if file.Purpose != "batch" {
+		renderOpenAIError(c, http.StatusBadRequest, "invalid_file", "the input file must be uploaded with purpose batch")
+		return
+	}
+	batch := &model.Batch{
+		UserId:           userId,
+		TokenId:          c.GetInt(ctxkey.TokenId),
+		Endpoint:         request.Endpoint,
+		InputFileId:      file.Id,
+		CompletionWindow: request.CompletionWindow,
+		ExpiresAt:        helper.GetTimestamp() + int64(window.Seconds()),
+	}
+	if request.Metadata != nil {
+		metadata, _ := json.Marshal(request.Metadata)
+		batch.Metadata = string(metadata)
+	}
+	if err := batch.Insert(); err != nil {
+		renderOpenAIError(c, http.StatusInternalServerError, "create_batch_failed", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, batchObject(batch))
+}
+
+func RetrieveBatch(c *gin.Context) {
+	batch, err := model.GetBatchById(c.Param("id"), c.GetInt(ctxkey.Id))
+	if err != nil {
+		renderOpenAIError(c, http.StatusNotFound, "batch_not_found", fmt.Sprintf("No such Batch object: %s", c.Param("id")))
+		return
+	}
+	c.JSON(http.StatusOK, batchObject(batch))
+}
+
+func CancelBatch(c *gin.Context) {
+	batch, err := model.GetBatchById(c.Param("id"), c.GetInt(ctxkey.Id))
+	if err != nil {
+		renderOpenAIError(c, http.StatusNotFound, "batch_not_found", fmt.Sprintf("No such Batch object: %s", c.Param("id")))
+		return
+	}
+	ok, err := model.UpdateBatchStatus(batch.Id, []string{model.BatchStatusValidating, model.BatchStatusInProgress}, model.BatchStatusCancelling, map[string]any{
+		"cancelling_at": helper.GetTimestamp(),
+	})
+	if err != nil {
+		renderOpenAIError(c, http.StatusInternalServerError, "cancel_batch_failed", err.Error())
+		return
+	}
+	if !ok {
+		renderOpenAIError(c, http.StatusConflict, "invalid_batch_status", fmt.Sprintf("Cannot cancel a batch with status %s", batch.Status))
+		return
+	}
+	batch, _ = model.GetBatchById(batch.Id, batch.UserId)
+	c.JSON(http.StatusOK, batchObject(batch))
+}
+
+func ListBatches(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	// one more to know if there is a next page
+	batches, err := model.GetUserBatches(c.GetInt(ctxkey.Id), c.Query("after"), limit+1)
+	if err != nil {
+		renderOpenAIError(c, http.StatusBadRequest, "list_batches_failed", err.Error())
+		return
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	data := make([]gin.H, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, batchObject(batch))
+	}
+	var firstId, lastId any
+	if len(batches) > 0 {
+		firstId, lastId = batches[0].Id, batches[len(batches)-1].Id
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     data,
+		"first_id": firstId,
+		"last_id":  lastId,
+		"has_more": hasMore,
+	})
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// checkTokenBudget returns the id of the token whose budget is accessed, the owner of the token reads its budget,
+// the budget of a token of an organization is changed by the admins of the organization only
+func checkTokenBudget(c *gin.Context, write bool) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, err
+	}
+	token, err := model.GetTokenById(id)
+	if err != nil {
+		return 0, err
+	}
+	if token.OrgId != 0 {
+		role, err := getOrgRole(c, token.OrgId)
+		if err == nil && role >= model.OrgRoleAdmin {
+			return token.Id, nil
+		}
+		if !write && token.UserId == c.GetInt(ctxkey.Id) {
+			return token.Id, nil
+		}
+	} else if token.UserId == c.GetInt(ctxkey.Id) {
+		return token.Id, nil
+	}
+	return 0, errors.New("无权进行此操作")
+}
+
+func getBudget(c *gin.Context, scope string, ownerId int) {
+	budget, err := model.GetBudget(scope, ownerId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    budget,
+	})
+}
+
+func setBudget(c *gin.Context, scope string, ownerId int) {
+	budget := model.Budget{}
+	if err := c.ShouldBindJSON(&budget); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	budget.Scope = scope
+	budget.OwnerId = ownerId
+	if err := model.SetBudget(&budget); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    budget,
+	})
+}
+
+func deleteBudget(c *gin.Context, scope string, ownerId int) {
+	if err := model.DeleteBudget(scope, ownerId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetTokenBudget(c *gin.Context) {
+	id, err := checkTokenBudget(c, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	getBudget(c, model.BudgetScopeToken, id)
+}
+
+func UpdateTokenBudget(c *gin.Context) {
+	id, err := checkTokenBudget(c, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	setBudget(c, model.BudgetScopeToken, id)
+}
+
+func DeleteTokenBudget(c *gin.Context) {
+	id, err := checkTokenBudget(c, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	deleteBudget(c, model.BudgetScopeToken, id)
+}
+
+func GetSelfBudget(c *gin.Context) {
+	getBudget(c, model.BudgetScopeUser, c.GetInt(ctxkey.Id))
+}
+
+// the budget of a user is set by the admins
+func GetUserBudget(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	getBudget(c, model.BudgetScopeUser, id)
+}
+
+func UpdateUserBudget(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	setBudget(c, model.BudgetScopeUser, id)
+}
+
+func DeleteUserBudget(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	deleteBudget(c, model.BudgetScopeUser, id)
+}
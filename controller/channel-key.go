@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/model"
+)
+
+var errInvalidKeyStatus = errors.New("无效的 key 状态")
+
+// addMultiKeyChannel 创建一个渠道，每行一个 key 加入渠道的 key 池
+func addMultiKeyChannel(c *gin.Context, channel model.Channel) {
+	keys := channel.Key
+	channel.Key = ""
+	channels := []model.Channel{channel}
+	err := model.BatchInsertChannels(channels)
+	if err == nil {
+		_, err = model.AddChannelKeys(channels[0].Id, keys)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// getMultiKeyChannel returns the channel of the id parameter, the response is written when it is not a multi key channel
+func getMultiKeyChannel(c *gin.Context) *model.Channel {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return nil
+	}
+	channel, err := model.GetChannelById(id, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return nil
+	}
+	if !channel.MultiKey {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该渠道不是多 key 渠道",
+		})
+		return nil
+	}
+	return channel
+}
+
+// GetChannelKeys 返回渠道的 key 池，key 的内容被遮盖
+func GetChannelKeys(c *gin.Context) {
+	channel := getMultiKeyChannel(c)
+	if channel == nil {
+		return
+	}
+	keys, err := model.GetChannelKeys(channel.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	for _, key := range keys {
+		key.Key = key.MaskedKey()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    keys,
+	})
+}
+
+// AddChannelKeys 向渠道的 key 池加入 key，每行一个
+func AddChannelKeys(c *gin.Context) {
+	channel := getMultiKeyChannel(c)
+	if channel == nil {
+		return
+	}
+	var request struct {
+		Keys string `json:"keys"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	keys, err := model.AddChannelKeys(channel.Id, request.Keys)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    len(keys),
+	})
+}
+
+// UpdateChannelKey 启用或禁用渠道的一个 key，不影响渠道的状态
+func UpdateChannelKey(c *gin.Context) {
+	channel := getMultiKeyChannel(c)
+	if channel == nil {
+		return
+	}
+	keyId, _ := strconv.Atoi(c.Param("key_id"))
+	var request struct {
+		Status int `json:"status"`
+	}
+	err := c.ShouldBindJSON(&request)
+	if err == nil && request.Status != model.ChannelKeyStatusEnabled && request.Status != model.ChannelKeyStatusManuallyDisabled {
+		err = errInvalidKeyStatus
+	}
+	if err == nil {
+		err = model.UpdateChannelKeyStatus(channel.Id, keyId, request.Status)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// DeleteChannelKey 删除渠道的一个 key
+func DeleteChannelKey(c *gin.Context) {
+	channel := getMultiKeyChannel(c)
+	if channel == nil {
+		return
+	}
+	keyId, _ := strconv.Atoi(c.Param("key_id"))
+	if err := model.DeleteChannelKey(channel.Id, keyId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -204,7 +204,10 @@ func testChannels(notify bool, scope string) error {
 				if config.AutomaticDisableChannelEnabled {
 					monitor.DisableChannel(channel.Id, channel.Name, err.Error())
 				} else {
-					_ = message.Notify(message.ByAll, fmt.Sprintf("渠道 %s （%d）测试超时", channel.Name, channel.Id), "", err.Error())
+					title := fmt.Sprintf("渠道 %s （%d）测试超时", channel.Name, channel.Id)
+					if !message.Dispatch(&message.Notification{Event: message.EventChannelTest, Title: title, Content: err.Error()}) {
+						_ = message.Notify(message.ByAll, title, "", err.Error())
+					}
 				}
 			}
 			if isChannelEnabled && monitor.ShouldDisableChannel(openaiErr, -1, channel.Id, channel.Type) {
@@ -220,9 +223,12 @@ func testChannels(notify bool, scope string) error {
 		testAllChannelsRunning = false
 		testAllChannelsLock.Unlock()
 		if notify {
-			err := message.Notify(message.ByAll, "渠道测试完成", "", "渠道测试完成，如果没有收到禁用通知，说明所有渠道都正常")
-			if err != nil {
-				logger.SysError(fmt.Sprintf("failed to send email: %s", err.Error()))
+			title := "渠道测试完成"
+			content := "渠道测试完成，如果没有收到禁用通知，说明所有渠道都正常"
+			if !message.Dispatch(&message.Notification{Event: message.EventChannelTest, Title: title, Content: content}) {
+				if err := message.Notify(message.ByAll, title, "", content); err != nil {
+					logger.SysError(fmt.Sprintf("failed to send email: %s", err.Error()))
+				}
 			}
 		}
 	}()
@@ -1,14 +1,20 @@
 package controller
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/transform"
 )
 
 func GetAllChannels(c *gin.Context) {
@@ -24,6 +30,7 @@ func GetAllChannels(c *gin.Context) {
 		})
 		return
 	}
+	maskChannelSecrets(channels...)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -42,6 +49,7 @@ func SearchChannels(c *gin.Context) {
 		})
 		return
 	}
+	maskChannelSecrets(channels...)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -67,6 +75,7 @@ func GetChannel(c *gin.Context) {
 		})
 		return
 	}
+	maskChannelSecrets(channel)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -75,6 +84,54 @@ func GetChannel(c *gin.Context) {
 	return
 }
 
+// maskChannelSecrets hides the key and the secrets in the config, they are only returned by RevealChannelSecrets
+func maskChannelSecrets(channels ...*model.Channel) {
+	for _, channel := range channels {
+		channel.Key = ""
+		channel.Config = model.MaskChannelConfig(channel.Config)
+	}
+}
+
+// RevealChannelSecrets 返回渠道的 key、配置和 key 池，仅限 root 用户，每次查看都记录日志
+func RevealChannelSecrets(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	var keys []*model.ChannelKey
+	if channel.MultiKey {
+		if keys, err = model.GetChannelKeys(channel.Id); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	model.RecordLog(c.GetInt(ctxkey.Id), model.LogTypeManage, fmt.Sprintf("查看了渠道 #%d（%s）的密钥", channel.Id, channel.Name))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"key":    channel.Key,
+			"config": channel.Config,
+			"keys":   keys,
+		},
+	})
+}
+
 func AddChannel(c *gin.Context) {
 	channel := model.Channel{}
 	err := c.ShouldBindJSON(&channel)
@@ -85,7 +142,18 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	if err = validateChannelConfig(&channel); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	channel.CreatedTime = helper.GetTimestamp()
+	if channel.MultiKey {
+		addMultiKeyChannel(c, channel)
+		return
+	}
 	keys := strings.Split(channel.Key, "\n")
 	channels := make([]model.Channel, 0, len(keys))
 	for _, key := range keys {
@@ -146,6 +214,70 @@ func DeleteDisabledChannel(c *gin.Context) {
 	return
 }
 
+// GetChannelSleeps 列出休眠中的渠道模型，all=true 时包括已唤醒但仍保留休眠次数的记录
+func GetChannelSleeps(c *gin.Context) {
+	sleeps, err := model.GetChannelSleeps()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	now := helper.GetTimestamp()
+	data := make([]*model.ChannelSleep, 0, len(sleeps))
+	for _, sleep := range sleeps {
+		if c.Query("all") == "true" || sleep.IsSleeping(now) {
+			data = append(data, sleep)
+		}
+	}
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].AwakeTime > data[j].AwakeTime
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
+
+// ClearChannelSleeps 唤醒渠道模型并清除休眠次数，不传 channel_id 时清除所有渠道，不传 model 时清除渠道的所有模型
+func ClearChannelSleeps(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	rows, err := model.ClearChannelSleeps(channelId, c.Query("model"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    rows,
+	})
+}
+
+// GetChannelBreakers 列出当前节点没有关闭的熔断器
+func GetChannelBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetChannelBreakers(),
+	})
+}
+
+// ResetChannelBreakers 关闭当前节点的熔断器，不传 channel_id 时重置所有渠道，不传 model 时重置渠道的所有模型
+func ResetChannelBreakers(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.ResetChannelBreakers(channelId, c.Query("model")),
+	})
+}
+
 func UpdateChannel(c *gin.Context) {
 	channel := model.Channel{}
 	err := c.ShouldBindJSON(&channel)
@@ -156,6 +288,19 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	if channel.Config != "" {
+		stored, err := model.GetChannelById(channel.Id, false)
+		if err == nil {
+			channel.Config = model.UnmaskChannelConfig(channel.Config, stored.Config)
+		}
+	}
+	if err = validateChannelConfig(&channel); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -164,6 +309,7 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	maskChannelSecrets(&channel)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -187,3 +333,74 @@ func UpdateChannelsAbilities(c *gin.Context) {
 	})
 	return
 }
+
+// validateChannelConfig checks the config of the channel, such as its transform rules
+func validateChannelConfig(channel *model.Channel) error {
+	cfg, err := channel.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("无效的渠道配置：%s", err.Error())
+	}
+	if err = transform.Validate(cfg.Transform); err != nil {
+		return fmt.Errorf("无效的改写规则：%s", err.Error())
+	}
+	switch channel.KeySelection {
+	case "", model.KeySelectionRoundRobin, model.KeySelectionRandom, model.KeySelectionLeastUsed:
+	default:
+		return fmt.Errorf("无效的 key 选择方式：%s", channel.KeySelection)
+	}
+	return nil
+}
+
+// TransformChannelRequest 返回请求体经过渠道改写规则后的内容，请求不会发送到上游
+func TransformChannelRequest(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cfg, err := channel.LoadConfig()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil || !json.Valid(body) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "请求体不是有效的 JSON",
+		})
+		return
+	}
+	header := http.Header{}
+	var removedHeaders []string
+	if cfg.Transform != nil {
+		body = transform.Apply(cfg.Transform.Request, body)
+		transform.ApplyHeaders(cfg.Transform.Headers, header)
+		if cfg.Transform.Headers != nil {
+			removedHeaders = cfg.Transform.Headers.Remove
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"body":            json.RawMessage(body),
+			"headers":         header,
+			"removed_headers": removedHeaders,
+		},
+	})
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/files
+
+func renderOpenAIError(c *gin.Context, statusCode int, code string, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": relaymodel.Error{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	})
+}
+
+func fileObject(file *model.UserFile) gin.H {
+	return gin.H{
+		"id":         file.Id,
+		"object":     "file",
+		"bytes":      file.Bytes,
+		"created_at": file.CreatedAt,
+		"filename":   file.Filename,
+		"purpose":    file.Purpose,
+	}
+}
+
+func UploadFile(c *gin.Context) {
+	purpose := c.PostForm("purpose")
+	if purpose == "" {
+		renderOpenAIError(c, http.StatusBadRequest, "invalid_request", "purpose is required")
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		renderOpenAIError(c, http.StatusBadRequest, "invalid_request", "file is required")
+		return
+	}
+	if fileHeader.Size > int64(config.FileMaxSize)<<20 {
+		renderOpenAIError(c, http.StatusBadRequest, "file_too_large", fmt.Sprintf("file is larger than %d MB", config.FileMaxSize))
+		return
+	}
+	reader, err := fileHeader.Open()
+	if err != nil {
+		renderOpenAIError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		renderOpenAIError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	file := &model.UserFile{
+		UserId:   c.GetInt(ctxkey.Id),
+		Filename: fileHeader.Filename,
+		Purpose:  purpose,
+		Content:  content,
+	}
+	if err := file.Insert(); err != nil {
+		renderOpenAIError(c, http.StatusInternalServerError, "save_file_failed", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, fileObject(file))
+}
+
+func ListFiles(c *gin.Context) {
+	files, err := model.GetUserFiles(c.GetInt(ctxkey.Id), c.Query("purpose"))
+	if err != nil {
+		renderOpenAIError(c, http.StatusInternalServerError, "list_files_failed", err.Error())
+		return
+	}
+	data := make([]gin.H, 0, len(files))
+	for _, file := range files {
+		data = append(data, fileObject(file))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+func RetrieveFile(c *gin.Context) {
+	file, err := model.GetUserFileById(c.Param("id"), c.GetInt(ctxkey.Id))
+	if err != nil {
+		renderOpenAIError(c, http.StatusNotFound, "file_not_found", fmt.Sprintf("No such File object: %s", c.Param("id")))
+		return
+	}
+	c.JSON(http.StatusOK, fileObject(file))
+}
+
+func RetrieveFileContent(c *gin.Context) {
+	file, err := model.GetUserFileById(c.Param("id"), c.GetInt(ctxkey.Id))
+	if err != nil {
+		renderOpenAIError(c, http.StatusNotFound, "file_not_found", fmt.Sprintf("No such File object: %s", c.Param("id")))
+		return
+	}
+	content, err := model.GetUserFileContent(file.Id, file.UserId)
+	if err != nil {
+		renderOpenAIError(c, http.StatusInternalServerError, "read_file_failed", err.Error())
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+	c.Data(http.StatusOK, "application/octet-stream", content)
+}
+
+func DeleteFile(c *gin.Context) {
+	id := c.Param("id")
+	if err := model.DeleteUserFileById(id, c.GetInt(ctxkey.Id)); err != nil {
+		renderOpenAIError(c, http.StatusNotFound, "file_not_found", fmt.Sprintf("No such File object: %s", id))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"object":  "file",
+		"deleted": true,
+	})
+}
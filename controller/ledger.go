@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// GetSelfLedger 返回当前用户的额度流水，format=csv 时导出全部流水
+func GetSelfLedger(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	userId := c.GetInt(ctxkey.Id)
+	ledgerType, _ := strconv.Atoi(c.Query("type"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	if c.Query("format") == "csv" {
+		exportLedger(c, userId, ledgerType, startTimestamp, endTimestamp)
+		return
+	}
+	entries, err := model.GetUserQuotaLedger(userId, ledgerType, startTimestamp, endTimestamp, p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    entries,
+	})
+	return
+}
+
+func exportLedger(c *gin.Context, userId int, ledgerType int, startTimestamp int64, endTimestamp int64) {
+	entries, err := model.GetUserQuotaLedger(userId, ledgerType, startTimestamp, endTimestamp, 0, 0)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=ledger-%d.csv", userId))
+	c.Status(http.StatusOK)
+	writer := csv.NewWriter(c.Writer)
+	_ = writer.Write([]string{"id", "time", "type", "amount", "balance_after", "token_id", "request_id", "remark"})
+	for _, entry := range entries {
+		_ = writer.Write([]string{
+			strconv.Itoa(entry.Id),
+			time.Unix(entry.CreatedTime, 0).Format(time.RFC3339),
+			model.LedgerTypeNames[entry.Type],
+			strconv.FormatInt(entry.Amount, 10),
+			strconv.FormatInt(entry.BalanceAfter, 10),
+			strconv.Itoa(entry.TokenId),
+			entry.RequestId,
+			entry.Remark,
+		})
+	}
+	writer.Flush()
+}
+
+// CheckQuotaLedger 对比流水和 users.quota、tokens.remain_quota，返回不一致的账户
+func CheckQuotaLedger(c *gin.Context) {
+	mismatches, total, err := model.CheckQuotaLedger()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"mismatches": mismatches,
+			"total":      total, // sum of all entries, 0 when every transaction is balanced
+		},
+	})
+	return
+}
@@ -143,6 +143,69 @@ func GetLogsSelfStat(c *gin.Context) {
 	return
 }
 
+// GetOrgLogs 返回组织及其下级组织的令牌的消费日志，组织成员可见
+func GetOrgLogs(c *gin.Context) {
+	org, _ := checkOrgRole(c, model.OrgRoleMember)
+	if org == nil {
+		return
+	}
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	orgIds, err := model.GetOrganizationTreeIds(org.Id)
+	var logs []*model.Log
+	if err == nil {
+		logs, err = model.GetOrganizationLogs(orgIds, startTimestamp, endTimestamp, c.Query("model_name"), c.Query("username"), c.Query("token_name"), p*config.ItemsPerPage, config.ItemsPerPage)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    logs,
+	})
+}
+
+// GetLogsOrgStat 统计组织及其下级组织的消耗，并按成员汇总
+func GetLogsOrgStat(c *gin.Context) {
+	org, _ := checkOrgRole(c, model.OrgRoleMember)
+	if org == nil {
+		return
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	orgIds, err := model.GetOrganizationTreeIds(org.Id)
+	var quotaNum int64
+	var usages []*model.OrganizationUsage
+	if err == nil {
+		quotaNum, usages, err = model.SumOrganizationUsedQuota(orgIds, startTimestamp, endTimestamp, c.Query("model_name"))
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"quota":     quotaNum,
+			"remaining": org.Quota,
+			"members":   usages,
+		},
+	})
+}
+
 func DeleteHistoryLogs(c *gin.Context) {
 	targetTimestamp, _ := strconv.ParseInt(c.Query("target_timestamp"), 10, 64)
 	if targetTimestamp == 0 {
@@ -0,0 +1,18 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/telemetry"
+)
+
+// Metrics 以 prometheus 格式输出指标，设置了 METRICS_TOKEN 时需要 Bearer 认证
+func Metrics(c *gin.Context) {
+	if config.MetricsToken != "" && c.Request.Header.Get("Authorization") != "Bearer "+config.MetricsToken {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	telemetry.MetricsHandler().ServeHTTP(c.Writer, c.Request)
+}
@@ -2,9 +2,16 @@ package controller
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/message"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/audit"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/errorrule"
+	"github.com/songquanpeng/one-api/relay/guardrail"
+	"github.com/songquanpeng/one-api/relay/moderation"
 	"net/http"
 	"strings"
 
@@ -15,7 +22,7 @@ func GetOptions(c *gin.Context) {
 	var options []*model.Option
 	config.OptionMapRWMutex.Lock()
 	for k, v := range config.OptionMap {
-		if strings.HasSuffix(k, "Token") || strings.HasSuffix(k, "Secret") {
+		if strings.HasSuffix(k, "Token") || strings.HasSuffix(k, "Secret") || model.IsSecretOption(k) {
 			continue
 		}
 		options = append(options, &model.Option{
@@ -51,6 +58,70 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "PoolMode":
+		if !model.IsValidPoolMode(option.Value) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的渠道选择策略",
+			})
+			return
+		}
+	case "ChannelErrorRules":
+		if _, err := errorrule.ParseRules(option.Value); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的错误规则: " + err.Error(),
+			})
+			return
+		}
+	case "NotifyTargets":
+		if _, err := message.ParseNotifyTargets(option.Value); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的通知渠道: " + err.Error(),
+			})
+			return
+		}
+	case "ModelPrice":
+		if _, err := billingratio.ParseModelPrice(option.Value); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的模型计费规则: " + err.Error(),
+			})
+			return
+		}
+	case "AuditRedactionRules":
+		if _, err := audit.ParseRedactionRules(option.Value); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的脱敏规则: " + err.Error(),
+			})
+			return
+		}
+	case "ModerationAction":
+		if option.Value != "block" && option.Value != "flag" {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的审核处理方式",
+			})
+			return
+		}
+	case "ModerationThresholds":
+		if _, err := moderation.ParseThresholds(option.Value); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的审核阈值: " + err.Error(),
+			})
+			return
+		}
+	case "OutputFilterRules":
+		if _, err := guardrail.ParseRuleSets(option.Value); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的输出过滤规则: " + err.Error(),
+			})
+			return
+		}
 	case "GitHubOAuthEnabled":
 		if option.Value == "true" && config.GitHubClientId == "" {
 			c.JSON(http.StatusOK, gin.H{
@@ -98,3 +169,42 @@ func UpdateOption(c *gin.Context) {
 	})
 	return
 }
+
+// TestNotifyTarget sends a test notification to the target in the body, or to the configured target of the name
+func TestNotifyTarget(c *gin.Context) {
+	var target message.NotifyTarget
+	if err := json.NewDecoder(c.Request.Body).Decode(&target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if target.Type == "" {
+		configured := message.GetNotifyTarget(target.Name)
+		if configured == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "通知渠道不存在",
+			})
+			return
+		}
+		target = *configured
+	}
+	err := message.SendNotification(&target, &message.Notification{
+		Event:   message.EventTest,
+		Title:   fmt.Sprintf("%s通知测试", config.SystemName),
+		Content: fmt.Sprintf("这是一条来自%s的测试通知，收到说明通知渠道「%s」配置正确", config.SystemName, target.Name),
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,496 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/message"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// organization invitations are valid for 7 days
+const orgInvitationValidSeconds = 7 * 24 * 60 * 60
+
+func isValidOrgRole(role int) bool {
+	return role == model.OrgRoleMember || role == model.OrgRoleAdmin || role == model.OrgRoleOwner
+}
+
+// getOrgRole returns the role of the current user in the organization, system admins act as owners
+func getOrgRole(c *gin.Context, orgId int) (int, error) {
+	if c.GetInt(ctxkey.Role) >= model.RoleAdminUser {
+		return model.OrgRoleOwner, nil
+	}
+	return model.GetOrganizationRole(orgId, c.GetInt(ctxkey.Id))
+}
+
+// checkOrgRole returns the organization of the id parameter and the role of the current user in it,
+// the response is written when the role is lower than minRole
+func checkOrgRole(c *gin.Context, minRole int) (*model.Organization, int) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err == nil {
+		var org *model.Organization
+		org, err = model.GetOrganizationById(id)
+		if err == nil {
+			var role int
+			role, err = getOrgRole(c, org.Id)
+			if err == nil && role < minRole {
+				err = errors.New("无权进行此操作")
+			}
+			if err == nil {
+				org.Role = role
+				return org, role
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": false,
+		"message": err.Error(),
+	})
+	return nil, 0
+}
+
+// GetOrganizations 返回当前用户所在的组织，系统管理员可以通过 all 参数查看所有组织
+func GetOrganizations(c *gin.Context) {
+	var orgs []*model.Organization
+	var err error
+	if c.Query("all") != "" && c.GetInt(ctxkey.Role) >= model.RoleAdminUser {
+		p, _ := strconv.Atoi(c.Query("p"))
+		if p < 0 {
+			p = 0
+		}
+		orgs, err = model.GetAllOrganizations(p*config.ItemsPerPage, config.ItemsPerPage)
+	} else {
+		orgs, err = model.GetUserOrganizations(c.GetInt(ctxkey.Id))
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    orgs,
+	})
+}
+
+func GetOrganization(c *gin.Context) {
+	org, _ := checkOrgRole(c, model.OrgRoleMember)
+	if org == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    org,
+	})
+}
+
+// CreateOrganization 创建组织，创建下级组织需要是上级组织的管理员
+func CreateOrganization(c *gin.Context) {
+	org := model.Organization{}
+	if err := c.ShouldBindJSON(&org); err != nil || org.Name == "" || len(org.Name) > 50 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if org.ParentId != 0 {
+		role, err := getOrgRole(c, org.ParentId)
+		if err == nil && role < model.OrgRoleAdmin {
+			err = errors.New("无权在该组织下创建下级组织")
+		}
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	if err := model.CreateOrganization(&org, c.GetInt(ctxkey.Id)); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    org,
+	})
+}
+
+func UpdateOrganization(c *gin.Context) {
+	org, _ := checkOrgRole(c, model.OrgRoleAdmin)
+	if org == nil {
+		return
+	}
+	var req model.Organization
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" || len(req.Name) > 50 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	org.Name = req.Name
+	if err := org.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    org,
+	})
+}
+
+func DeleteOrganization(c *gin.Context) {
+	org, _ := checkOrgRole(c, model.OrgRoleOwner)
+	if org == nil {
+		return
+	}
+	if err := model.DeleteOrganization(org.Id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetOrganizationMembers(c *gin.Context) {
+	org, _ := checkOrgRole(c, model.OrgRoleMember)
+	if org == nil {
+		return
+	}
+	members, err := model.GetOrganizationMembers(org.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    members,
+	})
+}
+
+// UpdateOrganizationMember 修改成员的角色，不能授予或修改高于自己的角色，只有所有者能任免管理员
+func UpdateOrganizationMember(c *gin.Context) {
+	org, myRole := checkOrgRole(c, model.OrgRoleAdmin)
+	if org == nil {
+		return
+	}
+	var req model.OrganizationMember
+	if err := c.ShouldBindJSON(&req); err != nil || !isValidOrgRole(req.Role) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	member, err := model.GetOrganizationMember(org.Id, req.UserId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该用户不是组织成员，请通过邀请加入",
+		})
+		return
+	}
+	if myRole < model.OrgRoleOwner && (req.Role >= model.OrgRoleAdmin || member.Role >= model.OrgRoleAdmin) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只有所有者可以任免管理员",
+		})
+		return
+	}
+	if err = model.SetOrganizationMember(org.Id, req.UserId, req.Role); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// RemoveOrganizationMember 移出成员，成员也可以自己退出组织
+func RemoveOrganizationMember(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	minRole := model.OrgRoleAdmin
+	if userId == c.GetInt(ctxkey.Id) {
+		minRole = model.OrgRoleMember
+	}
+	org, myRole := checkOrgRole(c, minRole)
+	if org == nil {
+		return
+	}
+	member, err := model.GetOrganizationMember(org.Id, userId)
+	if err == nil && userId != c.GetInt(ctxkey.Id) && myRole < model.OrgRoleOwner && member.Role >= model.OrgRoleAdmin {
+		err = errors.New("只有所有者可以移出管理员")
+	}
+	if err == nil {
+		err = model.RemoveOrganizationMember(org.Id, userId)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// InviteOrganizationMember 通过邮件邀请成员加入组织
+func InviteOrganizationMember(c *gin.Context) {
+	org, myRole := checkOrgRole(c, model.OrgRoleAdmin)
+	if org == nil {
+		return
+	}
+	var invitation model.OrganizationInvitation
+	err := c.ShouldBindJSON(&invitation)
+	if err == nil {
+		err = common.Validate.Var(invitation.Email, "required,email")
+	}
+	if invitation.Role == 0 {
+		invitation.Role = model.OrgRoleMember
+	}
+	if err != nil || !isValidOrgRole(invitation.Role) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if invitation.Role > myRole || (myRole < model.OrgRoleOwner && invitation.Role >= model.OrgRoleAdmin) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只有所有者可以邀请管理员",
+		})
+		return
+	}
+	invitation.Id = 0
+	invitation.OrgId = org.Id
+	invitation.InviterId = c.GetInt(ctxkey.Id)
+	invitation.Code = common.GenerateVerificationCode(0)
+	invitation.ExpiredTime = helper.GetTimestamp() + orgInvitationValidSeconds
+	if err = model.CreateOrganizationInvitation(&invitation); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	link := fmt.Sprintf("%s/organization/invite?code=%s", config.ServerAddress, invitation.Code)
+	subject := fmt.Sprintf("%s组织邀请", config.SystemName)
+	content := fmt.Sprintf("<p>您好，%s 邀请您加入%s的组织「%s」。</p>"+
+		"<p>登录后点击 <a href='%s'>此处</a> 接受邀请。</p>"+
+		"<p>如果链接无法点击，请尝试点击下面的链接或将其复制到浏览器中打开：<br> %s </p>"+
+		"<p>邀请 %d 天内有效，如果您不认识邀请人，请忽略。</p>",
+		model.GetUsernameById(invitation.InviterId), config.SystemName, org.Name, link, link, orgInvitationValidSeconds/(24*60*60))
+	if err = message.SendEmail(subject, invitation.Email, content); err != nil {
+		_ = model.RevokeOrganizationInvitation(org.Id, invitation.Id)
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    invitation,
+	})
+}
+
+func GetOrganizationInvitations(c *gin.Context) {
+	org, _ := checkOrgRole(c, model.OrgRoleAdmin)
+	if org == nil {
+		return
+	}
+	invitations, err := model.GetOrganizationInvitations(org.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    invitations,
+	})
+}
+
+func RevokeOrganizationInvitation(c *gin.Context) {
+	org, _ := checkOrgRole(c, model.OrgRoleAdmin)
+	if org == nil {
+		return
+	}
+	invitationId, _ := strconv.Atoi(c.Param("invitation_id"))
+	if err := model.RevokeOrganizationInvitation(org.Id, invitationId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// AcceptOrganizationInvitation 当前用户凭邀请码加入组织
+func AcceptOrganizationInvitation(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	invitation, err := model.AcceptOrganizationInvitation(req.Code, c.GetInt(ctxkey.Id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    invitation,
+	})
+}
+
+// TransferOrganizationQuota 管理员把自己的额度转入组织额度池，所有者可以用负数把额度池的额度转回自己
+func TransferOrganizationQuota(c *gin.Context) {
+	org, myRole := checkOrgRole(c, model.OrgRoleAdmin)
+	if org == nil {
+		return
+	}
+	var req struct {
+		Quota int64 `json:"quota"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Quota == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.Quota < 0 && myRole < model.OrgRoleOwner {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只有所有者可以转出组织额度",
+		})
+		return
+	}
+	userId := c.GetInt(ctxkey.Id)
+	if err := model.TransferOrganizationQuota(org.Id, userId, req.Quota); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Quota > 0 {
+		model.RecordLog(userId, model.LogTypeManage, fmt.Sprintf("向组织「%s」转入额度 %s", org.Name, common.LogQuota(req.Quota)))
+	} else {
+		model.RecordLog(userId, model.LogTypeManage, fmt.Sprintf("从组织「%s」转出额度 %s", org.Name, common.LogQuota(-req.Quota)))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// AdjustOrganizationQuota 系统管理员直接增减组织额度池
+func AdjustOrganizationQuota(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req struct {
+		Quota int64 `json:"quota"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Quota == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	org, err := model.GetOrganizationById(id)
+	if err == nil {
+		err = model.AdjustOrganizationQuota(org.Id, c.GetInt(ctxkey.Id), req.Quota)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(c.GetInt(ctxkey.Id), model.LogTypeManage, fmt.Sprintf("管理员调整组织「%s」的额度 %d", org.Name, req.Quota))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// checkOrgToken checks that the current user may issue a token of the organization to the owner of the token,
+// the owner defaults to the current user and must be a member of the organization or of its parents
+func checkOrgToken(c *gin.Context, token *model.Token) error {
+	if token.UserId == 0 {
+		token.UserId = c.GetInt(ctxkey.Id)
+	}
+	role, err := getOrgRole(c, token.OrgId)
+	if err != nil {
+		return err
+	}
+	if role < model.OrgRoleAdmin {
+		return errors.New("只有组织管理员可以签发组织令牌")
+	}
+	memberRole, err := model.GetOrganizationRole(token.OrgId, token.UserId)
+	if err != nil {
+		return err
+	}
+	if memberRole == 0 {
+		return errors.New("令牌所属用户不是组织成员")
+	}
+	return nil
+}
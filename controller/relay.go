@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
@@ -12,10 +13,16 @@ import (
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/telemetry"
 	"github.com/songquanpeng/one-api/middleware"
 	dbmodel "github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/monitor"
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/gemini"
+	"github.com/songquanpeng/one-api/relay/audit"
+	"github.com/songquanpeng/one-api/relay/billing"
 	"github.com/songquanpeng/one-api/relay/controller"
+	"github.com/songquanpeng/one-api/relay/errorrule"
 	"github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
 	"github.com/songquanpeng/one-api/service"
@@ -24,6 +31,17 @@ import (
 // https://platform.openai.com/docs/api-reference/chat
 
 func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
+	// in flight requests, latency and results of the channel are used by the channel selectors and the circuit breaker
+	channelId := c.GetInt(ctxkey.ChannelId)
+	dbmodel.IncreaseChannelInFlight(channelId)
+	defer dbmodel.DecreaseChannelInFlight(channelId)
+	startTime := time.Now()
+	var auditWriter *audit.Writer
+	if auditEnabled(c) {
+		c.Set(ctxkey.AuditEnabled, true)
+		c.Set(ctxkey.AuditRequest, "")
+		auditWriter = audit.StartCapture(c)
+	}
 	var err *model.ErrorWithStatusCode
 	switch relayMode {
 	case relaymode.VideoGenerations:
@@ -40,9 +58,36 @@ func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
 		err = controller.RelayAudioHelper(c, relayMode)
 	case relaymode.Proxy:
 		err = controller.RelayProxyHelper(c, relayMode)
+	case relaymode.AnthropicMessages:
+		err = controller.RelayMessagesHelper(c)
+	case relaymode.GeminiGenerateContent:
+		err = controller.RelayGeminiHelper(c)
+	case relaymode.Responses:
+		err = controller.RelayResponsesHelper(c)
 	default:
 		err = controller.RelayTextHelper(c)
 	}
+	if auditWriter != nil {
+		auditWriter.Stop(c)
+		saveAuditCapture(c, auditWriter, err)
+	}
+	if billing.IsTokenLimitError(err) || (err == nil && c.GetBool(ctxkey.CacheHit)) {
+		// the request never reached the channel
+		dbmodel.ReleaseChannelBreaker(channelId, c.GetString(ctxkey.OriginalModel))
+	} else if err == nil {
+		dbmodel.RecordChannelResult(channelId, c.GetString(ctxkey.OriginalModel), true)
+		dbmodel.RecordChannelRequest(channelId, c.GetInt(ctxkey.ChannelDpmLimit))
+		latency := time.Since(startTime)
+		if firstResponseTime := c.GetTime(ctxkey.FirstResponseTime); firstResponseTime.After(startTime) {
+			latency = firstResponseTime.Sub(startTime)
+		}
+		dbmodel.RecordChannelLatency(channelId, latency.Milliseconds())
+	}
+	statusCode := http.StatusOK
+	if err != nil {
+		statusCode = err.StatusCode
+	}
+	telemetry.RecordRelayRequest(c.GetString(ctxkey.OriginalModel), channelId, c.GetString(ctxkey.Group), statusCode, startTime, c.GetTime(ctxkey.FirstResponseTime))
 	return err
 }
 
@@ -57,7 +102,12 @@ func Relay(c *gin.Context) {
 	userId := c.GetInt(ctxkey.Id)
 	bizErr := relayHelper(c, relayMode)
 	if bizErr == nil {
-		monitor.Emit(channelId, true)
+		return
+	}
+	requestId := c.GetString(helper.RequestIdKey)
+	if billing.IsTokenLimitError(bizErr) {
+		bizErr.Error.Message = service.RenderMessage(bizErr.Error.Message, requestId)
+		renderRelayError(c, relayMode, bizErr)
 		return
 	}
 	lastFailedChannelId := channelId
@@ -69,9 +119,8 @@ func Relay(c *gin.Context) {
 	go func(c *gin.Context) {
 		processChannelRelayError(c, userId, channelId, channelName, tokenName, group, originalModel, channelType, bizErr)
 	}(c.Copy())
-	requestId := c.GetString(helper.RequestIdKey)
 	retryTimes := config.RetryTimes
-	if !shouldRetry(c, bizErr.StatusCode) {
+	if !shouldRetry(c, bizErr) {
 		logger.Errorf(ctx, "relay error happen, status code is %d, won't retry in this case", bizErr.StatusCode)
 		retryTimes = 0
 	}
@@ -82,9 +131,12 @@ func Relay(c *gin.Context) {
 			break
 		}
 		if channel.Id == lastFailedChannelId {
+			// the channel was acquired by the selection, a half open breaker would keep its probe
+			dbmodel.ReleaseChannelBreaker(channel.Id, originalModel)
 			continue
 		}
 		middleware.SetupContextForSelectedChannel(c, channel, originalModel)
+		telemetry.RecordRetry(originalModel, group)
 		requestBody, _ := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 		bizErr = relayHelper(c, relayMode)
@@ -94,11 +146,15 @@ func Relay(c *gin.Context) {
 		channelId := c.GetInt(ctxkey.ChannelId)
 		lastFailedChannelId = channelId
 		channelName := c.GetString(ctxkey.ChannelName)
+		channelType := c.GetInt(ctxkey.Channel)
 		// BUG: bizErr is in race condition
 		go func(c *gin.Context) {
 			processChannelRelayError(c, userId, channelId, channelName, tokenName, group, originalModel, channelType, bizErr)
 		}(c.Copy())
 	}
+	if bizErr != nil && shouldRetry(c, bizErr) {
+		bizErr = relayFallback(c, relayMode, bizErr)
+	}
 	if bizErr != nil {
 		// if bizErr.StatusCode == http.StatusTooManyRequests {
 		// 	bizErr.Error.Message = "The current group was overload, please try again later"
@@ -106,58 +162,151 @@ func Relay(c *gin.Context) {
 
 		// BUG: bizErr is in race condition
 		bizErr.Error.Message = service.RenderMessage(bizErr.Error.Message, requestId)
-		c.JSON(bizErr.StatusCode, gin.H{
-			"error": bizErr.Error,
-		})
+		renderRelayError(c, relayMode, bizErr)
 	}
 }
 
-func shouldRetry(c *gin.Context, statusCode int) bool {
-	if _, ok := c.Get(ctxkey.SpecificChannelId); ok {
-		return false
+// relayFallback relays the request with the substitutes of the requested model, after the channels of the model failed
+func relayFallback(c *gin.Context, relayMode int, bizErr *model.ErrorWithStatusCode) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	group := c.GetString(ctxkey.Group)
+	requestModel := c.GetString(ctxkey.RequestModel)
+	if fallbackFrom := c.GetString(ctxkey.FallbackFrom); fallbackFrom != "" {
+		requestModel = fallbackFrom
 	}
-	if statusCode == http.StatusTooManyRequests {
-		return true
+	chain := dbmodel.GetFallbackChain(group, requestModel)
+	if chain == nil {
+		return bizErr
 	}
-	if statusCode/100 == 5 {
-		return true
+	// the substitutes before the one already served were tried when the channel was selected
+	served := c.GetString(ctxkey.FallbackModel)
+	skip := served != ""
+	for _, substitute := range chain.Models {
+		if skip {
+			skip = substitute != served
+			continue
+		}
+		condition := fallbackCondition(bizErr.StatusCode)
+		if condition == "" || !chain.Allows(condition) {
+			break
+		}
+		channel, err := dbmodel.CacheGetRandomSatisfiedChannel(group, substitute, false)
+		if err != nil {
+			continue
+		}
+		logger.Infof(ctx, "model %s failed with status code %d, fall back to %s", requestModel, bizErr.StatusCode, substitute)
+		middleware.SetupContextForFallback(c, channel, requestModel, substitute)
+		requestBody, _ := common.GetRequestBody(c)
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		bizErr = relayHelper(c, relayMode)
+		if bizErr == nil {
+			return nil
+		}
+		userId := c.GetInt(ctxkey.Id)
+		channelName := c.GetString(ctxkey.ChannelName)
+		tokenName := c.GetString(ctxkey.TokenName)
+		channelType := c.GetInt(ctxkey.Channel)
+		go func(c *gin.Context, bizErr *model.ErrorWithStatusCode) {
+			processChannelRelayError(c, userId, channel.Id, channelName, tokenName, group, substitute, channelType, bizErr)
+		}(c.Copy(), bizErr)
 	}
-	if statusCode == http.StatusBadRequest {
-		return false
+	return bizErr
+}
+
+func fallbackCondition(statusCode int) string {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return dbmodel.FallbackOn429
+	case statusCode >= http.StatusInternalServerError:
+		return dbmodel.FallbackOn5xx
+	}
+	return ""
+}
+
+// renderRelayError answers in the error format of the endpoint the client called
+func renderRelayError(c *gin.Context, relayMode int, bizErr *model.ErrorWithStatusCode) {
+	if relayMode == relaymode.AnthropicMessages {
+		c.JSON(bizErr.StatusCode, anthropic.ErrorResponse{
+			Type: "error",
+			Error: anthropic.Error{
+				Type:    bizErr.Type,
+				Message: bizErr.Message,
+			},
+		})
+		return
 	}
-	if statusCode/100 == 2 {
+	if relayMode == relaymode.GeminiGenerateContent {
+		c.JSON(bizErr.StatusCode, gemini.GeminiErrorResponse{
+			Error: &gemini.Error{
+				Code:    bizErr.StatusCode,
+				Message: bizErr.Message,
+				Status:  gemini.ErrorStatus(bizErr.StatusCode),
+			},
+		})
+		return
+	}
+	c.JSON(bizErr.StatusCode, gin.H{
+		"error": bizErr.Error,
+	})
+}
+
+func shouldRetry(c *gin.Context, bizErr *model.ErrorWithStatusCode) bool {
+	if _, ok := c.Get(ctxkey.SpecificChannelId); ok {
 		return false
 	}
-	return true
+	action, _ := monitor.ErrorAction(c.GetInt(ctxkey.Channel), bizErr)
+	return action != errorrule.ActionFail
 }
 
 func processChannelRelayError(c *gin.Context, userId int, channelId int, channelName string, tokenName string, group string, modelName string, channelType int, err *model.ErrorWithStatusCode) {
 	logger.Errorf(c.Request.Context(), "relay error (channel id %d, user id: %d, token name: %s): %s", channelId, userId, tokenName, err.Message)
 
-	if monitor.ShouldSleepChannel(channelType, &err.Error, err.StatusCode) {
-		var awakeTime int64
-		//gemini的重试
-		delay := c.GetInt("gemini_delay")
-		if delay > 0 {
-			awakeTime = helper.GetTimestamp() + int64(delay)
-		}
-		if awakeTime == 0 {
-			awakeTime = helper.GetTimestamp() + 600
-		}
-		monitor.SleepChannel(channelType, group, modelName, channelId, awakeTime)
-	}
 	if monitor.ShouldDelFile(c, &err.Error) {
 		fileUri := c.GetString("FileUri")
 		if fileUri != "" {
 			monitor.DelFile(channelId, fileUri)
 		}
 	}
-	// https://platform.openai.com/docs/guides/error-codes/api-errors
-	if monitor.ShouldDisableChannel(&err.Error, err.StatusCode, channelId, channelType) {
-		monitor.DisableChannel(channelId, channelName, err.Message)
-	} else {
-		monitor.Emit(channelId, false)
+	action, sleepSeconds := monitor.ChannelErrorAction(channelId, channelType, err)
+	if keyId := c.GetInt(ctxkey.ChannelKeyId); keyId != 0 && action != errorrule.ActionFail {
+		// 多 key 渠道的错误让 key 休眠或禁用，渠道本身不受影响
+		dbmodel.RecordChannelKeyError(channelId, keyId, err.Message)
+		switch action {
+		case errorrule.ActionSleep:
+			if delay := int64(c.GetInt("gemini_delay")); delay > 0 {
+				dbmodel.SleepChannelKey(channelId, keyId, delay, false)
+			} else {
+				dbmodel.SleepChannelKey(channelId, keyId, sleepSeconds, true)
+			}
+			dbmodel.ReleaseChannelBreaker(channelId, modelName)
+			return
+		case errorrule.ActionDisable:
+			if config.AutomaticDisableChannelEnabled {
+				monitor.DisableChannelKey(channelId, channelName, keyId, err.Message)
+			}
+			dbmodel.ReleaseChannelBreaker(channelId, modelName)
+			return
+		}
+	}
+	switch action {
+	case errorrule.ActionFail:
+		// 客户端的错误，不计入渠道的失败
+		dbmodel.ReleaseChannelBreaker(channelId, modelName)
+		return
+	case errorrule.ActionSleep:
+		// gemini 返回的重试时间直接使用，规则的休眠时间随休眠次数指数增长
+		if delay := int64(c.GetInt("gemini_delay")); delay > 0 {
+			monitor.SleepChannel(channelType, modelName, channelId, channelName, delay, false)
+		} else {
+			monitor.SleepChannel(channelType, modelName, channelId, channelName, sleepSeconds, true)
+		}
+	case errorrule.ActionDisable:
+		// https://platform.openai.com/docs/guides/error-codes/api-errors
+		if config.AutomaticDisableChannelEnabled {
+			monitor.DisableChannel(channelId, channelName, err.Message)
+		}
 	}
+	dbmodel.RecordChannelResult(channelId, modelName, false)
 }
 
 func RelayNotImplemented(c *gin.Context) {
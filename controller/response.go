@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/responses/get
+
+func responseNotFound(c *gin.Context, id string) {
+	c.JSON(http.StatusNotFound, gin.H{
+		"error": relaymodel.Error{
+			Message: fmt.Sprintf("Response with id '%s' not found.", id),
+			Type:    "invalid_request_error",
+			Param:   "response_id",
+			Code:    "response_not_found",
+		},
+	})
+}
+
+func GetResponse(c *gin.Context) {
+	id := c.Param("id")
+	response, err := model.GetStoredResponseById(id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		responseNotFound(c, id)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", []byte(response.Response))
+}
+
+func DeleteResponse(c *gin.Context) {
+	id := c.Param("id")
+	if err := model.DeleteStoredResponseById(id, c.GetInt(ctxkey.Id)); err != nil {
+		responseNotFound(c, id)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"object":  "response.deleted",
+		"deleted": true,
+	})
+}
@@ -143,13 +143,25 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	// an admin of an organization issues its tokens to the members, the other tokens belong to the current user
+	userId := c.GetInt(ctxkey.Id)
+	if token.OrgId != 0 {
+		if err = checkOrgToken(c, &token); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		userId = token.UserId
+	}
 
 	currentTime := time.Now()
 	var date = currentTime.Format("0102")
 	var tokens = []model.Token{}
 	if token.BatchNumber == 1 {
 		cleanToken := model.Token{
-			UserId:            c.GetInt(ctxkey.Id),
+			UserId:            userId,
 			Name:              token.Name + "-" + date,
 			Key:               random.GenerateKey(),
 			CreatedTime:       helper.GetTimestamp(),
@@ -164,14 +176,22 @@ func AddToken(c *gin.Context) {
 			DpmLimit:          token.DpmLimit,
 			TpmLimit:          token.TpmLimit,
 			Email:             token.Email,
+			WebhookType:       token.WebhookType,
+			Webhook:           token.Webhook,
 			CustomContact:     token.CustomContact,
 			ModerationsEnable: token.ModerationsEnable,
+			CacheDisabled:     token.CacheDisabled,
+			CacheTTL:          token.CacheTTL,
+			MaxConcurrency:    token.MaxConcurrency,
+			AuditEnabled:      token.AuditEnabled,
+			OutputFilter:      token.OutputFilter,
+			OrgId:             token.OrgId,
 		}
 		tokens = append(tokens, cleanToken)
 	} else {
 		for i := 0; i < token.BatchNumber; i++ {
 			cleanToken := model.Token{
-				UserId:            c.GetInt(ctxkey.Id),
+				UserId:            userId,
 				Name:              token.Name + "-" + date + "-" + strconv.Itoa(i+1),
 				Key:               random.GenerateKey(),
 				CreatedTime:       helper.GetTimestamp(),
@@ -186,8 +206,16 @@ func AddToken(c *gin.Context) {
 				DpmLimit:          token.DpmLimit,
 				TpmLimit:          token.TpmLimit,
 				Email:             token.Email,
+				WebhookType:       token.WebhookType,
+				Webhook:           token.Webhook,
 				CustomContact:     token.CustomContact,
 				ModerationsEnable: token.ModerationsEnable,
+				CacheDisabled:     token.CacheDisabled,
+				CacheTTL:          token.CacheTTL,
+				MaxConcurrency:    token.MaxConcurrency,
+				AuditEnabled:      token.AuditEnabled,
+				OutputFilter:      token.OutputFilter,
+				OrgId:             token.OrgId,
 			}
 			tokens = append(tokens, cleanToken)
 		}
@@ -269,6 +297,8 @@ func UpdateToken(c *gin.Context) {
 			return
 		}
 	}
+	remainQuota := cleanToken.RemainQuota
+	var rechargeQuota int64
 	if statusOnly != "" {
 		cleanToken.Status = token.Status
 		cleanToken.ExhaustedAlert = 0
@@ -277,21 +307,48 @@ func UpdateToken(c *gin.Context) {
 		// If you add more fields, please also update token.Update()
 		cleanToken.Name = token.Name
 		cleanToken.ExpiredTime = token.ExpiredTime
-		cleanToken.RemainQuota = token.RemainQuota
-		cleanToken.UnlimitedQuota = token.UnlimitedQuota
-		cleanToken.HardLimitUsd = cleanToken.UsedQuota + cleanToken.RemainQuota
+		// the quota of a token of an organization is a share of its pool, which only the admins change
+		canChangeQuota := true
+		if cleanToken.OrgId != 0 {
+			role, err := getOrgRole(c, cleanToken.OrgId)
+			canChangeQuota = err == nil && role >= model.OrgRoleAdmin
+		}
+		if canChangeQuota {
+			remainQuota = token.RemainQuota
+			cleanToken.UnlimitedQuota = token.UnlimitedQuota
+		}
+		cleanToken.HardLimitUsd = cleanToken.UsedQuota + remainQuota
 		cleanToken.RpmLimit = token.RpmLimit
 		cleanToken.TpmLimit = token.TpmLimit
 		cleanToken.DpmLimit = token.DpmLimit
 		cleanToken.Email = token.Email
+		cleanToken.Webhook = token.Webhook
+		if token.WebhookType != 0 {
+			cleanToken.WebhookType = token.WebhookType
+		}
 		cleanToken.CustomContact = token.CustomContact
 		cleanToken.ModerationsEnable = token.ModerationsEnable
-		if token.RechargeQuota > 0 {
-			cleanToken.RemainQuota += int64(token.RechargeQuota * 500000)
-			cleanToken.HardLimitUsd += int64(token.RechargeQuota * 500000)
+		cleanToken.CacheDisabled = token.CacheDisabled
+		cleanToken.CacheTTL = token.CacheTTL
+		cleanToken.MaxConcurrency = token.MaxConcurrency
+		cleanToken.AuditEnabled = token.AuditEnabled
+		cleanToken.OutputFilter = token.OutputFilter
+		if token.RechargeQuota > 0 && canChangeQuota {
+			rechargeQuota = int64(token.RechargeQuota * 500000)
+			cleanToken.HardLimitUsd += rechargeQuota
 		}
 	}
-	err = cleanToken.Update()
+	// the remaining quota is changed through the ledger, Update does not write it
+	if remainQuota != cleanToken.RemainQuota {
+		err = cleanToken.SetRemainQuota(remainQuota)
+	}
+	if err == nil && rechargeQuota > 0 {
+		err = cleanToken.Recharge(rechargeQuota)
+	}
+	if err == nil {
+		cleanToken.RemainQuota = remainQuota + rechargeQuota
+		err = cleanToken.Update()
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
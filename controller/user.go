@@ -408,6 +408,9 @@ func UpdateUser(c *gin.Context) {
 		updatedUser.Password = "" // rollback to what it should be
 	}
 	updatePassword := updatedUser.Password != ""
+	// the quota is changed by the difference and written into the ledger, like Updates a zero quota is ignored
+	quota := updatedUser.Quota
+	updatedUser.Quota = 0
 	if err := updatedUser.Update(updatePassword); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -415,7 +418,21 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
-	if originUser.Quota != updatedUser.Quota {
+	updatedUser.Quota = quota
+	if quota != 0 && originUser.Quota != quota {
+		err = model.ApplyQuotaMovement(&model.QuotaMovement{
+			UserId: originUser.Id,
+			Type:   model.LedgerTypeAdminAdjust,
+			Amount: quota - originUser.Quota,
+			Remark: fmt.Sprintf("管理员 %d 修改额度", c.GetInt(ctxkey.Id)),
+		})
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 		model.RecordLog(originUser.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户额度从 %s修改为 %s", common.LogQuota(originUser.Quota), common.LogQuota(updatedUser.Quota)))
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -789,7 +806,19 @@ func AdminTopUp(c *gin.Context) {
 		})
 		return
 	}
-	err = model.IncreaseUserQuota(req.UserId, int64(req.Quota))
+	if req.Quota < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "quota 不能为负数！",
+		})
+		return
+	}
+	err = model.ApplyQuotaMovement(&model.QuotaMovement{
+		UserId: req.UserId,
+		Type:   model.LedgerTypeTopup,
+		Amount: int64(req.Quota),
+		Remark: req.Remark,
+	})
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"os"
@@ -14,6 +15,9 @@ import (
 	"github.com/songquanpeng/one-api/common/client"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/secret"
+	"github.com/songquanpeng/one-api/common/telemetry"
+	"github.com/songquanpeng/one-api/controller"
 	"github.com/songquanpeng/one-api/middleware"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/monitor"
@@ -39,10 +43,22 @@ func main() {
 		logger.SysLog("running in debug mode")
 	}
 
+	if err := secret.Init(); err != nil {
+		logger.FatalLog("failed to initialize the secret master key: " + err.Error())
+	}
+
 	// Initialize SQL Database
 	model.InitDB()
 	model.InitLogDB()
 
+	if *common.RotateSecrets {
+		if _, err := model.RotateSecrets(); err != nil {
+			logger.FatalLog("failed to rotate secrets: " + err.Error())
+		}
+		_ = model.CloseDB()
+		return
+	}
+
 	var err error
 	err = model.CreateRootAccountIfNeed()
 	if err != nil {
@@ -128,15 +144,27 @@ func main() {
 		logger.SysLog("batch update enabled with interval " + strconv.Itoa(config.BatchUpdateInterval) + "s")
 		model.InitBatchUpdater()
 	}
-	if config.EnableMetric {
-		logger.SysLog("metric enabled, will disable channel if too much request failed")
-	}
 	if os.Getenv("AUTO_ACTIVATE_CHANNEL") == "true" {
 		go monitor.AutoActivate(10)
 	}
 	go monitor.AutoDelFile(config.SyncFrequency)
+	go model.CleanExpiredResponseCaches(config.SyncFrequency)
+	// the captures saved as files are local to the node
+	go model.CleanExpiredAuditCaptures(config.SyncFrequency)
+	if config.IsMasterNode {
+		controller.InitBatchWorker()
+		go model.SweepQuotaReservations(60)
+		go model.SyncBudgets(60)
+	}
 	openai.InitTokenEncoders()
 	client.Init()
+	shutdownTracing, err := telemetry.InitTracing(context.Background(), config.TracingEnabled)
+	if err != nil {
+		logger.FatalLog("failed to initialize tracing: " + err.Error())
+	}
+	defer func() {
+		_ = shutdownTracing(context.Background())
+	}()
 
 	if os.Getenv("PPROF_DEBUG") == "true" {
 		pprofAddr := "0.0.0.0:6060"
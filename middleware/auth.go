@@ -93,6 +93,17 @@ func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
 		key := c.Request.Header.Get("Authorization")
+		if key == "" {
+			// claude-native clients send the key in x-api-key
+			key = c.Request.Header.Get("x-api-key")
+		}
+		if key == "" {
+			// gemini-native clients send the key in x-goog-api-key or the key query parameter
+			key = c.Request.Header.Get("x-goog-api-key")
+			if key == "" {
+				key = c.Query("key")
+			}
+		}
 		apiKey := strings.TrimPrefix(key, "Bearer ")
 		c.Set("api_key", apiKey)
 		key = strings.TrimPrefix(apiKey, "sk-")
@@ -140,15 +151,7 @@ func TokenAuth() func(c *gin.Context) {
 				return
 			}
 		}
-		c.Set(ctxkey.Id, token.UserId)
-		c.Set(ctxkey.UserId, token.UserId)
-		c.Set(ctxkey.TokenId, token.Id)
-		c.Set(ctxkey.TokenName, token.Name)
-		c.Set(ctxkey.DpmLimit, token.DpmLimit)
-		c.Set(ctxkey.RpmLimit, token.RpmLimit)
-		c.Set(ctxkey.TpmLimit, token.TpmLimit)
-		c.Set(ctxkey.CustomContact, token.CustomContact)
-		c.Set(ctxkey.ModerationsEnable, token.ModerationsEnable)
+		SetupContextForToken(c, token)
 
 		if len(parts) > 1 {
 			if model.IsAdmin(token.UserId) {
@@ -168,6 +171,24 @@ func TokenAuth() func(c *gin.Context) {
 	}
 }
 
+// SetupContextForToken sets the limits and the options of the token which the relay reads from the context
+func SetupContextForToken(c *gin.Context, token *model.Token) {
+	c.Set(ctxkey.Id, token.UserId)
+	c.Set(ctxkey.UserId, token.UserId)
+	c.Set(ctxkey.TokenId, token.Id)
+	c.Set(ctxkey.TokenName, token.Name)
+	c.Set(ctxkey.DpmLimit, token.DpmLimit)
+	c.Set(ctxkey.RpmLimit, token.RpmLimit)
+	c.Set(ctxkey.TpmLimit, token.TpmLimit)
+	c.Set(ctxkey.CustomContact, token.CustomContact)
+	c.Set(ctxkey.ModerationsEnable, token.ModerationsEnable)
+	c.Set(ctxkey.CacheDisabled, token.CacheDisabled)
+	c.Set(ctxkey.CacheTTL, token.CacheTTL)
+	c.Set(ctxkey.AuditEnabled, token.AuditEnabled)
+	c.Set(ctxkey.OutputFilter, token.OutputFilter)
+	c.Set(ctxkey.OrgId, token.OrgId)
+}
+
 func shouldCheckModel(c *gin.Context) bool {
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/completions") {
 		return true
@@ -175,6 +196,15 @@ func shouldCheckModel(c *gin.Context) bool {
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/chat/completions") {
 		return true
 	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/messages") {
+		return true
+	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/") {
+		return true
+	}
+	if c.Request.URL.Path == "/v1/responses" {
+		return true
+	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/images") {
 		return true
 	}
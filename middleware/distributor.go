@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -9,8 +10,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/telemetry"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/channeltype"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ModelRequest struct {
@@ -23,52 +26,90 @@ func Distribute() func(c *gin.Context) {
 		userGroup, _ := model.CacheGetUserGroup(userId)
 		c.Set(ctxkey.Group, userGroup)
 		requestModel := c.GetString(ctxkey.RequestModel)
-		var channel *model.Channel
-		channelId, ok := c.Get(ctxkey.SpecificChannelId)
-		if ok {
-			id, err := strconv.Atoi(channelId.(string))
-			if err != nil {
-				abortWithMessage(c, http.StatusBadRequest, "Invalid channel ID", true)
-				return
-			}
-			channel, err = model.GetChannelById(id, true)
-			if err != nil {
-				abortWithMessage(c, http.StatusBadRequest, "Invalid channel ID", true)
-				return
-			}
-			if channel.Status != model.ChannelStatusEnabled {
-				abortWithMessage(c, http.StatusForbidden, "The channel has been disabled", true)
-				return
-			}
+		_, span := telemetry.StartSpan(c.Request.Context(), "Distribute",
+			attribute.String("group", userGroup),
+			attribute.String("model", requestModel),
+		)
+		channel := selectChannel(c, userGroup, requestModel)
+		if channel == nil {
+			telemetry.EndSpan(span, errors.New("no channel is available"))
+			return
+		}
+		span.SetAttributes(attribute.Int("channel_id", channel.Id))
+		span.End()
+		if fallbackModel := c.GetString(ctxkey.FallbackModel); fallbackModel != "" {
+			SetupContextForFallback(c, channel, requestModel, fallbackModel)
 		} else {
-			var err error
-			channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, requestModel, false)
-			if err != nil {
-				message := fmt.Sprintf("The model `%s` was overload, please try again later", requestModel)
-				if channel != nil {
-					logger.SysError(fmt.Sprintf("Channel does not exist: %d", channel.Id))
-					message = "Database consistency has been broken, please contact the administrator"
-				}
-				abortWithMessage(c, http.StatusServiceUnavailable, message, false)
-				return
-			}
+			SetupContextForSelectedChannel(c, channel, requestModel)
 		}
-		SetupContextForSelectedChannel(c, channel, requestModel)
 		c.Next()
 	}
 }
 
+// selectChannel returns the channel specified by the admin or a random satisfied channel, nil when the request is aborted
+func selectChannel(c *gin.Context, userGroup string, requestModel string) *model.Channel {
+	var channel *model.Channel
+	channelId, ok := c.Get(ctxkey.SpecificChannelId)
+	if ok {
+		id, err := strconv.Atoi(channelId.(string))
+		if err != nil {
+			abortWithMessage(c, http.StatusBadRequest, "Invalid channel ID", true)
+			return nil
+		}
+		channel, err = model.GetChannelById(id, true)
+		if err != nil {
+			abortWithMessage(c, http.StatusBadRequest, "Invalid channel ID", true)
+			return nil
+		}
+		if channel.Status != model.ChannelStatusEnabled {
+			abortWithMessage(c, http.StatusForbidden, "The channel has been disabled", true)
+			return nil
+		}
+	} else {
+		var err error
+		channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, requestModel, false)
+		if err != nil && channel == nil {
+			if fallback := selectFallbackChannel(c, userGroup, requestModel); fallback != nil {
+				return fallback
+			}
+		}
+		if err != nil {
+			message := fmt.Sprintf("The model `%s` was overload, please try again later", requestModel)
+			if channel != nil {
+				logger.SysError(fmt.Sprintf("Channel does not exist: %d", channel.Id))
+				message = "Database consistency has been broken, please contact the administrator"
+			}
+			abortWithMessage(c, http.StatusServiceUnavailable, message, false)
+			return nil
+		}
+	}
+	return channel
+}
+
 func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) {
-	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
+	key := channel.Key
+	c.Set(ctxkey.ChannelKeyId, 0)
+	c.Set(ctxkey.ChannelKeyIndex, 0)
+	if channelKey := channel.SelectKey(); channelKey != nil {
+		key = channelKey.Key
+		c.Set(ctxkey.ChannelKeyId, channelKey.Id)
+		c.Set(ctxkey.ChannelKeyIndex, channelKey.Index)
+	}
+	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
 	c.Set(ctxkey.RequestStartTime, time.Now())
 	c.Set(ctxkey.Channel, channel.Type)
 	c.Set(ctxkey.ChannelId, channel.Id)
 	c.Set(ctxkey.CalcPrompt, *channel.CalcPrompt)
 	c.Set(ctxkey.ChannelName, channel.Name)
+	c.Set(ctxkey.ChannelTpmLimit, channel.TpmLimit)
+	c.Set(ctxkey.ChannelDpmLimit, channel.DpmLimit)
 	if channel.SystemPrompt != nil && *channel.SystemPrompt != "" {
 		c.Set(ctxkey.SystemPrompt, *channel.SystemPrompt)
 	}
 	c.Set(ctxkey.ModelMapping, channel.GetModelMapping())
+	if fallbackModel := c.GetString(ctxkey.FallbackModel); fallbackModel != "" && fallbackModel == modelName {
+		c.Set(ctxkey.ModelMapping, fallbackModelMapping(channel, c.GetString(ctxkey.FallbackFrom), fallbackModel))
+	}
 	c.Set(ctxkey.OriginalModel, modelName) // for retry
 	c.Set(ctxkey.BaseURL, channel.GetBaseURL())
 	cfg, _ := channel.LoadConfig()
@@ -99,3 +140,43 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	}
 	c.Set(ctxkey.Config, cfg)
 }
+
+// SetupContextForFallback sets up a channel of a substitute model, the requested model is mapped to the substitute,
+// so the relay sends and bills the substitute whatever the endpoint
+func SetupContextForFallback(c *gin.Context, channel *model.Channel, requestModel string, substitute string) {
+	c.Set(ctxkey.FallbackFrom, requestModel)
+	c.Set(ctxkey.FallbackModel, substitute)
+	c.Writer.Header().Set("X-Served-Model", substitute)
+	SetupContextForSelectedChannel(c, channel, substitute)
+}
+
+func fallbackModelMapping(channel *model.Channel, requestModel string, substitute string) map[string]string {
+	modelMapping := make(map[string]string)
+	for from, to := range channel.GetModelMapping() {
+		modelMapping[from] = to
+	}
+	actualModel := substitute
+	if mapped, ok := modelMapping[substitute]; ok && mapped != "" {
+		actualModel = mapped
+	}
+	modelMapping[requestModel] = actualModel
+	return modelMapping
+}
+
+// selectFallbackChannel returns a channel of the first substitute of the model which has one, the substitute is kept in the context
+func selectFallbackChannel(c *gin.Context, userGroup string, requestModel string) *model.Channel {
+	chain := model.GetFallbackChain(userGroup, requestModel)
+	if chain == nil || !chain.Allows(model.FallbackOnNoChannel) {
+		return nil
+	}
+	for _, substitute := range chain.Models {
+		channel, err := model.CacheGetRandomSatisfiedChannel(userGroup, substitute, false)
+		if err != nil {
+			continue
+		}
+		logger.Infof(c.Request.Context(), "no channel for model %s, fall back to %s", requestModel, substitute)
+		c.Set(ctxkey.FallbackModel, substitute)
+		return channel
+	}
+	return nil
+}
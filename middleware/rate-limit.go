@@ -11,6 +11,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 )
 
@@ -156,3 +157,32 @@ func UploadRateLimit() func(c *gin.Context) {
 func RalayRPMRateLimit() func(c *gin.Context) {
 	return rateLimitFactory(config.RalayRateLimitNum, config.RalayRateLimitDuration, "RALAY")
 }
+
+// RelayDPMRateLimit limits the requests per day of a token, the window is reset at 00:00 UTC
+func RelayDPMRateLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		dpm := c.GetInt(ctxkey.DpmLimit)
+		if dpm <= 0 || strings.HasPrefix(c.Request.URL.RawQuery, "retry=") {
+			c.Next()
+			return
+		}
+		tokenId := c.GetInt(ctxkey.TokenId)
+		key := fmt.Sprintf("DPM_%d", tokenId)
+		used, start, ok := common.WindowReserve(key, 1, int64(dpm), 24*60*60)
+		c.Writer.Header().Set("X-Ratelimit-Limit-Requests-Day", strconv.Itoa(dpm))
+		c.Writer.Header().Set("X-Ratelimit-Remaining-Requests-Day", strconv.FormatInt(max(int64(dpm)-used, 0), 10))
+		c.Writer.Header().Set("X-Ratelimit-Reset-Requests-Day", fmt.Sprintf("%ds", start+24*60*60-time.Now().Unix()))
+		if !ok {
+			apiKey := strings.TrimPrefix(c.GetString("api_key"), "sk-")
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": gin.H{
+					"message": helper.GetCustomReturnError(c, fmt.Sprintf("Rate limit reached in api-key %s on requests per day (RPD): Limit %d, Used %d, Requested 1", helper.EncryptKey(apiKey), dpm, used)).Error(),
+					"type":    "guoguo_api_error",
+				},
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts the span of a relay request, continuing the trace of the client
+func Tracing() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		ctx := telemetry.ExtractHeaders(c.Request.Context(), c.Request.Header)
+		ctx, span := telemetry.StartSpan(ctx, c.Request.Method+" "+c.FullPath(),
+			attribute.String("http.method", c.Request.Method),
+			attribute.String("http.route", c.FullPath()),
+			attribute.String("request_id", c.GetString(helper.RequestIdKey)),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(trace.ContextWithSpan(ctx, span))
+		c.Next()
+		span.SetAttributes(
+			attribute.Int("http.status_code", c.Writer.Status()),
+			attribute.String("model", c.GetString(ctxkey.OriginalModel)),
+			attribute.Int("channel_id", c.GetInt(ctxkey.ChannelId)),
+			attribute.Int("user_id", c.GetInt(ctxkey.Id)),
+		)
+		if c.Writer.Status() >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+}
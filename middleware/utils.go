@@ -47,6 +47,11 @@ func getRequestModel(c *gin.Context) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("common.UnmarshalBodyReusable failed: %w", err)
 	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/") {
+		// gemini-native requests carry the model in the path: /v1beta/models/{model}:{action}
+		modelAction := strings.TrimPrefix(c.Request.URL.Path, "/v1beta/models/")
+		modelRequest.Model, _, _ = strings.Cut(modelAction, ":")
+	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/moderations") {
 		if modelRequest.Model == "" {
 			modelRequest.Model = "text-moderation-stable"
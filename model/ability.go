@@ -16,7 +16,6 @@ type Ability struct {
 }
 
 func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority bool) (*Channel, error) {
-	ability := Ability{}
 	groupCol := "`group`"
 	trueVal := "1"
 	if common.UsingPostgreSQL {
@@ -26,24 +25,40 @@ func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority b
 
 	var err error = nil
 	var channelQuery *gorm.DB
+	// 过滤掉当前模型休眠中或者熔断中的渠道，以及 TPM 或 DPM 已经用满的渠道
+	unavailableIds := append(GetUnavailableChannelIds(model), GetSaturatedChannelIds()...)
+	satisfied := func() *gorm.DB {
+		query := DB.Model(&Ability{}).Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
+		if len(unavailableIds) > 0 {
+			query = query.Where("channel_id NOT IN ?", unavailableIds)
+		}
+		return query
+	}
 	if ignoreFirstPriority {
-		channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
+		channelQuery = satisfied()
 	} else {
-		maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
-		channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = (?)", group, model, maxPrioritySubQuery)
+		maxPrioritySubQuery := satisfied().Select("MAX(priority)")
+		channelQuery = satisfied().Where("priority = (?)", maxPrioritySubQuery)
 	}
-	if common.UsingSQLite || common.UsingPostgreSQL {
-		err = channelQuery.Order("RANDOM()").First(&ability).Error
-	} else {
-		err = channelQuery.Order("RAND()").First(&ability).Error
+	var channelIds []int
+	if err = channelQuery.Model(&Ability{}).Pluck("channel_id", &channelIds).Error; err != nil {
+		return nil, err
 	}
-	if err != nil {
+	var channels []*Channel
+	if err = DB.Where("id IN ?", channelIds).Order("id").Find(&channels).Error; err != nil {
 		return nil, err
 	}
-	channel := Channel{}
-	channel.Id = ability.ChannelId
-	err = DB.First(&channel, "id = ?", ability.ChannelId).Error
-	return &channel, err
+	// 多 key 渠道的 key 都被禁用或者休眠中时跳过
+	var candidates []*Channel
+	for _, channel := range channels {
+		if channel.HasAvailableKey() {
+			candidates = append(candidates, channel)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return GetChannelSelector(group).Select(group, model, candidates), nil
 }
 
 func (channel *Channel) AddAbilities() error {
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// AuditCapture 是一次请求的审计记录，包括发往上游的请求和返回给用户的响应，内容已经脱敏
+type AuditCapture struct {
+	Id          int64  `json:"id"`
+	RequestId   string `json:"request_id" gorm:"type:varchar(64);index"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	TokenId     int    `json:"token_id"`
+	ChannelId   int    `json:"channel_id"`
+	ModelName   string `json:"model_name"`
+	StatusCode  int    `json:"status_code"`
+	Request     string `json:"request"`
+	Response    string `json:"response"`
+	Error       string `json:"error"`
+	Truncated   bool   `json:"truncated"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint;index"`
+}
+
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// the files are saved as AuditDir/20060102/<request id>-<nano time>.json, so the retention removes whole days
+func auditFileDir(t time.Time) string {
+	return filepath.Join(config.AuditDir, t.Format("20060102"))
+}
+
+// RecordAuditCapture saves the capture into LOG_DB or the filesystem, depending on AUDIT_STORAGE
+func RecordAuditCapture(capture *AuditCapture) error {
+	capture.CreatedTime = helper.GetTimestamp()
+	if config.AuditStorage != "file" {
+		return LOG_DB.Create(capture).Error
+	}
+	if !requestIdPattern.MatchString(capture.RequestId) {
+		return errors.New("invalid request id")
+	}
+	now := time.Now()
+	dir := auditFileDir(now)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	capture.Id = now.UnixNano()
+	data, err := json.Marshal(capture)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, fmt.Sprintf("%s-%d.json", capture.RequestId, capture.Id)), data, 0640)
+}
+
+// GetAuditCaptures 返回请求的审计记录，重试的请求有多条
+func GetAuditCaptures(requestId string) ([]*AuditCapture, error) {
+	if !requestIdPattern.MatchString(requestId) {
+		return nil, errors.New("invalid request id")
+	}
+	captures := make([]*AuditCapture, 0)
+	if config.AuditStorage != "file" {
+		err := LOG_DB.Where("request_id = ?", requestId).Order("id").Find(&captures).Error
+		return captures, err
+	}
+	files, err := filepath.Glob(filepath.Join(config.AuditDir, "*", requestId+"-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		capture := &AuditCapture{}
+		if err := json.Unmarshal(data, capture); err != nil {
+			return nil, err
+		}
+		captures = append(captures, capture)
+	}
+	sort.Slice(captures, func(i, j int) bool {
+		return captures[i].Id < captures[j].Id
+	})
+	return captures, nil
+}
+
+// deleteExpiredAuditCaptures deletes the captures older than AuditRetentionDays
+func deleteExpiredAuditCaptures() (int64, error) {
+	if config.AuditRetentionDays <= 0 {
+		return 0, nil
+	}
+	deadline := time.Now().AddDate(0, 0, -config.AuditRetentionDays)
+	if config.AuditStorage != "file" {
+		result := LOG_DB.Where("created_time < ?", deadline.Unix()).Delete(&AuditCapture{})
+		return result.RowsAffected, result.Error
+	}
+	entries, err := os.ReadDir(config.AuditDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var deleted int64
+	for _, entry := range entries {
+		day, err := time.ParseInLocation("20060102", entry.Name(), time.Local)
+		// a day is removed once all of its captures are expired
+		if !entry.IsDir() || err != nil || day.AddDate(0, 0, 1).After(deadline) {
+			continue
+		}
+		dir := filepath.Join(config.AuditDir, entry.Name())
+		files, _ := os.ReadDir(dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return deleted, err
+		}
+		deleted += int64(len(files))
+	}
+	return deleted, nil
+}
+
+// CleanExpiredAuditCaptures 每 frequency 秒删除过期的审计记录
+func CleanExpiredAuditCaptures(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		deleted, err := deleteExpiredAuditCaptures()
+		if err != nil {
+			logger.SysError("failed to delete expired audit captures: " + err.Error())
+			continue
+		}
+		if deleted > 0 {
+			logger.SysLogf("deleted %d expired audit captures", deleted)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditCaptureFileStorage(t *testing.T) {
+	oldStorage, oldDir := config.AuditStorage, config.AuditDir
+	config.AuditStorage, config.AuditDir = "file", t.TempDir()
+	defer func() { config.AuditStorage, config.AuditDir = oldStorage, oldDir }()
+
+	assert.Nil(t, RecordAuditCapture(&AuditCapture{RequestId: "2024abc", Request: "a"}))
+	assert.Nil(t, RecordAuditCapture(&AuditCapture{RequestId: "2024abc", Request: "b"}))
+	assert.Nil(t, RecordAuditCapture(&AuditCapture{RequestId: "2024def", Request: "c"}))
+	assert.NotNil(t, RecordAuditCapture(&AuditCapture{RequestId: "../x"}))
+	captures, err := GetAuditCaptures("2024abc")
+	assert.Nil(t, err)
+	assert.Len(t, captures, 2)
+	assert.Equal(t, "a", captures[0].Request)
+	_, err = GetAuditCaptures("../../etc")
+	assert.NotNil(t, err)
+
+	// only the days older than the retention are removed
+	expired := filepath.Join(config.AuditDir, time.Now().AddDate(0, 0, -config.AuditRetentionDays-2).Format("20060102"))
+	assert.Nil(t, os.MkdirAll(expired, 0750))
+	assert.Nil(t, os.WriteFile(filepath.Join(expired, "old-1.json"), []byte("{}"), 0640))
+	deleted, err := deleteExpiredAuditCaptures()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+	captures, err = GetAuditCaptures("2024abc")
+	assert.Nil(t, err)
+	assert.Len(t, captures, 2)
+}
@@ -0,0 +1,208 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+	"gorm.io/gorm"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+const (
+	BatchRequestStatusPending = 0
+	BatchRequestStatusDone    = 1
+	BatchRequestStatusFailed  = 2
+)
+
+// Batch is a batch of the /v1/batches api, its requests are executed offline by the batch worker
+type Batch struct {
+	Id               string `json:"id" gorm:"primaryKey;type:varchar(64)"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	TokenId          int    `json:"token_id" gorm:"index"`
+	Endpoint         string `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string `json:"status" gorm:"type:varchar(16);index"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64);default:''"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64);default:''"`
+	Errors           string `json:"errors"`   // json array of the validation errors
+	Metadata         string `json:"metadata"` // json object
+	TotalCount       int    `json:"total_count" gorm:"default:0"`
+	CompletedCount   int    `json:"completed_count" gorm:"default:0"`
+	FailedCount      int    `json:"failed_count" gorm:"default:0"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64  `json:"in_progress_at" gorm:"bigint;default:0"`
+	FinalizingAt     int64  `json:"finalizing_at" gorm:"bigint;default:0"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint;default:0"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint;default:0"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint;default:0"`
+	ExpiredAt        int64  `json:"expired_at" gorm:"bigint;default:0"`
+	CancellingAt     int64  `json:"cancelling_at" gorm:"bigint;default:0"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint;default:0"`
+}
+
+// BatchRequest is one line of the input file of a batch, the results are kept here
+// until the batch is finalized, so that a batch can resume where it stopped after a restart
+type BatchRequest struct {
+	Id       int    `json:"id"`
+	BatchId  string `json:"batch_id" gorm:"type:varchar(64);index"`
+	Line     int    `json:"line"`
+	CustomId string `json:"custom_id" gorm:"type:varchar(255)"`
+	Body     string `json:"body"`
+	Status   int    `json:"status" gorm:"default:0;index"`
+	Result   string `json:"result"` // the line written to the output or error file
+}
+
+func (batch *Batch) Insert() error {
+	batch.Id = "batch_" + random.GetUUID()
+	batch.Status = BatchStatusValidating
+	batch.CreatedAt = helper.GetTimestamp()
+	return DB.Create(batch).Error
+}
+
+func GetBatchById(id string, userId int) (*Batch, error) {
+	if id == "" {
+		return nil, errors.New("id 为空！")
+	}
+	var batch Batch
+	err := DB.Where("id = ? AND user_id = ?", id, userId).First(&batch).Error
+	return &batch, err
+}
+
+// GetUserBatches lists the batches of a user, newest first, after is the id of the last batch of the previous page
+func GetUserBatches(userId int, after string, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	query := DB.Where("user_id = ?", userId)
+	if after != "" {
+		var last Batch
+		if err := DB.Select("created_at").Where("id = ? AND user_id = ?", after, userId).First(&last).Error; err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at < ?", last.CreatedAt)
+	}
+	err := query.Order("created_at desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// GetUnfinishedBatches returns the batches the worker still has to take care of
+func GetUnfinishedBatches() ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status IN ?", []string{BatchStatusValidating, BatchStatusInProgress, BatchStatusFinalizing, BatchStatusCancelling}).
+		Order("created_at").Find(&batches).Error
+	return batches, err
+}
+
+// UpdateBatchStatus moves the batch to status if it is still in one of from, it reports whether the batch was updated
+func UpdateBatchStatus(id string, from []string, status string, fields map[string]any) (bool, error) {
+	updates := map[string]any{"status": status}
+	for key, value := range fields {
+		updates[key] = value
+	}
+	result := DB.Model(&Batch{}).Where("id = ? AND status IN ?", id, from).Updates(updates)
+	return result.RowsAffected > 0, result.Error
+}
+
+// StartBatch saves the requests parsed from the input file and moves the batch to in_progress,
+// the requests of an earlier attempt interrupted by a restart are replaced
+func StartBatch(batch *Batch, requests []*BatchRequest) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("batch_id = ?", batch.Id).Delete(&BatchRequest{}).Error; err != nil {
+			return err
+		}
+		if len(requests) > 0 {
+			if err := tx.CreateInBatches(requests, 100).Error; err != nil {
+				return err
+			}
+		}
+		// a batch cancelled meanwhile stays cancelling, the worker then finalizes it
+		return tx.Model(&Batch{}).Where("id = ? AND status = ?", batch.Id, BatchStatusValidating).Updates(map[string]any{
+			"status":         BatchStatusInProgress,
+			"total_count":    len(requests),
+			"in_progress_at": helper.GetTimestamp(),
+		}).Error
+	})
+}
+
+func GetPendingBatchRequests(batchId string, limit int) ([]*BatchRequest, error) {
+	var requests []*BatchRequest
+	err := DB.Where("batch_id = ? AND status = ?", batchId, BatchRequestStatusPending).Order("line").Limit(limit).Find(&requests).Error
+	return requests, err
+}
+
+func CountPendingBatchRequests(batchId string) (int64, error) {
+	var count int64
+	err := DB.Model(&BatchRequest{}).Where("batch_id = ? AND status = ?", batchId, BatchRequestStatusPending).Count(&count).Error
+	return count, err
+}
+
+// FinishBatchRequest records the result of a request and counts it on its batch
+func FinishBatchRequest(request *BatchRequest, status int, result string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		updated := tx.Model(&BatchRequest{}).Where("id = ? AND status = ?", request.Id, BatchRequestStatusPending).
+			Updates(map[string]any{"status": status, "result": result})
+		if updated.Error != nil || updated.RowsAffected == 0 {
+			return updated.Error
+		}
+		column := "completed_count"
+		if status == BatchRequestStatusFailed {
+			column = "failed_count"
+		}
+		return tx.Model(&Batch{}).Where("id = ?", request.BatchId).Update(column, gorm.Expr(column+" + 1")).Error
+	})
+}
+
+// GetFinishedBatchResults returns the results of the finished requests in the order of the input file
+func GetFinishedBatchResults(batchId string) ([]*BatchRequest, error) {
+	var requests []*BatchRequest
+	err := DB.Select("id", "line", "status", "result").Where("batch_id = ? AND status <> ?", batchId, BatchRequestStatusPending).
+		Order("line").Find(&requests).Error
+	return requests, err
+}
+
+// FinalizeBatch saves the output and error files and closes the batch, the requests are not needed anymore
+func FinalizeBatch(batch *Batch, status string, outputFile *UserFile, errorFile *UserFile) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		now := helper.GetTimestamp()
+		updates := map[string]any{"status": status}
+		switch status {
+		case BatchStatusCompleted:
+			updates["completed_at"] = now
+		case BatchStatusCancelled:
+			updates["cancelled_at"] = now
+		case BatchStatusExpired:
+			updates["expired_at"] = now
+		}
+		for _, file := range []*UserFile{outputFile, errorFile} {
+			if file == nil {
+				continue
+			}
+			file.Id = "file-" + random.GetUUID()
+			file.Bytes = int64(len(file.Content))
+			file.CreatedAt = now
+			if err := tx.Create(file).Error; err != nil {
+				return err
+			}
+		}
+		if outputFile != nil {
+			updates["output_file_id"] = outputFile.Id
+		}
+		if errorFile != nil {
+			updates["error_file_id"] = errorFile.Id
+		}
+		if err := tx.Model(&Batch{}).Where("id = ?", batch.Id).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Where("batch_id = ?", batch.Id).Delete(&BatchRequest{}).Error
+	})
+}
@@ -0,0 +1,247 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (state BreakerState) String() string {
+	switch state {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker 是某个渠道某个模型的熔断器，状态只保存在当前节点
+type circuitBreaker struct {
+	sync.Mutex
+	state     BreakerState
+	failures  []int64 // 窗口内失败的时间
+	openedAt  int64
+	probes    int   // 半开状态下已放行的探测请求
+	successes int   // 半开状态下成功的探测请求
+	probedAt  int64 // 最近一次放行探测请求的时间
+}
+
+var circuitBreakers sync.Map // channel id:model -> *circuitBreaker
+
+func getCircuitBreaker(channelId int, model string) *circuitBreaker {
+	value, _ := circuitBreakers.LoadOrStore(fmt.Sprintf("%d:%s", channelId, model), &circuitBreaker{})
+	return value.(*circuitBreaker)
+}
+
+// refresh moves an open breaker to half open once the open time is over,
+// and gives back the probes of a half open breaker which never reported their result
+func (b *circuitBreaker) refresh(now int64) {
+	openSeconds := int64(config.CircuitBreakerOpenSeconds)
+	switch b.state {
+	case BreakerOpen:
+		if now-b.openedAt >= openSeconds {
+			b.state = BreakerHalfOpen
+			b.probes = 0
+			b.successes = 0
+		}
+	case BreakerHalfOpen:
+		if b.probes >= config.CircuitBreakerHalfOpenProbes && now-b.probedAt >= openSeconds {
+			b.probes = b.successes
+		}
+	}
+}
+
+func (b *circuitBreaker) available(now int64) bool {
+	b.Lock()
+	defer b.Unlock()
+	b.refresh(now)
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return b.probes < config.CircuitBreakerHalfOpenProbes
+	}
+	return true
+}
+
+func (b *circuitBreaker) acquire(now int64) {
+	b.Lock()
+	defer b.Unlock()
+	b.refresh(now)
+	if b.state == BreakerHalfOpen {
+		b.probes++
+		b.probedAt = now
+	}
+}
+
+func (b *circuitBreaker) release() {
+	b.Lock()
+	defer b.Unlock()
+	if b.state == BreakerHalfOpen && b.probes > b.successes {
+		b.probes--
+	}
+}
+
+// record counts the result of a request, it reports whether the breaker has just opened
+func (b *circuitBreaker) record(success bool, now int64) bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		if success {
+			return false
+		}
+		windowStart := now - int64(config.CircuitBreakerWindowSeconds)
+		failures := b.failures[:0]
+		for _, failedAt := range b.failures {
+			if failedAt > windowStart {
+				failures = append(failures, failedAt)
+			}
+		}
+		b.failures = append(failures, now)
+		if len(b.failures) >= config.CircuitBreakerThreshold {
+			b.state = BreakerOpen
+			b.openedAt = now
+			b.failures = nil
+			return true
+		}
+	case BreakerHalfOpen:
+		if !success {
+			b.state = BreakerOpen
+			b.openedAt = now
+			return true
+		}
+		b.successes++
+		if b.successes >= config.CircuitBreakerHalfOpenProbes {
+			b.state = BreakerClosed
+			b.failures = nil
+		}
+	}
+	// an open breaker ignores the requests started before it opened
+	return false
+}
+
+// IsChannelBreakerAvailable 判断渠道模型的熔断器是否放行请求
+func IsChannelBreakerAvailable(channelId int, model string) bool {
+	if !config.CircuitBreakerEnabled {
+		return true
+	}
+	return getCircuitBreaker(channelId, model).available(helper.GetTimestamp())
+}
+
+// AcquireChannelBreaker 在选中渠道后调用，半开状态下占用一个探测名额
+func AcquireChannelBreaker(channelId int, model string) {
+	if !config.CircuitBreakerEnabled {
+		return
+	}
+	getCircuitBreaker(channelId, model).acquire(helper.GetTimestamp())
+}
+
+// ReleaseChannelBreaker 归还探测名额，用于不能说明渠道好坏的结果，例如客户端的错误
+func ReleaseChannelBreaker(channelId int, model string) {
+	if !config.CircuitBreakerEnabled {
+		return
+	}
+	getCircuitBreaker(channelId, model).release()
+}
+
+// RecordChannelResult 记录请求的结果，失败次数达到阈值或者探测失败时熔断
+func RecordChannelResult(channelId int, model string, success bool) {
+	if !config.CircuitBreakerEnabled {
+		return
+	}
+	if getCircuitBreaker(channelId, model).record(success, helper.GetTimestamp()) {
+		logger.SysLogf("渠道 #%d , model - [%s] 已熔断 %d 秒", channelId, model, config.CircuitBreakerOpenSeconds)
+	}
+}
+
+// GetUnavailableChannelIds 返回模型休眠中或者熔断中的渠道
+func GetUnavailableChannelIds(model string) []int {
+	ids := GetSleepingChannelIds(model)
+	if !config.CircuitBreakerEnabled {
+		return ids
+	}
+	now := helper.GetTimestamp()
+	circuitBreakers.Range(func(key, value any) bool {
+		channelId, breakerModel, _ := strings.Cut(key.(string), ":")
+		if breakerModel != model || value.(*circuitBreaker).available(now) {
+			return true
+		}
+		if id, err := strconv.Atoi(channelId); err == nil {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	return ids
+}
+
+type ChannelBreaker struct {
+	ChannelId int    `json:"channel_id"`
+	Model     string `json:"model"`
+	State     string `json:"state"`
+	Failures  int    `json:"failures"`
+	OpenedAt  int64  `json:"opened_at"`
+}
+
+// GetChannelBreakers 返回当前节点所有没有关闭的熔断器，以及窗口内有失败的熔断器
+func GetChannelBreakers() []*ChannelBreaker {
+	now := helper.GetTimestamp()
+	breakers := make([]*ChannelBreaker, 0)
+	circuitBreakers.Range(func(key, value any) bool {
+		b := value.(*circuitBreaker)
+		b.Lock()
+		defer b.Unlock()
+		b.refresh(now)
+		if b.state == BreakerClosed && len(b.failures) == 0 {
+			return true
+		}
+		channelId, model, _ := strings.Cut(key.(string), ":")
+		id, _ := strconv.Atoi(channelId)
+		breakers = append(breakers, &ChannelBreaker{
+			ChannelId: id,
+			Model:     model,
+			State:     b.state.String(),
+			Failures:  len(b.failures),
+			OpenedAt:  b.openedAt,
+		})
+		return true
+	})
+	sort.Slice(breakers, func(i, j int) bool {
+		if breakers[i].ChannelId != breakers[j].ChannelId {
+			return breakers[i].ChannelId < breakers[j].ChannelId
+		}
+		return breakers[i].Model < breakers[j].Model
+	})
+	return breakers
+}
+
+// ResetChannelBreakers 关闭熔断器，channelId 为 0 时重置所有渠道，model 为空时重置渠道的所有模型
+func ResetChannelBreakers(channelId int, model string) int {
+	reset := 0
+	circuitBreakers.Range(func(key, value any) bool {
+		id, breakerModel, _ := strings.Cut(key.(string), ":")
+		if (channelId != 0 && id != strconv.Itoa(channelId)) || (model != "" && breakerModel != model) {
+			return true
+		}
+		circuitBreakers.Delete(key)
+		reset++
+		return true
+	})
+	return reset
+}
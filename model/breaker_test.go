@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	config.CircuitBreakerThreshold = 3
+	config.CircuitBreakerWindowSeconds = 60
+	config.CircuitBreakerOpenSeconds = 30
+	config.CircuitBreakerHalfOpenProbes = 1
+	b := &circuitBreaker{}
+
+	// failures out of the window are forgotten
+	assert.False(t, b.record(false, 100))
+	assert.False(t, b.record(false, 200))
+	assert.False(t, b.record(false, 210))
+	assert.True(t, b.record(false, 220))
+	assert.False(t, b.available(240))
+
+	// half open lets one probe through, its failure opens the breaker again
+	assert.True(t, b.available(250))
+	b.acquire(250)
+	assert.False(t, b.available(251))
+	assert.True(t, b.record(false, 252))
+	assert.False(t, b.available(260))
+
+	// a successful probe closes it
+	assert.True(t, b.available(282))
+	b.acquire(282)
+	assert.False(t, b.record(true, 283))
+	assert.Equal(t, BreakerClosed, b.state)
+	assert.True(t, b.available(284))
+}
+
+func TestCircuitBreakerLostProbe(t *testing.T) {
+	config.CircuitBreakerOpenSeconds = 30
+	config.CircuitBreakerHalfOpenProbes = 1
+	b := &circuitBreaker{state: BreakerOpen, openedAt: 0}
+	b.acquire(30)
+	assert.False(t, b.available(40))
+	b.release()
+	assert.True(t, b.available(41))
+	b.acquire(41)
+	// the probe never reported, another one is let through after the open time
+	assert.True(t, b.available(71))
+}
@@ -0,0 +1,334 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/message"
+	"gorm.io/gorm"
+)
+
+const (
+	BudgetScopeToken = "token"
+	BudgetScopeUser  = "user"
+)
+
+// 预算的周期，按服务器时区的自然日、自然周（周一开始）和自然月重置
+const (
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodWeekly  = "weekly"
+	BudgetPeriodMonthly = "monthly"
+)
+
+var ErrBudgetExceeded = errors.New("budget of the period is exceeded")
+
+// Budget 是令牌或用户在一个周期内可以消耗的额度，周期结束后已用额度清零
+type Budget struct {
+	Id             int    `json:"id"`
+	Scope          string `json:"scope" gorm:"type:varchar(16);uniqueIndex:idx_budget_owner"`
+	OwnerId        int    `json:"owner_id" gorm:"uniqueIndex:idx_budget_owner"`
+	Period         string `json:"period" gorm:"type:varchar(16)"`
+	Limit          int64  `json:"limit" gorm:"column:budget_limit;bigint"`
+	Used           int64  `json:"used" gorm:"bigint;default:0"`
+	PeriodStart    int64  `json:"period_start" gorm:"bigint"`
+	WarnThresholds string `json:"warn_thresholds" gorm:"default:''"` // percents of the limit which send a warning, such as 80,95
+	WarnedLevel    int    `json:"warned_level" gorm:"default:0"`     // the highest threshold already warned in the period
+	UpdatedTime    int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// GetPeriodStart returns the start of the period which contains now
+func GetPeriodStart(period string, now time.Time) time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case BudgetPeriodWeekly:
+		// time.Sunday is 0, the week starts on monday
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case BudgetPeriodMonthly:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	default:
+		return day
+	}
+}
+
+// GetPeriodEnd returns the time when the period starting at start resets
+func GetPeriodEnd(period string, start time.Time) time.Time {
+	switch period {
+	case BudgetPeriodWeekly:
+		return start.AddDate(0, 0, 7)
+	case BudgetPeriodMonthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+func parseWarnThresholds(thresholds string) ([]int, error) {
+	var levels []int
+	for _, part := range strings.Split(thresholds, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		level, err := strconv.Atoi(part)
+		if err != nil || level <= 0 || level > 100 {
+			return nil, fmt.Errorf("无效的预警阈值：%s", part)
+		}
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+	return levels, nil
+}
+
+func (budget *Budget) Validate() error {
+	switch budget.Period {
+	case BudgetPeriodDaily, BudgetPeriodWeekly, BudgetPeriodMonthly:
+	default:
+		return fmt.Errorf("无效的预算周期：%s", budget.Period)
+	}
+	if budget.Limit <= 0 {
+		return errors.New("预算额度必须大于 0")
+	}
+	_, err := parseWarnThresholds(budget.WarnThresholds)
+	return err
+}
+
+// current returns the used quota of the period containing now, a budget which has not been reset yet has used nothing
+func (budget *Budget) current(now time.Time) int64 {
+	if budget.PeriodStart < GetPeriodStart(budget.Period, now).Unix() {
+		return 0
+	}
+	return budget.Used
+}
+
+// GetBudget returns the budget of the token or the user, nil when there is none
+func GetBudget(scope string, ownerId int) (*Budget, error) {
+	var budgets []*Budget
+	if err := DB.Where("scope = ? AND owner_id = ?", scope, ownerId).Limit(1).Find(&budgets).Error; err != nil {
+		return nil, err
+	}
+	if len(budgets) == 0 {
+		return nil, nil
+	}
+	budgets[0].Used = budgets[0].current(time.Now())
+	return budgets[0], nil
+}
+
+// SetBudget creates or changes the budget of the token or the user, the used quota is kept unless the period changes
+func SetBudget(budget *Budget) error {
+	if err := budget.Validate(); err != nil {
+		return err
+	}
+	now := time.Now()
+	existing, err := GetBudget(budget.Scope, budget.OwnerId)
+	if err != nil {
+		return err
+	}
+	budget.UpdatedTime = now.Unix()
+	if existing == nil || existing.Period != budget.Period {
+		budget.Used = 0
+		budget.WarnedLevel = 0
+		budget.PeriodStart = GetPeriodStart(budget.Period, now).Unix()
+	} else {
+		budget.Used = existing.Used
+		budget.WarnedLevel = existing.WarnedLevel
+		budget.PeriodStart = existing.PeriodStart
+	}
+	if existing == nil {
+		budget.Id = 0
+		return DB.Create(budget).Error
+	}
+	budget.Id = existing.Id
+	return DB.Model(budget).Select("period", "budget_limit", "used", "period_start", "warn_thresholds", "warned_level", "updated_time").Updates(budget).Error
+}
+
+func DeleteBudget(scope string, ownerId int) error {
+	return DB.Where("scope = ? AND owner_id = ?", scope, ownerId).Delete(&Budget{}).Error
+}
+
+func getBudgets(tx *gorm.DB, tokenId int, userId int) ([]*Budget, error) {
+	var budgets []*Budget
+	err := tx.Where("(scope = ? AND owner_id = ?) OR (scope = ? AND owner_id = ?)", BudgetScopeToken, tokenId, BudgetScopeUser, userId).Find(&budgets).Error
+	return budgets, err
+}
+
+// CheckBudgets reports ErrBudgetExceeded when the quota does not fit in the budgets of the token or its user
+func CheckBudgets(tokenId int, userId int, quota int64) error {
+	budgets, err := getBudgets(DB, tokenId, userId)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, budget := range budgets {
+		if budget.current(now)+quota > budget.Limit {
+			return ErrBudgetExceeded
+		}
+	}
+	return nil
+}
+
+// chargeBudgets adds the quota spent by a token to its budget and to the one of its user, a negative quota is a refund.
+// With enforce the charge fails with ErrBudgetExceeded instead of going over a budget.
+// A budget whose period has ended is reset here, the scheduler only resets the idle ones.
+func chargeBudgets(tx *gorm.DB, tokenId int, userId int, quota int64, enforce bool) error {
+	if quota == 0 {
+		return nil
+	}
+	budgets, err := getBudgets(tx, tokenId, userId)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, budget := range budgets {
+		start := GetPeriodStart(budget.Period, now).Unix()
+		if budget.PeriodStart < start {
+			if enforce && quota > budget.Limit {
+				return ErrBudgetExceeded
+			}
+			result := tx.Model(&Budget{}).Where("id = ? AND period_start = ?", budget.Id, budget.PeriodStart).Updates(map[string]any{
+				"used":         max(quota, 0),
+				"period_start": start,
+				"warned_level": 0,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				continue
+			}
+			// reset by another request or by the scheduler in the meantime
+		}
+		query := tx.Model(&Budget{}).Where("id = ?", budget.Id)
+		if enforce && quota > 0 {
+			query = query.Where("used + ? <= budget_limit", quota)
+		}
+		result := query.Update("used", gorm.Expr("used + ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 && enforce {
+			return ErrBudgetExceeded
+		}
+	}
+	return nil
+}
+
+// spentQuota returns the quota a movement spends for a token, 0 for the other movements
+func spentQuota(movement *QuotaMovement) int64 {
+	if movement.TokenId == 0 {
+		return 0
+	}
+	switch movement.Type {
+	case LedgerTypeConsume, LedgerTypeRefund, LedgerTypeReserve:
+		return -(movement.Amount + movement.OrgAmount)
+	}
+	return 0
+}
+
+func notifyBudget(budget *Budget, level int) {
+	subject := fmt.Sprintf("%s预算提醒", config.SystemName)
+	end := GetPeriodEnd(budget.Period, time.Unix(budget.PeriodStart, 0))
+	var email, name string
+	var token *Token
+	if budget.Scope == BudgetScopeToken {
+		var err error
+		if token, err = GetTokenById(budget.OwnerId); err != nil {
+			logger.SysError("failed to get token of budget: " + err.Error())
+			return
+		}
+		email = token.Email
+		name = fmt.Sprintf("令牌「%s」", token.Name)
+	} else {
+		email, _ = GetUserEmail(budget.OwnerId)
+		name = fmt.Sprintf("用户「%s」", GetUsernameById(budget.OwnerId))
+	}
+	content := fmt.Sprintf("%s本周期已使用预算的 %d%%（%s / %s），预算将于 %s 重置，超出预算后请求将被拒绝。",
+		name, level, common.LogQuota(budget.Used), common.LogQuota(budget.Limit), end.Format("2006-01-02 15:04"))
+	notification := &message.Notification{
+		Event:   message.EventBudgetWarning,
+		Title:   subject,
+		Content: content,
+		Data: map[string]any{
+			"scope":     budget.Scope,
+			"owner_id":  budget.OwnerId,
+			"period":    budget.Period,
+			"limit":     budget.Limit,
+			"used":      budget.Used,
+			"level":     level,
+			"resets_at": end.Unix(),
+		},
+		Key:       fmt.Sprintf("%s:%d:%d:%d", budget.Scope, budget.OwnerId, budget.PeriodStart, level),
+		Timestamp: time.Now().Unix(),
+	}
+	go func() {
+		if email != "" {
+			if err := message.SendEmail(subject, email, content); err != nil {
+				logger.SysError("failed to send budget warning email: " + err.Error())
+			}
+		}
+		if token != nil && token.Webhook != "" {
+			if err := message.SendWebhook(token.WebhookType, token.Webhook, notification); err != nil {
+				logger.SysError("failed to send budget warning webhook: " + err.Error())
+			}
+		}
+	}()
+	message.Dispatch(notification)
+}
+
+// sweepBudgets resets the budgets whose period has ended and sends the warnings of the thresholds which have been reached,
+// the conditional updates keep a warning from being sent twice
+func sweepBudgets() {
+	var budgets []*Budget
+	if err := DB.Find(&budgets).Error; err != nil {
+		logger.SysError("failed to load budgets: " + err.Error())
+		return
+	}
+	now := time.Now()
+	for _, budget := range budgets {
+		start := GetPeriodStart(budget.Period, now).Unix()
+		if budget.PeriodStart < start {
+			err := DB.Model(&Budget{}).Where("id = ? AND period_start = ?", budget.Id, budget.PeriodStart).Updates(map[string]any{
+				"used":         0,
+				"period_start": start,
+				"warned_level": 0,
+			}).Error
+			if err != nil {
+				logger.SysError("failed to reset budget: " + err.Error())
+			}
+			continue
+		}
+		levels, _ := parseWarnThresholds(budget.WarnThresholds)
+		level := 0
+		for _, threshold := range levels {
+			if budget.Used*100 >= int64(threshold)*budget.Limit {
+				level = threshold
+			}
+		}
+		if level <= budget.WarnedLevel {
+			continue
+		}
+		result := DB.Model(&Budget{}).Where("id = ? AND period_start = ? AND warned_level = ?", budget.Id, budget.PeriodStart, budget.WarnedLevel).
+			Update("warned_level", level)
+		if result.Error != nil {
+			logger.SysError("failed to update budget warning: " + result.Error.Error())
+			continue
+		}
+		if result.RowsAffected > 0 {
+			notifyBudget(budget, level)
+		}
+	}
+}
+
+// SyncBudgets 每 frequency 秒重置到期的预算并发送预算提醒，只在主节点运行
+func SyncBudgets(frequency int) {
+	for {
+		sweepBudgets()
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
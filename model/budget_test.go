@@ -0,0 +1,72 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestGetPeriodStart(t *testing.T) {
+	// 2024-05-15 is a wednesday
+	now := time.Date(2024, 5, 15, 13, 30, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC), GetPeriodStart(BudgetPeriodDaily, now))
+	assert.Equal(t, time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC), GetPeriodStart(BudgetPeriodWeekly, now))
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), GetPeriodStart(BudgetPeriodMonthly, now))
+	sunday := time.Date(2024, 5, 19, 23, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC), GetPeriodStart(BudgetPeriodWeekly, sunday))
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), GetPeriodEnd(BudgetPeriodMonthly, GetPeriodStart(BudgetPeriodMonthly, now)))
+}
+
+func TestBudget(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB := DB
+	DB = db
+	defer func() { DB = oldDB }()
+	assert.Nil(t, DB.AutoMigrate(&User{}, &Token{}, &QuotaReservation{}, &QuotaLedger{}, &Budget{}))
+	assert.Nil(t, DB.Create(&User{Id: 1, Username: "test", Quota: 1000}).Error)
+	assert.Nil(t, DB.Create(&Token{Id: 1, UserId: 1, Key: "test", UnlimitedQuota: true}).Error)
+
+	assert.NotNil(t, SetBudget(&Budget{Scope: BudgetScopeToken, OwnerId: 1, Period: "yearly", Limit: 100}))
+	assert.NotNil(t, SetBudget(&Budget{Scope: BudgetScopeToken, OwnerId: 1, Period: BudgetPeriodDaily, Limit: 100, WarnThresholds: "80,120"}))
+	assert.Nil(t, SetBudget(&Budget{Scope: BudgetScopeToken, OwnerId: 1, Period: BudgetPeriodDaily, Limit: 100, WarnThresholds: "80"}))
+	assert.Nil(t, SetBudget(&Budget{Scope: BudgetScopeUser, OwnerId: 1, Period: BudgetPeriodMonthly, Limit: 1000}))
+
+	// the reservation is refused once it does not fit in the budget of the token
+	assert.Nil(t, ReserveQuota("a", 1, 1, 60))
+	assert.ErrorIs(t, ReserveQuota("b", 1, 1, 60), ErrBudgetExceeded)
+	assert.ErrorIs(t, CheckBudgets(1, 1, 60), ErrBudgetExceeded)
+	assert.Nil(t, SettleQuotaReservation("a", 30))
+	budget, err := GetBudget(BudgetScopeToken, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(30), budget.Used)
+	budget, err = GetBudget(BudgetScopeUser, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(30), budget.Used)
+
+	// a consumption over the budget is still recorded, only the pre-consumption is enforced
+	assert.Nil(t, PostConsumeTokenQuota(1, 60))
+	sweepBudgets()
+	budget, err = GetBudget(BudgetScopeToken, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(90), budget.Used)
+	assert.Equal(t, 80, budget.WarnedLevel)
+
+	// a budget of an ended period is reset by the next charge
+	yesterday := GetPeriodStart(BudgetPeriodDaily, time.Now()).AddDate(0, 0, -1).Unix()
+	assert.Nil(t, DB.Model(&Budget{}).Where("id = ?", budget.Id).Update("period_start", yesterday).Error)
+	assert.Nil(t, CheckBudgets(1, 1, 60))
+	assert.Nil(t, ReserveQuota("c", 1, 1, 60))
+	budget, err = GetBudget(BudgetScopeToken, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(60), budget.Used)
+	assert.Equal(t, 0, budget.WarnedLevel)
+	budget, err = GetBudget(BudgetScopeUser, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(150), budget.Used)
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net/url"
 	"sort"
 	"strconv"
@@ -16,9 +15,7 @@ import (
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
-	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
-	"github.com/songquanpeng/one-api/common/random"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 )
 
@@ -190,7 +187,6 @@ func InitChannelCacheByMem() {
 		config.ChannelBaseUrlList = make(map[int]string)
 	}
 	for _, channel := range channels {
-		channel.SleepModels = make(map[string]*SleepInfo)
 		if *channel.BaseURL != "" {
 			config.ChannelBaseUrlList[channel.Id] = *channel.BaseURL
 		} else {
@@ -244,6 +240,7 @@ func InitChannelCacheByMem() {
 		}
 	}
 
+	loadChannelKeyPools()
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
 	channelSyncLock.Unlock()
@@ -261,7 +258,11 @@ func SyncChannelCache(frequency int) {
 
 func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority bool) (*Channel, error) {
 	if !config.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, ignoreFirstPriority)
+		channel, err := GetRandomSatisfiedChannel(group, model, ignoreFirstPriority)
+		if err == nil {
+			AcquireChannelBreaker(channel.Id, model)
+		}
+		return channel, err
 	}
 	channelSyncLock.RLock()
 	defer channelSyncLock.RUnlock()
@@ -270,15 +271,10 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 		return nil, errors.New("channel not found")
 	}
 
-	// 过滤掉被禁用当前模型的渠道
+	// 过滤掉当前模型休眠中或者熔断中的渠道，以及 TPM 或 DPM 已经用满的渠道
 	var validChannels []*Channel
-	currentTime := helper.GetTimestamp()
 	for _, ch := range channels {
-		ch.SleepLock.RLock()
-		sleepInfo := ch.SleepModels[model]
-		ch.SleepLock.RUnlock()
-		// 如果没有休眠记录，或者休眠时间为0（已唤醒），或者已经到达唤醒时间，则可用
-		if sleepInfo == nil || sleepInfo.AwakeTime == 0 || sleepInfo.AwakeTime <= currentTime {
+		if !IsChannelModelSleeping(ch.Id, model) && IsChannelBreakerAvailable(ch.Id, model) && ch.HasAvailableKey() && !IsChannelSaturated(ch.Id) {
 			validChannels = append(validChannels, ch)
 		}
 	}
@@ -299,104 +295,15 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 			}
 		}
 	}
-	idx := rand.Intn(endIdx)
+	candidates := validChannels[:endIdx]
 	if ignoreFirstPriority {
 		if endIdx < len(validChannels) { // which means there are more than one priority
-			idx = random.RandRange(endIdx, len(validChannels))
-		}
-	}
-	return validChannels[idx], nil
-}
-
-// 新版锁定模型
-func SleepChannel(channelType int, group string, model string, channelId int, awakeTime int64) {
-	channelSyncLock.RLock()
-	defer channelSyncLock.RUnlock()
-	channels := group2model2channels[group][model]
-	for _, channel := range channels {
-		if channel.Id == channelId {
-			channel.SleepLock.Lock()
-			// 初始化SleepModels map（如果为nil）
-			if channel.SleepModels == nil {
-				channel.SleepModels = make(map[string]*SleepInfo)
-			}
-
-			// 获取或创建SleepInfo
-			sleepInfo := channel.SleepModels[model]
-			if sleepInfo == nil {
-				sleepInfo = &SleepInfo{
-					AwakeTime:  awakeTime,
-					SleepCount: 1,
-				}
-				channel.SleepModels[model] = sleepInfo
-			} else {
-				// 更新休眠时间和休眠次数
-				sleepInfo.AwakeTime = awakeTime
-				sleepInfo.SleepCount++
-			}
-
-			channel.SleepLock.Unlock()
-
-			logger.SysLogf("渠道 - [%s(%d)] , model - [%s] 已休眠至 %d, 已休眠次数: %d", channel.Name, channel.Id, model, awakeTime, sleepInfo.SleepCount)
-			// 当gemini渠道休眠次数达到10次时，禁用渠道
-			if sleepInfo.SleepCount >= 10 && channelType == channeltype.Gemini {
-				channel.Status = 2 // 状态设置为2（禁用）
-				// 这里应该调用数据库更新方法，例如：
-				logger.SysLogf("渠道 - [%s(%d)] , model - [%s] 已10次触发429错误, 开始自动禁用", channel.Name, channel.Id, model)
-				DisableChannel(channel.Id, channel.Name, "当前渠道已10次触发429错误")
-			}
-			break
-		}
-	}
-}
-
-// 渠道唤醒
-func WakeupChannel(frequency int) {
-	for {
-		logger.SysLog("begining wakeup channel")
-		var tasks []struct {
-			channel *Channel
-			model   string
+			candidates = validChannels[endIdx:]
 		}
-		func() {
-			channelSyncLock.RLock()
-			defer channelSyncLock.RUnlock()
-			for _, models := range group2model2channels {
-			mLoop:
-				for model, channels := range models {
-					for _, channel := range channels {
-						if len(channel.SleepModels) > 0 {
-							for sm, sleepInfo := range channel.SleepModels {
-								if sm == model && sleepInfo.AwakeTime <= helper.GetTimestamp() {
-									tasks = append(tasks, struct {
-										channel *Channel
-										model   string
-									}{
-										channel: channel,
-										model:   model,
-									})
-								}
-							}
-							continue mLoop
-						}
-					}
-				}
-			}
-		}()
-		channelSyncLock.Lock()
-		for _, task := range tasks {
-			task.channel.SleepLock.Lock()
-			// 唤醒时不删除记录，而是将 AwakeTime 设置为 0，保留 SleepCount 让其继续累积
-			if sleepInfo, ok := task.channel.SleepModels[task.model]; ok {
-				sleepInfo.AwakeTime = 0
-				logger.SysLogf("渠道 - [%s(%d)] , model - [%s] 已唤醒，当前累积休眠次数: %d", task.channel.Name, task.channel.Id, task.model, sleepInfo.SleepCount)
-			}
-			task.channel.SleepLock.Unlock()
-		}
-		channelSyncLock.Unlock()
-		logger.SysLog("wakeup channel end")
-		time.Sleep(time.Duration(frequency) * time.Second)
 	}
+	channel := GetChannelSelector(group).Select(group, model, candidates)
+	AcquireChannelBreaker(channel.Id, model)
+	return channel, nil
 }
 
 // 获取错误的缓存key
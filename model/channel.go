@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
@@ -24,12 +23,6 @@ const (
 	ChannelStatusUnActivate       = 5
 )
 
-// SleepInfo 记录模型的休眠信息
-type SleepInfo struct {
-	AwakeTime  int64 // 唤醒时间
-	SleepCount int   // 休眠次数，默认0
-}
-
 type Channel struct {
 	Id                 int     `json:"id"`
 	Type               int     `json:"type" gorm:"default:0"`
@@ -58,10 +51,8 @@ type Channel struct {
 	TpmLimit           int     `json:"tpm_limit" gorm:"default:0"`
 	SoftLimitUsd       int     `json:"soft_limit_usd" gorm:"default:0;index:idx_soft_limit_usd"`
 	CalcPrompt         *bool   `json:"calc_prompt" gorm:"default:1"`
-
-	// 新增字段，记录被禁用的模型
-	SleepModels map[string]*SleepInfo `gorm:"-"` // 标记为忽略数据库
-	SleepLock   sync.RWMutex          `gorm:"-"` // 锁也不需要持久化
+	MultiKey           bool    `json:"multi_key" gorm:"default:false"`                   // the keys are in channel_keys instead of Key
+	KeySelection       string  `json:"key_selection" gorm:"type:varchar(32);default:''"` // round_robin (default), random or least_used
 }
 
 func GetAllChannels(startIdx int, num int, scope string) ([]*Channel, error) {
@@ -118,6 +109,13 @@ func (channel *Channel) GetPriority() int64 {
 	return *channel.Priority
 }
 
+func (channel *Channel) GetWeight() int {
+	if channel.Weight == nil {
+		return 0
+	}
+	return int(*channel.Weight)
+}
+
 func (channel *Channel) GetBaseURL() string {
 	if channel.BaseURL == nil {
 		return ""
@@ -187,6 +185,9 @@ func (channel *Channel) Delete() error {
 	if err != nil {
 		return err
 	}
+	if err = deleteChannelKeys(channel.Id); err != nil {
+		return err
+	}
 	err = channel.DeleteAbilities()
 	//更新缓存
 	InitChannelCache()
@@ -377,7 +378,7 @@ func DisableChannel(channelId int, channelName string, reason string) {
 	go func() {
 		subject := fmt.Sprintf("通道「%s」(#%d)已被禁用", channelName, channelId)
 		content := fmt.Sprintf("通道「%s」(#%d)已被禁用，原因: %s", channelName, channelId, reason)
-		message.SendMailToAdmin(subject, content)
+		message.NotifyAdmin(message.EventChannelDisabled, subject, content, map[string]any{"channel_id": channelId, "reason": reason})
 
 		//重新初始化更新渠道信息
 		InitChannelCache()
@@ -0,0 +1,334 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	ChannelKeyStatusEnabled          = 1
+	ChannelKeyStatusManuallyDisabled = 2
+	ChannelKeyStatusAutoDisabled     = 3
+)
+
+// 多 key 渠道选择 key 的方式
+const (
+	KeySelectionRoundRobin = "round_robin"
+	KeySelectionRandom     = "random"
+	KeySelectionLeastUsed  = "least_used"
+)
+
+// ChannelKey 是多 key 渠道的一个 key，休眠、禁用和用量都按 key 记录，不影响渠道的状态
+type ChannelKey struct {
+	Id            int    `json:"id"`
+	ChannelId     int    `json:"channel_id" gorm:"index"`
+	Index         int    `json:"index" gorm:"column:key_index"` // key 在渠道中的序号，从 1 开始，记录在日志中
+	Key           string `json:"key" gorm:"type:text"`
+	Status        int    `json:"status" gorm:"default:1"`
+	AwakeTime     int64  `json:"awake_time" gorm:"bigint"`     // 休眠到的时间，0 表示未休眠
+	SleepCount    int    `json:"sleep_count" gorm:"default:0"` // 休眠次数，超过重置时间后重新计数
+	SleepTime     int64  `json:"sleep_time" gorm:"bigint"`     // 最近一次休眠的时间
+	UsedQuota     int64  `json:"used_quota" gorm:"bigint;default:0"`
+	LastError     string `json:"last_error" gorm:"type:text"`
+	LastErrorTime int64  `json:"last_error_time" gorm:"bigint"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint"`
+}
+
+// MaskedKey hides the key in the admin apis
+func (key *ChannelKey) MaskedKey() string {
+	if len(key.Key) <= 8 {
+		return "****"
+	}
+	return key.Key[:4] + "****" + key.Key[len(key.Key)-4:]
+}
+
+func (key *ChannelKey) available(now int64) bool {
+	return key.Status == ChannelKeyStatusEnabled && key.AwakeTime <= now
+}
+
+type channelKeyPool struct {
+	keys []*ChannelKey
+	next int
+}
+
+// channelKeyPools 是多 key 渠道的 key 的本地缓存，随渠道缓存一起刷新
+var channelKeyPools = make(map[int]*channelKeyPool)
+var channelKeyPoolsLock sync.Mutex
+
+func loadChannelKeyPools() {
+	var keys []*ChannelKey
+	if err := DB.Order("channel_id, key_index").Find(&keys).Error; err != nil {
+		logger.SysError("failed to load channel keys: " + err.Error())
+		return
+	}
+	pools := make(map[int]*channelKeyPool)
+	for _, key := range keys {
+		pool, ok := pools[key.ChannelId]
+		if !ok {
+			pool = &channelKeyPool{}
+			pools[key.ChannelId] = pool
+		}
+		pool.keys = append(pool.keys, key)
+	}
+	channelKeyPoolsLock.Lock()
+	defer channelKeyPoolsLock.Unlock()
+	for channelId, pool := range pools {
+		if old, ok := channelKeyPools[channelId]; ok {
+			pool.next = old.next
+		}
+	}
+	channelKeyPools = pools
+}
+
+// getChannelKeyPool returns the pool of the channel, it is loaded from the database when it is not cached, the lock must be held
+func getChannelKeyPool(channelId int) *channelKeyPool {
+	pool, ok := channelKeyPools[channelId]
+	if ok {
+		return pool
+	}
+	pool = &channelKeyPool{}
+	if err := DB.Where("channel_id = ?", channelId).Order("key_index").Find(&pool.keys).Error; err != nil {
+		logger.SysError("failed to load channel keys: " + err.Error())
+		return pool
+	}
+	channelKeyPools[channelId] = pool
+	return pool
+}
+
+func invalidateChannelKeyPool(channelId int) {
+	channelKeyPoolsLock.Lock()
+	delete(channelKeyPools, channelId)
+	channelKeyPoolsLock.Unlock()
+}
+
+// HasAvailableKey reports whether a channel has a key which is enabled and awake, a single key channel always has one
+func (channel *Channel) HasAvailableKey() bool {
+	if !channel.MultiKey {
+		return true
+	}
+	now := helper.GetTimestamp()
+	channelKeyPoolsLock.Lock()
+	defer channelKeyPoolsLock.Unlock()
+	for _, key := range getChannelKeyPool(channel.Id).keys {
+		if key.available(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectKey returns the key sent upstream, nil for a single key channel or when the pool has no enabled key.
+// A sleeping key is only used when every enabled key sleeps.
+func (channel *Channel) SelectKey() *ChannelKey {
+	if !channel.MultiKey {
+		return nil
+	}
+	now := helper.GetTimestamp()
+	channelKeyPoolsLock.Lock()
+	defer channelKeyPoolsLock.Unlock()
+	pool := getChannelKeyPool(channel.Id)
+	var candidates []*ChannelKey
+	var enabled []*ChannelKey
+	for _, key := range pool.keys {
+		if key.Status != ChannelKeyStatusEnabled {
+			continue
+		}
+		enabled = append(enabled, key)
+		if key.available(now) {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = enabled
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	switch channel.KeySelection {
+	case KeySelectionRandom:
+		return candidates[rand.Intn(len(candidates))]
+	case KeySelectionLeastUsed:
+		selected := candidates[0]
+		for _, key := range candidates[1:] {
+			if key.UsedQuota < selected.UsedQuota {
+				selected = key
+			}
+		}
+		return selected
+	default:
+		pool.next++
+		return candidates[pool.next%len(candidates)]
+	}
+}
+
+// AddChannelKeys adds the keys, one per line, to the pool of the channel
+func AddChannelKeys(channelId int, keys string) ([]*ChannelKey, error) {
+	var lastIndex int
+	err := DB.Model(&ChannelKey{}).Where("channel_id = ?", channelId).Select("COALESCE(MAX(key_index), 0)").Scan(&lastIndex).Error
+	if err != nil {
+		return nil, err
+	}
+	var channelKeys []*ChannelKey
+	for _, key := range strings.Split(keys, "\n") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		lastIndex++
+		channelKeys = append(channelKeys, &ChannelKey{
+			ChannelId:   channelId,
+			Index:       lastIndex,
+			Key:         key,
+			Status:      ChannelKeyStatusEnabled,
+			CreatedTime: helper.GetTimestamp(),
+		})
+	}
+	if len(channelKeys) == 0 {
+		return nil, fmt.Errorf("no key is given")
+	}
+	if err = DB.Create(&channelKeys).Error; err != nil {
+		return nil, err
+	}
+	invalidateChannelKeyPool(channelId)
+	return channelKeys, nil
+}
+
+func GetChannelKeys(channelId int) ([]*ChannelKey, error) {
+	var keys []*ChannelKey
+	err := DB.Where("channel_id = ?", channelId).Order("key_index").Find(&keys).Error
+	return keys, err
+}
+
+func DeleteChannelKey(channelId int, keyId int) error {
+	result := DB.Where("id = ? AND channel_id = ?", keyId, channelId).Delete(&ChannelKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("key not found")
+	}
+	invalidateChannelKeyPool(channelId)
+	return nil
+}
+
+func deleteChannelKeys(channelId int) error {
+	err := DB.Where("channel_id = ?", channelId).Delete(&ChannelKey{}).Error
+	invalidateChannelKeyPool(channelId)
+	return err
+}
+
+// UpdateChannelKeyStatus 启用或禁用 key，启用时清除休眠
+func UpdateChannelKeyStatus(channelId int, keyId int, status int) error {
+	updates := map[string]any{"status": status}
+	if status == ChannelKeyStatusEnabled {
+		updates["awake_time"] = 0
+		updates["sleep_count"] = 0
+	}
+	result := DB.Model(&ChannelKey{}).Where("id = ? AND channel_id = ?", keyId, channelId).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("key not found")
+	}
+	invalidateChannelKeyPool(channelId)
+	return nil
+}
+
+// updateCachedChannelKey applies a change to the cached key, so that the selection sees it before the next sync
+func updateCachedChannelKey(channelId int, keyId int, update func(key *ChannelKey)) {
+	channelKeyPoolsLock.Lock()
+	defer channelKeyPoolsLock.Unlock()
+	if pool, ok := channelKeyPools[channelId]; ok {
+		for _, key := range pool.keys {
+			if key.Id == keyId {
+				update(key)
+			}
+		}
+	}
+}
+
+// RecordChannelKeyError 记录 key 最近一次的错误
+func RecordChannelKeyError(channelId int, keyId int, message string) {
+	now := helper.GetTimestamp()
+	err := DB.Model(&ChannelKey{}).Where("id = ?", keyId).Updates(map[string]any{"last_error": message, "last_error_time": now}).Error
+	if err != nil {
+		logger.SysError("failed to record channel key error: " + err.Error())
+	}
+	updateCachedChannelKey(channelId, keyId, func(key *ChannelKey) {
+		key.LastError = message
+		key.LastErrorTime = now
+	})
+}
+
+// SleepChannelKey 让 key 休眠，backoff 时 delay 为第一次休眠的秒数，之后每次翻倍，否则休眠 delay 秒
+func SleepChannelKey(channelId int, keyId int, delay int64, backoff bool) {
+	now := helper.GetTimestamp()
+	// the count is increased in one statement so that concurrent errors are all counted
+	err := DB.Model(&ChannelKey{}).Where("id = ?", keyId).Updates(map[string]interface{}{
+		"sleep_count": gorm.Expr("CASE WHEN sleep_time < ? THEN 1 ELSE sleep_count + 1 END", now-int64(config.ChannelSleepResetSeconds)),
+		"sleep_time":  now,
+	}).Error
+	if err != nil {
+		logger.SysError("failed to increase channel key sleep count: " + err.Error())
+		return
+	}
+	key := &ChannelKey{}
+	if err := DB.First(key, "id = ?", keyId).Error; err != nil {
+		logger.SysError("failed to get channel key: " + err.Error())
+		return
+	}
+	key.AwakeTime = now + delay
+	if backoff {
+		key.AwakeTime = now + channelSleepSeconds(delay, key.SleepCount)
+	}
+	if err := DB.Model(key).Update("awake_time", key.AwakeTime).Error; err != nil {
+		logger.SysError("failed to save channel key sleep: " + err.Error())
+	}
+	updateCachedChannelKey(channelId, keyId, func(cached *ChannelKey) {
+		cached.AwakeTime = key.AwakeTime
+		cached.SleepCount = key.SleepCount
+		cached.SleepTime = key.SleepTime
+	})
+	logger.SysLogf("渠道 #%d 的第 %d 个 key 已休眠至 %d, 已休眠次数: %d", channelId, key.Index, key.AwakeTime, key.SleepCount)
+}
+
+// DisableChannelKey 自动禁用 key，返回渠道是否已经没有启用的 key
+func DisableChannelKey(channelId int, keyId int) (exhausted bool, err error) {
+	err = DB.Model(&ChannelKey{}).Where("id = ?", keyId).Update("status", ChannelKeyStatusAutoDisabled).Error
+	if err != nil {
+		return false, err
+	}
+	updateCachedChannelKey(channelId, keyId, func(key *ChannelKey) {
+		key.Status = ChannelKeyStatusAutoDisabled
+	})
+	var enabled int64
+	err = DB.Model(&ChannelKey{}).Where("channel_id = ? AND status = ?", channelId, ChannelKeyStatusEnabled).Count(&enabled).Error
+	return enabled == 0, err
+}
+
+// UpdateChannelKeyUsedQuota 累计 key 的用量，keyId 为 0 表示单 key 渠道
+func UpdateChannelKeyUsedQuota(keyId int, quota int64) {
+	if keyId == 0 || quota == 0 {
+		return
+	}
+	if config.BatchUpdateEnabled {
+		addNewRecord(BatchUpdateTypeChannelKeyUsedQuota, keyId, quota)
+		return
+	}
+	updateChannelKeyUsedQuota(keyId, quota)
+}
+
+func updateChannelKeyUsedQuota(keyId int, quota int64) {
+	err := DB.Model(&ChannelKey{}).Where("id = ?", keyId).Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error
+	if err != nil {
+		logger.SysError("failed to update channel key used quota: " + err.Error())
+	}
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestChannelKeyPool(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB := DB
+	DB = db
+	defer func() { DB = oldDB }()
+	assert.Nil(t, DB.AutoMigrate(&ChannelKey{}))
+
+	channel := &Channel{Id: 7, MultiKey: true}
+	keys, err := AddChannelKeys(channel.Id, "key-a\n\nkey-b\nkey-c\n")
+	assert.Nil(t, err)
+	assert.Len(t, keys, 3)
+	assert.Equal(t, 3, keys[2].Index)
+
+	// round robin skips the disabled and the sleeping keys
+	assert.Nil(t, UpdateChannelKeyStatus(channel.Id, keys[1].Id, ChannelKeyStatusManuallyDisabled))
+	SleepChannelKey(channel.Id, keys[2].Id, 60, true)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "key-a", channel.SelectKey().Key)
+	}
+	assert.True(t, channel.HasAvailableKey())
+
+	exhausted, err := DisableChannelKey(channel.Id, keys[0].Id)
+	assert.Nil(t, err)
+	assert.False(t, exhausted)
+	assert.False(t, channel.HasAvailableKey())
+	// the sleeping key is still used when it is the only enabled one
+	assert.Equal(t, "key-c", channel.SelectKey().Key)
+
+	exhausted, err = DisableChannelKey(channel.Id, keys[2].Id)
+	assert.Nil(t, err)
+	assert.True(t, exhausted)
+	assert.Nil(t, channel.SelectKey())
+
+	assert.Nil(t, (&Channel{Id: 8}).SelectKey())
+	assert.Equal(t, "key-****-key", (&ChannelKey{Key: "key-0123456789-key"}).MaskedKey())
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// 渠道的 TPM 和 DPM 窗口和令牌的一样，TPM 按分钟计，DPM 按 UTC 自然日计，用满的渠道在选择时被跳过
+const (
+	channelTpmWindowSeconds = 60
+	channelDpmWindowSeconds = 24 * 60 * 60
+)
+
+// channelSaturationSyncSeconds 是本地用满快照的刷新间隔
+const channelSaturationSyncSeconds = 5
+
+func channelTpmKey(channelId int) string {
+	return fmt.Sprintf("CHANNEL_TPM_%d", channelId)
+}
+
+func channelDpmKey(channelId int) string {
+	return fmt.Sprintf("CHANNEL_DPM_%d", channelId)
+}
+
+// IsSaturated reports whether the channel has used up its TPM or DPM limit in the current window
+func (channel *Channel) IsSaturated() bool {
+	if channel.TpmLimit > 0 && common.WindowUsed(channelTpmKey(channel.Id), channelTpmWindowSeconds) >= int64(channel.TpmLimit) {
+		return true
+	}
+	if channel.DpmLimit > 0 && common.WindowUsed(channelDpmKey(channel.Id), channelDpmWindowSeconds) >= int64(channel.DpmLimit) {
+		return true
+	}
+	return false
+}
+
+// RecordChannelRequest counts a request relayed by the channel in its DPM window
+func RecordChannelRequest(channelId int, dpmLimit int) {
+	if channelId == 0 || dpmLimit <= 0 {
+		return
+	}
+	used, _, _ := common.WindowReserve(channelDpmKey(channelId), 1, math.MaxInt64, channelDpmWindowSeconds)
+	if used >= int64(dpmLimit) {
+		setLocalChannelSaturated(channelId)
+	}
+}
+
+// RecordChannelTokens counts the tokens used by the channel in its TPM window
+func RecordChannelTokens(channelId int, tpmLimit int, tokens int64) {
+	if channelId == 0 || tpmLimit <= 0 || tokens <= 0 {
+		return
+	}
+	used, _, _ := common.WindowReserve(channelTpmKey(channelId), tokens, math.MaxInt64, channelTpmWindowSeconds)
+	if used >= int64(tpmLimit) {
+		setLocalChannelSaturated(channelId)
+	}
+}
+
+// saturatedChannels 是用满 TPM 或 DPM 的渠道的本地快照，选择渠道时只读快照，每 channelSaturationSyncSeconds 秒刷新一次
+var saturatedChannels = make(map[int]bool)
+var saturatedChannelsLock sync.RWMutex
+var saturatedChannelsSyncedAt atomic.Int64
+
+func syncSaturatedChannels() {
+	var channels []*Channel
+	err := DB.Select("id", "tpm_limit", "dpm_limit").Where("tpm_limit > 0 OR dpm_limit > 0").Find(&channels).Error
+	if err != nil {
+		logger.SysError("failed to get the channels with limits: " + err.Error())
+		return
+	}
+	saturated := make(map[int]bool)
+	for _, channel := range channels {
+		if channel.IsSaturated() {
+			saturated[channel.Id] = true
+		}
+	}
+	saturatedChannelsLock.Lock()
+	saturatedChannels = saturated
+	saturatedChannelsLock.Unlock()
+}
+
+// setLocalChannelSaturated 让本节点在下次刷新前就跳过刚用满的渠道
+func setLocalChannelSaturated(channelId int) {
+	saturatedChannelsLock.Lock()
+	saturatedChannels[channelId] = true
+	saturatedChannelsLock.Unlock()
+}
+
+// refreshSaturatedChannels 刷新过期的快照，第一次同步加载，之后在后台刷新
+func refreshSaturatedChannels() {
+	now := helper.GetTimestamp()
+	syncedAt := saturatedChannelsSyncedAt.Load()
+	if now-syncedAt < channelSaturationSyncSeconds || !saturatedChannelsSyncedAt.CompareAndSwap(syncedAt, now) {
+		return
+	}
+	if syncedAt == 0 {
+		syncSaturatedChannels()
+		return
+	}
+	go syncSaturatedChannels()
+}
+
+// IsChannelSaturated 判断渠道的 TPM 或 DPM 是否已经用满
+func IsChannelSaturated(channelId int) bool {
+	refreshSaturatedChannels()
+	saturatedChannelsLock.RLock()
+	defer saturatedChannelsLock.RUnlock()
+	return saturatedChannels[channelId]
+}
+
+// GetSaturatedChannelIds 返回 TPM 或 DPM 已经用满的渠道
+func GetSaturatedChannelIds() []int {
+	refreshSaturatedChannels()
+	saturatedChannelsLock.RLock()
+	defer saturatedChannelsLock.RUnlock()
+	var ids []int
+	for id := range saturatedChannels {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestChannelSaturated(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB := DB
+	DB = db
+	defer func() { DB = oldDB }()
+	common.RedisEnabled = false
+	assert.Nil(t, DB.AutoMigrate(&Channel{}))
+	tpmChannel := &Channel{Id: 101, Name: "tpm", TpmLimit: 100}
+	dpmChannel := &Channel{Id: 102, Name: "dpm", DpmLimit: 2}
+	assert.Nil(t, DB.Create(tpmChannel).Error)
+	assert.Nil(t, DB.Create(dpmChannel).Error)
+	assert.Nil(t, DB.Create(&Channel{Id: 103, Name: "unlimited"}).Error)
+
+	RecordChannelTokens(tpmChannel.Id, tpmChannel.TpmLimit, 60)
+	assert.False(t, tpmChannel.IsSaturated())
+	RecordChannelTokens(tpmChannel.Id, tpmChannel.TpmLimit, 40)
+	assert.True(t, tpmChannel.IsSaturated())
+
+	RecordChannelRequest(dpmChannel.Id, dpmChannel.DpmLimit)
+	assert.False(t, dpmChannel.IsSaturated())
+	assert.Equal(t, []int{101}, GetSaturatedChannelIds())
+	RecordChannelRequest(dpmChannel.Id, dpmChannel.DpmLimit)
+	assert.True(t, dpmChannel.IsSaturated())
+	assert.ElementsMatch(t, []int{101, 102}, GetSaturatedChannelIds())
+}
@@ -5,18 +5,60 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/songquanpeng/one-api/common/logger"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 )
 
 type Group struct {
-	Id          int    `json:"id"`
-	Type        string `json:"type" gorm:"default:''"`
-	Name        string `json:"name" gorm:"index"`
-	Models      string `json:"models"`
-	Ratio       string `json:"ratio"`
-	ActiveNum   int64  `json:"active_num" gorm:"default:0"`
-	Status      int    `json:"status" gorm:"default:1;index:idx_status"`
-	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	Id           int    `json:"id"`
+	Type         string `json:"type" gorm:"default:''"`
+	Name         string `json:"name" gorm:"index"`
+	Models       string `json:"models"`
+	Ratio        string `json:"ratio"`
+	ActiveNum    int64  `json:"active_num" gorm:"default:0"`
+	Status       int    `json:"status" gorm:"default:1;index:idx_status"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+	PoolMode     string `json:"pool_mode" gorm:"type:varchar(32);default:''"` // 渠道选择策略，为空时使用全局 PoolMode
+	CacheTTL     int    `json:"cache_ttl" gorm:"default:0"`                   // 响应缓存的秒数，为 0 时使用全局 ResponseCacheTTL
+	AuditEnabled bool   `json:"audit_enabled" gorm:"default:false"`           // 记录分组所有请求的请求和响应内容
+	OutputFilter string `json:"output_filter" gorm:"default:''"`              // 输出过滤规则集，令牌未指定时使用
+	Fallbacks    string `json:"fallbacks" gorm:"type:text"`                   // 模型降级链，JSON 格式：{"gpt-4o":{"models":["claude-sonnet-4"],"on":["no_channel","429","5xx"]}}
+
+	FallbackChains map[string]*FallbackChain `json:"-" gorm:"-"`
+}
+
+// 触发降级的情况
+const (
+	FallbackOnNoChannel = "no_channel"
+	FallbackOn429       = "429"
+	FallbackOn5xx       = "5xx"
+)
+
+// FallbackChain 是模型的替代模型，按顺序尝试，On 为空时任何情况都降级
+type FallbackChain struct {
+	Models []string `json:"models"`
+	On     []string `json:"on,omitempty"`
+}
+
+func (chain *FallbackChain) Allows(condition string) bool {
+	if len(chain.On) == 0 {
+		return true
+	}
+	for _, on := range chain.On {
+		if on == condition {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFallbackChain 返回分组中模型的降级链，没有时返回 nil
+func GetFallbackChain(group string, modelName string) *FallbackChain {
+	info, ok := GroupInfo[group]
+	if !ok || info.FallbackChains == nil {
+		return nil
+	}
+	return info.FallbackChains[modelName]
 }
 
 var GroupModels = make(map[string]string)
@@ -27,9 +69,21 @@ func InitGroupInfo() {
 	for _, group := range groups {
 		GroupModels[group.Name] = fmt.Sprintf(",%s,", group.Models)
 		GroupInfo[group.Name] = &Group{
-			Id:        group.Id,
-			Type:      group.Type,
-			ActiveNum: group.ActiveNum,
+			Id:           group.Id,
+			Type:         group.Type,
+			ActiveNum:    group.ActiveNum,
+			PoolMode:     group.PoolMode,
+			CacheTTL:     group.CacheTTL,
+			AuditEnabled: group.AuditEnabled,
+			OutputFilter: group.OutputFilter,
+		}
+		if group.Fallbacks != "" {
+			chains := make(map[string]*FallbackChain)
+			if err := json.Unmarshal([]byte(group.Fallbacks), &chains); err != nil {
+				logger.SysError(fmt.Sprintf("failed to unmarshal fallbacks of group %s: %s", group.Name, err.Error()))
+			} else {
+				GroupInfo[group.Name].FallbackChains = chains
+			}
 		}
 		tmp := make(map[string]float64)
 		err := json.Unmarshal([]byte(group.Ratio), &tmp)
@@ -0,0 +1,23 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackChain(t *testing.T) {
+	GroupInfo["fallback-test"] = &Group{FallbackChains: map[string]*FallbackChain{
+		"gpt-4o": {Models: []string{"claude-sonnet-4"}, On: []string{FallbackOnNoChannel, FallbackOn5xx}},
+		"gpt-4":  {Models: []string{"gpt-4o"}},
+	}}
+	defer delete(GroupInfo, "fallback-test")
+
+	chain := GetFallbackChain("fallback-test", "gpt-4o")
+	assert.Equal(t, []string{"claude-sonnet-4"}, chain.Models)
+	assert.True(t, chain.Allows(FallbackOn5xx))
+	assert.False(t, chain.Allows(FallbackOn429))
+	assert.True(t, GetFallbackChain("fallback-test", "gpt-4").Allows(FallbackOn429))
+	assert.Nil(t, GetFallbackChain("fallback-test", "gemini-2.5-pro"))
+	assert.Nil(t, GetFallbackChain("default", "gpt-4o"))
+}
@@ -0,0 +1,247 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+	"gorm.io/gorm"
+)
+
+// types of the quota movements
+const (
+	LedgerTypeUnknown = iota
+	LedgerTypeTopup
+	LedgerTypeRedemption
+	LedgerTypeConsume
+	LedgerTypeRefund
+	LedgerTypeAdminAdjust
+	LedgerTypeInviteReward
+	LedgerTypeReserve
+	LedgerTypeOrgTransfer
+)
+
+var LedgerTypeNames = map[int]string{
+	LedgerTypeTopup:        "topup",
+	LedgerTypeRedemption:   "redemption",
+	LedgerTypeConsume:      "consume",
+	LedgerTypeRefund:       "refund",
+	LedgerTypeAdminAdjust:  "admin_adjust",
+	LedgerTypeInviteReward: "invite_reward",
+	LedgerTypeReserve:      "reserve",
+	LedgerTypeOrgTransfer:  "org_transfer",
+}
+
+// QuotaLedger 是只追加的额度流水，复式记账：每次变动在用户或令牌账户记一笔，在系统账户记一笔相反的，同一变动的金额之和为 0
+type QuotaLedger struct {
+	Id            int    `json:"id"`
+	TransactionId string `json:"transaction_id" gorm:"type:varchar(64);index"`
+	UserId        int    `json:"user_id" gorm:"index:idx_ledger_user_id"`
+	Account       string `json:"account" gorm:"type:varchar(64);index"` // user:1, token:2, org:3, or system:consume
+	Type          int    `json:"type"`
+	Amount        int64  `json:"amount" gorm:"bigint"`        // signed change of the account
+	BalanceAfter  int64  `json:"balance_after" gorm:"bigint"` // balance of a user or token account after the change
+	TokenId       int    `json:"token_id"`
+	RequestId     string `json:"request_id" gorm:"type:varchar(64)"`
+	Remark        string `json:"remark"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint;index:idx_ledger_user_id"`
+}
+
+// QuotaMovement 是一次额度变动，Amount 是用户额度的变化，TokenAmount 是令牌剩余额度的变化，OrgAmount 是组织额度池的变化
+type QuotaMovement struct {
+	UserId      int
+	TokenId     int
+	OrgId       int
+	Type        int
+	Amount      int64
+	TokenAmount int64
+	OrgAmount   int64
+	RequestId   string
+	Remark      string
+}
+
+func userAccount(userId int) string {
+	return fmt.Sprintf("user:%d", userId)
+}
+
+func tokenAccount(tokenId int) string {
+	return fmt.Sprintf("token:%d", tokenId)
+}
+
+func orgAccount(orgId int) string {
+	return fmt.Sprintf("org:%d", orgId)
+}
+
+func systemAccount(ledgerType int) string {
+	return "system:" + LedgerTypeNames[ledgerType]
+}
+
+// appendQuotaLedger appends the entries of a movement which has already been applied in the transaction,
+// the balances are read in the transaction, after the rows have been locked by the update
+func appendQuotaLedger(tx *gorm.DB, movement *QuotaMovement) error {
+	transactionId := random.GetUUID()
+	now := helper.GetTimestamp()
+	var entries []*QuotaLedger
+	newEntries := func(account string, amount int64, balanceAfter int64) {
+		entry := QuotaLedger{
+			TransactionId: transactionId,
+			UserId:        movement.UserId,
+			Account:       account,
+			Type:          movement.Type,
+			Amount:        amount,
+			BalanceAfter:  balanceAfter,
+			TokenId:       movement.TokenId,
+			RequestId:     movement.RequestId,
+			Remark:        movement.Remark,
+			CreatedTime:   now,
+		}
+		counter := entry
+		counter.Account = systemAccount(movement.Type)
+		counter.Amount = -amount
+		counter.BalanceAfter = 0
+		entries = append(entries, &entry, &counter)
+	}
+	if movement.Amount != 0 {
+		var quota int64
+		if err := tx.Model(&User{}).Where("id = ?", movement.UserId).Select("quota").Scan(&quota).Error; err != nil {
+			return err
+		}
+		newEntries(userAccount(movement.UserId), movement.Amount, quota)
+	}
+	if movement.TokenAmount != 0 {
+		var remainQuota int64
+		if err := tx.Model(&Token{}).Where("id = ?", movement.TokenId).Select("remain_quota").Scan(&remainQuota).Error; err != nil {
+			return err
+		}
+		newEntries(tokenAccount(movement.TokenId), movement.TokenAmount, remainQuota)
+	}
+	if movement.OrgAmount != 0 {
+		var quota int64
+		if err := tx.Model(&Organization{}).Where("id = ?", movement.OrgId).Select("quota").Scan(&quota).Error; err != nil {
+			return err
+		}
+		newEntries(orgAccount(movement.OrgId), movement.OrgAmount, quota)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return tx.Create(&entries).Error
+}
+
+// applyQuotaMovement changes the quotas and appends the ledger entries in the transaction
+func applyQuotaMovement(tx *gorm.DB, movement *QuotaMovement) error {
+	if movement.Amount != 0 {
+		if err := tx.Model(&User{}).Where("id = ?", movement.UserId).Update("quota", gorm.Expr("quota + ?", movement.Amount)).Error; err != nil {
+			return err
+		}
+	}
+	if movement.TokenAmount != 0 {
+		updates := map[string]interface{}{
+			"remain_quota": gorm.Expr("remain_quota + ?", movement.TokenAmount),
+		}
+		// an adjustment or a recharge by an admin changes the remaining quota only
+		if movement.Type == LedgerTypeConsume || movement.Type == LedgerTypeRefund {
+			updates["used_quota"] = gorm.Expr("used_quota - ?", movement.TokenAmount)
+			updates["accessed_time"] = helper.GetTimestamp()
+		}
+		err := tx.Model(&Token{}).Where("id = ?", movement.TokenId).Updates(updates).Error
+		if err != nil {
+			return err
+		}
+	}
+	if movement.OrgAmount != 0 {
+		if err := tx.Model(&Organization{}).Where("id = ?", movement.OrgId).Update("quota", gorm.Expr("quota + ?", movement.OrgAmount)).Error; err != nil {
+			return err
+		}
+	}
+	if err := chargeBudgets(tx, movement.TokenId, movement.UserId, spentQuota(movement), false); err != nil {
+		return err
+	}
+	return appendQuotaLedger(tx, movement)
+}
+
+// ApplyQuotaMovement 变更用户、令牌和组织的额度并记录流水
+func ApplyQuotaMovement(movement *QuotaMovement) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return applyQuotaMovement(tx, movement)
+	})
+}
+
+// GetUserQuotaLedger 返回用户账户的流水，按时间倒序
+func GetUserQuotaLedger(userId int, ledgerType int, startTimestamp int64, endTimestamp int64, startIdx int, num int) ([]*QuotaLedger, error) {
+	tx := DB.Where("user_id = ? AND account = ?", userId, userAccount(userId))
+	if ledgerType != LedgerTypeUnknown {
+		tx = tx.Where("type = ?", ledgerType)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("created_time >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_time <= ?", endTimestamp)
+	}
+	var entries []*QuotaLedger
+	query := tx.Order("id desc")
+	if num > 0 {
+		query = query.Limit(num).Offset(startIdx)
+	}
+	err := query.Find(&entries).Error
+	return entries, err
+}
+
+// LedgerMismatch 是流水和实际额度不一致的账户
+type LedgerMismatch struct {
+	Account       string `json:"account"`
+	LedgerBalance int64  `json:"ledger_balance"` // balance after the last entry
+	LedgerSum     int64  `json:"ledger_sum"`     // balance before the first entry plus the sum of the entries
+	ActualBalance int64  `json:"actual_balance"`
+}
+
+type ledgerSummary struct {
+	Account string
+	FirstId int
+	LastId  int
+	Total   int64
+}
+
+// CheckQuotaLedger 对账：每个账户的流水之和要等于最后一笔的余额，最后一笔的余额要等于 users.quota、tokens.remain_quota 或 organizations.quota，
+// 系统账户记录的是对手方，所有流水之和为 0
+func CheckQuotaLedger() ([]*LedgerMismatch, int64, error) {
+	var summaries []*ledgerSummary
+	err := DB.Model(&QuotaLedger{}).Select("account, min(id) as first_id, max(id) as last_id, sum(amount) as total").
+		Where("account NOT LIKE ?", "system:%").Group("account").Scan(&summaries).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	mismatches := make([]*LedgerMismatch, 0)
+	for _, summary := range summaries {
+		var first, last QuotaLedger
+		if err := DB.First(&first, summary.FirstId).Error; err != nil {
+			return nil, 0, err
+		}
+		if err := DB.First(&last, summary.LastId).Error; err != nil {
+			return nil, 0, err
+		}
+		mismatch := &LedgerMismatch{
+			Account:       summary.Account,
+			LedgerBalance: last.BalanceAfter,
+			LedgerSum:     first.BalanceAfter - first.Amount + summary.Total,
+		}
+		var id int
+		if _, err := fmt.Sscanf(summary.Account, "user:%d", &id); err == nil {
+			err = DB.Model(&User{}).Where("id = ?", id).Select("quota").Scan(&mismatch.ActualBalance).Error
+		} else if _, err = fmt.Sscanf(summary.Account, "token:%d", &id); err == nil {
+			err = DB.Model(&Token{}).Where("id = ?", id).Select("remain_quota").Scan(&mismatch.ActualBalance).Error
+		} else if _, err = fmt.Sscanf(summary.Account, "org:%d", &id); err == nil {
+			err = DB.Model(&Organization{}).Where("id = ?", id).Select("quota").Scan(&mismatch.ActualBalance).Error
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if mismatch.LedgerBalance != mismatch.LedgerSum || mismatch.LedgerBalance != mismatch.ActualBalance {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	var total int64
+	err = DB.Model(&QuotaLedger{}).Select("COALESCE(sum(amount), 0)").Scan(&total).Error
+	return mismatches, total, err
+}
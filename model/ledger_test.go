@@ -0,0 +1,84 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestQuotaLedger(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB := DB
+	DB = db
+	defer func() { DB = oldDB }()
+	assert.Nil(t, DB.AutoMigrate(&User{}, &Token{}, &QuotaReservation{}, &QuotaLedger{}, &Budget{}))
+	assert.Nil(t, DB.Create(&User{Id: 1, Username: "test", Quota: 1000}).Error)
+	assert.Nil(t, DB.Create(&Token{Id: 1, UserId: 1, Key: "test", RemainQuota: 500}).Error)
+
+	assert.Nil(t, ApplyQuotaMovement(&QuotaMovement{UserId: 1, Type: LedgerTypeTopup, Amount: 500}))
+	assert.Nil(t, ReserveQuota("a", 1, 1, 300))
+	assert.Nil(t, SettleQuotaReservation("a", 100))
+
+	entries, err := GetUserQuotaLedger(1, LedgerTypeUnknown, 0, 0, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, LedgerTypeRefund, entries[0].Type)
+	assert.Equal(t, int64(200), entries[0].Amount)
+	assert.Equal(t, int64(1400), entries[0].BalanceAfter)
+
+	mismatches, total, err := CheckQuotaLedger()
+	assert.Nil(t, err)
+	assert.Empty(t, mismatches)
+	assert.Equal(t, int64(0), total)
+
+	// a change bypassing the ledger is reported
+	assert.Nil(t, DB.Model(&User{}).Where("id = ?", 1).Update("quota", 0).Error)
+	mismatches, _, err = CheckQuotaLedger()
+	assert.Nil(t, err)
+	assert.Len(t, mismatches, 1)
+	assert.Equal(t, "user:1", mismatches[0].Account)
+}
+
+func TestTokenQuotaAdjustment(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB, oldRedisEnabled := DB, common.RedisEnabled
+	DB, common.RedisEnabled = db, false
+	defer func() { DB, common.RedisEnabled = oldDB, oldRedisEnabled }()
+	assert.Nil(t, DB.AutoMigrate(&User{}, &Token{}, &QuotaReservation{}, &QuotaLedger{}, &Budget{}))
+	assert.Nil(t, DB.Create(&User{Id: 1, Username: "test", Quota: 1000}).Error)
+	assert.Nil(t, DB.Create(&Token{Id: 1, UserId: 1, Key: "test", RemainQuota: 500}).Error)
+	assert.Nil(t, PostConsumeTokenQuota(1, 100))
+
+	// the edits of an admin go through the ledger and leave the used quota alone
+	token, err := GetTokenById(1)
+	assert.Nil(t, err)
+	assert.Nil(t, token.SetRemainQuota(1000))
+	assert.Nil(t, token.Recharge(500))
+	token.Name = "renamed"
+	assert.Nil(t, token.Update())
+	token, err = GetTokenById(1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1500), token.RemainQuota)
+	assert.Equal(t, int64(100), token.UsedQuota)
+	assert.Equal(t, "renamed", token.Name)
+
+	entries, err := GetUserQuotaLedger(1, LedgerTypeAdminAdjust, 0, 0, 0, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, entries) // the user quota is not changed
+	var adjust QuotaLedger
+	assert.Nil(t, DB.Where("account = ? AND type = ?", "token:1", LedgerTypeAdminAdjust).First(&adjust).Error)
+	assert.Equal(t, int64(600), adjust.Amount)
+	mismatches, total, err := CheckQuotaLedger()
+	assert.Nil(t, err)
+	assert.Empty(t, mismatches)
+	assert.Equal(t, int64(0), total)
+}
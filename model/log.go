@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 	"gorm.io/gorm"
@@ -30,6 +31,10 @@ type Log struct {
 	UseTime           int    `json:"use_time" gorm:"default:0"`
 	IsStream          bool   `json:"is_stream" gorm:"default:false"`
 	FirstResponseTime int64  `json:"first_response_time" gorm:"default:0"`
+	CacheHit          bool   `json:"cache_hit" gorm:"default:false"` // the response was replayed from the response cache
+	ServedModel       string `json:"served_model" gorm:"default:''"` // the substitute model served by a fallback, empty when the requested model was served
+	KeyIndex          int    `json:"key_index" gorm:"default:0"`     // the key of a multi key channel, 0 for a single key channel
+	OrgId             int    `json:"org_id" gorm:"index;default:0"`  // the organization which paid, 0 when the user paid
 }
 
 const (
@@ -95,6 +100,10 @@ func RecordConsumeLog(ctx *gin.Context, isStream bool, FirstResponseTime time.Ti
 		FirstResponseTime: FirstResponseTime.Unix(),
 		IsStream:          isStream,
 		Ip:                ctx.ClientIP(),
+		CacheHit:          ctx.GetBool(ctxkey.CacheHit),
+		ServedModel:       ctx.GetString(ctxkey.FallbackModel),
+		KeyIndex:          ctx.GetInt(ctxkey.ChannelKeyIndex),
+		OrgId:             ctx.GetInt(ctxkey.OrgId),
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -154,6 +163,53 @@ func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int
 	return logs, err
 }
 
+// GetOrganizationLogs 返回组织及其下级组织的令牌产生的消费日志
+func GetOrganizationLogs(orgIds []int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int) (logs []*Log, err error) {
+	tx := LOG_DB.Where("org_id IN ? and type = ?", orgIds, LogTypeConsume)
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	if username != "" {
+		tx = tx.Where("username = ?", username)
+	}
+	if tokenName != "" {
+		tx = tx.Where("token_name = ?", tokenName)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	return logs, err
+}
+
+type OrganizationUsage struct {
+	Username     string `json:"username" gorm:"column:username"`
+	RequestCount int    `json:"request_count" gorm:"column:request_count"`
+	Quota        int64  `json:"quota" gorm:"column:quota"`
+}
+
+// SumOrganizationUsedQuota returns the quota used by the tokens of the organizations, in total and per member
+func SumOrganizationUsedQuota(orgIds []int, startTimestamp int64, endTimestamp int64, modelName string) (quota int64, usages []*OrganizationUsage, err error) {
+	tx := LOG_DB.Table("logs").Where("org_id IN ? and type = ?", orgIds, LogTypeConsume)
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	err = tx.Select("username, count(1) as request_count, sum(quota) as quota").Group("username").Order("quota desc").Scan(&usages).Error
+	for _, usage := range usages {
+		quota += usage.Quota
+	}
+	return quota, usages, err
+}
+
 func SearchAllLogs(keyword string) (logs []*Log, err error) {
 	err = LOG_DB.Where("type = ? or content LIKE ?", keyword, keyword+"%").Order("id desc").Limit(config.MaxRecentItems).Find(&logs).Error
 	return logs, err
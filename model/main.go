@@ -158,6 +158,9 @@ func migrateDB() error {
 	if err = DB.AutoMigrate(&Log{}); err != nil {
 		return err
 	}
+	if err = DB.AutoMigrate(&AuditCapture{}); err != nil {
+		return err
+	}
 	if err = DB.AutoMigrate(&Channel{}); err != nil {
 		return err
 	}
@@ -170,6 +173,45 @@ func migrateDB() error {
 	if err = DB.AutoMigrate(&Model{}); err != nil {
 		return err
 	}
+	if err = DB.AutoMigrate(&StoredResponse{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&UserFile{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&Batch{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&BatchRequest{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&ChannelSleep{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&ChannelKey{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&ResponseCache{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&QuotaReservation{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&QuotaLedger{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&Organization{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&OrganizationMember{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&OrganizationInvitation{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&Budget{}); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -207,6 +249,9 @@ func migrateLOGDB() error {
 	if err = LOG_DB.AutoMigrate(&Log{}); err != nil {
 		return err
 	}
+	if err = LOG_DB.AutoMigrate(&AuditCapture{}); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -7,7 +7,13 @@ import (
 
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/message"
+	"github.com/songquanpeng/one-api/common/secret"
+	"github.com/songquanpeng/one-api/relay/audit"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/errorrule"
+	"github.com/songquanpeng/one-api/relay/guardrail"
+	"github.com/songquanpeng/one-api/relay/moderation"
 )
 
 type Option struct {
@@ -45,6 +51,12 @@ func InitOptionMap() {
 	config.OptionMap["GeminiNewEnabled"] = strconv.FormatBool(config.GeminiNewEnabled)
 	config.OptionMap["GeminiUploadImageEnabled"] = strconv.FormatBool(config.GeminiUploadImageEnabled)
 	config.OptionMap["ChannelDisableThreshold"] = strconv.FormatFloat(config.ChannelDisableThreshold, 'f', -1, 64)
+	config.OptionMap["ChannelErrorRules"] = errorrule.Rules2JSONString()
+	config.OptionMap["CircuitBreakerEnabled"] = strconv.FormatBool(config.CircuitBreakerEnabled)
+	config.OptionMap["CircuitBreakerThreshold"] = strconv.Itoa(config.CircuitBreakerThreshold)
+	config.OptionMap["CircuitBreakerWindowSeconds"] = strconv.Itoa(config.CircuitBreakerWindowSeconds)
+	config.OptionMap["CircuitBreakerOpenSeconds"] = strconv.Itoa(config.CircuitBreakerOpenSeconds)
+	config.OptionMap["CircuitBreakerHalfOpenProbes"] = strconv.Itoa(config.CircuitBreakerHalfOpenProbes)
 	config.OptionMap["EmailDomainRestrictionEnabled"] = strconv.FormatBool(config.EmailDomainRestrictionEnabled)
 	config.OptionMap["EmailDomainWhitelist"] = strings.Join(config.EmailDomainWhitelist, ",")
 	config.OptionMap["SMTPServer"] = ""
@@ -66,6 +78,8 @@ func InitOptionMap() {
 	config.OptionMap["WeChatAccountQRCodeImageURL"] = ""
 	config.OptionMap["MessagePusherAddress"] = ""
 	config.OptionMap["MessagePusherToken"] = ""
+	config.OptionMap["NotifyTargets"] = message.NotifyTargets2JSONString()
+	config.OptionMap["NotifyDedupSeconds"] = strconv.Itoa(config.NotifyDedupSeconds)
 	config.OptionMap["TurnstileSiteKey"] = ""
 	config.OptionMap["TurnstileSecretKey"] = ""
 	config.OptionMap["QuotaForNewUser"] = strconv.FormatInt(config.QuotaForNewUser, 10)
@@ -76,6 +90,10 @@ func InitOptionMap() {
 	config.OptionMap["ModelRatio"] = billingratio.ModelRatio2JSONString()
 	config.OptionMap["GroupRatio"] = billingratio.GroupRatio2JSONString()
 	config.OptionMap["CompletionRatio"] = billingratio.CompletionRatio2JSONString()
+	config.OptionMap["ModelPrice"] = billingratio.ModelPrice2JSONString()
+	for option := range billingratio.TokenRatioOptions {
+		config.OptionMap[option] = billingratio.TokenRatio2JSONString(option)
+	}
 	config.OptionMap["TopUpLink"] = config.TopUpLink
 	config.OptionMap["ChatLink"] = config.ChatLink
 	config.OptionMap["QuotaPerUnit"] = strconv.FormatFloat(config.QuotaPerUnit, 'f', -1, 64)
@@ -86,6 +104,18 @@ func InitOptionMap() {
 		config.OptionMap["PoolMode"] = "random"
 	}
 	config.OptionMap["QuotaForAddChannel"] = strconv.Itoa(config.QuotaForAddChannel)
+	config.OptionMap["BatchRatio"] = strconv.FormatFloat(config.BatchRatio, 'f', -1, 64)
+	config.OptionMap["ResponseCacheEnabled"] = strconv.FormatBool(config.ResponseCacheEnabled)
+	config.OptionMap["ResponseCacheTTL"] = strconv.Itoa(config.ResponseCacheTTL)
+	config.OptionMap["CacheRatio"] = strconv.FormatFloat(config.CacheRatio, 'f', -1, 64)
+	config.OptionMap["AuditRedactionRules"] = audit.RedactionRules2JSONString()
+	config.OptionMap["AuditRetentionDays"] = strconv.Itoa(config.AuditRetentionDays)
+	config.OptionMap["ModerationChannelId"] = strconv.Itoa(config.ModerationChannelId)
+	config.OptionMap["ModerationModel"] = config.ModerationModel
+	config.OptionMap["ModerationAction"] = config.ModerationAction
+	config.OptionMap["ModerationMaxViolations"] = strconv.Itoa(config.ModerationMaxViolations)
+	config.OptionMap["ModerationThresholds"] = moderation.Thresholds2JSONString()
+	config.OptionMap["OutputFilterRules"] = guardrail.RuleSets2JSONString()
 	config.OptionMapRWMutex.Unlock()
 	loadOptionsFromDatabase()
 }
@@ -96,6 +126,14 @@ func loadOptionsFromDatabase() {
 		if option.Key == "ModelRatio" {
 			option.Value = billingratio.AddNewMissingRatio(option.Value)
 		}
+		if IsSecretOption(option.Key) {
+			value, err := secret.Decrypt(option.Value)
+			if err != nil {
+				logger.SysError("failed to decrypt option " + option.Key + ": " + err.Error())
+				continue
+			}
+			option.Value = value
+		}
 		err := updateOptionMap(option.Key, option.Value)
 		if err != nil {
 			logger.SysError("failed to update option map: " + err.Error())
@@ -121,6 +159,13 @@ func UpdateOption(key string, value string) error {
 	// https://gorm.io/docs/update.html#Save-All-Fields
 	DB.FirstOrCreate(&option, Option{Key: key})
 	option.Value = value
+	if IsSecretOption(key) {
+		encrypted, err := secret.Encrypt(value)
+		if err != nil {
+			return err
+		}
+		option.Value = encrypted
+	}
 	// Save is a combination function.
 	// If save value does not contain primary key, it will execute Create,
 	// otherwise it will execute Update (with all fields).
@@ -174,6 +219,10 @@ func updateOptionMap(key string, value string) (err error) {
 			config.GeminiNewEnabled = boolValue
 		case "GeminiUploadImageEnabled":
 			config.GeminiUploadImageEnabled = boolValue
+		case "CircuitBreakerEnabled":
+			config.CircuitBreakerEnabled = boolValue
+		case "ResponseCacheEnabled":
+			config.ResponseCacheEnabled = boolValue
 		}
 	}
 	switch key {
@@ -230,6 +279,10 @@ func updateOptionMap(key string, value string) (err error) {
 		config.MessagePusherAddress = value
 	case "MessagePusherToken":
 		config.MessagePusherToken = value
+	case "NotifyTargets":
+		err = message.UpdateNotifyTargetsByJSONString(value)
+	case "NotifyDedupSeconds":
+		config.NotifyDedupSeconds, _ = strconv.Atoi(value)
 	case "TurnstileSiteKey":
 		config.TurnstileSiteKey = value
 	case "TurnstileSecretKey":
@@ -254,14 +307,50 @@ func updateOptionMap(key string, value string) (err error) {
 		err = billingratio.UpdateGroupRatioByJSONString(value)
 	case "CompletionRatio":
 		err = billingratio.UpdateCompletionRatioByJSONString(value)
+	case "ModelPrice":
+		err = billingratio.UpdateModelPriceByJSONString(value)
+	case "CachedInputRatio", "CacheCreationRatio", "AudioInputRatio", "AudioOutputRatio", "ImageInputRatio", "ImageOutputRatio":
+		err = billingratio.UpdateTokenRatioByJSONString(key, value)
 	case "TopUpLink":
 		config.TopUpLink = value
 	case "ChatLink":
 		config.ChatLink = value
 	case "ChannelDisableThreshold":
 		config.ChannelDisableThreshold, _ = strconv.ParseFloat(value, 64)
+	case "ChannelErrorRules":
+		err = errorrule.UpdateRulesByJSONString(value)
+	case "CircuitBreakerThreshold":
+		config.CircuitBreakerThreshold, _ = strconv.Atoi(value)
+	case "CircuitBreakerWindowSeconds":
+		config.CircuitBreakerWindowSeconds, _ = strconv.Atoi(value)
+	case "CircuitBreakerOpenSeconds":
+		config.CircuitBreakerOpenSeconds, _ = strconv.Atoi(value)
+	case "CircuitBreakerHalfOpenProbes":
+		config.CircuitBreakerHalfOpenProbes, _ = strconv.Atoi(value)
 	case "QuotaPerUnit":
 		config.QuotaPerUnit, _ = strconv.ParseFloat(value, 64)
+	case "BatchRatio":
+		config.BatchRatio, _ = strconv.ParseFloat(value, 64)
+	case "ResponseCacheTTL":
+		config.ResponseCacheTTL, _ = strconv.Atoi(value)
+	case "CacheRatio":
+		config.CacheRatio, _ = strconv.ParseFloat(value, 64)
+	case "AuditRedactionRules":
+		err = audit.UpdateRedactionRulesByJSONString(value)
+	case "AuditRetentionDays":
+		config.AuditRetentionDays, _ = strconv.Atoi(value)
+	case "ModerationChannelId":
+		config.ModerationChannelId, _ = strconv.Atoi(value)
+	case "ModerationModel":
+		config.ModerationModel = value
+	case "ModerationAction":
+		config.ModerationAction = value
+	case "ModerationMaxViolations":
+		config.ModerationMaxViolations, _ = strconv.Atoi(value)
+	case "ModerationThresholds":
+		err = moderation.UpdateThresholdsByJSONString(value)
+	case "OutputFilterRules":
+		err = guardrail.UpdateRuleSetsByJSONString(value)
 	case "Theme":
 		config.Theme = value
 	case "PoolMode":
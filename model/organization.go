@@ -0,0 +1,390 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"gorm.io/gorm"
+)
+
+// 组织成员的角色，上级组织的角色在下级组织中同样有效
+const (
+	OrgRoleMember = 1
+	OrgRoleAdmin  = 10
+	OrgRoleOwner  = 100
+)
+
+const (
+	OrgInvitationStatusPending  = 1
+	OrgInvitationStatusAccepted = 2
+	OrgInvitationStatusRevoked  = 3
+)
+
+// organizations are nested at most this deep, which also stops the walk on a broken parent chain
+const maxOrgDepth = 8
+
+var (
+	ErrInsufficientOrgQuota = errors.New("insufficient organization quota")
+	ErrLastOrgOwner         = errors.New("the organization must keep an owner")
+)
+
+// Organization 是一个团队，成员共享组织的额度池，组织令牌的消耗从额度池扣除
+type Organization struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"index"`
+	ParentId    int    `json:"parent_id" gorm:"index;default:0"` // 0 means a top level organization
+	Quota       int64  `json:"quota" gorm:"bigint;default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	Role        int    `json:"role" gorm:"-"` // role of the current user, inherited from the parents
+}
+
+type OrganizationMember struct {
+	Id          int    `json:"id"`
+	OrgId       int    `json:"org_id" gorm:"uniqueIndex:idx_org_member"`
+	UserId      int    `json:"user_id" gorm:"uniqueIndex:idx_org_member;index"`
+	Role        int    `json:"role" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	Username    string `json:"username" gorm:"-"`
+}
+
+// OrganizationInvitation 是发往邮箱的邀请，凭邀请码加入组织
+type OrganizationInvitation struct {
+	Id          int    `json:"id"`
+	OrgId       int    `json:"org_id" gorm:"index"`
+	Email       string `json:"email"`
+	Role        int    `json:"role" gorm:"default:1"`
+	Code        string `json:"-" gorm:"type:varchar(32);uniqueIndex"`
+	InviterId   int    `json:"inviter_id"`
+	Status      int    `json:"status" gorm:"default:1"`
+	ExpiredTime int64  `json:"expired_time" gorm:"bigint"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	org := &Organization{}
+	err := DB.First(org, "id = ?", id).Error
+	return org, err
+}
+
+// GetAllOrganizations 返回所有组织，供系统管理员使用
+func GetAllOrganizations(startIdx int, num int) (orgs []*Organization, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&orgs).Error
+	return orgs, err
+}
+
+// GetUserOrganizations returns the organizations the user belongs to, and their descendants, with the role of the user
+func GetUserOrganizations(userId int) ([]*Organization, error) {
+	var members []*OrganizationMember
+	if err := DB.Where("user_id = ?", userId).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	roles := make(map[int]int)
+	for _, member := range members {
+		ids, err := GetOrganizationTreeIds(member.OrgId)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			roles[id] = max(roles[id], member.Role)
+		}
+	}
+	if len(roles) == 0 {
+		return []*Organization{}, nil
+	}
+	ids := make([]int, 0, len(roles))
+	for id := range roles {
+		ids = append(ids, id)
+	}
+	var orgs []*Organization
+	if err := DB.Where("id IN ?", ids).Order("id").Find(&orgs).Error; err != nil {
+		return nil, err
+	}
+	for _, org := range orgs {
+		org.Role = roles[org.Id]
+	}
+	return orgs, nil
+}
+
+// CreateOrganization 创建组织，创建者成为所有者
+func CreateOrganization(org *Organization, ownerId int) error {
+	if org.ParentId != 0 {
+		depth := 0
+		for parentId := org.ParentId; parentId != 0; depth++ {
+			if depth >= maxOrgDepth-1 {
+				return fmt.Errorf("organizations can be nested at most %d levels", maxOrgDepth)
+			}
+			parent, err := GetOrganizationById(parentId)
+			if err != nil {
+				return err
+			}
+			parentId = parent.ParentId
+		}
+	}
+	org.Quota = 0
+	org.CreatedTime = helper.GetTimestamp()
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{OrgId: org.Id, UserId: ownerId, Role: OrgRoleOwner, CreatedTime: org.CreatedTime}).Error
+	})
+}
+
+func (org *Organization) Update() error {
+	return DB.Model(org).Select("name").Updates(org).Error
+}
+
+// DeleteOrganization 删除没有下级组织且额度池为空的组织，组织的令牌被禁用
+func DeleteOrganization(id int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var org Organization
+		if err := tx.First(&org, "id = ?", id).Error; err != nil {
+			return err
+		}
+		var children int64
+		if err := tx.Model(&Organization{}).Where("parent_id = ?", id).Count(&children).Error; err != nil {
+			return err
+		}
+		if children > 0 {
+			return errors.New("请先删除下级组织")
+		}
+		if org.Quota != 0 {
+			return errors.New("请先转出组织的额度")
+		}
+		if err := tx.Model(&Token{}).Where("org_id = ?", id).Update("status", TokenStatusDisabled).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("org_id = ?", id).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("org_id = ?", id).Delete(&OrganizationInvitation{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&org).Error
+	})
+}
+
+// GetOrganizationRole returns the role of the user in the organization, the highest one of the organization and its parents,
+// 0 means the user is not a member
+func GetOrganizationRole(orgId int, userId int) (int, error) {
+	role := 0
+	for depth := 0; orgId != 0 && depth < maxOrgDepth; depth++ {
+		var member OrganizationMember
+		err := DB.Where("org_id = ? AND user_id = ?", orgId, userId).Limit(1).Find(&member).Error
+		if err != nil {
+			return 0, err
+		}
+		role = max(role, member.Role)
+		parentId := 0
+		if err = DB.Model(&Organization{}).Where("id = ?", orgId).Select("parent_id").Scan(&parentId).Error; err != nil {
+			return 0, err
+		}
+		orgId = parentId
+	}
+	return role, nil
+}
+
+// GetOrganizationTreeIds returns the id of the organization and of all its descendants
+func GetOrganizationTreeIds(orgId int) ([]int, error) {
+	ids := []int{orgId}
+	parents := []int{orgId}
+	for depth := 0; len(parents) > 0 && depth < maxOrgDepth; depth++ {
+		var children []int
+		if err := DB.Model(&Organization{}).Where("parent_id IN ?", parents).Pluck("id", &children).Error; err != nil {
+			return nil, err
+		}
+		ids = append(ids, children...)
+		parents = children
+	}
+	return ids, nil
+}
+
+// GetOrganizationMembers returns the direct members of the organization, the members of the parents are not listed
+func GetOrganizationMembers(orgId int) ([]*OrganizationMember, error) {
+	var members []*OrganizationMember
+	if err := DB.Where("org_id = ?", orgId).Order("role desc, id").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		member.Username = GetUsernameById(member.UserId)
+	}
+	return members, nil
+}
+
+func GetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	member := &OrganizationMember{}
+	err := DB.First(member, "org_id = ? AND user_id = ?", orgId, userId).Error
+	return member, err
+}
+
+// SetOrganizationMember 加入成员或修改成员的角色，组织至少保留一个所有者
+func SetOrganizationMember(orgId int, userId int, role int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var member OrganizationMember
+		err := tx.Where("org_id = ? AND user_id = ?", orgId, userId).Limit(1).Find(&member).Error
+		if err != nil {
+			return err
+		}
+		if member.Id == 0 {
+			return tx.Create(&OrganizationMember{OrgId: orgId, UserId: userId, Role: role, CreatedTime: helper.GetTimestamp()}).Error
+		}
+		if member.Role == OrgRoleOwner && role != OrgRoleOwner {
+			if err = checkOtherOwner(tx, orgId, userId); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&member).Update("role", role).Error
+	})
+}
+
+func RemoveOrganizationMember(orgId int, userId int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkOtherOwner(tx, orgId, userId); err != nil {
+			return err
+		}
+		result := tx.Where("org_id = ? AND user_id = ?", orgId, userId).Delete(&OrganizationMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("该用户不是组织成员")
+		}
+		// the tokens issued by the organization stop drawing from its pool
+		return tx.Model(&Token{}).Where("org_id = ? AND user_id = ?", orgId, userId).Update("status", TokenStatusDisabled).Error
+	})
+}
+
+// checkOtherOwner fails when the user is the last owner of a top level organization,
+// the owners of the parents still manage a nested one
+func checkOtherOwner(tx *gorm.DB, orgId int, userId int) error {
+	var org Organization
+	if err := tx.First(&org, "id = ?", orgId).Error; err != nil {
+		return err
+	}
+	if org.ParentId != 0 {
+		return nil
+	}
+	var owners int64
+	err := tx.Model(&OrganizationMember{}).Where("org_id = ? AND role = ? AND user_id <> ?", orgId, OrgRoleOwner, userId).Count(&owners).Error
+	if err != nil {
+		return err
+	}
+	var isOwner int64
+	err = tx.Model(&OrganizationMember{}).Where("org_id = ? AND role = ? AND user_id = ?", orgId, OrgRoleOwner, userId).Count(&isOwner).Error
+	if err != nil {
+		return err
+	}
+	if isOwner > 0 && owners == 0 {
+		return ErrLastOrgOwner
+	}
+	return nil
+}
+
+func CreateOrganizationInvitation(invitation *OrganizationInvitation) error {
+	invitation.Status = OrgInvitationStatusPending
+	invitation.CreatedTime = helper.GetTimestamp()
+	return DB.Create(invitation).Error
+}
+
+func GetOrganizationInvitations(orgId int) (invitations []*OrganizationInvitation, err error) {
+	err = DB.Where("org_id = ? AND status = ?", orgId, OrgInvitationStatusPending).Order("id desc").Find(&invitations).Error
+	return invitations, err
+}
+
+func RevokeOrganizationInvitation(orgId int, id int) error {
+	return DB.Model(&OrganizationInvitation{}).Where("id = ? AND org_id = ? AND status = ?", id, orgId, OrgInvitationStatusPending).
+		Update("status", OrgInvitationStatusRevoked).Error
+}
+
+// AcceptOrganizationInvitation 使用邀请码加入组织，邀请码只能使用一次
+func AcceptOrganizationInvitation(code string, userId int) (*OrganizationInvitation, error) {
+	invitation := &OrganizationInvitation{}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(invitation, "code = ?", code).Error; err != nil {
+			return errors.New("邀请码无效")
+		}
+		if invitation.ExpiredTime != 0 && invitation.ExpiredTime < helper.GetTimestamp() {
+			return errors.New("邀请已过期")
+		}
+		result := tx.Model(&OrganizationInvitation{}).Where("id = ? AND status = ?", invitation.Id, OrgInvitationStatusPending).
+			Update("status", OrgInvitationStatusAccepted)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("邀请已被使用或已撤销")
+		}
+		var member OrganizationMember
+		if err := tx.Where("org_id = ? AND user_id = ?", invitation.OrgId, userId).Limit(1).Find(&member).Error; err != nil {
+			return err
+		}
+		if member.Id != 0 {
+			// an invitation never lowers the role of a member
+			return tx.Model(&member).Update("role", max(member.Role, invitation.Role)).Error
+		}
+		return tx.Create(&OrganizationMember{OrgId: invitation.OrgId, UserId: userId, Role: invitation.Role, CreatedTime: helper.GetTimestamp()}).Error
+	})
+	return invitation, err
+}
+
+func GetOrganizationQuota(id int) (quota int64, err error) {
+	err = DB.Model(&Organization{}).Where("id = ?", id).Select("quota").Find(&quota).Error
+	return quota, err
+}
+
+// GetPayerQuota returns the quota which pays for the requests of a token, the pool of its organization or the quota of its user
+func GetPayerQuota(ctx context.Context, userId int, orgId int) (int64, error) {
+	if orgId != 0 {
+		return GetOrganizationQuota(orgId)
+	}
+	return CacheGetUserQuota(ctx, userId)
+}
+
+// AdjustOrganizationQuota 系统管理员增减组织的额度池
+func AdjustOrganizationQuota(orgId int, operatorId int, quota int64) error {
+	return ApplyQuotaMovement(&QuotaMovement{
+		UserId:    operatorId,
+		OrgId:     orgId,
+		Type:      LedgerTypeAdminAdjust,
+		OrgAmount: quota,
+		Remark:    fmt.Sprintf("管理员 %d 调整组织额度", operatorId),
+	})
+}
+
+// TransferOrganizationQuota moves quota from the user to the pool of the organization, a negative quota moves it back to the user
+func TransferOrganizationQuota(orgId int, userId int, quota int64) error {
+	if quota == 0 {
+		return errors.New("quota cannot be zero")
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		// the conditional update locks the row which gives the quota, and fails when it is not enough
+		var result *gorm.DB
+		if quota > 0 {
+			result = tx.Model(&User{}).Where("id = ? AND quota >= ?", userId, quota).Update("quota", gorm.Expr("quota - ?", quota))
+		} else {
+			result = tx.Model(&Organization{}).Where("id = ? AND quota >= ?", orgId, -quota).Update("quota", gorm.Expr("quota + ?", quota))
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if quota > 0 {
+				return ErrInsufficientUserQuota
+			}
+			return ErrInsufficientOrgQuota
+		}
+		// the giving side has been changed above, only the receiving side is left
+		if quota > 0 {
+			if err := tx.Model(&Organization{}).Where("id = ?", orgId).Update("quota", gorm.Expr("quota + ?", quota)).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota - ?", quota)).Error; err != nil {
+				return err
+			}
+		}
+		return appendQuotaLedger(tx, &QuotaMovement{UserId: userId, OrgId: orgId, Type: LedgerTypeOrgTransfer, Amount: -quota, OrgAmount: quota})
+	})
+}
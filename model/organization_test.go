@@ -0,0 +1,76 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestOrganization(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB := DB
+	DB = db
+	defer func() { DB = oldDB }()
+	assert.Nil(t, DB.AutoMigrate(&User{}, &Token{}, &QuotaReservation{}, &QuotaLedger{},
+		&Organization{}, &OrganizationMember{}, &OrganizationInvitation{}, &Budget{}))
+	assert.Nil(t, DB.Create(&User{Id: 1, Username: "owner", AccessToken: "a", AffCode: "a", Quota: 1000}).Error)
+	assert.Nil(t, DB.Create(&User{Id: 2, Username: "member", AccessToken: "b", AffCode: "b", Quota: 0}).Error)
+
+	team := &Organization{Name: "team"}
+	assert.Nil(t, CreateOrganization(team, 1))
+	sub := &Organization{Name: "sub", ParentId: team.Id}
+	assert.Nil(t, CreateOrganization(sub, 1))
+	assert.Nil(t, CreateOrganizationInvitation(&OrganizationInvitation{OrgId: sub.Id, Email: "m@example.com", Role: OrgRoleMember, Code: "code"}))
+	_, err = AcceptOrganizationInvitation("code", 2)
+	assert.Nil(t, err)
+	_, err = AcceptOrganizationInvitation("code", 2)
+	assert.NotNil(t, err)
+
+	// the role in the parent is inherited
+	role, err := GetOrganizationRole(sub.Id, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, OrgRoleOwner, role)
+	role, err = GetOrganizationRole(team.Id, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, role)
+	ids, err := GetOrganizationTreeIds(team.Id)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []int{team.Id, sub.Id}, ids)
+	assert.Equal(t, ErrLastOrgOwner, RemoveOrganizationMember(team.Id, 1))
+
+	// the token of the member draws from the pool of the organization
+	assert.Nil(t, TransferOrganizationQuota(sub.Id, 1, 600))
+	assert.Equal(t, ErrInsufficientOrgQuota, TransferOrganizationQuota(sub.Id, 1, -700))
+	assert.Nil(t, DB.Create(&Token{Id: 1, UserId: 2, Key: "org", UnlimitedQuota: true, OrgId: sub.Id}).Error)
+	assert.Nil(t, ReserveQuota("a", 1, 2, 500))
+	assert.Equal(t, ErrInsufficientOrgQuota, ReserveQuota("b", 1, 2, 200))
+	assert.Nil(t, SettleQuotaReservation("a", 300))
+
+	quota, err := GetOrganizationQuota(sub.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(300), quota)
+	userQuota, err := GetUserQuota(2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), userQuota)
+	userQuota, err = GetUserQuota(1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(400), userQuota)
+
+	mismatches, total, err := CheckQuotaLedger()
+	assert.Nil(t, err)
+	assert.Empty(t, mismatches)
+	assert.Equal(t, int64(0), total)
+
+	assert.NotNil(t, DeleteOrganization(team.Id))
+	assert.NotNil(t, DeleteOrganization(sub.Id))
+	assert.Nil(t, TransferOrganizationQuota(sub.Id, 1, -300))
+	assert.Nil(t, DeleteOrganization(sub.Id))
+	token, err := GetTokenById(1)
+	assert.Nil(t, err)
+	assert.Equal(t, TokenStatusDisabled, token.Status)
+}
@@ -70,7 +70,12 @@ func Redeem(key string, userId int) (quota int64, err error) {
 		if redemption.Status != RedemptionCodeStatusEnabled {
 			return errors.New("该兑换码已被使用")
 		}
-		err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+		err = applyQuotaMovement(tx, &QuotaMovement{
+			UserId: userId,
+			Type:   LedgerTypeRedemption,
+			Amount: redemption.Quota,
+			Remark: fmt.Sprintf("兑换码 %d", redemption.Id),
+		})
 		if err != nil {
 			return err
 		}
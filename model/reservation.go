@@ -0,0 +1,229 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	ReservationStatusOpen     = 1
+	ReservationStatusSettled  = 2
+	ReservationStatusReleased = 3
+	// ReservationStatusExpired is settled by the sweeper at the reserved quota, the request may still finish and settle the difference
+	ReservationStatusExpired = 4
+)
+
+var (
+	ErrInsufficientTokenQuota    = errors.New("insufficient token quota")
+	ErrInsufficientUserQuota     = errors.New("insufficient user quota")
+	ErrTooManyConcurrentRequests = errors.New("too many concurrent requests of the token")
+	ErrQuotaReservationNotOpen   = errors.New("quota reservation is already settled or released")
+	ErrQuotaReservationExpired   = errors.New("quota reservation was settled by the sweeper, only the difference is settled")
+)
+
+// QuotaReservation 是一次请求预扣的额度，预扣时额度已经从令牌和用户扣除，结算或释放只会发生一次
+type QuotaReservation struct {
+	Id          string `json:"id" gorm:"primaryKey;type:varchar(64)"` // request id of the attempt
+	TokenId     int    `json:"token_id" gorm:"index:idx_reservation_token_status"`
+	UserId      int    `json:"user_id"`
+	Quota       int64  `json:"quota" gorm:"bigint"`
+	Status      int    `json:"status" gorm:"index:idx_reservation_token_status"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint;index"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// ReserveQuota 预扣额度并记录一条未结算的预扣，令牌的并发请求数达到上限时返回 ErrTooManyConcurrentRequests
+func ReserveQuota(id string, tokenId int, userId int, quota int64) error {
+	if quota < 0 {
+		return errors.New("quota cannot be negative")
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var token Token
+		if err := tx.Select("id", "user_id", "org_id", "unlimited_quota", "max_concurrency").First(&token, "id = ?", tokenId).Error; err != nil {
+			return err
+		}
+		// the update locks the token row, so the checks below are serialized per token
+		if token.UnlimitedQuota {
+			if err := tx.Model(&Token{}).Where("id = ?", tokenId).Update("accessed_time", helper.GetTimestamp()).Error; err != nil {
+				return err
+			}
+		} else {
+			result := tx.Model(&Token{}).Where("id = ? AND remain_quota >= ?", tokenId, quota).Updates(map[string]interface{}{
+				"remain_quota":  gorm.Expr("remain_quota - ?", quota),
+				"used_quota":    gorm.Expr("used_quota + ?", quota),
+				"accessed_time": helper.GetTimestamp(),
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 && quota > 0 {
+				return ErrInsufficientTokenQuota
+			}
+		}
+		if token.MaxConcurrency > 0 {
+			var open int64
+			if err := tx.Model(&QuotaReservation{}).Where("token_id = ? AND status = ?", tokenId, ReservationStatusOpen).Count(&open).Error; err != nil {
+				return err
+			}
+			if open >= int64(token.MaxConcurrency) {
+				return ErrTooManyConcurrentRequests
+			}
+		}
+		// the tokens of an organization draw from its pool instead of the quota of the user
+		if token.OrgId != 0 {
+			result := tx.Model(&Organization{}).Where("id = ? AND quota >= ?", token.OrgId, quota).Update("quota", gorm.Expr("quota - ?", quota))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 && quota > 0 {
+				return ErrInsufficientOrgQuota
+			}
+		} else {
+			result := tx.Model(&User{}).Where("id = ? AND quota >= ?", userId, quota).Update("quota", gorm.Expr("quota - ?", quota))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 && quota > 0 {
+				return ErrInsufficientUserQuota
+			}
+		}
+		movement := token.quotaMovement(LedgerTypeReserve, -quota)
+		movement.UserId = userId
+		movement.RequestId = id
+		if err := chargeBudgets(tx, tokenId, userId, quota, true); err != nil {
+			return err
+		}
+		if err := appendQuotaLedger(tx, movement); err != nil {
+			return err
+		}
+		now := helper.GetTimestamp()
+		return tx.Create(&QuotaReservation{
+			Id:          id,
+			TokenId:     tokenId,
+			UserId:      userId,
+			Quota:       quota,
+			Status:      ReservationStatusOpen,
+			CreatedTime: now,
+			UpdatedTime: now,
+		}).Error
+	})
+}
+
+// closeReservation moves the reservation from the status from to status, settled at quota or released,
+// and applies the difference to the token and the user
+func closeReservation(id string, quota int64, from int, status int) (*QuotaReservation, error) {
+	reservation := &QuotaReservation{}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(reservation, "id = ?", id).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&QuotaReservation{}).Where("id = ? AND status = ?", id, from).Updates(map[string]interface{}{
+			"status":       status,
+			"updated_time": helper.GetTimestamp(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrQuotaReservationNotOpen
+		}
+		delta := quota - reservation.Quota
+		if delta == 0 {
+			return nil
+		}
+		var token Token
+		if err := tx.Select("id", "org_id", "unlimited_quota").First(&token, "id = ?", reservation.TokenId).Error; err != nil {
+			return err
+		}
+		ledgerType := LedgerTypeConsume
+		if delta < 0 {
+			ledgerType = LedgerTypeRefund
+		}
+		movement := token.quotaMovement(ledgerType, -delta)
+		movement.UserId = reservation.UserId
+		movement.RequestId = id
+		return applyQuotaMovement(tx, movement)
+	})
+	return reservation, err
+}
+
+// SettleQuotaReservation 按实际消耗结算预扣，多退少补，重复结算返回 ErrQuotaReservationNotOpen。
+// 已经被超时结算的预扣补结算差额并修正用户的已用额度，返回 ErrQuotaReservationExpired，调用方不要再记已用额度
+func SettleQuotaReservation(id string, quota int64) error {
+	_, err := closeReservation(id, quota, ReservationStatusOpen, ReservationStatusSettled)
+	if !errors.Is(err, ErrQuotaReservationNotOpen) {
+		return err
+	}
+	reservation, err := closeReservation(id, quota, ReservationStatusExpired, ReservationStatusSettled)
+	if err != nil || reservation.Quota == 0 {
+		return err
+	}
+	updateUserUsedQuota(reservation.UserId, quota-reservation.Quota)
+	return ErrQuotaReservationExpired
+}
+
+// ReleaseQuotaReservation 释放预扣并退还全部预扣额度，已经被超时结算的预扣同时撤销记入的已用额度和请求数
+func ReleaseQuotaReservation(id string) error {
+	_, err := closeReservation(id, 0, ReservationStatusOpen, ReservationStatusReleased)
+	if !errors.Is(err, ErrQuotaReservationNotOpen) {
+		return err
+	}
+	reservation, err := closeReservation(id, 0, ReservationStatusExpired, ReservationStatusReleased)
+	if err != nil || reservation.Quota == 0 {
+		return err
+	}
+	updateUserUsedQuotaAndRequestCount(reservation.UserId, -reservation.Quota, -1)
+	return nil
+}
+
+// GetOpenQuotaReservationCount 返回令牌未结算的预扣数，即正在进行的请求数
+func GetOpenQuotaReservationCount(tokenId int) (int64, error) {
+	var count int64
+	err := DB.Model(&QuotaReservation{}).Where("token_id = ? AND status = ?", tokenId, ReservationStatusOpen).Count(&count).Error
+	return count, err
+}
+
+// sweepQuotaReservations settles the reservations left open by a crashed node at the reserved quota,
+// the request may have been served, so the reservation is charged instead of refunded.
+// A long request which is still running settles the difference when it finishes
+func sweepQuotaReservations() {
+	now := helper.GetTimestamp()
+	var reservations []*QuotaReservation
+	err := DB.Where("status = ? AND created_time < ?", ReservationStatusOpen, now-int64(config.QuotaReservationTimeout)).Find(&reservations).Error
+	if err != nil {
+		logger.SysError("failed to load stale quota reservations: " + err.Error())
+		return
+	}
+	for _, reservation := range reservations {
+		if _, err := closeReservation(reservation.Id, reservation.Quota, ReservationStatusOpen, ReservationStatusExpired); err != nil {
+			if !errors.Is(err, ErrQuotaReservationNotOpen) {
+				logger.SysError("failed to settle stale quota reservation " + reservation.Id + ": " + err.Error())
+			}
+			continue
+		}
+		if reservation.Quota > 0 {
+			UpdateUserUsedQuotaAndRequestCount(reservation.UserId, reservation.Quota)
+			RecordLog(reservation.UserId, LogTypeSystem, fmt.Sprintf("请求 %s 超时未结算，已按预扣额度 %s 结算", reservation.Id, common.LogQuota(reservation.Quota)))
+		}
+		logger.SysLogf("settled stale quota reservation %s of token %d", reservation.Id, reservation.TokenId)
+	}
+	// closed reservations are kept for a day for troubleshooting
+	if err := DB.Where("status <> ? AND updated_time < ?", ReservationStatusOpen, now-24*60*60).Delete(&QuotaReservation{}).Error; err != nil {
+		logger.SysError("failed to delete closed quota reservations: " + err.Error())
+	}
+}
+
+// SweepQuotaReservations 启动时以及之后每 frequency 秒结算超时未结算的预扣
+func SweepQuotaReservations(frequency int) {
+	for {
+		sweepQuotaReservations()
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
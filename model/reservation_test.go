@@ -0,0 +1,94 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestQuotaReservation(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB := DB
+	DB = db
+	defer func() { DB = oldDB }()
+	assert.Nil(t, DB.AutoMigrate(&User{}, &Token{}, &QuotaReservation{}, &QuotaLedger{}, &Budget{}))
+	assert.Nil(t, DB.Create(&User{Id: 1, Username: "test", Quota: 1000}).Error)
+	assert.Nil(t, DB.Create(&Token{Id: 1, UserId: 1, Key: "test", RemainQuota: 500, MaxConcurrency: 2}).Error)
+
+	getQuota := func() (int64, int64) {
+		var user User
+		var token Token
+		DB.First(&user, 1)
+		DB.First(&token, 1)
+		return user.Quota, token.RemainQuota
+	}
+
+	assert.Nil(t, ReserveQuota("a", 1, 1, 300))
+	assert.ErrorIs(t, ReserveQuota("b", 1, 1, 300), ErrInsufficientTokenQuota)
+	assert.Nil(t, ReserveQuota("c", 1, 1, 100))
+	// the third request in flight exceeds max_concurrency
+	assert.ErrorIs(t, ReserveQuota("d", 1, 1, 0), ErrTooManyConcurrentRequests)
+	userQuota, tokenQuota := getQuota()
+	assert.Equal(t, int64(600), userQuota)
+	assert.Equal(t, int64(100), tokenQuota)
+
+	// settled at the real usage, only once
+	assert.Nil(t, SettleQuotaReservation("a", 200))
+	assert.ErrorIs(t, SettleQuotaReservation("a", 200), ErrQuotaReservationNotOpen)
+	assert.ErrorIs(t, ReleaseQuotaReservation("a"), ErrQuotaReservationNotOpen)
+	assert.Nil(t, ReleaseQuotaReservation("c"))
+	userQuota, tokenQuota = getQuota()
+	assert.Equal(t, int64(800), userQuota)
+	assert.Equal(t, int64(300), tokenQuota)
+
+	count, err := GetOpenQuotaReservationCount(1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestSweptQuotaReservation(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB := DB
+	DB = db
+	defer func() { DB = oldDB }()
+	LOG_DB = db
+	common.RedisEnabled = false
+	assert.Nil(t, DB.AutoMigrate(&User{}, &Token{}, &QuotaReservation{}, &QuotaLedger{}, &Budget{}, &Log{}))
+	assert.Nil(t, DB.Create(&User{Id: 1, Username: "test", Quota: 1000}).Error)
+	assert.Nil(t, DB.Create(&Token{Id: 1, UserId: 1, Key: "test", RemainQuota: 500}).Error)
+
+	getUser := func() User {
+		var user User
+		DB.First(&user, 1)
+		return user
+	}
+
+	assert.Nil(t, ReserveQuota("a", 1, 1, 100))
+	assert.Nil(t, ReserveQuota("b", 1, 1, 100))
+	DB.Model(&QuotaReservation{}).Where("1 = 1").Update("created_time", helper.GetTimestamp()-int64(config.QuotaReservationTimeout)-1)
+	sweepQuotaReservations()
+	user := getUser()
+	assert.Equal(t, int64(800), user.Quota)
+	assert.Equal(t, int64(200), user.UsedQuota)
+	assert.Equal(t, 2, user.RequestCount)
+
+	// the long request finishes after the sweep and settles the difference only
+	assert.ErrorIs(t, SettleQuotaReservation("a", 150), ErrQuotaReservationExpired)
+	assert.ErrorIs(t, SettleQuotaReservation("a", 150), ErrQuotaReservationNotOpen)
+	assert.Nil(t, ReleaseQuotaReservation("b"))
+	user = getUser()
+	assert.Equal(t, int64(850), user.Quota)
+	assert.Equal(t, int64(150), user.UsedQuota)
+	assert.Equal(t, 1, user.RequestCount)
+}
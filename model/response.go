@@ -0,0 +1,81 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+// maxResponseChainDepth bounds the walk over previous_response_id, a chain longer than this is cut at its oldest end
+const maxResponseChainDepth = 256
+
+// StoredResponse keeps a response of the responses api with the input items of its turn,
+// so that previous_response_id can be chained whatever channel served the previous turn
+type StoredResponse struct {
+	Id                 string `json:"id" gorm:"primaryKey;type:varchar(64)"`
+	UserId             int    `json:"user_id" gorm:"index"`
+	TokenId            int    `json:"token_id" gorm:"index"`
+	Model              string `json:"model" gorm:"type:varchar(255)"`
+	PreviousResponseId string `json:"previous_response_id" gorm:"type:varchar(64);default:''"`
+	Input              string `json:"input"`    // json array of the input items of this turn
+	Output             string `json:"output"`   // json array of the output items
+	Response           string `json:"response"` // the response object as sent to the client
+	CreatedAt          int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+func (response *StoredResponse) Insert() error {
+	response.CreatedAt = helper.GetTimestamp()
+	return DB.Create(response).Error
+}
+
+func GetStoredResponseById(id string, userId int) (*StoredResponse, error) {
+	if id == "" {
+		return nil, errors.New("id 为空！")
+	}
+	var response StoredResponse
+	err := DB.Where("id = ? AND user_id = ?", id, userId).First(&response).Error
+	return &response, err
+}
+
+func DeleteStoredResponseById(id string, userId int) error {
+	result := DB.Where("id = ? AND user_id = ?", id, userId).Delete(&StoredResponse{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("response not found")
+	}
+	return nil
+}
+
+// GetResponseHistory returns the input and output items of the chain ending at id, oldest first
+func GetResponseHistory(id string, userId int) ([]any, error) {
+	var chain []*StoredResponse
+	for id != "" && len(chain) < maxResponseChainDepth {
+		response, err := GetStoredResponseById(id, userId)
+		if err != nil {
+			if len(chain) == 0 {
+				return nil, err
+			}
+			// an older turn was deleted, the conversation starts after it
+			break
+		}
+		chain = append(chain, response)
+		id = response.PreviousResponseId
+	}
+	var items []any
+	for i := len(chain) - 1; i >= 0; i-- {
+		for _, field := range []string{chain[i].Input, chain[i].Output} {
+			var turnItems []any
+			if field == "" {
+				continue
+			}
+			if err := json.Unmarshal([]byte(field), &turnItems); err != nil {
+				return nil, err
+			}
+			items = append(items, turnItems...)
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"gorm.io/gorm/clause"
+)
+
+// ResponseCache is an entry of the response cache, it is only used when redis is not enabled
+type ResponseCache struct {
+	Id        string `json:"id" gorm:"primaryKey;type:varchar(64)"` // hash of the request
+	Value     string `json:"value"`
+	ExpiresAt int64  `json:"expires_at" gorm:"bigint;index"`
+}
+
+func responseCacheRedisKey(key string) string {
+	return fmt.Sprintf("response_cache:%s", key)
+}
+
+// GetResponseCache returns the cached value of the key, an empty string means a miss
+func GetResponseCache(key string) (string, error) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(responseCacheRedisKey(key))
+		if err != nil {
+			// redis.Nil is a miss
+			return "", nil
+		}
+		return value, nil
+	}
+	var caches []*ResponseCache
+	if err := DB.Where("id = ? AND expires_at > ?", key, helper.GetTimestamp()).Limit(1).Find(&caches).Error; err != nil {
+		return "", err
+	}
+	if len(caches) == 0 {
+		return "", nil
+	}
+	return caches[0].Value, nil
+}
+
+func SetResponseCache(key string, value string, ttl int) error {
+	if common.RedisEnabled {
+		return common.RedisSet(responseCacheRedisKey(key), value, time.Duration(ttl)*time.Second)
+	}
+	cache := &ResponseCache{
+		Id:        key,
+		Value:     value,
+		ExpiresAt: helper.GetTimestamp() + int64(ttl),
+	}
+	return DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(cache).Error
+}
+
+// CleanExpiredResponseCaches deletes the expired entries of the database, redis expires its keys by itself
+func CleanExpiredResponseCaches(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		if common.RedisEnabled {
+			continue
+		}
+		result := DB.Where("expires_at <= ?", helper.GetTimestamp()).Delete(&ResponseCache{})
+		if result.Error != nil {
+			logger.SysError("failed to clean expired response caches: " + result.Error.Error())
+		} else if result.RowsAffected > 0 {
+			logger.SysLogf("cleaned %d expired response caches", result.RowsAffected)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/secret"
+	"gorm.io/gorm"
+)
+
+// secretOptions 是加密保存的选项，接口不会返回它们的值
+var secretOptions = map[string]bool{
+	"GitHubClientSecret": true,
+	"MessagePusherToken": true,
+	"NotifyTargets":      true,
+	"SMTPToken":          true,
+	"TurnstileSecretKey": true,
+	"WeChatServerToken":  true,
+}
+
+func IsSecretOption(key string) bool {
+	return secretOptions[key]
+}
+
+// secretConfigFields 是渠道配置中的密钥，接口返回时被遮盖
+var secretConfigFields = []string{"sk", "ak", "vertex_ai_adc"}
+
+// MaskChannelConfig masks the secrets in the config of a channel
+func MaskChannelConfig(config string) string {
+	var fields map[string]any
+	if config == "" || json.Unmarshal([]byte(config), &fields) != nil {
+		return config
+	}
+	for _, name := range secretConfigFields {
+		if value, ok := fields[name].(string); ok {
+			fields[name] = secret.Mask(value)
+		}
+	}
+	masked, err := json.Marshal(fields)
+	if err != nil {
+		return config
+	}
+	return string(masked)
+}
+
+// UnmaskChannelConfig restores the secrets which are submitted back as masked from the stored config
+func UnmaskChannelConfig(config string, storedConfig string) string {
+	var fields, stored map[string]any
+	if config == "" || json.Unmarshal([]byte(config), &fields) != nil || json.Unmarshal([]byte(storedConfig), &stored) != nil {
+		return config
+	}
+	changed := false
+	for _, name := range secretConfigFields {
+		value, ok := fields[name].(string)
+		storedValue, storedOk := stored[name].(string)
+		if ok && storedOk && value != storedValue && value == secret.Mask(storedValue) {
+			fields[name] = storedValue
+			changed = true
+		}
+	}
+	if !changed {
+		return config
+	}
+	unmasked, err := json.Marshal(fields)
+	if err != nil {
+		return config
+	}
+	return string(unmasked)
+}
+
+func encryptFields(fields ...*string) error {
+	for _, field := range fields {
+		encrypted, err := secret.Encrypt(*field)
+		if err != nil {
+			return err
+		}
+		*field = encrypted
+	}
+	return nil
+}
+
+func decryptFields(fields ...*string) error {
+	for _, field := range fields {
+		plaintext, err := secret.Decrypt(*field)
+		if err != nil {
+			return err
+		}
+		*field = plaintext
+	}
+	return nil
+}
+
+// savesColumn reports whether the statement writes the column, the hooks leave the struct alone for
+// the updates of other columns, such as the response time of a cached channel
+func savesColumn(tx *gorm.DB, column string) bool {
+	if len(tx.Statement.Selects) == 0 {
+		return true
+	}
+	for _, selected := range tx.Statement.Selects {
+		if selected == column || selected == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// the key and the config of a channel are encrypted when they are saved and decrypted when they are loaded,
+// the struct keeps the plaintext after it is saved
+
+func (channel *Channel) BeforeSave(tx *gorm.DB) error {
+	if !savesColumn(tx, "key") && !savesColumn(tx, "config") {
+		return nil
+	}
+	return encryptFields(&channel.Key, &channel.Config)
+}
+
+func (channel *Channel) AfterSave(tx *gorm.DB) error {
+	return decryptFields(&channel.Key, &channel.Config)
+}
+
+func (channel *Channel) AfterFind(tx *gorm.DB) error {
+	if err := decryptFields(&channel.Key, &channel.Config); err != nil {
+		return fmt.Errorf("failed to decrypt channel %d: %w", channel.Id, err)
+	}
+	return nil
+}
+
+func (key *ChannelKey) BeforeSave(tx *gorm.DB) error {
+	if !savesColumn(tx, "key") {
+		return nil
+	}
+	return encryptFields(&key.Key)
+}
+
+func (key *ChannelKey) AfterSave(tx *gorm.DB) error {
+	return decryptFields(&key.Key)
+}
+
+func (key *ChannelKey) AfterFind(tx *gorm.DB) error {
+	if err := decryptFields(&key.Key); err != nil {
+		return fmt.Errorf("failed to decrypt key %d of channel %d: %w", key.Id, key.ChannelId, err)
+	}
+	return nil
+}
+
+// rotateColumns rotates the columns of the rows of a table, the hooks are skipped to read and write the stored values
+func rotateColumns(table string, columns ...string) (int, error) {
+	rows, err := DB.Table(table).Select(append([]string{"id"}, columns...)).Rows()
+	if err != nil {
+		return 0, err
+	}
+	type update struct {
+		id      any
+		changes map[string]any
+	}
+	var updates []update
+	for rows.Next() {
+		var id any
+		values := make([]*string, len(columns))
+		dest := []any{&id}
+		for i := range values {
+			values[i] = new(string)
+			dest = append(dest, &values[i])
+		}
+		if err = rows.Scan(dest...); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		changes := make(map[string]any)
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+			rotated, changed, err := secret.Rotate(*value)
+			if err != nil {
+				_ = rows.Close()
+				return 0, fmt.Errorf("%s %v %s: %w", table, id, columns[i], err)
+			}
+			if changed {
+				changes[columns[i]] = rotated
+			}
+		}
+		if len(changes) > 0 {
+			updates = append(updates, update{id: id, changes: changes})
+		}
+	}
+	_ = rows.Close()
+	for _, u := range updates {
+		err = DB.Session(&gorm.Session{SkipHooks: true}).Table(table).Where("id = ?", u.id).UpdateColumns(u.changes).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(updates), nil
+}
+
+// RotateSecrets 使用当前的主密钥加密明文保存的密钥，并把旧主密钥加密的数据密钥改为当前主密钥加密
+func RotateSecrets() (int, error) {
+	if !secret.Enabled() {
+		return 0, fmt.Errorf("SECRET_MASTER_KEY is not set")
+	}
+	total := 0
+	for table, columns := range map[string][]string{
+		"channels":     {"key", "config"},
+		"channel_keys": {"key"},
+	} {
+		n, err := rotateColumns(table, columns...)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	var options []*Option
+	if err := DB.Find(&options).Error; err != nil {
+		return total, err
+	}
+	for _, option := range options {
+		if !IsSecretOption(option.Key) {
+			continue
+		}
+		rotated, changed, err := secret.Rotate(option.Value)
+		if err != nil {
+			return total, fmt.Errorf("option %s: %w", option.Key, err)
+		}
+		if changed {
+			if err = DB.Model(option).Update("value", rotated).Error; err != nil {
+				return total, err
+			}
+			total++
+		}
+	}
+	logger.SysLogf("%d rows of secrets were rotated", total)
+	return total, nil
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/secret"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestChannelSecrets(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB := DB
+	DB = db
+	defer func() { DB = oldDB }()
+	defer secret.SetMasterKeys("", nil)
+	assert.Nil(t, DB.AutoMigrate(&Channel{}, &ChannelKey{}, &Option{}))
+
+	// a channel saved before the encryption is enabled
+	assert.Nil(t, DB.Create(&Channel{Id: 1, Key: "sk-plain", Config: `{"sk":"s"}`}).Error)
+	secret.SetMasterKeys("old", nil)
+	channel := &Channel{Id: 2, Key: "sk-secret", Config: `{"ak":"a"}`}
+	assert.Nil(t, DB.Create(channel).Error)
+	assert.Equal(t, "sk-secret", channel.Key)
+	assert.Nil(t, DB.Create(&ChannelKey{ChannelId: 2, Index: 1, Key: "sk-pool"}).Error)
+
+	var stored string
+	DB.Table("channels").Select("key").Where("id = ?", 2).Scan(&stored)
+	assert.True(t, secret.IsEncrypted(stored))
+	loaded, err := GetChannelById(2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, "sk-secret", loaded.Key)
+	assert.Equal(t, `{"ak":"a"}`, loaded.Config)
+
+	// the update of another column leaves the key alone
+	loaded.UpdateResponseTime(100)
+	assert.Equal(t, "sk-secret", loaded.Key)
+
+	secret.SetMasterKeys("new", []string{"old"})
+	n, err := RotateSecrets()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	secret.SetMasterKeys("new", nil)
+	loaded, err = GetChannelById(1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, "sk-plain", loaded.Key)
+	keys, err := GetChannelKeys(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "sk-pool", keys[0].Key)
+	n, err = RotateSecrets()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
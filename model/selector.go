@@ -0,0 +1,190 @@
+package model
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/songquanpeng/one-api/common/config"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+)
+
+// pool modes, set globally by the PoolMode option or per group by Group.PoolMode
+const (
+	PoolModeRandom        = "random"
+	PoolModeWeighted      = "weighted"
+	PoolModeRoundRobin    = "round_robin"
+	PoolModeLeastInFlight = "least_inflight"
+	PoolModeLowestLatency = "lowest_latency"
+	PoolModeLowestCost    = "lowest_cost"
+	// PoolModePolling is the name of round_robin saved by the earlier versions
+	PoolModePolling = "polling"
+)
+
+// latencyAlpha is the weight of the newest sample in the latency moving average
+const latencyAlpha = 0.3
+
+// ChannelSelector picks one of the candidate channels, the candidates are never empty,
+// they are the first priority tier, or all the lower tiers when retrying
+type ChannelSelector interface {
+	Select(group string, model string, channels []*Channel) *Channel
+}
+
+var roundRobin = &roundRobinSelector{}
+
+var channelSelectors = map[string]ChannelSelector{
+	PoolModeRandom:        randomSelector{},
+	PoolModeWeighted:      weightedSelector{},
+	PoolModeRoundRobin:    roundRobin,
+	PoolModePolling:       roundRobin,
+	PoolModeLeastInFlight: leastInFlightSelector{},
+	PoolModeLowestLatency: lowestLatencySelector{},
+	PoolModeLowestCost:    lowestCostSelector{},
+}
+
+func IsValidPoolMode(mode string) bool {
+	_, ok := channelSelectors[mode]
+	return ok
+}
+
+// GetChannelSelector returns the selector of the group, falling back to the global PoolMode
+func GetChannelSelector(group string) ChannelSelector {
+	mode := config.PoolMode
+	if info, ok := GroupInfo[group]; ok && info.PoolMode != "" {
+		mode = info.PoolMode
+	}
+	if selector, ok := channelSelectors[mode]; ok {
+		return selector
+	}
+	return channelSelectors[PoolModeRandom]
+}
+
+type randomSelector struct{}
+
+func (randomSelector) Select(group string, model string, channels []*Channel) *Channel {
+	return channels[rand.Intn(len(channels))]
+}
+
+// weightedSelector picks a channel with a probability proportional to its weight,
+// channels are picked uniformly when none of them has a weight
+type weightedSelector struct{}
+
+func (weightedSelector) Select(group string, model string, channels []*Channel) *Channel {
+	total := 0
+	for _, channel := range channels {
+		total += channel.GetWeight()
+	}
+	if total == 0 {
+		return channels[rand.Intn(len(channels))]
+	}
+	n := rand.Intn(total)
+	for _, channel := range channels {
+		n -= channel.GetWeight()
+		if n < 0 {
+			return channel
+		}
+	}
+	return channels[len(channels)-1]
+}
+
+type roundRobinSelector struct {
+	counters sync.Map // group:model -> *atomic.Uint64
+}
+
+func (s *roundRobinSelector) Select(group string, model string, channels []*Channel) *Channel {
+	value, _ := s.counters.LoadOrStore(group+":"+model, new(atomic.Uint64))
+	n := value.(*atomic.Uint64).Add(1) - 1
+	return channels[n%uint64(len(channels))]
+}
+
+type leastInFlightSelector struct{}
+
+func (leastInFlightSelector) Select(group string, model string, channels []*Channel) *Channel {
+	return pickLowest(channels, func(channel *Channel) float64 {
+		return float64(GetChannelInFlight(channel.Id))
+	})
+}
+
+// lowestLatencySelector picks the channel with the lowest moving average of its latency,
+// channels without any sample use the response time of their last test, so untested channels are tried first
+type lowestLatencySelector struct{}
+
+func (lowestLatencySelector) Select(group string, model string, channels []*Channel) *Channel {
+	return pickLowest(channels, func(channel *Channel) float64 {
+		if latency, ok := GetChannelLatency(channel.Id); ok {
+			return latency
+		}
+		return float64(channel.ResponseTime)
+	})
+}
+
+// lowestCostSelector picks the channel with the lowest price for the model after its model mapping
+type lowestCostSelector struct{}
+
+func (lowestCostSelector) Select(group string, model string, channels []*Channel) *Channel {
+	return pickLowest(channels, func(channel *Channel) float64 {
+		name := model
+		if mapped, ok := channel.GetModelMapping()[model]; ok && mapped != "" {
+			name = mapped
+		}
+		return billingratio.GetModelRatio(name, channel.Type, group) * (1 + billingratio.GetCompletionRatio(name, channel.Type))
+	})
+}
+
+// pickLowest returns the channel with the lowest score, ties are broken randomly
+func pickLowest(channels []*Channel, score func(channel *Channel) float64) *Channel {
+	var lowest []*Channel
+	var lowestScore float64
+	for _, channel := range channels {
+		s := score(channel)
+		if len(lowest) == 0 || s < lowestScore {
+			lowest = append(lowest[:0], channel)
+			lowestScore = s
+		} else if s == lowestScore {
+			lowest = append(lowest, channel)
+		}
+	}
+	return lowest[rand.Intn(len(lowest))]
+}
+
+var channelInFlight sync.Map // channel id -> *atomic.Int64
+
+func channelInFlightCounter(id int) *atomic.Int64 {
+	value, _ := channelInFlight.LoadOrStore(id, new(atomic.Int64))
+	return value.(*atomic.Int64)
+}
+
+// IncreaseChannelInFlight counts a request relayed by this node to the channel, it must be paired with DecreaseChannelInFlight
+func IncreaseChannelInFlight(id int) {
+	channelInFlightCounter(id).Add(1)
+}
+
+func DecreaseChannelInFlight(id int) {
+	channelInFlightCounter(id).Add(-1)
+}
+
+func GetChannelInFlight(id int) int64 {
+	return channelInFlightCounter(id).Load()
+}
+
+var channelLatency = make(map[int]float64)
+var channelLatencyLock sync.RWMutex
+
+// RecordChannelLatency adds a sample (time to first token for streams, total time otherwise) to the latency of the channel
+func RecordChannelLatency(id int, milliseconds int64) {
+	channelLatencyLock.Lock()
+	defer channelLatencyLock.Unlock()
+	latency, ok := channelLatency[id]
+	if !ok {
+		channelLatency[id] = float64(milliseconds)
+		return
+	}
+	channelLatency[id] = latencyAlpha*float64(milliseconds) + (1-latencyAlpha)*latency
+}
+
+func GetChannelLatency(id int) (float64, bool) {
+	channelLatencyLock.RLock()
+	defer channelLatencyLock.RUnlock()
+	latency, ok := channelLatency[id]
+	return latency, ok
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func weight(w uint) *uint {
+	return &w
+}
+
+func TestWeightedSelector(t *testing.T) {
+	channels := []*Channel{{Id: 1, Weight: weight(0)}, {Id: 2, Weight: weight(3)}, {Id: 3}}
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, 2, weightedSelector{}.Select("default", "gpt-4o", channels).Id)
+	}
+}
+
+func TestRoundRobinSelector(t *testing.T) {
+	selector := &roundRobinSelector{}
+	channels := []*Channel{{Id: 1}, {Id: 2}, {Id: 3}}
+	var ids []int
+	for i := 0; i < 4; i++ {
+		ids = append(ids, selector.Select("default", "gpt-4o", channels).Id)
+	}
+	assert.Equal(t, []int{1, 2, 3, 1}, ids)
+	assert.Equal(t, 1, selector.Select("vip", "gpt-4o", channels).Id)
+}
+
+func TestLeastInFlightSelector(t *testing.T) {
+	channels := []*Channel{{Id: 101}, {Id: 102}}
+	IncreaseChannelInFlight(101)
+	defer DecreaseChannelInFlight(101)
+	assert.Equal(t, 102, leastInFlightSelector{}.Select("default", "gpt-4o", channels).Id)
+}
+
+func TestLowestLatencySelector(t *testing.T) {
+	channels := []*Channel{{Id: 201, ResponseTime: 100}, {Id: 202, ResponseTime: 50}}
+	assert.Equal(t, 202, lowestLatencySelector{}.Select("default", "gpt-4o", channels).Id)
+
+	RecordChannelLatency(202, 1000)
+	RecordChannelLatency(202, 2000)
+	latency, _ := GetChannelLatency(202)
+	assert.InDelta(t, 1300, latency, 0.001)
+	assert.Equal(t, 201, lowestLatencySelector{}.Select("default", "gpt-4o", channels).Id)
+}
+
+func TestPollingPoolMode(t *testing.T) {
+	// polling is saved by the earlier versions of the settings page
+	assert.True(t, IsValidPoolMode(PoolModePolling))
+	assert.Same(t, channelSelectors[PoolModeRoundRobin], channelSelectors[PoolModePolling])
+	assert.False(t, IsValidPoolMode("limit"))
+}
+
+func TestGetRandomSatisfiedChannel(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB := DB
+	DB = db
+	defer func() { DB = oldDB }()
+	common.RedisEnabled = false
+	assert.Nil(t, DB.AutoMigrate(&Channel{}, &Ability{}, &ChannelKey{}, &ChannelSleep{}))
+	assert.Nil(t, DB.Create(&Channel{Id: 301, Name: "single", Status: ChannelStatusEnabled}).Error)
+	assert.Nil(t, DB.Create(&Channel{Id: 302, Name: "keyless", Status: ChannelStatusEnabled, MultiKey: true}).Error)
+	for _, id := range []int{301, 302} {
+		assert.Nil(t, DB.Create(&Ability{Group: "default", Model: "gpt-4o", ChannelId: id, Enabled: true}).Error)
+	}
+	keys, err := AddChannelKeys(302, "key-a")
+	assert.Nil(t, err)
+	_, err = DisableChannelKey(302, keys[0].Id)
+	assert.Nil(t, err)
+
+	// the multi key channel without an available key is skipped as in the memory cache
+	for i := 0; i < 10; i++ {
+		channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", false)
+		assert.Nil(t, err)
+		assert.Equal(t, 301, channel.Id)
+	}
+}
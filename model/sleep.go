@@ -0,0 +1,290 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChannelSleep 记录渠道某个模型的休眠信息，开启 Redis 时保存在 Redis，否则保存在数据库，所有节点共享
+type ChannelSleep struct {
+	ChannelId   int    `json:"channel_id" gorm:"primaryKey;autoIncrement:false"`
+	Model       string `json:"model" gorm:"primaryKey;type:varchar(191)"`
+	AwakeTime   int64  `json:"awake_time" gorm:"bigint"`     // 唤醒时间，0 表示已唤醒
+	SleepCount  int    `json:"sleep_count" gorm:"default:0"` // 休眠次数，超过重置时间后重新计数
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`   // 最近一次休眠的时间
+}
+
+const channelSleepRedisKey = "channel_sleep"
+
+// channelSleepCountRedisKey 是休眠次数的计数器，每次休眠时续期，超过重置时间没有再休眠就过期
+const channelSleepCountRedisKey = "channel_sleep_count:"
+
+// channelSleepSyncSeconds 是本地休眠快照的刷新间隔
+const channelSleepSyncSeconds = 5
+
+func channelSleepField(channelId int, model string) string {
+	return fmt.Sprintf("%d:%s", channelId, model)
+}
+
+func (sleep *ChannelSleep) IsSleeping(now int64) bool {
+	return sleep.AwakeTime > now
+}
+
+func loadChannelSleeps() (map[string]*ChannelSleep, error) {
+	sleeps := make(map[string]*ChannelSleep)
+	if common.RedisEnabled {
+		values, err := common.RedisHashGetAll(channelSleepRedisKey)
+		if err != nil {
+			return nil, err
+		}
+		for field, value := range values {
+			sleep := &ChannelSleep{}
+			if err := json.Unmarshal([]byte(value), sleep); err != nil {
+				logger.SysError("failed to unmarshal channel sleep " + field + ": " + err.Error())
+				continue
+			}
+			sleeps[field] = sleep
+		}
+		return sleeps, nil
+	}
+	var list []*ChannelSleep
+	if err := DB.Find(&list).Error; err != nil {
+		return nil, err
+	}
+	for _, sleep := range list {
+		sleeps[channelSleepField(sleep.ChannelId, sleep.Model)] = sleep
+	}
+	return sleeps, nil
+}
+
+func getStoredChannelSleep(channelId int, model string) (*ChannelSleep, error) {
+	if common.RedisEnabled {
+		value, err := common.RedisHashGet(channelSleepRedisKey, channelSleepField(channelId, model))
+		if err != nil {
+			// redis.Nil means there is no sleep record
+			return nil, nil
+		}
+		sleep := &ChannelSleep{}
+		return sleep, json.Unmarshal([]byte(value), sleep)
+	}
+	var sleeps []*ChannelSleep
+	if err := DB.Where("channel_id = ? AND model = ?", channelId, model).Limit(1).Find(&sleeps).Error; err != nil {
+		return nil, err
+	}
+	if len(sleeps) == 0 {
+		return nil, nil
+	}
+	return sleeps[0], nil
+}
+
+// saveChannelSleep saves the awake time, the sleep count is only changed by increaseChannelSleepCount
+func saveChannelSleep(sleep *ChannelSleep) error {
+	if common.RedisEnabled {
+		return common.RedisHashSet(channelSleepRedisKey, channelSleepField(sleep.ChannelId, sleep.Model), sleep, 0)
+	}
+	return DB.Model(&ChannelSleep{}).Where("channel_id = ? AND model = ?", sleep.ChannelId, sleep.Model).Update("awake_time", sleep.AwakeTime).Error
+}
+
+// increaseChannelSleepCount increases the sleep count atomically, the count restarts from 1 after the reset time
+func increaseChannelSleepCount(channelId int, model string, now int64) (int, error) {
+	resetSeconds := int64(config.ChannelSleepResetSeconds)
+	if common.RedisEnabled {
+		count, err := common.RedisIncrease(channelSleepCountRedisKey+channelSleepField(channelId, model), time.Duration(resetSeconds)*time.Second)
+		return int(count), err
+	}
+	sleep := &ChannelSleep{ChannelId: channelId, Model: model, SleepCount: 1, UpdatedTime: now}
+	err := DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "channel_id"}, {Name: "model"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"sleep_count":  gorm.Expr("CASE WHEN channel_sleeps.updated_time < ? THEN 1 ELSE channel_sleeps.sleep_count + 1 END", now-resetSeconds),
+			"updated_time": now,
+		}),
+	}).Create(sleep).Error
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = DB.Model(&ChannelSleep{}).Where("channel_id = ? AND model = ?", channelId, model).Select("sleep_count").Scan(&count).Error
+	return count, err
+}
+
+func deleteChannelSleep(sleep *ChannelSleep) error {
+	if common.RedisEnabled {
+		_ = common.RedisDel(channelSleepCountRedisKey + channelSleepField(sleep.ChannelId, sleep.Model))
+		return common.RedisHashDel(channelSleepRedisKey, channelSleepField(sleep.ChannelId, sleep.Model))
+	}
+	return DB.Where("channel_id = ? AND model = ?", sleep.ChannelId, sleep.Model).Delete(&ChannelSleep{}).Error
+}
+
+// channelSleeps 是共享休眠状态的本地快照，选择渠道时只读快照，每 channelSleepSyncSeconds 秒刷新一次
+var channelSleeps = make(map[string]*ChannelSleep)
+var channelSleepsLock sync.RWMutex
+var channelSleepsSyncedAt atomic.Int64
+
+func syncChannelSleeps() {
+	sleeps, err := loadChannelSleeps()
+	if err != nil {
+		logger.SysError("failed to load channel sleeps: " + err.Error())
+		return
+	}
+	channelSleepsLock.Lock()
+	channelSleeps = sleeps
+	channelSleepsLock.Unlock()
+}
+
+func setLocalChannelSleep(sleep *ChannelSleep) {
+	channelSleepsLock.Lock()
+	channelSleeps[channelSleepField(sleep.ChannelId, sleep.Model)] = sleep
+	channelSleepsLock.Unlock()
+}
+
+// IsChannelModelSleeping 判断渠道的模型是否处于休眠中
+func IsChannelModelSleeping(channelId int, model string) bool {
+	now := helper.GetTimestamp()
+	syncedAt := channelSleepsSyncedAt.Load()
+	if now-syncedAt >= channelSleepSyncSeconds && channelSleepsSyncedAt.CompareAndSwap(syncedAt, now) {
+		// the first selection waits for the snapshot, the later ones read the previous snapshot while it is refreshed
+		if syncedAt == 0 {
+			syncChannelSleeps()
+		} else {
+			go syncChannelSleeps()
+		}
+	}
+	channelSleepsLock.RLock()
+	sleep := channelSleeps[channelSleepField(channelId, model)]
+	channelSleepsLock.RUnlock()
+	return sleep != nil && sleep.IsSleeping(now)
+}
+
+// GetSleepingChannelIds 返回模型处于休眠中的渠道
+func GetSleepingChannelIds(model string) []int {
+	IsChannelModelSleeping(0, model) // refreshes the snapshot if needed
+	now := helper.GetTimestamp()
+	var ids []int
+	channelSleepsLock.RLock()
+	defer channelSleepsLock.RUnlock()
+	for _, sleep := range channelSleeps {
+		if sleep.Model == model && sleep.IsSleeping(now) {
+			ids = append(ids, sleep.ChannelId)
+		}
+	}
+	return ids
+}
+
+// channelSleepSeconds 指数退避：第 n 次休眠的时长为 delay * 2^(n-1)，不超过 ChannelSleepMaxSeconds
+func channelSleepSeconds(delay int64, sleepCount int) int64 {
+	maxSeconds := int64(config.ChannelSleepMaxSeconds)
+	seconds := delay
+	for i := 1; i < sleepCount && seconds < maxSeconds; i++ {
+		seconds *= 2
+	}
+	if maxSeconds > 0 && seconds > maxSeconds {
+		seconds = maxSeconds
+	}
+	return seconds
+}
+
+// SleepChannel 让渠道的模型休眠，backoff 时 delay 为第一次休眠的秒数，之后每次翻倍，否则休眠 delay 秒
+func SleepChannel(channelType int, model string, channelId int, channelName string, delay int64, backoff bool) {
+	now := helper.GetTimestamp()
+	sleepCount, err := increaseChannelSleepCount(channelId, model, now)
+	if err != nil {
+		logger.SysError("failed to increase channel sleep count: " + err.Error())
+		sleepCount = 1
+	}
+	sleep := &ChannelSleep{ChannelId: channelId, Model: model, SleepCount: sleepCount, UpdatedTime: now}
+	sleep.AwakeTime = now + delay
+	if backoff {
+		sleep.AwakeTime = now + channelSleepSeconds(delay, sleepCount)
+	}
+	if err := saveChannelSleep(sleep); err != nil {
+		logger.SysError("failed to save channel sleep: " + err.Error())
+	}
+	setLocalChannelSleep(sleep)
+
+	logger.SysLogf("渠道 - [%s(%d)] , model - [%s] 已休眠至 %d, 已休眠次数: %d", channelName, channelId, model, sleep.AwakeTime, sleep.SleepCount)
+	// 当gemini渠道休眠次数达到10次时，禁用渠道
+	if sleep.SleepCount >= 10 && channelType == channeltype.Gemini {
+		logger.SysLogf("渠道 - [%s(%d)] , model - [%s] 已10次触发429错误, 开始自动禁用", channelName, channelId, model)
+		DisableChannel(channelId, channelName, "当前渠道已10次触发429错误")
+	}
+}
+
+// 渠道唤醒，到期的模型标记为已唤醒并保留休眠次数，超过重置时间没有再休眠的记录被删除
+func WakeupChannel(frequency int) {
+	for {
+		logger.SysLog("begining wakeup channel")
+		sleeps, err := loadChannelSleeps()
+		if err != nil {
+			logger.SysError("failed to load channel sleeps: " + err.Error())
+		}
+		now := helper.GetTimestamp()
+		for _, sleep := range sleeps {
+			if sleep.AwakeTime == 0 && now-sleep.UpdatedTime > int64(config.ChannelSleepResetSeconds) {
+				if err := deleteChannelSleep(sleep); err != nil {
+					logger.SysError("failed to delete channel sleep: " + err.Error())
+				}
+				continue
+			}
+			if sleep.AwakeTime != 0 && sleep.AwakeTime <= now {
+				// 其他节点可能刚刚让它再次休眠
+				if stored, err := getStoredChannelSleep(sleep.ChannelId, sleep.Model); err != nil || stored == nil || stored.AwakeTime > now {
+					continue
+				}
+				sleep.AwakeTime = 0
+				if err := saveChannelSleep(sleep); err != nil {
+					logger.SysError("failed to save channel sleep: " + err.Error())
+					continue
+				}
+				logger.SysLogf("渠道 - [%d] , model - [%s] 已唤醒，当前累积休眠次数: %d", sleep.ChannelId, sleep.Model, sleep.SleepCount)
+			}
+		}
+		syncChannelSleeps()
+		logger.SysLog("wakeup channel end")
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
+
+// GetChannelSleeps 返回所有的休眠记录，包括已唤醒但还没有重置的
+func GetChannelSleeps() ([]*ChannelSleep, error) {
+	sleeps, err := loadChannelSleeps()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*ChannelSleep, 0, len(sleeps))
+	for _, sleep := range sleeps {
+		list = append(list, sleep)
+	}
+	return list, nil
+}
+
+// ClearChannelSleeps 清除休眠记录，channelId 为 0 时清除所有渠道，model 为空时清除渠道的所有模型
+func ClearChannelSleeps(channelId int, model string) (int, error) {
+	sleeps, err := loadChannelSleeps()
+	if err != nil {
+		return 0, err
+	}
+	cleared := 0
+	for _, sleep := range sleeps {
+		if (channelId != 0 && sleep.ChannelId != channelId) || (model != "" && sleep.Model != model) {
+			continue
+		}
+		if err := deleteChannelSleep(sleep); err != nil {
+			return cleared, err
+		}
+		cleared++
+	}
+	syncChannelSleeps()
+	return cleared, nil
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestChannelSleepSeconds(t *testing.T) {
+	config.ChannelSleepMaxSeconds = 3600
+	assert.Equal(t, int64(600), channelSleepSeconds(600, 1))
+	assert.Equal(t, int64(1200), channelSleepSeconds(600, 2))
+	assert.Equal(t, int64(2400), channelSleepSeconds(600, 3))
+	assert.Equal(t, int64(3600), channelSleepSeconds(600, 4))
+	assert.Equal(t, int64(3600), channelSleepSeconds(600, 100))
+}
+
+func TestSleepChannel(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skip("sqlite is not available: " + err.Error())
+	}
+	oldDB := DB
+	DB = db
+	defer func() { DB = oldDB }()
+	common.RedisEnabled = false
+	config.ChannelSleepMaxSeconds = 3600
+	config.ChannelSleepResetSeconds = 3600
+	assert.Nil(t, DB.AutoMigrate(&ChannelSleep{}))
+
+	SleepChannel(channeltype.OpenAI, "gpt-4o", 1, "test", 600, true)
+	SleepChannel(channeltype.OpenAI, "gpt-4o", 1, "test", 600, true)
+	sleep, err := getStoredChannelSleep(1, "gpt-4o")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, sleep.SleepCount)
+	assert.Equal(t, sleep.UpdatedTime+1200, sleep.AwakeTime)
+
+	// the delay given by the provider is not doubled
+	SleepChannel(channeltype.OpenAI, "gpt-4o", 1, "test", 30, false)
+	sleep, err = getStoredChannelSleep(1, "gpt-4o")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, sleep.SleepCount)
+	assert.Equal(t, sleep.UpdatedTime+30, sleep.AwakeTime)
+
+	// the count restarts after the reset time
+	assert.Nil(t, DB.Model(&ChannelSleep{}).Where("channel_id = ?", 1).Update("updated_time", sleep.UpdatedTime-3601).Error)
+	SleepChannel(channeltype.OpenAI, "gpt-4o", 1, "test", 600, true)
+	sleep, err = getStoredChannelSleep(1, "gpt-4o")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sleep.SleepCount)
+	assert.Equal(t, sleep.UpdatedTime+600, sleep.AwakeTime)
+}
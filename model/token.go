@@ -40,7 +40,7 @@ type Token struct {
 	Subnet              *string `json:"subnet" gorm:"default:''"`           // allowed subnet
 	RpmLimit            int     `json:"rpm_limit" gorm:"default:0"`
 	DpmLimit            int     `json:"dpm_limit" gorm:"default:0"`
-	TpmLimit            int     `json:"tpm_limit" gorm:"default:0"`
+	TpmLimit            int     `json:"tpm_limit" gorm:"default:0"` // tokens of the text endpoints only
 	Email               string  `json:"email"`
 	WebhookType         int     `json:"webhook_type" gorm:"default:1"`
 	Webhook             string  `json:"webhook"`
@@ -50,6 +50,12 @@ type Token struct {
 	ModerationsEnable   bool    `json:"moderations_enable" gorm:"default:false"`
 	ModerationsNum      int     `json:"moderations_num" gorm:"default:0"`
 	LastModerationsTime int64   `json:"last_moderations_time" gorm:"bigint"`
+	CacheDisabled       bool    `json:"cache_disabled" gorm:"default:false"` // opt out of the response cache
+	CacheTTL            int     `json:"cache_ttl" gorm:"default:0"`          // seconds, 0 means the ttl of the group
+	MaxConcurrency      int     `json:"max_concurrency" gorm:"default:0"`    // concurrent requests, 0 means no limit
+	AuditEnabled        bool    `json:"audit_enabled" gorm:"default:false"`  // capture the requests and responses for auditing
+	OutputFilter        string  `json:"output_filter" gorm:"default:''"`     // rule set of the output filter, empty means the one of the group
+	OrgId               int     `json:"org_id" gorm:"index;default:0"`       // the organization whose pool pays for the token, 0 means the user
 
 	//标记为忽略数据库
 	BatchNumber   int `json:"batch_number" gorm:"-"`
@@ -155,8 +161,8 @@ func (t *Token) Insert() error {
 
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (t *Token) Update() error {
-	err := DB.Model(t).Select("name", "status", "expired_time", "remain_quota", "hard_limit_usd", "unlimited_quota", "rpm_limit", "dpm_limit", "tpm_limit",
-		"custom_contact", "email", "moderations_enable", "expired_alert", "exhausted_alert", "models", "subnet").Updates(t).Error
+	err := DB.Model(t).Select("name", "status", "expired_time", "hard_limit_usd", "unlimited_quota", "rpm_limit", "dpm_limit", "tpm_limit",
+		"custom_contact", "email", "webhook_type", "webhook", "moderations_enable", "expired_alert", "exhausted_alert", "models", "subnet", "cache_disabled", "cache_ttl", "max_concurrency", "audit_enabled", "output_filter").Updates(t).Error
 	if common.RedisEnabled {
 		common.RedisDel(fmt.Sprintf("Auth_Error:sk-%s", t.Key))
 		common.RedisDel(fmt.Sprintf("token:%s", t.Key))
@@ -179,6 +185,32 @@ func (token *Token) UpdateModeration() error {
 	return DB.Model(token).Select("moderations_num", "last_moderations_time", "status").Updates(token).Error
 }
 
+// RecordTokenViolation 记录令牌的一次内容审核违规，违规次数达到 maxViolations 时禁用令牌
+func RecordTokenViolation(tokenId int, maxViolations int) (token *Token, disabled bool, err error) {
+	err = DB.Model(&Token{}).Where("id = ?", tokenId).Updates(map[string]interface{}{
+		"moderations_num":       gorm.Expr("moderations_num + ?", 1),
+		"last_moderations_time": helper.GetTimestamp(),
+	}).Error
+	if err != nil {
+		return nil, false, err
+	}
+	token, err = GetTokenById(tokenId)
+	if err != nil {
+		return nil, false, err
+	}
+	if maxViolations > 0 && token.ModerationsNum >= maxViolations && token.Status == TokenStatusEnabled {
+		token.Status = TokenStatusDisabled
+		if err = token.UpdateModeration(); err != nil {
+			return token, false, err
+		}
+		disabled = true
+	}
+	if common.RedisEnabled {
+		_ = common.RedisDel(fmt.Sprintf("token:%s", token.Key))
+	}
+	return token, disabled, nil
+}
+
 func (t *Token) Delete() error {
 	var err error
 	err = DB.Delete(t).Error
@@ -263,6 +295,19 @@ func PreConsumeTokenQuota(tokenId int, quota int64) (err error) {
 	if !token.UnlimitedQuota && token.RemainQuota < quota {
 		return errors.New("insufficient token quota")
 	}
+	if err = CheckBudgets(tokenId, token.UserId, quota); err != nil {
+		return err
+	}
+	if token.OrgId != 0 {
+		orgQuota, err := GetOrganizationQuota(token.OrgId)
+		if err != nil {
+			return err
+		}
+		if orgQuota < quota {
+			return ErrInsufficientOrgQuota
+		}
+		return ApplyQuotaMovement(token.quotaMovement(LedgerTypeConsume, -quota))
+	}
 	userQuota, err := GetUserQuota(token.UserId)
 	if err != nil {
 		return err
@@ -292,14 +337,7 @@ func PreConsumeTokenQuota(tokenId int, quota int64) (err error) {
 			}
 		}()
 	}
-	if !token.UnlimitedQuota {
-		err = DecreaseTokenQuota(tokenId, quota)
-		if err != nil {
-			return err
-		}
-	}
-	err = DecreaseUserQuota(token.UserId, quota)
-	return err
+	return ApplyQuotaMovement(token.quotaMovement(LedgerTypeConsume, -quota))
 }
 
 func PostConsumeTokenQuota(tokenId int, quota int64) (err error) {
@@ -307,22 +345,45 @@ func PostConsumeTokenQuota(tokenId int, quota int64) (err error) {
 	if err != nil {
 		return err
 	}
-	if quota > 0 {
-		err = DecreaseUserQuota(token.UserId, quota)
-	} else {
-		err = IncreaseUserQuota(token.UserId, -quota)
+	ledgerType := LedgerTypeConsume
+	if quota < 0 {
+		ledgerType = LedgerTypeRefund
 	}
-	if !token.UnlimitedQuota {
-		if quota > 0 {
-			err = DecreaseTokenQuota(tokenId, quota)
-		} else {
-			err = IncreaseTokenQuota(tokenId, -quota)
-		}
-		if err != nil {
+	return ApplyQuotaMovement(token.quotaMovement(ledgerType, -quota))
+}
+
+// SetRemainQuota sets the remaining quota of the token, the difference is recorded in the ledger as an admin adjustment
+func (t *Token) SetRemainQuota(remainQuota int64) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var current int64
+		if err := tx.Model(&Token{}).Where("id = ?", t.Id).Select("remain_quota").Scan(&current).Error; err != nil {
 			return err
 		}
+		if current == remainQuota {
+			return nil
+		}
+		return applyQuotaMovement(tx, &QuotaMovement{UserId: t.UserId, TokenId: t.Id, Type: LedgerTypeAdminAdjust, TokenAmount: remainQuota - current})
+	})
+}
+
+// Recharge adds the quota to the remaining quota of the token as a topup
+func (t *Token) Recharge(quota int64) error {
+	return ApplyQuotaMovement(&QuotaMovement{UserId: t.UserId, TokenId: t.Id, Type: LedgerTypeTopup, TokenAmount: quota})
+}
+
+// quotaMovement charges the change of quota to the token, and to the pool of its organization or to its user
+func (token *Token) quotaMovement(ledgerType int, amount int64) *QuotaMovement {
+	movement := &QuotaMovement{UserId: token.UserId, TokenId: token.Id, Type: ledgerType}
+	if token.OrgId != 0 {
+		movement.OrgId = token.OrgId
+		movement.OrgAmount = amount
+	} else {
+		movement.Amount = amount
 	}
-	return nil
+	if !token.UnlimitedQuota {
+		movement.TokenAmount = amount
+	}
+	return movement
 }
 
 func UpdateAllTokensStatus(frequency int) error {
@@ -410,8 +471,9 @@ func GetTokenLog(token_id int) (*[]billing.OpenAIUsageDetailCost, error) {
 func SyncTokenAlert(sleepTime int) {
 	for {
 		logger.SysLog("Start Token Alert..")
+		alertType := ""
 		var tokens []*Token
-		err := DB.Where("email !='' and status = ? and ((remain_quota / hard_limit_usd <= 0.2 and exhausted_alert =0) or (`expired_time` != -1 and `expired_time` <= ? and expired_alert = 0))",
+		err := DB.Where("(email != '' or webhook != '') and status = ? and ((remain_quota / hard_limit_usd <= 0.2 and exhausted_alert =0) or (`expired_time` != -1 and `expired_time` <= ? and expired_alert = 0))",
 			TokenStatusEnabled, helper.GetTimestamp()+(5*86400)).Find(&tokens).Error
 		if err == nil {
 			if len(tokens) > 0 {
@@ -426,6 +488,7 @@ func SyncTokenAlert(sleepTime int) {
 						content = fmt.Sprintf("Hi there,<br/><br/>You've reached your API usage soft limit of $%.2f for this Key: %s, which has triggered this friendly notification email.<br/><br/>Don't worry, you still have API access! Your current hard limit is set to $%.2f. If you reach this amount we'll start rejecting your API requests. <br/><br/><a href='https://t.me/aiguoguo199' target='_blank'>Contact us</a><br/><br/>Best,<br/>AI GuoGuo",
 							float64(token.RemainQuota)/500000, helper.EncryptKey(token.Key), float64(token.HardLimitUsd)/500000)
 						token.ExhaustedAlert = 1
+						alertType = "exhausted"
 						needSend = true
 					} else if token.ExpiredTime != -1 && token.ExpiredTime <= helper.GetTimestamp()+(5*86400) && token.ExpiredAlert == 0 {
 						t := time.Unix(token.ExpiredTime, 0)
@@ -435,10 +498,11 @@ func SyncTokenAlert(sleepTime int) {
 						content = fmt.Sprintf("Hi there,<br/><br/>Your API Key: %s, will expire on %s, please use it as soon as possible. After this date, your Key will not be usable. <br/><br/>Don't worry, you still have API access! If you don't have time to use it, please contact us. Each Key has one free renewal opportunity. The renewal period is determined based on the remaining balance of the key, up to 15 days. If the validity period expires again and the balance is not exhausted, you will need to buy again. <br/><br/><a href='https://t.me/aiguoguo199' target='_blank'>Contact us</a><br/><br/>Best,<br/>AI GuoGuo",
 							helper.EncryptKey(token.Key), fdate)
 						token.ExpiredAlert = 1
+						alertType = "expired"
 						needSend = true
 					}
 					if needSend {
-						message.SendMailASync(token.Email, subject, content)
+						sendTokenAlert(token, alertType, subject, content)
 						token.UpdateAlertTime()
 						logger.SysLogf("[%s][%s] 触发告警 : %s", token.Name, token.Email, content)
 						needSend = false
@@ -454,6 +518,30 @@ func SyncTokenAlert(sleepTime int) {
 		time.Sleep(time.Duration(sleepTime) * time.Second)
 	}
 }
+
+// sendTokenAlert sends the alert to the email and the webhook of the token, and to the notify targets of token alerts
+func sendTokenAlert(token *Token, alertType string, subject string, content string) {
+	if token.Email != "" {
+		message.SendMailASync(token.Email, subject, content)
+	}
+	notification := &message.Notification{
+		Event:     message.EventTokenAlert,
+		Title:     subject,
+		Content:   content,
+		Data:      map[string]any{"token_id": token.Id, "alert": alertType},
+		Key:       fmt.Sprintf("%d:%s", token.Id, alertType),
+		Timestamp: helper.GetTimestamp(),
+	}
+	if token.Webhook != "" {
+		go func() {
+			if err := message.SendWebhook(token.WebhookType, token.Webhook, notification); err != nil {
+				logger.SysError("failed to send token alert webhook: " + err.Error())
+			}
+		}()
+	}
+	message.Dispatch(notification)
+}
+
 func TokenAlert(id int, alertType string) error {
 	var token *Token
 	err := DB.Where("id =?", id).Find(&token).Error
@@ -474,7 +562,10 @@ func TokenAlert(id int, alertType string) error {
 				helper.EncryptKey(token.Key), fdate)
 		}
 		if subject != "" {
-			message.SendMailASync(token.Email, subject, content)
+			if alertType == "yue" {
+				alertType = "exhausted"
+			}
+			sendTokenAlert(token, alertType, subject, content)
 			logger.SysLog(fmt.Sprintf("[%s][%s] 手动触发告警 : %s", token.Name, token.Email, content))
 			content = ""
 		}
@@ -137,11 +137,11 @@ func (user *User) Insert(inviterId int) error {
 	}
 	if inviterId != 0 {
 		if config.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, config.QuotaForInvitee)
+			_ = ApplyQuotaMovement(&QuotaMovement{UserId: user.Id, Type: LedgerTypeInviteReward, Amount: config.QuotaForInvitee})
 			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(config.QuotaForInvitee)))
 		}
 		if config.QuotaForInviter > 0 {
-			_ = IncreaseUserQuota(inviterId, config.QuotaForInviter)
+			_ = ApplyQuotaMovement(&QuotaMovement{UserId: inviterId, Type: LedgerTypeInviteReward, Amount: config.QuotaForInviter})
 			RecordLog(inviterId, LogTypeSystem, fmt.Sprintf("邀请用户赠送 %s", common.LogQuota(config.QuotaForInviter)))
 		}
 	}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+// UserFile is a file uploaded through /v1/files or written by a batch,
+// unlike Files, which tracks the uploads made to gemini, the content is kept here
+type UserFile struct {
+	Id        string `json:"id" gorm:"primaryKey;type:varchar(64)"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	Filename  string `json:"filename" gorm:"type:varchar(255)"`
+	Purpose   string `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes     int64  `json:"bytes" gorm:"bigint"`
+	Content   []byte `json:"-"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+func (file *UserFile) Insert() error {
+	if file.Id == "" {
+		file.Id = "file-" + random.GetUUID()
+	}
+	file.Bytes = int64(len(file.Content))
+	file.CreatedAt = helper.GetTimestamp()
+	return DB.Create(file).Error
+}
+
+// GetUserFileById returns the file without its content
+func GetUserFileById(id string, userId int) (*UserFile, error) {
+	if id == "" {
+		return nil, errors.New("id 为空！")
+	}
+	var file UserFile
+	err := DB.Omit("content").Where("id = ? AND user_id = ?", id, userId).First(&file).Error
+	return &file, err
+}
+
+func GetUserFileContent(id string, userId int) ([]byte, error) {
+	var file UserFile
+	err := DB.Select("content").Where("id = ? AND user_id = ?", id, userId).First(&file).Error
+	return file.Content, err
+}
+
+func GetUserFiles(userId int, purpose string) ([]*UserFile, error) {
+	var files []*UserFile
+	query := DB.Omit("content").Where("user_id = ?", userId)
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	err := query.Order("created_at desc").Find(&files).Error
+	return files, err
+}
+
+func DeleteUserFileById(id string, userId int) error {
+	result := DB.Where("id = ? AND user_id = ?", id, userId).Delete(&UserFile{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("file not found")
+	}
+	return nil
+}
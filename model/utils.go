@@ -13,6 +13,7 @@ const (
 	BatchUpdateTypeUsedQuota
 	BatchUpdateTypeChannelUsedQuota
 	BatchUpdateTypeRequestCount
+	BatchUpdateTypeChannelKeyUsedQuota
 	BatchUpdateTypeCount // if you add a new type, you need to add a new map and a new lock
 )
 
@@ -71,6 +72,8 @@ func batchUpdate() {
 				updateUserRequestCount(key, int(value))
 			case BatchUpdateTypeChannelUsedQuota:
 				updateChannelUsedQuota(key, value)
+			case BatchUpdateTypeChannelKeyUsedQuota:
+				updateChannelKeyUsedQuota(key, value)
 			}
 		}
 	}
@@ -7,6 +7,7 @@ import (
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/common/message"
+	"github.com/songquanpeng/one-api/common/telemetry"
 	"github.com/songquanpeng/one-api/model"
 )
 
@@ -16,8 +17,9 @@ func syncUpdateChannel() {
 		model.InitChannelCache()
 	}()
 }
-func SleepChannel(channelType int, group string, modelName string, channelId int, awakeTime int64) {
-	model.SleepChannel(channelType, group, modelName, channelId, awakeTime)
+func SleepChannel(channelType int, modelName string, channelId int, channelName string, delay int64, backoff bool) {
+	model.SleepChannel(channelType, modelName, channelId, channelName, delay, backoff)
+	telemetry.RecordChannelEvent(channelId, telemetry.ChannelEventSleep)
 }
 func WakeupChannel(frequency int) {
 	model.WakeupChannel(frequency)
@@ -26,30 +28,36 @@ func WakeupChannel(frequency int) {
 // DisableChannel disable & notify
 func DisableChannel(channelId int, channelName string, reason string) {
 	model.UpdateChannelStatusById(channelId, model.ChannelStatusAutoDisabled)
+	telemetry.RecordChannelEvent(channelId, telemetry.ChannelEventDisable)
 	logger.SysLog(fmt.Sprintf("channel #%d has been disabled: %s", channelId, reason))
 	subject := fmt.Sprintf("渠道「%s」（#%d）已被禁用", channelName, channelId)
 	content := fmt.Sprintf("渠道「%s」（#%d）已被禁用，原因：%s", channelName, channelId, reason)
-	message.SendMailToAdmin(subject, content)
+	message.NotifyAdmin(message.EventChannelDisabled, subject, content, map[string]any{"channel_id": channelId, "reason": reason})
 	//异步执行更新
 	syncUpdateChannel()
 }
 
-func MetricDisableChannel(channelId int, successRate float64) {
-	model.UpdateChannelStatusById(channelId, model.ChannelStatusAutoDisabled)
-	logger.SysLog(fmt.Sprintf("channel #%d has been disabled due to low success rate: %.2f", channelId, successRate*100))
-	subject := fmt.Sprintf("渠道 #%d 已被禁用", channelId)
-	content := fmt.Sprintf("该渠道（#%d）在最近 %d 次调用中成功率为 %.2f%%，低于阈值 %.2f%%，因此被系统自动禁用。",
-		channelId, config.MetricQueueSize, successRate*100, config.MetricSuccessRateThreshold*100)
-	message.SendMailToAdmin(subject, content)
+// DisableChannelKey disables a key of a multi key channel, the channel is disabled with its last key
+func DisableChannelKey(channelId int, channelName string, keyId int, reason string) {
+	exhausted, err := model.DisableChannelKey(channelId, keyId)
+	if err != nil {
+		logger.SysError("failed to disable channel key: " + err.Error())
+		return
+	}
+	logger.SysLog(fmt.Sprintf("key #%d of channel #%d has been disabled: %s", keyId, channelId, reason))
+	if exhausted {
+		DisableChannel(channelId, channelName, "所有 key 均已被禁用，最后一个 key 的错误："+reason)
+	}
 }
 
 // EnableChannel enable & notify
 func EnableChannel(channelId int, channelName string) {
 	model.UpdateChannelStatusById(channelId, model.ChannelStatusEnabled)
+	telemetry.RecordChannelEvent(channelId, telemetry.ChannelEventEnable)
 	logger.SysLog(fmt.Sprintf("channel #%d has been enabled", channelId))
 	subject := fmt.Sprintf("渠道「%s」（#%d）已被启用", channelName, channelId)
 	content := fmt.Sprintf("渠道「%s」（#%d）已被启用", channelName, channelId)
-	message.SendMailToAdmin(subject, content)
+	message.NotifyAdmin(message.EventChannelEnabled, subject, content, map[string]any{"channel_id": channelId})
 }
 
 func DelFile(channelId int, fileId string) {
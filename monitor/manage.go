@@ -3,81 +3,65 @@ package monitor
 import (
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/message"
-	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/errorrule"
 	"github.com/songquanpeng/one-api/relay/model"
 )
 
-func ShouldDisableChannel(err *model.Error, statusCode int, channelId int, channelType int) bool {
-	if !config.AutomaticDisableChannelEnabled {
-		return false
+// ErrorAction classifies a relay error with the error rules, errors matching no rule are retried
+// unless they are client errors
+func ErrorAction(channelType int, err *model.ErrorWithStatusCode) (action string, sleepSeconds int64) {
+	if rule := errorrule.Classify(channelType, err.StatusCode, &err.Error); rule != nil {
+		return rule.Action, rule.SleepSeconds
 	}
-	if err == nil {
-		return false
-	}
-	if statusCode == http.StatusUnauthorized {
-		//自定义渠道在下面返回错误, 不在这里直接禁用
-		if channelType != channeltype.Custom {
-			return true
-		}
-	}
-	switch err.Type {
-	case "insufficient_quota", "authentication_error", "permission_error", "forbidden":
-		return true
+	return defaultErrorAction(err.StatusCode), 0
+}
+
+// ChannelErrorAction is ErrorAction for an error returned by the channel, a disable rule with disable_after
+// counts the error and retries until the channel has matched it often enough
+func ChannelErrorAction(channelId int, channelType int, err *model.ErrorWithStatusCode) (action string, sleepSeconds int64) {
+	rule := errorrule.Classify(channelType, err.StatusCode, &err.Error)
+	if rule == nil {
+		return defaultErrorAction(err.StatusCode), 0
 	}
-	if err.Code == "invalid_api_key" || err.Code == "account_deactivated" {
-		return true
+	if rule.Action == errorrule.ActionDisable && !shouldDisable(rule, channelId, &err.Error) {
+		return errorrule.ActionRetry, 0
 	}
+	return rule.Action, rule.SleepSeconds
+}
 
-	lowerMessage := strings.ToLower(err.Message)
-	if strings.Contains(lowerMessage, "your access was terminated") ||
-		strings.Contains(lowerMessage, "violation of our policies") ||
-		strings.Contains(lowerMessage, "your credit balance is too low") ||
-		strings.Contains(lowerMessage, "you have reached your specified api usage limits") ||
-		strings.Contains(lowerMessage, "organization has been disabled") ||
-		strings.Contains(lowerMessage, "credit") ||
-		strings.Contains(lowerMessage, "balance") ||
-		strings.Contains(lowerMessage, "permission denied") ||
-		strings.Contains(lowerMessage, "organization has been restricted") || // groq
-		strings.Contains(lowerMessage, "已欠费") ||
-		strings.Contains(lowerMessage, "无效的令牌") || //中转
-		strings.Contains(lowerMessage, "quota exceeded for quota metric 'generate content api requests per minute'") || // gemini
-		strings.Contains(lowerMessage, "api key not found. please pass a valid api key") || // gemini
-		strings.Contains(lowerMessage, "api key expired. please renew the api key") || // gemini
-		strings.Contains(lowerMessage, "generative language api has not been used") || // gemini
-		strings.Contains(lowerMessage, "permission denied: consumer 'api_key:ai") {
-
-		if channelType == channeltype.Custom {
-			//需要针对自定义渠道做优化, 10次收到以下错误信息, 再做禁用
-			channelCount, serr := common.RedisGet(fmt.Sprintf("channel_fail:%d", channelId))
-			num, _ := strconv.Atoi(channelCount)
-			if serr != nil || num <= 10 {
-				num += 1
-				ok, _ := common.RedisSetNx(fmt.Sprintf("channel_fail:%d", channelId), string(num), time.Duration(60*time.Second))
-				if ok {
-					subject := fmt.Sprintf("渠道ID「%d」返回错误(%s)，次数(%d)，请关注", channelId, lowerMessage, num)
-					content := fmt.Sprintf("渠道ID「%d」出现错误: %s，累计10次将被禁用，当前累计错误次数: %d, 剩余次数: %d", channelId, lowerMessage, num, (10 - num))
-					message.SendMailToAdmin(subject, content)
-					return false
-				}
-			}
-		}
+// shouldDisable counts the error against a disable rule, the admin is notified of every strike before the channel is disabled
+func shouldDisable(rule *errorrule.Rule, channelId int, err *model.Error) bool {
+	disable, strikes := rule.ShouldDisable(channelId)
+	if !disable && strikes > 0 {
+		subject := fmt.Sprintf("渠道ID「%d」返回错误(%s)，次数(%d)，请关注", channelId, err.Message, strikes)
+		content := fmt.Sprintf("渠道ID「%d」出现错误: %s，累计%d次将被禁用，当前累计错误次数: %d, 剩余次数: %d",
+			channelId, err.Message, rule.DisableAfter, strikes, rule.DisableAfter-strikes)
+		message.NotifyAdmin(message.EventChannelError, subject, content, map[string]any{"channel_id": channelId, "strikes": strikes})
+	}
+	return disable
+}
 
-		return true
+func defaultErrorAction(statusCode int) string {
+	if statusCode == http.StatusBadRequest || statusCode/100 == 2 {
+		return errorrule.ActionFail
 	}
+	return errorrule.ActionRetry
+}
 
-	//gemini的429错误处理
-	if statusCode == -1 && channelType == channeltype.Gemini && strings.Contains(lowerMessage, "you exceeded your current quota") {
-		return true
+func ShouldDisableChannel(err *model.Error, statusCode int, channelId int, channelType int) bool {
+	if !config.AutomaticDisableChannelEnabled {
+		return false
 	}
-	return false
+	if err == nil {
+		return false
+	}
+	rule := errorrule.Classify(channelType, statusCode, err)
+	return rule != nil && shouldDisable(rule, channelId, err)
 }
 
 func ShouldDelFile(c *gin.Context, err *model.Error) bool {
@@ -90,19 +74,6 @@ func ShouldDelFile(c *gin.Context, err *model.Error) bool {
 	return false
 }
 
-func ShouldSleepChannel(channelType int, err *model.Error, statusCode int) bool {
-	lowerMessage := strings.ToLower(err.Message)
-	if channelType == channeltype.Custom {
-		return false
-	}
-	if strings.Contains(lowerMessage, "resource has been exhauste") ||
-		(strings.Contains(lowerMessage, "you exceeded your current quota") && channelType == channeltype.Gemini) ||
-		strings.Contains(lowerMessage, "e.g. check quota") {
-		return true
-	}
-	return false
-}
-
 func ShouldEnableChannel(err error, openAIErr *model.Error) bool {
 	if !config.AutomaticEnableChannelEnabled {
 		return false
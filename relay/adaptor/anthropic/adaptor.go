@@ -39,6 +39,10 @@ func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Request, meta *me
 	if strings.HasPrefix(meta.ActualModelName, "claude-3-5-sonnet") {
 		req.Header.Set("anthropic-beta", "max-tokens-3-5-sonnet-2024-07-15")
 	}
+	// claude-native clients on /v1/messages choose their own beta features
+	if anthropicBeta := c.Request.Header.Get("anthropic-beta"); anthropicBeta != "" {
+		req.Header.Set("anthropic-beta", anthropicBeta)
+	}
 
 	return nil
 }
@@ -0,0 +1,420 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// the inbound side of /v1/messages, requests from claude-native clients are converted to openai format
+// and responses from non-claude channels are converted back to claude format
+
+// MessagesRequest is the request received on /v1/messages, system and content can be either string or blocks
+type MessagesRequest struct {
+	Model         string            `json:"model"`
+	Messages      []MessagesMessage `json:"messages"`
+	System        any               `json:"system,omitempty"`
+	MaxTokens     int               `json:"max_tokens,omitempty"`
+	StopSequences []string          `json:"stop_sequences,omitempty"`
+	Stream        bool              `json:"stream,omitempty"`
+	Temperature   *float64          `json:"temperature,omitempty"`
+	TopP          *float64          `json:"top_p,omitempty"`
+	TopK          int               `json:"top_k,omitempty"`
+	Tools         []Tool            `json:"tools,omitempty"`
+	ToolChoice    any               `json:"tool_choice,omitempty"`
+	Thinking      *Thinking         `json:"thinking,omitempty"`
+	Metadata      *Metadata         `json:"metadata,omitempty"`
+}
+
+type MessagesMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+// MessagesResponse is the response sent back on /v1/messages
+type MessagesResponse struct {
+	Id           string    `json:"id"`
+	Type         string    `json:"type"`
+	Role         string    `json:"role"`
+	Content      []Content `json:"content"`
+	Model        string    `json:"model"`
+	StopReason   *string   `json:"stop_reason"`
+	StopSequence *string   `json:"stop_sequence"`
+	Usage        Usage     `json:"usage"`
+}
+
+type StreamEvent struct {
+	Type         string            `json:"type"`
+	Message      *MessagesResponse `json:"message,omitempty"`
+	Index        *int              `json:"index,omitempty"`
+	ContentBlock any               `json:"content_block,omitempty"`
+	Delta        any               `json:"delta,omitempty"`
+	Usage        *Usage            `json:"usage,omitempty"`
+}
+
+type ErrorResponse struct {
+	Type  string `json:"type"`
+	Error Error  `json:"error"`
+}
+
+func stopReasonOpenAI2Claude(reason string) string {
+	switch reason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+func messageId(id string) string {
+	id = strings.TrimPrefix(id, "chatcmpl-")
+	if strings.HasPrefix(id, "msg_") {
+		return id
+	}
+	return "msg_" + id
+}
+
+// blockText returns the text of a string content or the joined text blocks of a block content
+func blockText(content any) string {
+	if text, ok := content.(string); ok {
+		return text
+	}
+	blocks, _ := content.([]any)
+	var texts []string
+	for _, item := range blocks {
+		block, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if block["type"] == "text" {
+			if text, ok := block["text"].(string); ok {
+				texts = append(texts, text)
+			}
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func convertImageBlock(block map[string]any) map[string]any {
+	source, ok := block["source"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	var url string
+	switch source["type"] {
+	case "base64":
+		url = fmt.Sprintf("data:%v;base64,%v", source["media_type"], source["data"])
+	case "url":
+		url, _ = source["url"].(string)
+	}
+	if url == "" {
+		return nil
+	}
+	return map[string]any{
+		"type":      model.ContentTypeImageURL,
+		"image_url": map[string]any{"url": url},
+	}
+}
+
+func convertToolChoice(toolChoice any) any {
+	choice, ok := toolChoice.(map[string]any)
+	if !ok {
+		return nil
+	}
+	switch choice["type"] {
+	case "any":
+		return "required"
+	case "none":
+		return "none"
+	case "tool":
+		return map[string]any{
+			"type":     "function",
+			"function": map[string]any{"name": choice["name"]},
+		}
+	default:
+		return "auto"
+	}
+}
+
+// ConvertMessagesRequest converts a claude-native request into the openai format used by the other channels
+func ConvertMessagesRequest(request *MessagesRequest) *model.GeneralOpenAIRequest {
+	openaiRequest := model.GeneralOpenAIRequest{
+		Model:       request.Model,
+		MaxTokens:   request.MaxTokens,
+		Stop:        request.StopSequences,
+		Stream:      request.Stream,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		TopK:        request.TopK,
+		ToolChoice:  convertToolChoice(request.ToolChoice),
+	}
+	if len(request.StopSequences) == 0 {
+		openaiRequest.Stop = nil
+	}
+	if request.Stream {
+		openaiRequest.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+	if request.Metadata != nil {
+		openaiRequest.User = request.Metadata.UserId
+	}
+	if request.Thinking != nil && request.Thinking.Type == "enabled" {
+		openaiRequest.Thinking = &model.Thinking{
+			Type:           "enabled",
+			ThinkingBudget: request.Thinking.BudgetTokens,
+		}
+	}
+	for _, tool := range request.Tools {
+		openaiRequest.Tools = append(openaiRequest.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters: map[string]any{
+					"type":       tool.InputSchema.Type,
+					"properties": tool.InputSchema.Properties,
+					"required":   tool.InputSchema.Required,
+				},
+			},
+		})
+	}
+	if system := blockText(request.System); system != "" {
+		openaiRequest.Messages = append(openaiRequest.Messages, model.Message{
+			Role:    "system",
+			Content: system,
+		})
+	}
+	for _, message := range request.Messages {
+		openaiRequest.Messages = append(openaiRequest.Messages, convertMessage(message)...)
+	}
+	return &openaiRequest
+}
+
+// convertMessage may return several messages, as tool results are separate messages in openai format
+func convertMessage(message MessagesMessage) []model.Message {
+	if text, ok := message.Content.(string); ok {
+		return []model.Message{{Role: message.Role, Content: text}}
+	}
+	blocks, _ := message.Content.([]any)
+	var messages []model.Message
+	var parts []any
+	var toolCalls []model.Tool
+	for _, item := range blocks {
+		block, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch block["type"] {
+		case "text":
+			parts = append(parts, map[string]any{
+				"type": model.ContentTypeText,
+				"text": block["text"],
+			})
+		case "image":
+			if part := convertImageBlock(block); part != nil {
+				parts = append(parts, part)
+			}
+		case "tool_use":
+			arguments, _ := json.Marshal(block["input"])
+			id, _ := block["id"].(string)
+			name, _ := block["name"].(string)
+			toolCalls = append(toolCalls, model.Tool{
+				Id:   id,
+				Type: "function",
+				Function: model.Function{
+					Name:      name,
+					Arguments: string(arguments),
+				},
+			})
+		case "tool_result":
+			toolUseId, _ := block["tool_use_id"].(string)
+			messages = append(messages, model.Message{
+				Role:       "tool",
+				Content:    blockText(block["content"]),
+				ToolCallId: toolUseId,
+			})
+		}
+	}
+	if len(parts) == 0 && len(toolCalls) == 0 {
+		return messages
+	}
+	openaiMessage := model.Message{
+		Role:      message.Role,
+		ToolCalls: toolCalls,
+	}
+	if len(parts) == 1 && parts[0].(map[string]any)["type"] == model.ContentTypeText {
+		openaiMessage.Content = parts[0].(map[string]any)["text"]
+	} else if len(parts) > 0 {
+		openaiMessage.Content = parts
+	} else {
+		openaiMessage.Content = ""
+	}
+	return append(messages, openaiMessage)
+}
+
+// ResponseOpenAI2Claude converts a non-stream openai response into a claude message
+func ResponseOpenAI2Claude(response *openai.TextResponse, modelName string) *MessagesResponse {
+	claudeResponse := MessagesResponse{
+		Id:      messageId(response.Id),
+		Type:    "message",
+		Role:    "assistant",
+		Content: make([]Content, 0),
+		Model:   modelName,
+		Usage: Usage{
+			InputTokens:  response.Usage.PromptTokens,
+			OutputTokens: response.Usage.CompletionTokens,
+		},
+	}
+	stopReason := "end_turn"
+	if len(response.Choices) > 0 {
+		choice := response.Choices[0]
+		if choice.Message.ReasoningContent != nil && *choice.Message.ReasoningContent != "" {
+			claudeResponse.Content = append(claudeResponse.Content, Content{
+				Type:     "thinking",
+				Thinking: *choice.Message.ReasoningContent,
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			claudeResponse.Content = append(claudeResponse.Content, Content{
+				Type: "text",
+				Text: text,
+			})
+		}
+		for _, toolCall := range choice.Message.ToolCalls {
+			input := make(map[string]any)
+			if arguments, ok := toolCall.Function.Arguments.(string); ok {
+				_ = json.Unmarshal([]byte(arguments), &input)
+			}
+			claudeResponse.Content = append(claudeResponse.Content, Content{
+				Type:  "tool_use",
+				Id:    toolCall.Id,
+				Name:  toolCall.Function.Name,
+				Input: input,
+			})
+		}
+		stopReason = stopReasonOpenAI2Claude(choice.FinishReason)
+	}
+	claudeResponse.StopReason = &stopReason
+	return &claudeResponse
+}
+
+// StreamConverter turns openai stream chunks into claude stream events, it keeps track of the open content block
+type StreamConverter struct {
+	Id           string
+	Model        string
+	InputTokens  int
+	started      bool
+	blockIndex   int
+	blockType    string
+	blockOpen    bool
+	outputTokens int
+	stopReason   string
+}
+
+func (s *StreamConverter) start() []StreamEvent {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	s.blockIndex = -1
+	return []StreamEvent{{
+		Type: "message_start",
+		Message: &MessagesResponse{
+			Id:      messageId(s.Id),
+			Type:    "message",
+			Role:    "assistant",
+			Content: make([]Content, 0),
+			Model:   s.Model,
+			Usage:   Usage{InputTokens: s.InputTokens},
+		},
+	}}
+}
+
+func (s *StreamConverter) closeBlock() []StreamEvent {
+	if !s.blockOpen {
+		return nil
+	}
+	s.blockOpen = false
+	index := s.blockIndex
+	return []StreamEvent{{Type: "content_block_stop", Index: &index}}
+}
+
+func (s *StreamConverter) openBlock(blockType string, block map[string]any) []StreamEvent {
+	events := s.closeBlock()
+	s.blockIndex++
+	s.blockType = blockType
+	s.blockOpen = true
+	index := s.blockIndex
+	return append(events, StreamEvent{Type: "content_block_start", Index: &index, ContentBlock: block})
+}
+
+func (s *StreamConverter) delta(delta map[string]any) StreamEvent {
+	index := s.blockIndex
+	return StreamEvent{Type: "content_block_delta", Index: &index, Delta: delta}
+}
+
+// Convert returns the claude events for one openai chunk
+func (s *StreamConverter) Convert(chunk *openai.ChatCompletionsStreamResponse) []StreamEvent {
+	if s.Id == "" {
+		s.Id = chunk.Id
+	}
+	events := s.start()
+	if chunk.Usage != nil {
+		if chunk.Usage.PromptTokens > 0 {
+			s.InputTokens = chunk.Usage.PromptTokens
+		}
+		s.outputTokens = chunk.Usage.CompletionTokens
+	}
+	for _, choice := range chunk.Choices {
+		if choice.Delta.ReasoningContent != nil && *choice.Delta.ReasoningContent != "" {
+			if !s.blockOpen || s.blockType != "thinking" {
+				events = append(events, s.openBlock("thinking", map[string]any{"type": "thinking", "thinking": ""})...)
+			}
+			events = append(events, s.delta(map[string]any{"type": "thinking_delta", "thinking": *choice.Delta.ReasoningContent}))
+		}
+		if text := choice.Delta.StringContent(); text != "" {
+			if !s.blockOpen || s.blockType != "text" {
+				events = append(events, s.openBlock("text", map[string]any{"type": "text", "text": ""})...)
+			}
+			events = append(events, s.delta(map[string]any{"type": "text_delta", "text": text}))
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			if toolCall.Id != "" || !s.blockOpen || s.blockType != "tool_use" {
+				events = append(events, s.openBlock("tool_use", map[string]any{
+					"type":  "tool_use",
+					"id":    toolCall.Id,
+					"name":  toolCall.Function.Name,
+					"input": map[string]any{},
+				})...)
+			}
+			if arguments, ok := toolCall.Function.Arguments.(string); ok && arguments != "" {
+				events = append(events, s.delta(map[string]any{"type": "input_json_delta", "partial_json": arguments}))
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			s.stopReason = *choice.FinishReason
+		}
+	}
+	return events
+}
+
+// Finish closes the message, usage is the one counted by the relay and may be nil
+func (s *StreamConverter) Finish(usage *model.Usage) []StreamEvent {
+	events := s.start()
+	events = append(events, s.closeBlock()...)
+	if usage != nil {
+		s.outputTokens = usage.CompletionTokens
+	}
+	return append(events, StreamEvent{
+		Type: "message_delta",
+		Delta: map[string]any{
+			"stop_reason":   stopReasonOpenAI2Claude(s.stopReason),
+			"stop_sequence": nil,
+		},
+		Usage: &Usage{OutputTokens: s.outputTokens},
+	}, StreamEvent{Type: "message_stop"})
+}
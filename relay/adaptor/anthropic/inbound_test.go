@@ -0,0 +1,57 @@
+package anthropic_test
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertMessagesRequest(t *testing.T) {
+	request := &anthropic.MessagesRequest{
+		Model:  "claude-3-5-sonnet-20241022",
+		System: []any{map[string]any{"type": "text", "text": "be brief"}},
+		Messages: []anthropic.MessagesMessage{
+			{Role: "user", Content: "What's the weather?"},
+			{Role: "assistant", Content: []any{
+				map[string]any{"type": "tool_use", "id": "toolu_1", "name": "weather", "input": map[string]any{"city": "Paris"}},
+			}},
+			{Role: "user", Content: []any{
+				map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": "sunny"},
+			}},
+		},
+		ToolChoice: map[string]any{"type": "any"},
+	}
+	openaiRequest := anthropic.ConvertMessagesRequest(request)
+	assert.Equal(t, "required", openaiRequest.ToolChoice)
+	assert.Len(t, openaiRequest.Messages, 4)
+	assert.Equal(t, "system", openaiRequest.Messages[0].Role)
+	assert.Equal(t, "be brief", openaiRequest.Messages[0].StringContent())
+	assert.Equal(t, `{"city":"Paris"}`, openaiRequest.Messages[2].ToolCalls[0].Function.Arguments)
+	assert.Equal(t, "tool", openaiRequest.Messages[3].Role)
+	assert.Equal(t, "toolu_1", openaiRequest.Messages[3].ToolCallId)
+}
+
+func TestStreamConverter(t *testing.T) {
+	converter := &anthropic.StreamConverter{Model: "claude-3-5-sonnet-20241022", InputTokens: 10}
+	events := converter.Convert(&openai.ChatCompletionsStreamResponse{
+		Id:      "chatcmpl-1",
+		Choices: []openai.ChatCompletionsStreamResponseChoice{{Delta: relaymodel.Message{Content: "Hi"}}},
+	})
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{"message_start", "content_block_start", "content_block_delta"}, types)
+	assert.Equal(t, "msg_1", events[0].Message.Id)
+
+	events = converter.Finish(&relaymodel.Usage{PromptTokens: 10, CompletionTokens: 3})
+	types = types[:0]
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{"content_block_stop", "message_delta", "message_stop"}, types)
+	assert.Equal(t, 3, events[1].Usage.OutputTokens)
+}
@@ -367,9 +367,6 @@ func StreamHandler(c *gin.Context, resp *http.Response, meta *meta.Meta) (*model
 	var id string
 	var lastToolCallChoice openai.ChatCompletionsStreamResponseChoice
 
-	var promptTokens int
-	var completionTokens int
-
 	for scanner.Scan() {
 		adaptor.StartingStream(c, meta)
 		data := scanner.Text()
@@ -392,14 +389,7 @@ func StreamHandler(c *gin.Context, resp *http.Response, meta *meta.Meta) (*model
 				modelName = currentResp.Model
 				id = fmt.Sprintf("chatcmpl-%s", currentResp.Id)
 				if currentResp.Usage != nil {
-					promptTokens += currentResp.Usage.InputTokens
-					completionTokens += currentResp.Usage.OutputTokens
-					usage = model.Usage{
-						PromptTokens:     promptTokens,
-						CompletionTokens: completionTokens,
-						ThoughtsTokens:   0,
-						TotalTokens:      promptTokens + completionTokens,
-					}
+					usage = MergeUsage(&usage, currentResp.Usage)
 				}
 				continue
 			} else { // finish_reason case
@@ -427,14 +417,7 @@ func StreamHandler(c *gin.Context, resp *http.Response, meta *meta.Meta) (*model
 			}
 		}
 		if currentResp != nil && currentResp.Usage != nil {
-			promptTokens += currentResp.Usage.InputTokens
-			completionTokens += currentResp.Usage.OutputTokens
-			usage = model.Usage{
-				PromptTokens:     promptTokens,
-				CompletionTokens: completionTokens,
-				ThoughtsTokens:   0,
-				TotalTokens:      promptTokens + completionTokens,
-			}
+			usage = MergeUsage(&usage, currentResp.Usage)
 		}
 		response.Usage = &usage
 		err = render.ObjectData(c, response)
@@ -456,6 +439,46 @@ func StreamHandler(c *gin.Context, resp *http.Response, meta *meta.Meta) (*model
 	return nil, &usage
 }
 
+// ConvertUsage converts the claude usage, the cache reads and writes are counted in the prompt tokens like openai does
+func ConvertUsage(claudeUsage *Usage) model.Usage {
+	usage := model.Usage{
+		PromptTokens:     claudeUsage.InputTokens + claudeUsage.CacheCreationInputTokens + claudeUsage.CacheReadInputTokens,
+		CompletionTokens: claudeUsage.OutputTokens,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	if claudeUsage.CacheCreationInputTokens > 0 || claudeUsage.CacheReadInputTokens > 0 {
+		usage.PromptTokensDetails = &model.PromptTokensDetails{
+			CachedTokens:        claudeUsage.CacheReadInputTokens,
+			CacheCreationTokens: claudeUsage.CacheCreationInputTokens,
+		}
+	}
+	return usage
+}
+
+// MergeUsage merges the usage of a stream event into the usage so far,
+// the counts of claude are cumulative so the non zero ones replace the previous ones
+func MergeUsage(usage *model.Usage, claudeUsage *Usage) model.Usage {
+	merged := Usage{OutputTokens: usage.CompletionTokens}
+	if details := usage.PromptTokensDetails; details != nil {
+		merged.CacheReadInputTokens = details.CachedTokens
+		merged.CacheCreationInputTokens = details.CacheCreationTokens
+	}
+	merged.InputTokens = usage.PromptTokens - merged.CacheReadInputTokens - merged.CacheCreationInputTokens
+	if claudeUsage.InputTokens > 0 {
+		merged.InputTokens = claudeUsage.InputTokens
+	}
+	if claudeUsage.OutputTokens > 0 {
+		merged.OutputTokens = claudeUsage.OutputTokens
+	}
+	if claudeUsage.CacheReadInputTokens > 0 {
+		merged.CacheReadInputTokens = claudeUsage.CacheReadInputTokens
+	}
+	if claudeUsage.CacheCreationInputTokens > 0 {
+		merged.CacheCreationInputTokens = claudeUsage.CacheCreationInputTokens
+	}
+	return ConvertUsage(&merged)
+}
+
 func Handler(c *gin.Context, resp *http.Response, promptTokens int, meta *meta.Meta) (*model.ErrorWithStatusCode, *model.Usage) {
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -483,10 +506,9 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, meta *meta.M
 	}
 	fullTextResponse := ResponseClaude2OpenAI(&claudeResponse, meta)
 	fullTextResponse.Model = meta.ActualModelName
-	usage := model.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
+	var usage model.Usage
+	if claudeResponse.Usage != nil {
+		usage = ConvertUsage(claudeResponse.Usage)
 	}
 	fullTextResponse.Usage = usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
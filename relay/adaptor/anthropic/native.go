@@ -0,0 +1,102 @@
+package anthropic
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// the native handlers are used by /v1/messages when the channel speaks claude itself, the response is passed through as is
+
+// ParseStreamUsage accumulates the usage carried by message_start and message_delta events
+func ParseStreamUsage(event *StreamResponse, usage *model.Usage) {
+	var claudeUsage *Usage
+	switch event.Type {
+	case "message_start":
+		if event.Message != nil {
+			claudeUsage = event.Message.Usage
+		}
+	case "message_delta":
+		claudeUsage = event.Usage
+	}
+	if claudeUsage != nil {
+		*usage = MergeUsage(usage, claudeUsage)
+	}
+}
+
+func NativeStreamHandler(c *gin.Context, resp *http.Response, meta *meta.Meta) (*model.ErrorWithStatusCode, *model.Usage) {
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	common.SetEventStreamHeaders(c)
+
+	var usage model.Usage
+	for scanner.Scan() {
+		adaptor.StartingStream(c, meta)
+		line := scanner.Text()
+		if data, ok := strings.CutPrefix(line, "data:"); ok {
+			var event StreamResponse
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err == nil {
+				ParseStreamUsage(&event, &usage)
+			} else {
+				logger.SysError("error unmarshalling stream response: " + err.Error())
+			}
+		}
+		_, _ = c.Writer.WriteString(line + "\n")
+		if line == "" {
+			c.Writer.Flush()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.SysError("error reading stream: " + err.Error())
+	}
+	c.Writer.Flush()
+	return nil, &usage
+}
+
+func NativeHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	return WriteNativeResponse(c, resp.StatusCode, responseBody)
+}
+
+// WriteNativeResponse writes a non-stream claude response and returns its usage
+func WriteNativeResponse(c *gin.Context, statusCode int, responseBody []byte) (*model.ErrorWithStatusCode, *model.Usage) {
+	var claudeResponse Response
+	err := json.Unmarshal(responseBody, &claudeResponse)
+	if err != nil {
+		return openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if claudeResponse.Error.Type != "" {
+		return &model.ErrorWithStatusCode{
+			Error: model.Error{
+				Message: claudeResponse.Error.Message,
+				Type:    claudeResponse.Error.Type,
+				Code:    claudeResponse.Error.Type,
+			},
+			StatusCode: statusCode,
+		}, nil
+	}
+	var usage model.Usage
+	if claudeResponse.Usage != nil {
+		usage = ConvertUsage(claudeResponse.Usage)
+	}
+	c.Data(statusCode, "application/json", responseBody)
+	return nil, &usage
+}
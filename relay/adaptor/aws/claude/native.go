@@ -0,0 +1,99 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/aws/utils"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+const bedrockAnthropicVersion = "bedrock-2023-05-31"
+
+// nativeBody turns a claude-native request into a bedrock one, the model goes into the model id instead of the body
+func nativeBody(request map[string]any) ([]byte, error) {
+	delete(request, "model")
+	delete(request, "stream")
+	request["anthropic_version"] = bedrockAnthropicVersion
+	return json.Marshal(request)
+}
+
+// NativeHandler serves /v1/messages from bedrock without converting the claude response
+func NativeHandler(c *gin.Context, awsCli *bedrockruntime.Client, meta *meta.Meta, request map[string]any) (*relaymodel.ErrorWithStatusCode, *relaymodel.Usage) {
+	awsModelId, err := awsModelID(meta.ActualModelName)
+	if err != nil {
+		return utils.WrapErr(errors.Wrap(err, "awsModelID"), 0), nil
+	}
+	body, err := nativeBody(request)
+	if err != nil {
+		return utils.WrapErr(errors.Wrap(err, "marshal request"), 0), nil
+	}
+	awsResp, err := awsCli.InvokeModel(c.Request.Context(), &bedrockruntime.InvokeModelInput{
+		ModelId:     aws.String(awsModelId),
+		Accept:      aws.String("application/json"),
+		ContentType: aws.String("application/json"),
+		Body:        body,
+	})
+	if err != nil {
+		return utils.WrapErr(errors.Wrap(err, "InvokeModel"), 0), nil
+	}
+	return anthropic.WriteNativeResponse(c, 200, awsResp.Body)
+}
+
+func NativeStreamHandler(c *gin.Context, awsCli *bedrockruntime.Client, meta *meta.Meta, request map[string]any) (*relaymodel.ErrorWithStatusCode, *relaymodel.Usage) {
+	awsModelId, err := awsModelID(meta.ActualModelName)
+	if err != nil {
+		return utils.WrapErr(errors.Wrap(err, "awsModelID"), 0), nil
+	}
+	body, err := nativeBody(request)
+	if err != nil {
+		return utils.WrapErr(errors.Wrap(err, "marshal request"), 0), nil
+	}
+	awsResp, err := awsCli.InvokeModelWithResponseStream(c.Request.Context(), &bedrockruntime.InvokeModelWithResponseStreamInput{
+		ModelId:     aws.String(awsModelId),
+		Accept:      aws.String("application/json"),
+		ContentType: aws.String("application/json"),
+		Body:        body,
+	})
+	if err != nil {
+		return utils.WrapErr(errors.Wrap(err, "InvokeModelWithResponseStream"), 0), nil
+	}
+	stream := awsResp.GetStream()
+	defer stream.Close()
+
+	common.SetEventStreamHeaders(c)
+	var usage relaymodel.Usage
+	c.Stream(func(w io.Writer) bool {
+		event, ok := <-stream.Events()
+		if !ok {
+			return false
+		}
+		switch v := event.(type) {
+		case *types.ResponseStreamMemberChunk:
+			adaptor.StartingStream(c, meta)
+			var claudeResp anthropic.StreamResponse
+			if err := json.Unmarshal(v.Value.Bytes, &claudeResp); err != nil {
+				logger.SysError("error unmarshalling stream response: " + err.Error())
+				return false
+			}
+			anthropic.ParseStreamUsage(&claudeResp, &usage)
+			_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", claudeResp.Type, v.Value.Bytes)
+			return true
+		default:
+			logger.SysError(fmt.Sprintf("unexpected bedrock stream event: %T", v))
+			return false
+		}
+	})
+	return nil, &usage
+}
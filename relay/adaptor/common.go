@@ -8,7 +8,10 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/telemetry"
 	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/transform"
 )
 
 func SetupCommonRequestHeader(c *gin.Context, req *http.Request, meta *meta.Meta) {
@@ -32,6 +35,9 @@ func DoRequestHelper(a Adaptor, c *gin.Context, meta *meta.Meta, requestBody io.
 	if err != nil {
 		return nil, fmt.Errorf("setup request header failed: %w", err)
 	}
+	if meta.Config.Transform != nil {
+		transform.ApplyHeaders(meta.Config.Transform.Headers, req.Header)
+	}
 	resp, err := DoRequest(c, req)
 	if err != nil {
 		return nil, fmt.Errorf("do request failed: %w", err)
@@ -40,6 +46,7 @@ func DoRequestHelper(a Adaptor, c *gin.Context, meta *meta.Meta, requestBody io.
 }
 
 func DoRequest(c *gin.Context, req *http.Request) (*http.Response, error) {
+	telemetry.InjectHeaders(c.Request.Context(), req.Header)
 	resp, err := client.HTTPClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -54,4 +61,6 @@ func DoRequest(c *gin.Context, req *http.Request) (*http.Response, error) {
 
 func StartingStream(c *gin.Context, meta *meta.Meta) {
 	meta.SetFirstResponseTime()
+	// the channel selector measures the latency of streams with it
+	c.Set(ctxkey.FirstResponseTime, meta.FirstResponseTime)
 }
@@ -363,6 +363,9 @@ func handleGenaiStream(c *gin.Context, meta *meta.Meta, iter *genai.GenerateCont
 			CompletionTokens: int(resp.UsageMetadata.CandidatesTokenCount),
 			TotalTokens:      int(resp.UsageMetadata.TotalTokenCount),
 		}
+		if resp.UsageMetadata.CachedContentTokenCount > 0 {
+			usage.PromptTokensDetails = &relaymodel.PromptTokensDetails{CachedTokens: int(resp.UsageMetadata.CachedContentTokenCount)}
+		}
 		response.Usage = usage
 		err = render.ObjectData(c, response)
 		if err != nil {
@@ -451,6 +454,9 @@ func handleGenaiUnStream(c *gin.Context, meta *meta.Meta, resp *genai.GenerateCo
 			CompletionTokens: int(resp.UsageMetadata.CandidatesTokenCount),
 			TotalTokens:      int(resp.UsageMetadata.TotalTokenCount),
 		}
+		if resp.UsageMetadata.CachedContentTokenCount > 0 {
+			usage.PromptTokensDetails = &relaymodel.PromptTokensDetails{CachedTokens: int(resp.UsageMetadata.CachedContentTokenCount)}
+		}
 	} else {
 		completionTokens := openai.CountTokenText(fullText, meta.ActualModelName)
 		usage = relaymodel.Usage{
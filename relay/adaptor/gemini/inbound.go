@@ -0,0 +1,312 @@
+package gemini
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// the inbound side of /v1beta/models/{model}:generateContent, requests from gemini-native clients are converted
+// to openai format and responses from non-gemini channels are converted back
+
+// GenerateContentRequest is the request received from the genai sdks, which use camelCase field names
+type GenerateContentRequest struct {
+	Contents          []ChatContent         `json:"contents"`
+	SystemInstruction *ChatContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *ChatGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []InboundTool         `json:"tools,omitempty"`
+}
+
+type InboundTool struct {
+	FunctionDeclarations []FunctionDeclaration `json:"functionDeclarations,omitempty"`
+}
+
+type FunctionDeclaration struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+// ParseModelAction splits the "{model}:{action}" path segment
+func ParseModelAction(modelAction string) (modelName string, action string) {
+	modelAction = strings.TrimPrefix(modelAction, "/")
+	modelName, action, _ = strings.Cut(modelAction, ":")
+	return modelName, action
+}
+
+func partsText(parts []Part) string {
+	var texts []string
+	for _, part := range parts {
+		if part.Text != "" && !part.Thought {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// ConvertGenerateContentRequest converts a gemini-native request into the openai format used by the other channels
+func ConvertGenerateContentRequest(request *GenerateContentRequest, modelName string, stream bool) *model.GeneralOpenAIRequest {
+	openaiRequest := model.GeneralOpenAIRequest{
+		Model:  modelName,
+		Stream: stream,
+	}
+	if stream {
+		openaiRequest.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+	if config := request.GenerationConfig; config != nil {
+		openaiRequest.Temperature = config.Temperature
+		openaiRequest.TopP = config.TopP
+		openaiRequest.TopK = int(config.TopK)
+		openaiRequest.MaxTokens = config.MaxOutputTokens
+		openaiRequest.N = config.CandidateCount
+		openaiRequest.Stop = config.StopSequences
+		if config.ResponseMimeType == "application/json" {
+			openaiRequest.ResponseFormat = &model.ResponseFormat{Type: "json_object"}
+		}
+		if config.ThinkingConfig != nil && config.ThinkingConfig.ThinkingBudget != nil && *config.ThinkingConfig.ThinkingBudget > 0 {
+			openaiRequest.Thinking = &model.Thinking{
+				Type:           "enabled",
+				ThinkingBudget: *config.ThinkingConfig.ThinkingBudget,
+			}
+		}
+	}
+	for _, tool := range request.Tools {
+		for _, function := range tool.FunctionDeclarations {
+			openaiRequest.Tools = append(openaiRequest.Tools, model.Tool{
+				Type: "function",
+				Function: model.Function{
+					Name:        function.Name,
+					Description: function.Description,
+					Parameters:  function.Parameters,
+				},
+			})
+		}
+	}
+	if request.SystemInstruction != nil {
+		if system := partsText(request.SystemInstruction.Parts); system != "" {
+			openaiRequest.Messages = append(openaiRequest.Messages, model.Message{
+				Role:    "system",
+				Content: system,
+			})
+		}
+	}
+	for _, content := range request.Contents {
+		openaiRequest.Messages = append(openaiRequest.Messages, convertContent(content)...)
+	}
+	return &openaiRequest
+}
+
+// convertContent may return several messages, as function responses are separate messages in openai format.
+// gemini has no call ids, the function name is used instead
+func convertContent(content ChatContent) []model.Message {
+	role := "user"
+	if content.Role == "model" {
+		role = "assistant"
+	}
+	var messages []model.Message
+	var parts []any
+	var toolCalls []model.Tool
+	for _, part := range content.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			arguments, _ := json.Marshal(part.FunctionCall.Arguments)
+			toolCalls = append(toolCalls, model.Tool{
+				Id:   part.FunctionCall.FunctionName,
+				Type: "function",
+				Function: model.Function{
+					Name:      part.FunctionCall.FunctionName,
+					Arguments: string(arguments),
+				},
+			})
+		case part.FunctionResponse != nil:
+			response, _ := json.Marshal(part.FunctionResponse.Response)
+			messages = append(messages, model.Message{
+				Role:       "tool",
+				Content:    string(response),
+				ToolCallId: part.FunctionResponse.Name,
+			})
+		case part.InlineData != nil:
+			parts = append(parts, map[string]any{
+				"type":      model.ContentTypeImageURL,
+				"image_url": map[string]any{"url": fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data)},
+			})
+		case part.FileData != nil:
+			parts = append(parts, map[string]any{
+				"type":      model.ContentTypeImageURL,
+				"image_url": map[string]any{"url": part.FileData.Uri},
+			})
+		case part.Text != "" && !part.Thought:
+			parts = append(parts, map[string]any{
+				"type": model.ContentTypeText,
+				"text": part.Text,
+			})
+		}
+	}
+	if len(parts) == 0 && len(toolCalls) == 0 {
+		return messages
+	}
+	message := model.Message{
+		Role:      role,
+		ToolCalls: toolCalls,
+	}
+	if len(parts) == 1 && parts[0].(map[string]any)["type"] == model.ContentTypeText {
+		message.Content = parts[0].(map[string]any)["text"]
+	} else if len(parts) > 0 {
+		message.Content = parts
+	} else {
+		message.Content = ""
+	}
+	return append(messages, message)
+}
+
+func finishReasonOpenAI2Gemini(reason string) string {
+	switch reason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+// UsageMetadata converts the relay usage into gemini usage metadata
+func UsageMetadata(usage *model.Usage) *UsageMetaData {
+	if usage == nil {
+		return nil
+	}
+	metadata := &UsageMetaData{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens,
+		ThoughtsTokenCount:   usage.ThoughtsTokens,
+		TotalTokenCount:      usage.PromptTokens + usage.CompletionTokens + usage.ThoughtsTokens,
+	}
+	if details := usage.GetPromptTokensDetails(); details != nil {
+		metadata.CachedContentTokenCount = details.CachedTokens
+	}
+	return metadata
+}
+
+func functionCallPart(toolCall model.Tool) Part {
+	arguments := make(map[string]any)
+	if args, ok := toolCall.Function.Arguments.(string); ok {
+		_ = json.Unmarshal([]byte(args), &arguments)
+	}
+	return Part{
+		FunctionCall: &FunctionCall{
+			FunctionName: toolCall.Function.Name,
+			Arguments:    arguments,
+		},
+	}
+}
+
+// ResponseOpenAI2Gemini converts a non-stream openai response into a gemini one
+func ResponseOpenAI2Gemini(response *openai.TextResponse, modelName string, usage *model.Usage) *ChatResponse {
+	geminiResponse := ChatResponse{
+		Candidates:   make([]ChatCandidate, 0, len(response.Choices)),
+		ModelVersion: modelName,
+	}
+	for _, choice := range response.Choices {
+		candidate := ChatCandidate{
+			Content:      ChatContent{Role: "model"},
+			FinishReason: finishReasonOpenAI2Gemini(choice.FinishReason),
+			Index:        int64(choice.Index),
+		}
+		if choice.Message.ReasoningContent != nil && *choice.Message.ReasoningContent != "" {
+			candidate.Content.Parts = append(candidate.Content.Parts, Part{Text: *choice.Message.ReasoningContent, Thought: true})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			candidate.Content.Parts = append(candidate.Content.Parts, Part{Text: text})
+		}
+		for _, toolCall := range choice.Message.ToolCalls {
+			candidate.Content.Parts = append(candidate.Content.Parts, functionCallPart(toolCall))
+		}
+		geminiResponse.Candidates = append(geminiResponse.Candidates, candidate)
+	}
+	if usage == nil {
+		usage = &response.Usage
+	}
+	geminiResponse.UsageMetadata = UsageMetadata(usage)
+	return &geminiResponse
+}
+
+// StreamConverter turns openai stream chunks into gemini stream responses,
+// function call arguments arrive in pieces so the calls are held back until the end
+type StreamConverter struct {
+	Model        string
+	toolCalls    []model.Tool
+	finishReason string
+}
+
+// Convert returns the gemini response for one openai chunk, nil if there is nothing to send yet
+func (s *StreamConverter) Convert(chunk *openai.ChatCompletionsStreamResponse) *ChatResponse {
+	var parts []Part
+	for _, choice := range chunk.Choices {
+		if choice.Delta.ReasoningContent != nil && *choice.Delta.ReasoningContent != "" {
+			parts = append(parts, Part{Text: *choice.Delta.ReasoningContent, Thought: true})
+		}
+		if text := choice.Delta.StringContent(); text != "" {
+			parts = append(parts, Part{Text: text})
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			if toolCall.Id != "" || toolCall.Function.Name != "" || len(s.toolCalls) == 0 {
+				toolCall.Function.Arguments, _ = toolCall.Function.Arguments.(string)
+				s.toolCalls = append(s.toolCalls, toolCall)
+				continue
+			}
+			last := &s.toolCalls[len(s.toolCalls)-1]
+			arguments, _ := toolCall.Function.Arguments.(string)
+			last.Function.Arguments = last.Function.Arguments.(string) + arguments
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			s.finishReason = *choice.FinishReason
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return &ChatResponse{
+		Candidates:   []ChatCandidate{{Content: ChatContent{Role: "model", Parts: parts}}},
+		ModelVersion: s.Model,
+	}
+}
+
+// Finish returns the last response, carrying the function calls, the finish reason and the usage
+func (s *StreamConverter) Finish(usage *model.Usage) *ChatResponse {
+	candidate := ChatCandidate{
+		Content:      ChatContent{Role: "model", Parts: make([]Part, 0, len(s.toolCalls))},
+		FinishReason: finishReasonOpenAI2Gemini(s.finishReason),
+	}
+	for _, toolCall := range s.toolCalls {
+		candidate.Content.Parts = append(candidate.Content.Parts, functionCallPart(toolCall))
+	}
+	return &ChatResponse{
+		Candidates:    []ChatCandidate{candidate},
+		UsageMetadata: UsageMetadata(usage),
+		ModelVersion:  s.Model,
+	}
+}
+
+// ErrorStatus returns the google rpc status matching an http status code
+func ErrorStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	default:
+		return "INTERNAL"
+	}
+}
@@ -66,12 +66,18 @@ type FileData struct {
 	Uri      string `json:"fileUri"`
 }
 
+type FunctionResponse struct {
+	Name     string `json:"name"`
+	Response any    `json:"response"`
+}
+
 type Part struct {
-	Text         string        `json:"text,omitempty"`
-	InlineData   *InlineData   `json:"inlineData,omitempty"`
-	FileData     *FileData     `json:"fileData,omitempty"`
-	FunctionCall *FunctionCall `json:"functionCall,omitempty"`
-	Thought      bool          `json:"thought,omitempty"`
+	Text             string            `json:"text,omitempty"`
+	InlineData       *InlineData       `json:"inlineData,omitempty"`
+	FileData         *FileData         `json:"fileData,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
+	Thought          bool              `json:"thought,omitempty"`
 }
 
 type ChatContent struct {
@@ -133,10 +139,27 @@ type ChatResponse struct {
 	ModelVersion   string              `json:"modelVersion"`
 }
 type UsageMetaData struct {
-	PromptTokenCount     int `json:"promptTokenCount"`
-	CandidatesTokenCount int `json:"candidatesTokenCount"`
-	ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
-	TotalTokenCount      int `json:"totalTokenCount"`
+	PromptTokenCount        int                  `json:"promptTokenCount"`
+	CachedContentTokenCount int                  `json:"cachedContentTokenCount,omitempty"`
+	CandidatesTokenCount    int                  `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int                  `json:"thoughtsTokenCount"`
+	TotalTokenCount         int                  `json:"totalTokenCount"`
+	PromptTokensDetails     []ModalityTokenCount `json:"promptTokensDetails,omitempty"`
+	CandidatesTokensDetails []ModalityTokenCount `json:"candidatesTokensDetails,omitempty"`
+}
+
+type ModalityTokenCount struct {
+	Modality   string `json:"modality"` // TEXT, IMAGE, AUDIO or VIDEO
+	TokenCount int    `json:"tokenCount"`
+}
+
+func modalityTokenCount(details []ModalityTokenCount, modality string) int {
+	for _, detail := range details {
+		if detail.Modality == modality {
+			return detail.TokenCount
+		}
+	}
+	return 0
 }
 
 type ImageRequest struct {
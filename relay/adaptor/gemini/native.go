@@ -0,0 +1,136 @@
+package gemini
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// the native handlers are used by the gemini inbound endpoints when the channel speaks gemini itself
+
+// usageFromMetadata converts the usage metadata, the cached, audio and image tokens become the token details
+func usageFromMetadata(metadata *UsageMetaData) *relaymodel.Usage {
+	usage := &relaymodel.Usage{
+		PromptTokens:     metadata.PromptTokenCount,
+		CompletionTokens: metadata.CandidatesTokenCount,
+		ThoughtsTokens:   metadata.ThoughtsTokenCount,
+		TotalTokens:      metadata.TotalTokenCount,
+	}
+	promptDetails := relaymodel.PromptTokensDetails{
+		CachedTokens: metadata.CachedContentTokenCount,
+		AudioTokens:  modalityTokenCount(metadata.PromptTokensDetails, "AUDIO"),
+		ImageTokens:  modalityTokenCount(metadata.PromptTokensDetails, "IMAGE"),
+	}
+	if promptDetails != (relaymodel.PromptTokensDetails{}) {
+		usage.PromptTokensDetails = &promptDetails
+	}
+	completionDetails := relaymodel.CompletionTokensDetails{
+		AudioTokens: modalityTokenCount(metadata.CandidatesTokensDetails, "AUDIO"),
+		ImageTokens: modalityTokenCount(metadata.CandidatesTokensDetails, "IMAGE"),
+	}
+	if completionDetails != (relaymodel.CompletionTokensDetails{}) {
+		usage.CompletionTokensDetails = &completionDetails
+	}
+	return usage
+}
+
+// StreamWriter writes gemini stream responses, either as server-sent events (alt=sse)
+// or, as google does without alt=sse, as the elements of one json array
+type StreamWriter struct {
+	SSE     bool
+	started bool
+}
+
+func (w *StreamWriter) Write(writer io.Writer, data []byte) {
+	if w.SSE {
+		_, _ = io.WriteString(writer, "data: "+string(data)+"\r\n\r\n")
+	} else {
+		if !w.started {
+			_, _ = io.WriteString(writer, "[")
+		} else {
+			_, _ = io.WriteString(writer, ",\r\n")
+		}
+		_, _ = writer.Write(data)
+	}
+	w.started = true
+}
+
+func (w *StreamWriter) Close(writer io.Writer) {
+	if w.SSE {
+		return
+	}
+	if !w.started {
+		_, _ = io.WriteString(writer, "[")
+	}
+	_, _ = io.WriteString(writer, "]")
+}
+
+func NativeStreamHandler(c *gin.Context, resp *http.Response, meta *meta.Meta, sse bool) (*relaymodel.ErrorWithStatusCode, *relaymodel.Usage) {
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if sse {
+		common.SetEventStreamHeaders(c)
+	} else {
+		c.Writer.Header().Set("Content-Type", "application/json")
+	}
+
+	writer := &StreamWriter{SSE: sse}
+	usage := &relaymodel.Usage{}
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		adaptor.StartingStream(c, meta)
+		data = strings.TrimSpace(data)
+		var geminiResponse ChatResponse
+		if err := json.Unmarshal([]byte(data), &geminiResponse); err != nil {
+			logger.SysError("error unmarshalling stream response: " + err.Error())
+			continue
+		}
+		if geminiResponse.UsageMetadata != nil {
+			usage = usageFromMetadata(geminiResponse.UsageMetadata)
+		}
+		writer.Write(c.Writer, []byte(data))
+		c.Writer.Flush()
+	}
+	if err := scanner.Err(); err != nil {
+		logger.SysError("error reading stream: " + err.Error())
+	}
+	writer.Close(c.Writer)
+	c.Writer.Flush()
+	return nil, usage
+}
+
+func NativeHandler(c *gin.Context, resp *http.Response) (*relaymodel.ErrorWithStatusCode, *relaymodel.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var geminiResponse ChatResponse
+	err = json.Unmarshal(responseBody, &geminiResponse)
+	if err != nil {
+		return openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	usage := &relaymodel.Usage{}
+	if geminiResponse.UsageMetadata != nil {
+		usage = usageFromMetadata(geminiResponse.UsageMetadata)
+	}
+	c.Data(resp.StatusCode, "application/json", responseBody)
+	return nil, usage
+}
@@ -511,12 +511,7 @@ func StreamHandler(c *gin.Context, resp *http.Response, meta *meta.Meta) (*relay
 		}
 
 		responseText += response.Choices[0].Delta.StringContent()
-		usage = &relaymodel.Usage{
-			PromptTokens:     geminiResponse.UsageMetadata.PromptTokenCount,
-			CompletionTokens: geminiResponse.UsageMetadata.CandidatesTokenCount,
-			ThoughtsTokens:   geminiResponse.UsageMetadata.ThoughtsTokenCount,
-			TotalTokens:      geminiResponse.UsageMetadata.TotalTokenCount,
-		}
+		usage = usageFromMetadata(geminiResponse.UsageMetadata)
 		response.Usage = usage
 
 		err = render.ObjectData(c, response)
@@ -594,12 +589,7 @@ func Handler(c *gin.Context, resp *http.Response, meta *meta.Meta) (*relaymodel.
 	fullTextResponse.Model = meta.OriginModelName
 	var usage relaymodel.Usage
 	if geminiResponse.UsageMetadata != nil {
-		usage = relaymodel.Usage{
-			PromptTokens:     geminiResponse.UsageMetadata.PromptTokenCount,
-			CompletionTokens: geminiResponse.UsageMetadata.CandidatesTokenCount,
-			ThoughtsTokens:   geminiResponse.UsageMetadata.ThoughtsTokenCount,
-			TotalTokens:      geminiResponse.UsageMetadata.TotalTokenCount,
-		}
+		usage = *usageFromMetadata(geminiResponse.UsageMetadata)
 	} else {
 		responseText, reasoningContent := geminiResponse.GetResponseText(meta)
 		completionTokens := openai.CountTokenText((responseText + reasoningContent), meta.OriginModelName)
@@ -0,0 +1,552 @@
+package openai
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/responses/create
+
+type ResponsesRequest struct {
+	Model              string          `json:"model"`
+	Input              any             `json:"input,omitempty"`
+	Instructions       string          `json:"instructions,omitempty"`
+	PreviousResponseId string          `json:"previous_response_id,omitempty"`
+	Store              *bool           `json:"store,omitempty"`
+	Stream             bool            `json:"stream,omitempty"`
+	Tools              []ResponsesTool `json:"tools,omitempty"`
+	ToolChoice         any             `json:"tool_choice,omitempty"`
+	Temperature        *float64        `json:"temperature,omitempty"`
+	TopP               *float64        `json:"top_p,omitempty"`
+	MaxOutputTokens    int             `json:"max_output_tokens,omitempty"`
+	Metadata           any             `json:"metadata,omitempty"`
+	User               string          `json:"user,omitempty"`
+}
+
+// ResponsesTool is either a function or a built-in tool such as web_search_preview, which only openai runs
+type ResponsesTool struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type ResponsesContent struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations"`
+}
+
+type ResponsesSummary struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type ResponsesOutputItem struct {
+	Type      string             `json:"type"`
+	Id        string             `json:"id"`
+	Status    string             `json:"status,omitempty"`
+	Role      string             `json:"role,omitempty"`
+	Content   []ResponsesContent `json:"content,omitempty"`
+	CallId    string             `json:"call_id,omitempty"`
+	Name      string             `json:"name,omitempty"`
+	Arguments string             `json:"arguments,omitempty"`
+	Summary   []ResponsesSummary `json:"summary,omitempty"`
+}
+
+type ResponsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	OutputTokens       int `json:"output_tokens"`
+	TotalTokens        int `json:"total_tokens"`
+	InputTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+}
+
+type ResponsesResponse struct {
+	Id                 string                `json:"id"`
+	Object             string                `json:"object"`
+	CreatedAt          int64                 `json:"created_at"`
+	Status             string                `json:"status"`
+	Model              string                `json:"model"`
+	Output             []ResponsesOutputItem `json:"output"`
+	Instructions       *string               `json:"instructions"`
+	PreviousResponseId *string               `json:"previous_response_id"`
+	Store              bool                  `json:"store"`
+	Metadata           any                   `json:"metadata,omitempty"`
+	Usage              *ResponsesUsage       `json:"usage"`
+	Error              any                   `json:"error"`
+}
+
+type ResponsesStreamEvent struct {
+	Type           string               `json:"type"`
+	SequenceNumber int                  `json:"sequence_number"`
+	Response       *ResponsesResponse   `json:"response,omitempty"`
+	OutputIndex    *int                 `json:"output_index,omitempty"`
+	ContentIndex   *int                 `json:"content_index,omitempty"`
+	SummaryIndex   *int                 `json:"summary_index,omitempty"`
+	ItemId         string               `json:"item_id,omitempty"`
+	Item           *ResponsesOutputItem `json:"item,omitempty"`
+	Part           *ResponsesContent    `json:"part,omitempty"`
+	Delta          string               `json:"delta,omitempty"`
+	Text           string               `json:"text,omitempty"`
+	Arguments      string               `json:"arguments,omitempty"`
+}
+
+// InputItems normalizes the input, a plain string is one user message
+func (r *ResponsesRequest) InputItems() []any {
+	switch input := r.Input.(type) {
+	case string:
+		return []any{map[string]any{"type": "message", "role": "user", "content": input}}
+	case []any:
+		return input
+	}
+	return nil
+}
+
+// ShouldStore tells if the response should be kept for previous_response_id, like openai it defaults to true
+func (r *ResponsesRequest) ShouldStore() bool {
+	return r.Store == nil || *r.Store
+}
+
+func responsesContentParts(content any) []any {
+	if text, ok := content.(string); ok {
+		return []any{map[string]any{"type": model.ContentTypeText, "text": text}}
+	}
+	list, _ := content.([]any)
+	var parts []any
+	for _, item := range list {
+		part, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch part["type"] {
+		case "input_text", "output_text", "text":
+			parts = append(parts, map[string]any{"type": model.ContentTypeText, "text": part["text"]})
+		case "input_image":
+			if url, ok := part["image_url"].(string); ok && url != "" {
+				parts = append(parts, map[string]any{
+					"type":      model.ContentTypeImageURL,
+					"image_url": map[string]any{"url": url},
+				})
+			}
+		}
+	}
+	return parts
+}
+
+// ResponsesItems2Messages converts input items, including the output items of previous turns, into chat messages
+func ResponsesItems2Messages(items []any) []model.Message {
+	var messages []model.Message
+	for _, raw := range items {
+		item, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		itemType, _ := item["type"].(string)
+		if itemType == "" && item["role"] != nil {
+			itemType = "message"
+		}
+		switch itemType {
+		case "message":
+			role, _ := item["role"].(string)
+			if role == "developer" {
+				role = "system"
+			}
+			parts := responsesContentParts(item["content"])
+			message := model.Message{Role: role, Content: parts}
+			if len(parts) == 1 && parts[0].(map[string]any)["type"] == model.ContentTypeText {
+				message.Content = parts[0].(map[string]any)["text"]
+			}
+			messages = append(messages, message)
+		case "function_call":
+			callId, _ := item["call_id"].(string)
+			name, _ := item["name"].(string)
+			arguments, _ := item["arguments"].(string)
+			toolCall := model.Tool{
+				Id:   callId,
+				Type: "function",
+				Function: model.Function{
+					Name:      name,
+					Arguments: arguments,
+				},
+			}
+			// parallel calls belong to the same assistant message
+			if last := len(messages) - 1; last >= 0 && messages[last].Role == "assistant" {
+				messages[last].ToolCalls = append(messages[last].ToolCalls, toolCall)
+				continue
+			}
+			messages = append(messages, model.Message{Role: "assistant", Content: "", ToolCalls: []model.Tool{toolCall}})
+		case "function_call_output":
+			callId, _ := item["call_id"].(string)
+			output, ok := item["output"].(string)
+			if !ok {
+				outputJson, _ := json.Marshal(item["output"])
+				output = string(outputJson)
+			}
+			messages = append(messages, model.Message{Role: "tool", Content: output, ToolCallId: callId})
+		}
+	}
+	return messages
+}
+
+// HistoryItems prepares the stored items of previous turns to be sent again to openai with store disabled,
+// item ids and reasoning items only resolve against responses stored upstream so they are dropped
+func HistoryItems(items []any) []any {
+	history := make([]any, 0, len(items))
+	for _, raw := range items {
+		item, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		if item["type"] == "reasoning" {
+			continue
+		}
+		cleaned := make(map[string]any, len(item))
+		for key, value := range item {
+			if key != "id" {
+				cleaned[key] = value
+			}
+		}
+		history = append(history, cleaned)
+	}
+	return history
+}
+
+// ConvertResponsesRequest converts a responses request into a chat completions one,
+// items holds the history of previous turns followed by the input of this one
+func ConvertResponsesRequest(request *ResponsesRequest, items []any) *model.GeneralOpenAIRequest {
+	textRequest := model.GeneralOpenAIRequest{
+		Model:       request.Model,
+		Stream:      request.Stream,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		MaxTokens:   request.MaxOutputTokens,
+		User:        request.User,
+	}
+	if request.Stream {
+		textRequest.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+	if request.Instructions != "" {
+		textRequest.Messages = append(textRequest.Messages, model.Message{Role: "system", Content: request.Instructions})
+	}
+	textRequest.Messages = append(textRequest.Messages, ResponsesItems2Messages(items)...)
+	for _, tool := range request.Tools {
+		if tool.Type != "function" {
+			logger.SysLogf("built-in tool %s is only available on openai channels, dropped", tool.Type)
+			continue
+		}
+		textRequest.Tools = append(textRequest.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	switch toolChoice := request.ToolChoice.(type) {
+	case string:
+		textRequest.ToolChoice = toolChoice
+	case map[string]any:
+		if toolChoice["type"] == "function" {
+			textRequest.ToolChoice = map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": toolChoice["name"]},
+			}
+		}
+	}
+	if len(textRequest.Tools) == 0 {
+		textRequest.ToolChoice = nil
+	}
+	return &textRequest
+}
+
+// NewResponsesResponse creates the response object in progress
+func NewResponsesResponse(request *ResponsesRequest, modelName string) *ResponsesResponse {
+	response := ResponsesResponse{
+		Id:        "resp_" + random.GetUUID(),
+		Object:    "response",
+		CreatedAt: helper.GetTimestamp(),
+		Status:    "in_progress",
+		Model:     modelName,
+		Output:    make([]ResponsesOutputItem, 0),
+		Store:     request.ShouldStore(),
+		Metadata:  request.Metadata,
+	}
+	if request.Instructions != "" {
+		response.Instructions = &request.Instructions
+	}
+	if request.PreviousResponseId != "" {
+		response.PreviousResponseId = &request.PreviousResponseId
+	}
+	return &response
+}
+
+// ResponsesUsageFromUsage converts the relay usage
+func ResponsesUsageFromUsage(usage *model.Usage) *ResponsesUsage {
+	if usage == nil {
+		return nil
+	}
+	responsesUsage := ResponsesUsage{
+		InputTokens:  usage.PromptTokens,
+		OutputTokens: usage.CompletionTokens + usage.ThoughtsTokens,
+	}
+	responsesUsage.TotalTokens = responsesUsage.InputTokens + responsesUsage.OutputTokens
+	responsesUsage.OutputTokensDetails.ReasoningTokens = usage.ThoughtsTokens
+	return &responsesUsage
+}
+
+func messageOutputItem(text string) ResponsesOutputItem {
+	return ResponsesOutputItem{
+		Type:    "message",
+		Id:      "msg_" + random.GetUUID(),
+		Status:  "completed",
+		Role:    "assistant",
+		Content: []ResponsesContent{{Type: "output_text", Text: text, Annotations: []any{}}},
+	}
+}
+
+func functionCallOutputItem(toolCall model.Tool) ResponsesOutputItem {
+	arguments, _ := toolCall.Function.Arguments.(string)
+	if arguments == "" {
+		arguments = "{}"
+	}
+	return ResponsesOutputItem{
+		Type:      "function_call",
+		Id:        "fc_" + random.GetUUID(),
+		Status:    "completed",
+		CallId:    toolCall.Id,
+		Name:      toolCall.Function.Name,
+		Arguments: arguments,
+	}
+}
+
+// ResponseChat2Responses fills the response object from a non-stream chat completion
+func ResponseChat2Responses(textResponse *TextResponse, response *ResponsesResponse, usage *model.Usage) *ResponsesResponse {
+	if len(textResponse.Choices) > 0 {
+		message := textResponse.Choices[0].Message
+		if message.ReasoningContent != nil && *message.ReasoningContent != "" {
+			response.Output = append(response.Output, ResponsesOutputItem{
+				Type:    "reasoning",
+				Id:      "rs_" + random.GetUUID(),
+				Summary: []ResponsesSummary{{Type: "summary_text", Text: *message.ReasoningContent}},
+			})
+		}
+		if text := message.StringContent(); text != "" {
+			response.Output = append(response.Output, messageOutputItem(text))
+		}
+		for _, toolCall := range message.ToolCalls {
+			response.Output = append(response.Output, functionCallOutputItem(toolCall))
+		}
+	}
+	if usage == nil {
+		usage = &textResponse.Usage
+	}
+	response.Usage = ResponsesUsageFromUsage(usage)
+	response.Status = "completed"
+	return response
+}
+
+// ResponsesStreamConverter turns chat completion chunks into responses stream events,
+// the output items are opened and closed as the kind of delta changes
+type ResponsesStreamConverter struct {
+	Response *ResponsesResponse
+	sequence int
+	started  bool
+	current  *ResponsesOutputItem
+	text     strings.Builder
+}
+
+func (s *ResponsesStreamConverter) event(event ResponsesStreamEvent) ResponsesStreamEvent {
+	event.SequenceNumber = s.sequence
+	s.sequence++
+	return event
+}
+
+func (s *ResponsesStreamConverter) start() []ResponsesStreamEvent {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	snapshot := *s.Response
+	return []ResponsesStreamEvent{
+		s.event(ResponsesStreamEvent{Type: "response.created", Response: &snapshot}),
+		s.event(ResponsesStreamEvent{Type: "response.in_progress", Response: &snapshot}),
+	}
+}
+
+func (s *ResponsesStreamConverter) outputIndex() *int {
+	index := len(s.Response.Output)
+	return &index
+}
+
+func (s *ResponsesStreamConverter) open(item ResponsesOutputItem) []ResponsesStreamEvent {
+	events := s.close()
+	item.Status = "in_progress"
+	s.current = &item
+	s.text.Reset()
+	added := item
+	events = append(events, s.event(ResponsesStreamEvent{Type: "response.output_item.added", OutputIndex: s.outputIndex(), Item: &added}))
+	if item.Type == "message" {
+		zero := 0
+		events = append(events, s.event(ResponsesStreamEvent{
+			Type:         "response.content_part.added",
+			OutputIndex:  s.outputIndex(),
+			ContentIndex: &zero,
+			ItemId:       item.Id,
+			Part:         &ResponsesContent{Type: "output_text", Annotations: []any{}},
+		}))
+	}
+	return events
+}
+
+func (s *ResponsesStreamConverter) close() []ResponsesStreamEvent {
+	if s.current == nil {
+		return nil
+	}
+	item := s.current
+	s.current = nil
+	item.Status = "completed"
+	zero := 0
+	var events []ResponsesStreamEvent
+	switch item.Type {
+	case "message":
+		part := ResponsesContent{Type: "output_text", Text: s.text.String(), Annotations: []any{}}
+		item.Content = []ResponsesContent{part}
+		events = append(events,
+			s.event(ResponsesStreamEvent{Type: "response.output_text.done", OutputIndex: s.outputIndex(), ContentIndex: &zero, ItemId: item.Id, Text: part.Text}),
+			s.event(ResponsesStreamEvent{Type: "response.content_part.done", OutputIndex: s.outputIndex(), ContentIndex: &zero, ItemId: item.Id, Part: &part}),
+		)
+	case "reasoning":
+		item.Status = ""
+		item.Summary = []ResponsesSummary{{Type: "summary_text", Text: s.text.String()}}
+		events = append(events, s.event(ResponsesStreamEvent{Type: "response.reasoning_summary_text.done", OutputIndex: s.outputIndex(), SummaryIndex: &zero, ItemId: item.Id, Text: s.text.String()}))
+	case "function_call":
+		item.Arguments = s.text.String()
+		if item.Arguments == "" {
+			item.Arguments = "{}"
+		}
+		events = append(events, s.event(ResponsesStreamEvent{Type: "response.function_call_arguments.done", OutputIndex: s.outputIndex(), ItemId: item.Id, Arguments: item.Arguments}))
+	}
+	done := *item
+	events = append(events, s.event(ResponsesStreamEvent{Type: "response.output_item.done", OutputIndex: s.outputIndex(), Item: &done}))
+	s.Response.Output = append(s.Response.Output, *item)
+	return events
+}
+
+// Convert returns the events for one chat completion chunk
+func (s *ResponsesStreamConverter) Convert(chunk *ChatCompletionsStreamResponse) []ResponsesStreamEvent {
+	events := s.start()
+	zero := 0
+	for _, choice := range chunk.Choices {
+		if choice.Delta.ReasoningContent != nil && *choice.Delta.ReasoningContent != "" {
+			if s.current == nil || s.current.Type != "reasoning" {
+				events = append(events, s.open(ResponsesOutputItem{Type: "reasoning", Id: "rs_" + random.GetUUID(), Summary: []ResponsesSummary{}})...)
+			}
+			s.text.WriteString(*choice.Delta.ReasoningContent)
+			events = append(events, s.event(ResponsesStreamEvent{Type: "response.reasoning_summary_text.delta", OutputIndex: s.outputIndex(), SummaryIndex: &zero, ItemId: s.current.Id, Delta: *choice.Delta.ReasoningContent}))
+		}
+		if text := choice.Delta.StringContent(); text != "" {
+			if s.current == nil || s.current.Type != "message" {
+				events = append(events, s.open(ResponsesOutputItem{Type: "message", Id: "msg_" + random.GetUUID(), Role: "assistant", Content: []ResponsesContent{}})...)
+			}
+			s.text.WriteString(text)
+			events = append(events, s.event(ResponsesStreamEvent{Type: "response.output_text.delta", OutputIndex: s.outputIndex(), ContentIndex: &zero, ItemId: s.current.Id, Delta: text}))
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			if toolCall.Id != "" || s.current == nil || s.current.Type != "function_call" {
+				events = append(events, s.open(ResponsesOutputItem{Type: "function_call", Id: "fc_" + random.GetUUID(), CallId: toolCall.Id, Name: toolCall.Function.Name})...)
+			}
+			if arguments, ok := toolCall.Function.Arguments.(string); ok && arguments != "" {
+				s.text.WriteString(arguments)
+				events = append(events, s.event(ResponsesStreamEvent{Type: "response.function_call_arguments.delta", OutputIndex: s.outputIndex(), ItemId: s.current.Id, Delta: arguments}))
+			}
+		}
+	}
+	return events
+}
+
+// Finish closes the last item and completes the response
+func (s *ResponsesStreamConverter) Finish(usage *model.Usage) []ResponsesStreamEvent {
+	events := s.start()
+	events = append(events, s.close()...)
+	s.Response.Status = "completed"
+	s.Response.Usage = ResponsesUsageFromUsage(usage)
+	return append(events, s.event(ResponsesStreamEvent{Type: "response.completed", Response: s.Response}))
+}
+
+// the responses handlers are used by /v1/responses when the channel is openai itself, the response is passed through as is
+
+// Usage converts the responses usage into the relay usage
+func (u *ResponsesUsage) Usage() *model.Usage {
+	return &model.Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+}
+
+// ResponsesStreamHandler passes the events through, the final response is taken from response.completed
+func ResponsesStreamHandler(c *gin.Context, resp *http.Response, meta *meta.Meta) (*model.ErrorWithStatusCode, *ResponsesResponse) {
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	common.SetEventStreamHeaders(c)
+
+	var response *ResponsesResponse
+	for scanner.Scan() {
+		adaptor.StartingStream(c, meta)
+		line := scanner.Text()
+		if data, ok := strings.CutPrefix(line, "data:"); ok {
+			var event ResponsesStreamEvent
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+				logger.SysError("error unmarshalling stream response: " + err.Error())
+			} else if event.Response != nil && (event.Type == "response.completed" || event.Type == "response.incomplete") {
+				response = event.Response
+			}
+		}
+		_, _ = c.Writer.WriteString(line + "\n")
+		if line == "" {
+			c.Writer.Flush()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.SysError("error reading stream: " + err.Error())
+	}
+	c.Writer.Flush()
+	return nil, response
+}
+
+func ResponsesHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *ResponsesResponse) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var response ResponsesResponse
+	err = json.Unmarshal(responseBody, &response)
+	if err != nil {
+		return ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	c.Data(resp.StatusCode, "application/json", responseBody)
+	return nil, &response
+}
@@ -0,0 +1,79 @@
+package openai_test
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertResponsesRequest(t *testing.T) {
+	request := &openai.ResponsesRequest{
+		Model:        "gpt-4o",
+		Instructions: "be brief",
+		Tools: []openai.ResponsesTool{
+			{Type: "function", Name: "weather", Parameters: map[string]any{"type": "object"}},
+			{Type: "web_search_preview"},
+		},
+		ToolChoice: map[string]any{"type": "function", "name": "weather"},
+	}
+	history := []any{
+		map[string]any{"type": "message", "role": "user", "content": "What's the weather in Paris and Rome?"},
+		map[string]any{"type": "reasoning", "id": "rs_1", "summary": []any{}},
+		map[string]any{"type": "function_call", "call_id": "call_1", "name": "weather", "arguments": `{"city":"Paris"}`},
+		map[string]any{"type": "function_call", "call_id": "call_2", "name": "weather", "arguments": `{"city":"Rome"}`},
+	}
+	input := []any{
+		map[string]any{"type": "function_call_output", "call_id": "call_1", "output": "sunny"},
+		map[string]any{"type": "function_call_output", "call_id": "call_2", "output": "rainy"},
+	}
+	textRequest := openai.ConvertResponsesRequest(request, append(history, input...))
+	assert.Len(t, textRequest.Messages, 5)
+	assert.Equal(t, "system", textRequest.Messages[0].Role)
+	assert.Equal(t, "What's the weather in Paris and Rome?", textRequest.Messages[1].Content)
+	assert.Equal(t, "assistant", textRequest.Messages[2].Role)
+	assert.Len(t, textRequest.Messages[2].ToolCalls, 2)
+	assert.Equal(t, "tool", textRequest.Messages[3].Role)
+	assert.Equal(t, "call_2", textRequest.Messages[4].ToolCallId)
+	assert.Len(t, textRequest.Tools, 1)
+	assert.Equal(t, "weather", textRequest.ToolChoice.(map[string]any)["function"].(map[string]any)["name"])
+}
+
+func TestHistoryItems(t *testing.T) {
+	items := openai.HistoryItems([]any{
+		map[string]any{"type": "reasoning", "id": "rs_1"},
+		map[string]any{"type": "message", "id": "msg_1", "role": "assistant", "content": "hi"},
+	})
+	assert.Len(t, items, 1)
+	assert.NotContains(t, items[0], "id")
+}
+
+func TestResponsesStreamConverter(t *testing.T) {
+	text := "Hello"
+	converter := &openai.ResponsesStreamConverter{
+		Response: openai.NewResponsesResponse(&openai.ResponsesRequest{}, "gpt-4o"),
+	}
+	events := converter.Convert(&openai.ChatCompletionsStreamResponse{
+		Choices: []openai.ChatCompletionsStreamResponseChoice{{Delta: relaymodel.Message{Content: text}}},
+	})
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{
+		"response.created",
+		"response.in_progress",
+		"response.output_item.added",
+		"response.content_part.added",
+		"response.output_text.delta",
+	}, types)
+
+	events = converter.Finish(&relaymodel.Usage{PromptTokens: 3, CompletionTokens: 1})
+	last := events[len(events)-1]
+	assert.Equal(t, "response.completed", last.Type)
+	assert.Equal(t, len(types)+len(events)-1, last.SequenceNumber)
+	assert.Equal(t, "completed", last.Response.Status)
+	assert.Equal(t, text, last.Response.Output[0].Content[0].Text)
+	assert.Equal(t, 4, last.Response.Usage.TotalTokens)
+}
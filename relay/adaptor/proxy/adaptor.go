@@ -1,6 +1,8 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"net/http"
@@ -36,16 +38,51 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Met
 	}
 
 	c.Writer.WriteHeader(resp.StatusCode)
-	if _, gerr := io.Copy(c.Writer, resp.Body); gerr != nil {
-		return nil, &relaymodel.ErrorWithStatusCode{
-			StatusCode: http.StatusInternalServerError,
-			Error: relaymodel.Error{
-				Message: gerr.Error(),
-			},
+	rule := meta.Config.ProxyBilling
+	if rule == nil || rule.Mode != model.ProxyBillingUsage || resp.StatusCode/100 != 2 {
+		if _, gerr := io.Copy(c.Writer, resp.Body); gerr != nil {
+			return nil, copyError(gerr)
 		}
+		return nil, nil
 	}
 
-	return nil, nil
+	// the usage is read from the body on its way to the client
+	usage = &model.Usage{}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		meta.IsStream = true
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if data, ok := strings.CutPrefix(line, "data:"); ok {
+				ParseUsage(rule, []byte(strings.TrimSpace(data)), usage)
+			}
+			_, _ = c.Writer.WriteString(line + "\n")
+			if line == "" {
+				c.Writer.Flush()
+			}
+		}
+		c.Writer.Flush()
+		if gerr := scanner.Err(); gerr != nil {
+			return usage, copyError(gerr)
+		}
+		return usage, nil
+	}
+	var body bytes.Buffer
+	if _, gerr := io.Copy(io.MultiWriter(c.Writer, &body), resp.Body); gerr != nil {
+		return nil, copyError(gerr)
+	}
+	ParseUsage(rule, body.Bytes(), usage)
+	return usage, nil
+}
+
+func copyError(err error) *relaymodel.ErrorWithStatusCode {
+	return &relaymodel.ErrorWithStatusCode{
+		StatusCode: http.StatusInternalServerError,
+		Error: relaymodel.Error{
+			Message: err.Error(),
+		},
+	}
 }
 
 func (a *Adaptor) GetModelList() (models []string) {
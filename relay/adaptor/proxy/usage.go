@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// lookupPath returns the value at a dotted path such as usage.prompt_tokens, array elements are selected by index: data.0.tokens
+func lookupPath(data any, path string) (any, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := data.(type) {
+		case map[string]any:
+			value, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			data = value
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			data = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+func lookupInt(data any, path string) (int, bool) {
+	if path == "" {
+		return 0, false
+	}
+	value, ok := lookupPath(data, path)
+	if !ok {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case float64:
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// ParseUsage reads the token counts of the pricing rule from a json body,
+// the counts found replace the previous ones, as streams usually carry the final usage in their last events
+func ParseUsage(rule *model.ProxyBilling, body []byte, usage *model.Usage) {
+	var data any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return
+	}
+	if promptTokens, ok := lookupInt(data, rule.PromptTokensPath); ok {
+		usage.PromptTokens = promptTokens
+	}
+	if completionTokens, ok := lookupInt(data, rule.CompletionTokensPath); ok {
+		usage.CompletionTokens = completionTokens
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+}
@@ -0,0 +1,25 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUsage(t *testing.T) {
+	rule := &model.ProxyBilling{
+		Mode:                 model.ProxyBillingUsage,
+		PromptTokensPath:     "usage.input",
+		CompletionTokensPath: "data.1.tokens",
+	}
+	usage := &model.Usage{}
+	ParseUsage(rule, []byte(`{"usage":{"input":12},"data":[{"tokens":1},{"tokens":"30"}]}`), usage)
+	assert.Equal(t, 12, usage.PromptTokens)
+	assert.Equal(t, 30, usage.CompletionTokens)
+	assert.Equal(t, 42, usage.TotalTokens)
+
+	// an event without the counts keeps the previous ones
+	ParseUsage(rule, []byte(`{"delta":"hi"}`), usage)
+	assert.Equal(t, 42, usage.TotalTokens)
+}
@@ -0,0 +1,39 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "mail [EMAIL], key [API_KEY], phone [PHONE]",
+		Redact("mail alice@example.com, key sk-abcdefghijklmnopqrstuvwx, phone 13812345678"))
+	assert.Equal(t, "Authorization: Bearer [TOKEN]", Redact("Authorization: Bearer abc.def-123"))
+
+	defaultRules := RedactionRules2JSONString()
+	assert.Nil(t, UpdateRedactionRulesByJSONString(`[{"pattern":"secret-\\d+","replacement":"***"}]`))
+	defer func() {
+		assert.Nil(t, UpdateRedactionRulesByJSONString(defaultRules))
+	}()
+	assert.Equal(t, "code *** alice@example.com", Redact("code secret-42 alice@example.com"))
+	assert.NotNil(t, UpdateRedactionRulesByJSONString(`[{"pattern":"(","replacement":""}]`))
+	// an invalid rule keeps the current rules
+	assert.Equal(t, "***", Redact("secret-1"))
+}
+
+func TestAssembleStream(t *testing.T) {
+	openaiStream := "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\ndata: [DONE]\n\n"
+	assert.Equal(t, "Hello", AssembleStream(openaiStream))
+
+	claudeStream := "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"}}\n\n"
+	assert.Equal(t, "Hi", AssembleStream(claudeStream))
+
+	geminiStream := "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Bonjour\"}]}}]}\n\n"
+	assert.Equal(t, "Bonjour", AssembleStream(geminiStream))
+
+	responsesStream := "event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"Hola\"}\n\n"
+	assert.Equal(t, "Hola", AssembleStream(responsesStream))
+}
@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// Writer copies the response sent to the client, it stops copying at AuditMaxSize
+type Writer struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	truncated bool
+}
+
+// StartCapture puts a Writer in front of the writer of the context
+func StartCapture(c *gin.Context) *Writer {
+	writer := &Writer{ResponseWriter: c.Writer}
+	c.Writer = writer
+	return writer
+}
+
+// Stop restores the original writer of the context
+func (w *Writer) Stop(c *gin.Context) {
+	c.Writer = w.ResponseWriter
+}
+
+func (w *Writer) capture(data []byte) {
+	if w.truncated {
+		return
+	}
+	if remain := config.AuditMaxSize*1024 - w.body.Len(); len(data) > remain {
+		w.body.Write(data[:remain])
+		w.truncated = true
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *Writer) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Response returns the captured response, a stream of server sent events is reassembled into its text
+func (w *Writer) Response() (response string, truncated bool) {
+	// the cut may split a character
+	body := strings.ToValidUTF8(w.body.String(), "")
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		return AssembleStream(body), w.truncated
+	}
+	return body, w.truncated
+}
+
+// Truncate cuts s to AuditMaxSize
+func Truncate(s string) (string, bool) {
+	if len(s) > config.AuditMaxSize*1024 {
+		return strings.ToValidUTF8(s[:config.AuditMaxSize*1024], ""), true
+	}
+	return s, false
+}
+
+// AssembleStream concatenates the text deltas of the openai, claude, gemini and responses streams
+func AssembleStream(body string) string {
+	var text strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" || data == "[DONE]" {
+			continue
+		}
+		var event streamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			continue
+		}
+		event.writeText(&text)
+	}
+	return text.String()
+}
+
+type streamEvent struct {
+	Type    string `json:"type"`
+	Choices []struct {
+		Text  string `json:"text"`
+		Delta struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Delta      json.RawMessage `json:"delta"`
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"content"`
+	} `json:"candidates"`
+}
+
+func (event *streamEvent) writeText(text *strings.Builder) {
+	for _, choice := range event.Choices {
+		text.WriteString(choice.Delta.ReasoningContent)
+		text.WriteString(choice.Delta.Content)
+		text.WriteString(choice.Text)
+	}
+	for _, candidate := range event.Candidates {
+		for _, part := range candidate.Content.Parts {
+			text.WriteString(part.Text)
+		}
+	}
+	switch event.Type {
+	case "content_block_delta":
+		// claude
+		var delta struct {
+			Text        string `json:"text"`
+			Thinking    string `json:"thinking"`
+			PartialJson string `json:"partial_json"`
+		}
+		if json.Unmarshal(event.Delta, &delta) == nil {
+			text.WriteString(delta.Thinking + delta.Text + delta.PartialJson)
+		}
+	case "response.output_text.delta", "response.reasoning_summary_text.delta", "response.function_call_arguments.delta":
+		// responses
+		var delta string
+		if json.Unmarshal(event.Delta, &delta) == nil {
+			text.WriteString(delta)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Rule replaces the matches of Pattern in the captured requests and responses
+type Rule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// RedactionRules 是审计记录的脱敏规则，保存在 AuditRedactionRules 选项中
+var RedactionRules = []Rule{
+	{Pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, Replacement: "[EMAIL]"},
+	{Pattern: `\bsk-[A-Za-z0-9_-]{16,}`, Replacement: "[API_KEY]"},
+	{Pattern: `(?i)\bbearer\s+[A-Za-z0-9._~+/-]+=*`, Replacement: "Bearer [TOKEN]"},
+	{Pattern: `\b\d{17}[\dXx]\b`, Replacement: "[ID_NUMBER]"},
+	{Pattern: `\b(?:\d[ -]?){12,15}\d\b`, Replacement: "[CARD_NUMBER]"},
+	{Pattern: `\b1[3-9]\d{9}\b`, Replacement: "[PHONE]"},
+}
+
+var (
+	compiledRules []*regexp.Regexp
+	rulesLock     sync.RWMutex
+)
+
+func init() {
+	compiled, err := compileRules(RedactionRules)
+	if err != nil {
+		panic(err)
+	}
+	compiledRules = compiled
+}
+
+func compileRules(rules []Rule) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", rule.Pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func RedactionRules2JSONString() string {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	jsonBytes, err := json.Marshal(RedactionRules)
+	if err != nil {
+		logger.SysError("error marshalling redaction rules: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// ParseRedactionRules parses and checks the rules saved in the AuditRedactionRules option
+func ParseRedactionRules(jsonStr string) ([]Rule, error) {
+	var rules []Rule
+	if err := json.Unmarshal([]byte(jsonStr), &rules); err != nil {
+		return nil, err
+	}
+	if _, err := compileRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// UpdateRedactionRulesByJSONString replaces the rules, nothing changes if a pattern is invalid
+func UpdateRedactionRulesByJSONString(jsonStr string) error {
+	rules, err := ParseRedactionRules(jsonStr)
+	if err != nil {
+		return err
+	}
+	compiled, _ := compileRules(rules)
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	RedactionRules = rules
+	compiledRules = compiled
+	return nil
+}
+
+// Redact applies the redaction rules in order
+func Redact(s string) string {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	for i, re := range compiledRules {
+		s = re.ReplaceAllString(s, RedactionRules[i].Replacement)
+	}
+	return s
+}
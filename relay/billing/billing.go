@@ -10,7 +10,10 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/common/telemetry"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
@@ -19,11 +22,20 @@ import (
 	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
-func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int64, tokenId int) {
+func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int64, meta *meta.Meta) {
+	if meta.ReservationId != "" {
+		if err := model.ReleaseQuotaReservation(meta.ReservationId); err != nil {
+			logger.Error(ctx, "error release quota reservation: "+err.Error())
+		}
+		if err := model.CacheUpdateUserQuota(ctx, meta.UserId); err != nil {
+			logger.Error(ctx, "error update user quota cache: "+err.Error())
+		}
+		return
+	}
 	if preConsumedQuota != 0 {
 		go func(ctx context.Context) {
 			// return pre-consumed quota
-			err := model.PostConsumeTokenQuota(tokenId, -preConsumedQuota)
+			err := model.PostConsumeTokenQuota(meta.TokenId, -preConsumedQuota)
 			if err != nil {
 				logger.Error(ctx, "error return pre-consumed quota: "+err.Error())
 			}
@@ -31,6 +43,19 @@ func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int64, tokenId
 	}
 }
 
+// settleQuota charges the quota of a request, the reservation made by PreConsumeQuotaAmount is settled exactly once.
+// counted reports that the sweeper has already added the reservation to the used quota of the user
+func settleQuota(meta *meta.Meta, quota int64, preConsumedQuota int64) (counted bool, err error) {
+	if meta.ReservationId != "" {
+		err = model.SettleQuotaReservation(meta.ReservationId, quota)
+		if errors.Is(err, model.ErrQuotaReservationExpired) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, model.PostConsumeTokenQuota(meta.TokenId, quota-preConsumedQuota)
+}
+
 func GetPromptTokens(textRequest *relaymodel.GeneralOpenAIRequest, relayMode int) int {
 	switch relayMode {
 	case relaymode.ChatCompletions:
@@ -43,6 +68,14 @@ func GetPromptTokens(textRequest *relaymodel.GeneralOpenAIRequest, relayMode int
 	return 0
 }
 
+// discountRatio applies the discount of offline requests such as batches
+func discountRatio(meta *meta.Meta, ratio float64) float64 {
+	if meta.DiscountRatio > 0 {
+		return ratio * meta.DiscountRatio
+	}
+	return ratio
+}
+
 func getPreConsumedQuota(textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64) int64 {
 	preConsumedTokens := config.PreConsumedQuota + int64(promptTokens)
 	if textRequest.MaxTokens != 0 {
@@ -51,31 +84,81 @@ func getPreConsumedQuota(textRequest *relaymodel.GeneralOpenAIRequest, promptTok
 	return int64(float64(preConsumedTokens) * ratio)
 }
 
+// getModelPrice returns the pricing rule of the mapped model, or of the model requested by the user
+func getModelPrice(meta *meta.Meta) *billingratio.Price {
+	if price := billingratio.GetModelPrice(meta.ActualModelName, meta.ChannelType); price != nil {
+		return price
+	}
+	return billingratio.GetModelPrice(meta.OriginModelName, meta.ChannelType)
+}
+
 func PreConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64, meta *meta.Meta) (int64, *relaymodel.ErrorWithStatusCode) {
-	preConsumedQuota := getPreConsumedQuota(textRequest, promptTokens, ratio)
+	price := getModelPrice(meta)
+	if price.HasTiers() {
+		// the tier depends on the prompt, which some channels do not count
+		if promptTokens == 0 {
+			promptTokens = GetPromptTokens(textRequest, meta.Mode)
+		}
+		if tier := price.GetTier(promptTokens); tier != nil {
+			ratio = tier.ModelRatio * billingratio.GetGroupRatio(meta.Group)
+		}
+	}
+	preConsumedQuota := getPreConsumedQuota(textRequest, promptTokens, discountRatio(meta, ratio))
+	preConsumedQuota += int64(price.GetFixedPrice(0, 0) * discountRatio(meta, billingratio.GetGroupRatio(meta.Group)))
+	return PreConsumeQuotaAmount(ctx, preConsumedQuota, meta)
+}
 
-	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
+// PreConsumeQuotaAmount reserves a known amount of quota in the reservation ledger, the reservation is kept in meta
+// and must be settled by PostConsumeQuota or released by ReturnPreConsumedQuota
+func PreConsumeQuotaAmount(ctx context.Context, preConsumedQuota int64, meta *meta.Meta) (int64, *relaymodel.ErrorWithStatusCode) {
+	userQuota, err := model.GetPayerQuota(ctx, meta.UserId, meta.OrgId)
 	if err != nil {
 		return preConsumedQuota, openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
 	if userQuota-preConsumedQuota < 0 {
+		if meta.OrgId != 0 {
+			return preConsumedQuota, openai.ErrorWrapper(model.ErrInsufficientOrgQuota, "insufficient_organization_quota", http.StatusForbidden)
+		}
 		return preConsumedQuota, openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
-	err = model.CacheDecreaseUserQuota(meta.UserId, preConsumedQuota)
-	if err != nil {
-		return preConsumedQuota, openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
-	}
-	if userQuota > 100*preConsumedQuota {
-		// in this case, we do not pre-consume quota
-		// because the user has enough quota
-		preConsumedQuota = 0
-		// logger.Info(ctx, fmt.Sprintf("user %d has enough quota %d, trusted and no need to pre-consume", meta.UserId, userQuota))
-	}
-	if preConsumedQuota > 0 {
-		err := model.PreConsumeTokenQuota(meta.TokenId, preConsumedQuota)
-		if err != nil {
-			return preConsumedQuota, openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
+	reservationId := random.GetUUID()
+	if requestId, ok := ctx.Value(helper.RequestIdKey).(string); ok && requestId != "" {
+		// a request is reserved once per retry
+		reservationId = requestId + "-" + random.GetRandomString(8)
+	}
+	err = model.ReserveQuota(reservationId, meta.TokenId, meta.UserId, preConsumedQuota)
+	switch {
+	case errors.Is(err, model.ErrTooManyConcurrentRequests):
+		return preConsumedQuota, &relaymodel.ErrorWithStatusCode{
+			Error: relaymodel.Error{
+				Message: err.Error(),
+				Type:    "guoguo_api_error",
+				Code:    ErrorCodeConcurrencyLimitExceeded,
+			},
+			StatusCode: http.StatusTooManyRequests,
 		}
+	case errors.Is(err, model.ErrInsufficientUserQuota):
+		return preConsumedQuota, openai.ErrorWrapper(err, "insufficient_user_quota", http.StatusForbidden)
+	case errors.Is(err, model.ErrBudgetExceeded):
+		return preConsumedQuota, &relaymodel.ErrorWithStatusCode{
+			Error: relaymodel.Error{
+				Message: err.Error(),
+				Type:    "guoguo_api_error",
+				Code:    ErrorCodeBudgetExceeded,
+			},
+			StatusCode: http.StatusTooManyRequests,
+		}
+	case errors.Is(err, model.ErrInsufficientOrgQuota):
+		return preConsumedQuota, openai.ErrorWrapper(err, "insufficient_organization_quota", http.StatusForbidden)
+	case err != nil:
+		return preConsumedQuota, openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
+	}
+	meta.ReservationId = reservationId
+	if meta.OrgId != 0 {
+		return preConsumedQuota, nil
+	}
+	if err := model.CacheDecreaseUserQuota(meta.UserId, preConsumedQuota); err != nil {
+		logger.Error(ctx, "error decrease user quota cache: "+err.Error())
 	}
 	return preConsumedQuota, nil
 }
@@ -86,15 +169,32 @@ func PostConsumeQuota(ctx *gin.Context, usage *relaymodel.Usage, meta *meta.Meta
 		return
 	}
 	useTimeSeconds := time.Now().Unix() - meta.StartTime.Unix()
+	promptTokens := usage.PromptTokens
+	if promptTokens == 0 && usage.InputTokens > 0 {
+		promptTokens = usage.InputTokens
+	}
+	var priceLog string
+	price := getModelPrice(meta)
+	tier := price.GetTier(promptTokens)
+	if tier != nil {
+		modelRatio = tier.ModelRatio
+		ratio = modelRatio * groupRatio
+		priceLog = fmt.Sprintf("，阶梯计费 >%d tokens", tier.PromptTokens)
+	}
+	fixedRatio := discountRatio(meta, groupRatio)
+	ratio = discountRatio(meta, ratio)
+	if meta.CacheHit {
+		ratio *= config.CacheRatio
+		fixedRatio *= config.CacheRatio
+	}
 	var quota int64
 	modelName := meta.OriginModelName
 	if meta.UseThinking {
 		modelName = modelName + "-thinking"
 	}
 	completionRatio := billingratio.GetCompletionRatio(modelName, meta.ChannelType)
-	promptTokens := usage.PromptTokens
-	if promptTokens == 0 && usage.InputTokens > 0 {
-		promptTokens = usage.InputTokens
+	if tier != nil && tier.CompletionRatio > 0 {
+		completionRatio = tier.CompletionRatio
 	}
 	completionTokens := usage.CompletionTokens
 	if completionTokens == 0 && usage.OutputTokens > 0 {
@@ -104,7 +204,12 @@ func PostConsumeQuota(ctx *gin.Context, usage *relaymodel.Usage, meta *meta.Meta
 		//计费需要加上思考token
 		completionTokens += usage.ThoughtsTokens
 	}
-	quota = int64(math.Ceil((float64(promptTokens) + float64(completionTokens)*completionRatio) * ratio))
+	tokensCost, tokensLog := getTokensCost(usage, modelName, meta.ChannelType, promptTokens, completionTokens, completionRatio)
+	fixedPrice := price.GetFixedPrice(usage.ImageCount, usage.VideoTokens)
+	if fixedPrice > 0 {
+		priceLog += fmt.Sprintf("，固定费用 %.2f", fixedPrice)
+	}
+	quota = int64(math.Ceil(tokensCost*ratio + fixedPrice*fixedRatio))
 	if ratio != 0 && quota <= 0 {
 		quota = 1
 	}
@@ -114,8 +219,7 @@ func PostConsumeQuota(ctx *gin.Context, usage *relaymodel.Usage, meta *meta.Meta
 		// we cannot just return, because we may have to return the pre-consumed quota
 		quota = 0
 	}
-	quotaDelta := quota - preConsumedQuota
-	err := model.PostConsumeTokenQuota(meta.TokenId, quotaDelta)
+	counted, err := settleQuota(meta, quota, preConsumedQuota)
 	if err != nil {
 		logger.Error(ctx, "error consuming token remain quota: "+err.Error())
 	}
@@ -123,35 +227,46 @@ func PostConsumeQuota(ctx *gin.Context, usage *relaymodel.Usage, meta *meta.Meta
 	if err != nil {
 		logger.Error(ctx, "error update user quota cache: "+err.Error())
 	}
-	var extraLog string
+	extraLog := priceLog + tokensLog
 	if systemPromptReset {
-		extraLog = " （注意系统提示词已被重置）"
+		extraLog += " （注意系统提示词已被重置）"
+	}
+	if meta.DiscountRatio > 0 {
+		extraLog = fmt.Sprintf("，折扣倍率 %.2f", meta.DiscountRatio) + extraLog
+	}
+	if meta.CacheHit {
+		extraLog = fmt.Sprintf("，缓存命中倍率 %.2f", config.CacheRatio) + extraLog
 	}
 	logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f，补全倍率 %.2f%s", modelRatio, groupRatio, completionRatio, extraLog)
 	model.RecordConsumeLog(ctx, meta.IsStream, meta.FirstResponseTime, int(useTimeSeconds), meta.UserId, meta.ChannelId, promptTokens, completionTokens, meta.OriginModelName, meta.TokenName, quota, logContent, meta.TokenId)
-	model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
+	if !counted {
+		model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
+	}
 	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+	model.UpdateChannelKeyUsedQuota(meta.ChannelKeyId, quota)
+	telemetry.RecordConsumption(meta.OriginModelName, meta.ChannelId, meta.Group, promptTokens, completionTokens, quota)
 }
 
-func PostAudioConsumeQuota(ctx *gin.Context, meta *meta.Meta, tokenId int, quotaDelta int64, totalQuota int64, userId int, channelId int, modelRatio float64, groupRatio float64, modelName string, tokenName string) {
-	// quotaDelta is remaining quota to be consumed
+// PostConsumeQuotaAmount settles the reservation of a request whose quota is computed by its helper, such as images, videos and audio,
+// and records the consumption
+func PostConsumeQuotaAmount(ctx *gin.Context, meta *meta.Meta, quota int64, preConsumedQuota int64, promptTokens int, completionTokens int, modelName string, logContent string) {
 	useTimeSeconds := time.Now().Unix() - meta.StartTime.Unix()
-	err := model.PostConsumeTokenQuota(tokenId, quotaDelta)
+	counted, err := settleQuota(meta, quota, preConsumedQuota)
 	if err != nil {
-		logger.SysError("error consuming token remain quota: " + err.Error())
+		logger.Error(ctx, "error consuming token remain quota: "+err.Error())
 	}
-	err = model.CacheUpdateUserQuota(ctx, userId)
+	err = model.CacheUpdateUserQuota(ctx, meta.UserId)
 	if err != nil {
-		logger.SysError("error update user quota cache: " + err.Error())
+		logger.Error(ctx, "error update user quota cache: "+err.Error())
 	}
-	// totalQuota is total quota consumed
-	if totalQuota != 0 {
-		logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f", modelRatio, groupRatio)
-		model.RecordConsumeLog(ctx, meta.IsStream, meta.FirstResponseTime, int(useTimeSeconds), userId, channelId, int(totalQuota), 0, modelName, tokenName, totalQuota, logContent, tokenId)
-		model.UpdateUserUsedQuotaAndRequestCount(userId, totalQuota)
-		model.UpdateChannelUsedQuota(channelId, totalQuota)
+	if quota == 0 {
+		return
 	}
-	if totalQuota <= 0 {
-		logger.Error(ctx, fmt.Sprintf("totalQuota consumed is %d, something is wrong", totalQuota))
+	model.RecordConsumeLog(ctx, meta.IsStream, meta.FirstResponseTime, int(useTimeSeconds), meta.UserId, meta.ChannelId, promptTokens, completionTokens, modelName, meta.TokenName, quota, logContent, meta.TokenId)
+	if !counted {
+		model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
 	}
+	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+	model.UpdateChannelKeyUsedQuota(meta.ChannelKeyId, quota)
+	telemetry.RecordConsumption(modelName, meta.ChannelId, meta.Group, promptTokens, completionTokens, quota)
 }
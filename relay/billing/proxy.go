@@ -0,0 +1,53 @@
+package billing
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/telemetry"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// ProxyQuota computes the quota of a proxy request from the pricing rule of its channel, before the group ratio
+func ProxyQuota(rule *relaymodel.ProxyBilling, requestBytes int64, responseBytes int64, usage *relaymodel.Usage, modelRatio float64, completionRatio float64) float64 {
+	if rule == nil {
+		return 0
+	}
+	quota := rule.RequestPrice
+	switch rule.Mode {
+	case relaymodel.ProxyBillingPerByte:
+		quota += float64(requestBytes)*rule.RequestBytePrice + float64(responseBytes)*rule.ResponseBytePrice
+	case relaymodel.ProxyBillingUsage:
+		if usage != nil {
+			quota += (float64(usage.PromptTokens) + float64(usage.CompletionTokens)*completionRatio) * modelRatio
+		}
+	}
+	return quota
+}
+
+// PostConsumeProxyQuota settles the quota of a proxy request and records its consume log
+func PostConsumeProxyQuota(ctx *gin.Context, meta *meta.Meta, usage *relaymodel.Usage, modelName string, quota int64, preConsumedQuota int64, logContent string) {
+	useTimeSeconds := time.Now().Unix() - meta.StartTime.Unix()
+	counted, err := settleQuota(meta, quota, preConsumedQuota)
+	if err != nil {
+		logger.Error(ctx, "error consuming token remain quota: "+err.Error())
+	}
+	err = model.CacheUpdateUserQuota(ctx, meta.UserId)
+	if err != nil {
+		logger.Error(ctx, "error update user quota cache: "+err.Error())
+	}
+	var promptTokens, completionTokens int
+	if usage != nil {
+		promptTokens, completionTokens = usage.PromptTokens, usage.CompletionTokens
+	}
+	model.RecordConsumeLog(ctx, meta.IsStream, meta.FirstResponseTime, int(useTimeSeconds), meta.UserId, meta.ChannelId, promptTokens, completionTokens, modelName, meta.TokenName, quota, logContent, meta.TokenId)
+	if !counted {
+		model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
+	}
+	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+	model.UpdateChannelKeyUsedQuota(meta.ChannelKeyId, quota)
+	telemetry.RecordConsumption(modelName, meta.ChannelId, meta.Group, promptTokens, completionTokens, quota)
+}
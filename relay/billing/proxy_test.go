@@ -0,0 +1,21 @@
+package billing
+
+import (
+	"testing"
+
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyQuota(t *testing.T) {
+	assert.Equal(t, 0.0, ProxyQuota(nil, 100, 100, nil, 1, 1))
+
+	perRequest := &relaymodel.ProxyBilling{Mode: relaymodel.ProxyBillingPerRequest, RequestPrice: 500}
+	assert.Equal(t, 500.0, ProxyQuota(perRequest, 100, 100, nil, 1, 1))
+
+	perByte := &relaymodel.ProxyBilling{Mode: relaymodel.ProxyBillingPerByte, RequestBytePrice: 0.5, ResponseBytePrice: 0.25}
+	assert.Equal(t, 100.0, ProxyQuota(perByte, 100, 200, nil, 1, 1))
+
+	usage := &relaymodel.ProxyBilling{Mode: relaymodel.ProxyBillingUsage, RequestPrice: 10}
+	assert.Equal(t, 10+(100+50*2)*1.5, ProxyQuota(usage, 0, 0, &relaymodel.Usage{PromptTokens: 100, CompletionTokens: 50}, 1.5, 2))
+}
@@ -92,6 +92,7 @@ var ModelRatio = map[string]float64{
 	"claude-3-5-sonnet-20241022": 3.0 / 1000 * USD,
 	"claude-3-7-sonnet-20250219": 3.0 / 1000 * USD,
 	"claude-3-opus-20240229":     15.0 / 1000 * USD,
+	"claude-sonnet-4-20250514":   3.0 / 1000 * USD,
 	// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/hlrk4akp7
 	"ERNIE-4.0-8K":       0.120 * RMB,
 	"ERNIE-3.5-8K":       0.012 * RMB,
@@ -123,6 +124,7 @@ var ModelRatio = map[string]float64{
 	"gemini-2.0-flash-thinking-exp-01-21": 1,
 	"gemini-2.0-flash-lite-preview-02-05": 1,
 	"gemini-2.0-pro-exp-02-05":            1,
+	"gemini-2.5-pro":                      1.25 / 1000 * USD,
 	"aqa":                                 1,
 	// https://open.bigmodel.cn/pricing
 	"glm-4":         0.1 * RMB,
@@ -340,6 +342,7 @@ var ModelRatio = map[string]float64{
 }
 
 var CompletionRatio = map[string]float64{
+	"gemini-2.5-pro": 10.0 / 1.25,
 	// aws llama3
 	"llama3-8b-8192(33)":  0.0006 / 0.0003,
 	"llama3-70b-8192(33)": 0.0035 / 0.00265,
@@ -0,0 +1,116 @@
+package ratio
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// PriceTier replaces the model ratio and the completion ratio when the prompt is longer than PromptTokens
+type PriceTier struct {
+	PromptTokens    int     `json:"prompt_tokens"`
+	ModelRatio      float64 `json:"model_ratio"`
+	CompletionRatio float64 `json:"completion_ratio,omitempty"` // 0 keeps the completion ratio of the model
+}
+
+// Price is a pricing rule of a model, the fixed prices are in quota and multiplied with the group ratio
+type Price struct {
+	Tiers        []PriceTier `json:"tiers,omitempty"`
+	RequestPrice float64     `json:"request_price,omitempty"` // per request
+	ImagePrice   float64     `json:"image_price,omitempty"`   // per generated image
+	SecondPrice  float64     `json:"second_price,omitempty"`  // per second of generated video
+}
+
+// ModelPrice 模型的计费规则，key 和 ModelRatio 一样可以是 model 或 model(channelType)
+var ModelPrice = map[string]*Price{
+	// https://ai.google.dev/gemini-api/docs/pricing
+	"gemini-2.5-pro": {
+		Tiers: []PriceTier{{PromptTokens: 200000, ModelRatio: 2.5 / 1000 * USD, CompletionRatio: 15.0 / 2.5}},
+	},
+	"gemini-2.5-pro-preview-06-05": {
+		Tiers: []PriceTier{{PromptTokens: 200000, ModelRatio: 2.5 / 1000 * USD, CompletionRatio: 15.0 / 2.5}},
+	},
+	"gemini-1.5-pro": {
+		Tiers: []PriceTier{{PromptTokens: 128000, ModelRatio: 2.5 / 1000 * USD, CompletionRatio: 10.0 / 2.5}},
+	},
+	// https://docs.anthropic.com/en/docs/build-with-claude/context-windows#1m-token-context-window
+	"claude-sonnet-4-20250514": {
+		Tiers: []PriceTier{{PromptTokens: 200000, ModelRatio: 6.0 / 1000 * USD, CompletionRatio: 22.5 / 6.0}},
+	},
+}
+
+func ModelPrice2JSONString() string {
+	jsonBytes, err := json.Marshal(ModelPrice)
+	if err != nil {
+		logger.SysError("error marshalling model price: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// ParseModelPrice parses and checks the rules saved in the ModelPrice option
+func ParseModelPrice(jsonStr string) (map[string]*Price, error) {
+	prices := make(map[string]*Price)
+	if err := json.Unmarshal([]byte(jsonStr), &prices); err != nil {
+		return nil, err
+	}
+	for name, price := range prices {
+		if price == nil {
+			return nil, fmt.Errorf("%s: empty price", name)
+		}
+		for _, tier := range price.Tiers {
+			if tier.PromptTokens < 0 || tier.ModelRatio < 0 || tier.CompletionRatio < 0 {
+				return nil, fmt.Errorf("%s: negative tier", name)
+			}
+		}
+		sort.Slice(price.Tiers, func(i, j int) bool {
+			return price.Tiers[i].PromptTokens < price.Tiers[j].PromptTokens
+		})
+	}
+	return prices, nil
+}
+
+func UpdateModelPriceByJSONString(jsonStr string) error {
+	prices, err := ParseModelPrice(jsonStr)
+	if err != nil {
+		return err
+	}
+	ModelPrice = prices
+	return nil
+}
+
+// GetModelPrice returns the pricing rule of the model, or nil
+func GetModelPrice(name string, channelType int) *Price {
+	if price, ok := ModelPrice[fmt.Sprintf("%s(%d)", name, channelType)]; ok {
+		return price
+	}
+	return ModelPrice[name]
+}
+
+// GetTier returns the highest tier below the prompt tokens, nil means the model ratio applies
+func (price *Price) GetTier(promptTokens int) *PriceTier {
+	if price == nil {
+		return nil
+	}
+	var tier *PriceTier
+	for i := range price.Tiers {
+		if promptTokens > price.Tiers[i].PromptTokens {
+			tier = &price.Tiers[i]
+		}
+	}
+	return tier
+}
+
+// HasTiers reports whether the price depends on the prompt tokens
+func (price *Price) HasTiers() bool {
+	return price != nil && len(price.Tiers) > 0
+}
+
+// GetFixedPrice returns the fixed part of the price of a request
+func (price *Price) GetFixedPrice(images int, seconds int) float64 {
+	if price == nil {
+		return 0
+	}
+	return price.RequestPrice + price.ImagePrice*float64(images) + price.SecondPrice*float64(seconds)
+}
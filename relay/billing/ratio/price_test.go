@@ -0,0 +1,24 @@
+package ratio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceTier(t *testing.T) {
+	prices, err := ParseModelPrice(`{"m":{"tiers":[{"prompt_tokens":200000,"model_ratio":2},{"prompt_tokens":100000,"model_ratio":1.5}],"request_price":10,"image_price":100}}`)
+	assert.Nil(t, err)
+	price := prices["m"]
+	assert.Nil(t, price.GetTier(100000))
+	assert.Equal(t, 1.5, price.GetTier(100001).ModelRatio)
+	assert.Equal(t, 2.0, price.GetTier(300000).ModelRatio)
+	assert.Equal(t, 210.0, price.GetFixedPrice(2, 0))
+
+	var none *Price
+	assert.Nil(t, none.GetTier(300000))
+	assert.Equal(t, 0.0, none.GetFixedPrice(1, 1))
+
+	_, err = ParseModelPrice(`{"m":{"tiers":[{"prompt_tokens":-1,"model_ratio":2}]}}`)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,158 @@
+package ratio
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// the token detail ratios price parts of the prompt and completion tokens,
+// like the completion ratio they are multiplied with the model ratio
+
+// CachedInputRatio prices the prompt tokens read from the prompt cache
+var CachedInputRatio = map[string]float64{
+	// https://openai.com/api/pricing/
+	"gpt-4.1":      0.25,
+	"gpt-4.1-mini": 0.25,
+	"gpt-4.1-nano": 0.25,
+	"gpt-4o":       0.5,
+	"gpt-4o-mini":  0.5,
+	"o1":           0.5,
+	"o3-mini":      0.5,
+	// https://ai.google.dev/pricing
+	"gemini-2.0-flash": 0.25,
+	"gemini-2.5-flash": 0.25,
+	"gemini-2.5-pro":   0.25,
+	// https://api-docs.deepseek.com/quick_start/pricing
+	"deepseek-chat":     0.25,
+	"deepseek-reasoner": 0.25,
+}
+
+// CacheCreationRatio prices the prompt tokens written into the claude prompt cache
+var CacheCreationRatio = map[string]float64{}
+
+// AudioInputRatio prices the audio prompt tokens
+var AudioInputRatio = map[string]float64{
+	"gpt-4o-audio-preview":         16,
+	"gpt-4o-mini-audio-preview":    66.67,
+	"gpt-4o-realtime-preview":      8,
+	"gpt-4o-mini-realtime-preview": 16.67,
+	"gemini-2.0-flash":             7,
+	"gemini-2.5-flash":             3.33,
+}
+
+// AudioOutputRatio prices the audio completion tokens
+var AudioOutputRatio = map[string]float64{
+	"gpt-4o-audio-preview":         32,
+	"gpt-4o-mini-audio-preview":    133.33,
+	"gpt-4o-realtime-preview":      16,
+	"gpt-4o-mini-realtime-preview": 33.33,
+}
+
+// ImageInputRatio prices the image prompt tokens
+var ImageInputRatio = map[string]float64{
+	"gpt-image-1": 2,
+}
+
+// ImageOutputRatio prices the image completion tokens
+var ImageOutputRatio = map[string]float64{
+	"gpt-image-1": 8,
+}
+
+// TokenRatioOptions maps the options saving the token detail ratios to their tables
+var TokenRatioOptions = map[string]*map[string]float64{
+	"CachedInputRatio":   &CachedInputRatio,
+	"CacheCreationRatio": &CacheCreationRatio,
+	"AudioInputRatio":    &AudioInputRatio,
+	"AudioOutputRatio":   &AudioOutputRatio,
+	"ImageInputRatio":    &ImageInputRatio,
+	"ImageOutputRatio":   &ImageOutputRatio,
+}
+
+func TokenRatio2JSONString(option string) string {
+	jsonBytes, err := json.Marshal(*TokenRatioOptions[option])
+	if err != nil {
+		logger.SysError("error marshalling " + option + ": " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateTokenRatioByJSONString(option string, jsonStr string) error {
+	ratios := make(map[string]float64)
+	if err := json.Unmarshal([]byte(jsonStr), &ratios); err != nil {
+		return err
+	}
+	*TokenRatioOptions[option] = ratios
+	return nil
+}
+
+// lookupTokenRatio finds the ratio of the model on the channel type, then of the model
+func lookupTokenRatio(ratios map[string]float64, name string, channelType int) (float64, bool) {
+	if ratio, ok := ratios[fmt.Sprintf("%s(%d)", name, channelType)]; ok {
+		return ratio, true
+	}
+	ratio, ok := ratios[name]
+	return ratio, ok
+}
+
+func GetCachedInputRatio(name string, channelType int) float64 {
+	if ratio, ok := lookupTokenRatio(CachedInputRatio, name, channelType); ok {
+		return ratio
+	}
+	switch {
+	case strings.HasPrefix(name, "gpt-4.1"):
+		return 0.25
+	case strings.HasPrefix(name, "gpt-4o"), strings.HasPrefix(name, "o1"), strings.HasPrefix(name, "o3"), strings.HasPrefix(name, "o4"):
+		return 0.5
+	case strings.HasPrefix(name, "claude-"):
+		return 0.1
+	case strings.HasPrefix(name, "gemini-"):
+		return 0.25
+	case strings.HasPrefix(name, "deepseek-"):
+		return 0.25
+	}
+	return 1
+}
+
+func GetCacheCreationRatio(name string, channelType int) float64 {
+	if ratio, ok := lookupTokenRatio(CacheCreationRatio, name, channelType); ok {
+		return ratio
+	}
+	if strings.HasPrefix(name, "claude-") {
+		// 5 minutes cache writes
+		return 1.25
+	}
+	return 1
+}
+
+func GetAudioInputRatio(name string, channelType int) float64 {
+	if ratio, ok := lookupTokenRatio(AudioInputRatio, name, channelType); ok {
+		return ratio
+	}
+	return 1
+}
+
+// GetAudioOutputRatio falls back to the completion ratio, so audio is priced like text when the model is unknown
+func GetAudioOutputRatio(name string, channelType int) float64 {
+	if ratio, ok := lookupTokenRatio(AudioOutputRatio, name, channelType); ok {
+		return ratio
+	}
+	return GetCompletionRatio(name, channelType)
+}
+
+func GetImageInputRatio(name string, channelType int) float64 {
+	if ratio, ok := lookupTokenRatio(ImageInputRatio, name, channelType); ok {
+		return ratio
+	}
+	return 1
+}
+
+// GetImageOutputRatio falls back to the completion ratio, so images are priced like text when the model is unknown
+func GetImageOutputRatio(name string, channelType int) float64 {
+	if ratio, ok := lookupTokenRatio(ImageOutputRatio, name, channelType); ok {
+		return ratio
+	}
+	return GetCompletionRatio(name, channelType)
+}
@@ -0,0 +1,40 @@
+package billing
+
+import (
+	"fmt"
+	"strings"
+
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// getTokensCost prices the prompt and completion tokens, the token details are parts of them billed at their own ratios.
+// The cost is still to be multiplied with the model and group ratios, the breakdown is written into the consume log.
+func getTokensCost(usage *relaymodel.Usage, modelName string, channelType int, promptTokens int, completionTokens int, completionRatio float64) (float64, string) {
+	cost := float64(promptTokens) + float64(completionTokens)*completionRatio
+	var breakdown []string
+	addDetail := func(name string, tokens int, baseRatio float64, ratio float64) {
+		if tokens <= 0 {
+			return
+		}
+		cost += float64(tokens) * (ratio - baseRatio)
+		breakdown = append(breakdown, fmt.Sprintf("%s %d tokens 倍率 %.2f", name, tokens, ratio))
+	}
+	if details := usage.GetPromptTokensDetails(); details != nil {
+		addDetail("缓存读取", details.CachedTokens, 1, billingratio.GetCachedInputRatio(modelName, channelType))
+		addDetail("缓存写入", details.CacheCreationTokens, 1, billingratio.GetCacheCreationRatio(modelName, channelType))
+		addDetail("音频输入", details.AudioTokens, 1, billingratio.GetAudioInputRatio(modelName, channelType))
+		addDetail("图片输入", details.ImageTokens, 1, billingratio.GetImageInputRatio(modelName, channelType))
+	}
+	if details := usage.GetCompletionTokensDetails(); details != nil {
+		addDetail("音频输出", details.AudioTokens, completionRatio, billingratio.GetAudioOutputRatio(modelName, channelType))
+		addDetail("图片输出", details.ImageTokens, completionRatio, billingratio.GetImageOutputRatio(modelName, channelType))
+	}
+	if cost < 0 {
+		cost = 0
+	}
+	if len(breakdown) == 0 {
+		return cost, ""
+	}
+	return cost, "，" + strings.Join(breakdown, "，")
+}
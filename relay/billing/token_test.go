@@ -0,0 +1,26 @@
+package billing
+
+import (
+	"testing"
+
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTokensCost(t *testing.T) {
+	usage := &relaymodel.Usage{PromptTokens: 1000, CompletionTokens: 100}
+	cost, log := getTokensCost(usage, "claude-3-5-sonnet-20241022", 0, 1000, 100, 5)
+	assert.Equal(t, 1500.0, cost)
+	assert.Equal(t, "", log)
+
+	// 600 tokens read from the cache at 0.1 and 200 written at 1.25
+	usage.PromptTokensDetails = &relaymodel.PromptTokensDetails{CachedTokens: 600, CacheCreationTokens: 200}
+	cost, log = getTokensCost(usage, "claude-3-5-sonnet-20241022", 0, 1000, 100, 5)
+	assert.InDelta(t, 200+600*0.1+200*1.25+500, cost, 1e-9)
+	assert.Contains(t, log, "缓存读取 600 tokens")
+
+	// audio output of an unknown model is priced like text
+	usage = &relaymodel.Usage{PromptTokens: 10, CompletionTokens: 100, CompletionTokensDetails: &relaymodel.CompletionTokensDetails{AudioTokens: 80}}
+	cost, _ = getTokensCost(usage, "unknown-model", 0, 10, 100, 1)
+	assert.InDelta(t, 110.0, cost, 1e-9)
+}
@@ -0,0 +1,116 @@
+package billing
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+const tpmWindowSeconds = 60
+
+// ErrorCodeTPMLimitExceeded marks errors caused by the token itself, the relay should neither retry nor blame the channel
+const ErrorCodeTPMLimitExceeded = "tpm_limit_exceeded"
+
+// ErrorCodeConcurrencyLimitExceeded is returned when the token already has max_concurrency requests in flight
+const ErrorCodeConcurrencyLimitExceeded = "concurrency_limit_exceeded"
+
+// ErrorCodeBudgetExceeded is returned when the request does not fit in the budget of the period of the token or its user
+const ErrorCodeBudgetExceeded = "budget_exceeded"
+
+// IsTokenLimitError reports whether the error is caused by a limit of the token rather than by the channel
+func IsTokenLimitError(err *relaymodel.ErrorWithStatusCode) bool {
+	return err != nil && (err.Code == ErrorCodeTPMLimitExceeded || err.Code == ErrorCodeConcurrencyLimitExceeded || err.Code == ErrorCodeBudgetExceeded)
+}
+
+// IsTokenLimitTransient reports whether the token limit clears by itself within its window, so the request can be sent again later,
+// an exceeded budget lasts until the end of its period
+func IsTokenLimitTransient(err *relaymodel.ErrorWithStatusCode) bool {
+	return IsTokenLimitError(err) && err.StatusCode == http.StatusTooManyRequests && err.Code != ErrorCodeBudgetExceeded
+}
+
+func tpmKey(meta *meta.Meta) string {
+	return fmt.Sprintf("TPM_%d", meta.TokenId)
+}
+
+// GetEstimatedTokens returns the tokens reserved in the TPM window before the request is relayed
+func GetEstimatedTokens(textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int) int64 {
+	estimated := int64(promptTokens)
+	if textRequest.MaxCompletionTokens != nil && *textRequest.MaxCompletionTokens > 0 {
+		estimated += int64(*textRequest.MaxCompletionTokens)
+	} else if textRequest.MaxTokens > 0 {
+		estimated += int64(textRequest.MaxTokens)
+	}
+	return estimated
+}
+
+// PreConsumeTPM reserves the estimated tokens in the tokens per minute window of the token.
+// Only the text endpoints count tokens, images, audio, videos and proxied requests are billed otherwise and are not limited by TPM
+func PreConsumeTPM(c *gin.Context, meta *meta.Meta, estimatedTokens int64) *relaymodel.ErrorWithStatusCode {
+	if meta.TpmLimit <= 0 {
+		return nil
+	}
+	limit := int64(meta.TpmLimit)
+	c.Writer.Header().Set("X-Ratelimit-Limit-Tokens", strconv.FormatInt(limit, 10))
+	if estimatedTokens > limit {
+		// the request would never fit in the window, waiting doesn't help
+		return &relaymodel.ErrorWithStatusCode{
+			Error: relaymodel.Error{
+				Message: helper.GetCustomReturnError(c, fmt.Sprintf("Request too large for %s on tokens per min (TPM): Limit %d, Requested %d. The input or output tokens must be reduced in order to run successfully.", meta.OriginModelName, limit, estimatedTokens)).Error(),
+				Type:    "guoguo_api_error",
+				Code:    ErrorCodeTPMLimitExceeded,
+			},
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	used, start, ok := common.WindowReserve(tpmKey(meta), estimatedTokens, limit, tpmWindowSeconds)
+	c.Writer.Header().Set("X-Ratelimit-Remaining-Tokens", strconv.FormatInt(max(limit-used, 0), 10))
+	c.Writer.Header().Set("X-Ratelimit-Reset-Tokens", fmt.Sprintf("%ds", start+tpmWindowSeconds-time.Now().Unix()))
+	if !ok {
+		return &relaymodel.ErrorWithStatusCode{
+			Error: relaymodel.Error{
+				Message: helper.GetCustomReturnError(c, fmt.Sprintf("Rate limit reached for %s on tokens per min (TPM): Limit %d, Used %d, Requested %d", meta.OriginModelName, limit, used, estimatedTokens)).Error(),
+				Type:    "guoguo_api_error",
+				Code:    ErrorCodeTPMLimitExceeded,
+			},
+			StatusCode: http.StatusTooManyRequests,
+		}
+	}
+	meta.TpmReserved = estimatedTokens
+	meta.TpmWindow = start
+	return nil
+}
+
+// ReturnPreConsumedTPM releases the reservation when the request failed
+func ReturnPreConsumedTPM(meta *meta.Meta) {
+	if meta.TpmReserved == 0 {
+		return
+	}
+	common.WindowAdjust(tpmKey(meta), meta.TpmWindow, -meta.TpmReserved)
+	meta.TpmReserved = 0
+}
+
+// PostConsumeTPM settles the reservation against the real usage and counts the usage in the window of the channel,
+// a cached response has no channel
+func PostConsumeTPM(meta *meta.Meta, usage *relaymodel.Usage) {
+	if usage == nil {
+		return
+	}
+	total := int64(usage.TotalTokens)
+	if total == 0 {
+		total = int64(usage.PromptTokens + usage.CompletionTokens + usage.ThoughtsTokens)
+	}
+	model.RecordChannelTokens(meta.ChannelId, meta.ChannelTpmLimit, total)
+	if meta.TpmLimit <= 0 {
+		return
+	}
+	common.WindowAdjust(tpmKey(meta), meta.TpmWindow, total-meta.TpmReserved)
+	meta.TpmReserved = 0
+}
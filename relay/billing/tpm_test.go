@@ -0,0 +1,35 @@
+package billing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreConsumeTPM(t *testing.T) {
+	common.RedisEnabled = false
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	relayMeta := &meta.Meta{TokenId: 1001, TpmLimit: 100, OriginModelName: "gpt-4o-mini"}
+
+	// a request larger than the limit never fits, the token is not throttled
+	bizErr := PreConsumeTPM(c, relayMeta, 101)
+	assert.NotNil(t, bizErr)
+	assert.Equal(t, http.StatusBadRequest, bizErr.StatusCode)
+	assert.True(t, IsTokenLimitError(bizErr))
+	assert.False(t, IsTokenLimitTransient(bizErr))
+
+	assert.Nil(t, PreConsumeTPM(c, relayMeta, 80))
+	bizErr = PreConsumeTPM(c, &meta.Meta{TokenId: 1001, TpmLimit: 100}, 30)
+	assert.NotNil(t, bizErr)
+	assert.Equal(t, http.StatusTooManyRequests, bizErr.StatusCode)
+	assert.True(t, IsTokenLimitTransient(bizErr))
+
+	// the reservation is released when the request fails
+	ReturnPreConsumedTPM(relayMeta)
+	assert.Nil(t, PreConsumeTPM(c, &meta.Meta{TokenId: 1001, TpmLimit: 100}, 30))
+}
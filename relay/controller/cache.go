@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// cachedResponse is a response saved in the response cache,
+// a stream keeps its server sent events so that it can be replayed chunk by chunk
+type cachedResponse struct {
+	ContentType string       `json:"content_type"`
+	Body        string       `json:"body,omitempty"`
+	Events      []string     `json:"events,omitempty"`
+	Usage       *model.Usage `json:"usage"`
+}
+
+// responseCacheTTL returns how many seconds the response of the request is cached, 0 means the request is not cached
+func responseCacheTTL(c *gin.Context, relayMode int) int {
+	if !config.ResponseCacheEnabled || c.GetBool(ctxkey.CacheDisabled) {
+		return 0
+	}
+	switch relayMode {
+	case relaymode.ChatCompletions, relaymode.Completions, relaymode.Embeddings:
+	default:
+		return 0
+	}
+	if ttl := c.GetInt(ctxkey.CacheTTL); ttl > 0 {
+		return ttl
+	}
+	if group, ok := dbmodel.GroupInfo[c.GetString(ctxkey.Group)]; ok && group.CacheTTL > 0 {
+		return group.CacheTTL
+	}
+	return config.ResponseCacheTTL
+}
+
+// hasCacheControl reports whether the Cache-Control header of the request contains the directive
+func hasCacheControl(c *gin.Context, directive string) bool {
+	for _, value := range strings.Split(c.Request.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(value), directive) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseCacheKey hashes the request as sent by the user, the same request of another user never shares an entry,
+// nor does the same request of another group or output filter rule set of the user
+func responseCacheKey(meta *meta.Meta, ruleSetName string, textRequest *model.GeneralOpenAIRequest) string {
+	request := *textRequest
+	// stream options only change how the response is sent
+	request.Stream = false
+	request.StreamOptions = nil
+	jsonData, _ := json.Marshal(request)
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%s:%s:%d:%t:%s", meta.UserId, meta.Group, ruleSetName, meta.Mode, meta.IsStream, jsonData)))
+	return hex.EncodeToString(hash[:])
+}
+
+func getCachedResponse(ctx context.Context, key string) *cachedResponse {
+	value, err := dbmodel.GetResponseCache(key)
+	if err != nil {
+		logger.Errorf(ctx, "failed to get response cache: %s", err.Error())
+		return nil
+	}
+	if value == "" {
+		return nil
+	}
+	cached := &cachedResponse{}
+	if err := json.Unmarshal([]byte(value), cached); err != nil || cached.Usage == nil {
+		return nil
+	}
+	return cached
+}
+
+func replayCachedResponse(c *gin.Context, cached *cachedResponse) {
+	if len(cached.Events) == 0 {
+		c.Data(http.StatusOK, cached.ContentType, []byte(cached.Body))
+		return
+	}
+	common.SetEventStreamHeaders(c)
+	c.Status(http.StatusOK)
+	for _, event := range cached.Events {
+		_, _ = c.Writer.WriteString(event + "\n\n")
+		c.Writer.Flush()
+	}
+}
+
+// responseCaptureWriter copies the response sent to the client, it gives up once the response exceeds ResponseCacheMaxSize
+type responseCaptureWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func startResponseCapture(c *gin.Context) *responseCaptureWriter {
+	writer := &responseCaptureWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	return writer
+}
+
+// stop restores the original writer of the context
+func (w *responseCaptureWriter) stop(c *gin.Context) {
+	c.Writer = w.ResponseWriter
+}
+
+func (w *responseCaptureWriter) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > config.ResponseCacheMaxSize*1024 {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *responseCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// splitEvents splits a server sent events stream into its events
+func splitEvents(body string) []string {
+	var events []string
+	for _, event := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n\n") {
+		if strings.TrimSpace(event) != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// newCachedResponse returns the captured response to cache, nil when it can't be cached
+func newCachedResponse(isStream bool, w *responseCaptureWriter, usage *model.Usage) *cachedResponse {
+	if w.overflow || w.Status() != http.StatusOK || usage == nil || w.body.Len() == 0 {
+		return nil
+	}
+	cached := &cachedResponse{
+		ContentType: w.Header().Get("Content-Type"),
+		Usage:       usage,
+	}
+	if isStream {
+		cached.Events = splitEvents(w.body.String())
+	} else {
+		cached.Body = w.body.String()
+	}
+	return cached
+}
+
+func saveCachedResponse(ctx context.Context, key string, ttl int, isStream bool, w *responseCaptureWriter, usage *model.Usage) {
+	cached := newCachedResponse(isStream, w, usage)
+	if cached == nil {
+		return
+	}
+	jsonData, err := json.Marshal(cached)
+	if err != nil {
+		logger.Errorf(ctx, "failed to marshal response cache: %s", err.Error())
+		return
+	}
+	go func() {
+		if err := dbmodel.SetResponseCache(key, string(jsonData), ttl); err != nil {
+			logger.Errorf(ctx, "failed to save response cache: %s", err.Error())
+		}
+	}()
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCacheKey(t *testing.T) {
+	parse := func(body string) *model.GeneralOpenAIRequest {
+		request := &model.GeneralOpenAIRequest{}
+		assert.Nil(t, json.Unmarshal([]byte(body), request))
+		return request
+	}
+	relayMeta := &meta.Meta{UserId: 1, Group: "default", Mode: relaymode.ChatCompletions}
+	key := responseCacheKey(relayMeta, "", parse(`{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hi"}],"temperature":0}`))
+
+	// the order of the fields and the stream options don't change the key
+	assert.Equal(t, key, responseCacheKey(relayMeta, "", parse(`{"temperature":0,"messages":[{"content":"hi","role":"user"}],"model":"gpt-4o-mini"}`)))
+	assert.Equal(t, key, responseCacheKey(relayMeta, "", parse(`{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hi"}],"temperature":0,"stream_options":{"include_usage":true}}`)))
+
+	// the request, the user, the group, the rule set and streaming all do
+	request := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hi"}],"temperature":0}`
+	assert.NotEqual(t, key, responseCacheKey(relayMeta, "", parse(`{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hello"}],"temperature":0}`)))
+	assert.NotEqual(t, key, responseCacheKey(&meta.Meta{UserId: 2, Group: "default", Mode: relaymode.ChatCompletions}, "", parse(request)))
+	assert.NotEqual(t, key, responseCacheKey(&meta.Meta{UserId: 1, Group: "vip", Mode: relaymode.ChatCompletions}, "", parse(request)))
+	assert.NotEqual(t, key, responseCacheKey(relayMeta, "strict", parse(request)))
+	assert.NotEqual(t, key, responseCacheKey(&meta.Meta{UserId: 1, Group: "default", Mode: relaymode.ChatCompletions, IsStream: true}, "", parse(request)))
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	oldEnabled, oldTTL := config.ResponseCacheEnabled, config.ResponseCacheTTL
+	config.ResponseCacheEnabled, config.ResponseCacheTTL = true, 100
+	defer func() { config.ResponseCacheEnabled, config.ResponseCacheTTL = oldEnabled, oldTTL }()
+	dbmodel.GroupInfo["cache-test"] = &dbmodel.Group{Name: "cache-test", CacheTTL: 200}
+	defer delete(dbmodel.GroupInfo, "cache-test")
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Equal(t, 100, responseCacheTTL(c, relaymode.ChatCompletions))
+	assert.Equal(t, 0, responseCacheTTL(c, relaymode.ImagesGenerations))
+
+	// the token overrides the group, which overrides the global ttl
+	c.Set(ctxkey.Group, "cache-test")
+	assert.Equal(t, 200, responseCacheTTL(c, relaymode.ChatCompletions))
+	c.Set(ctxkey.CacheTTL, 300)
+	assert.Equal(t, 300, responseCacheTTL(c, relaymode.Embeddings))
+
+	c.Set(ctxkey.CacheDisabled, true)
+	assert.Equal(t, 0, responseCacheTTL(c, relaymode.ChatCompletions))
+	config.ResponseCacheEnabled = false
+	c.Set(ctxkey.CacheDisabled, false)
+	assert.Equal(t, 0, responseCacheTTL(c, relaymode.ChatCompletions))
+}
+
+func TestHasCacheControl(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	assert.False(t, hasCacheControl(c, "no-cache"))
+
+	c.Request.Header.Set("Cache-Control", "max-age=0, No-Cache")
+	assert.True(t, hasCacheControl(c, "no-cache"))
+	assert.False(t, hasCacheControl(c, "no-store"))
+
+	c.Request.Header.Set("Cache-Control", "no-store")
+	assert.True(t, hasCacheControl(c, "no-store"))
+	assert.False(t, hasCacheControl(c, "no-cache"))
+}
+
+func TestCachedStreamReplay(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\r\n\r\ndata: {\"choices\":[{\"delta\":{\"content\":\"!\"}}]}\n\ndata: [DONE]\n\n"
+	usage := &model.Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3}
+
+	// the stream sent to the first client is captured event by event
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	writer := startResponseCapture(c)
+	c.Header("Content-Type", "text/event-stream")
+	c.Status(http.StatusOK)
+	_, _ = c.Writer.WriteString(stream)
+	writer.stop(c)
+	assert.Equal(t, stream, w.Body.String())
+	cached := newCachedResponse(true, writer, usage)
+	assert.NotNil(t, cached)
+	assert.Len(t, cached.Events, 3)
+
+	// and replayed as server sent events to the next one
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	replayCachedResponse(c, cached)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: {\"choices\":[{\"delta\":{\"content\":\"!\"}}]}\n\ndata: [DONE]\n\n", w.Body.String())
+
+	// a response without usage or over the size limit is not cached
+	assert.Nil(t, newCachedResponse(true, writer, nil))
+	oldMaxSize := config.ResponseCacheMaxSize
+	config.ResponseCacheMaxSize = 0
+	defer func() { config.ResponseCacheMaxSize = oldMaxSize }()
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	writer = startResponseCapture(c)
+	_, _ = c.Writer.WriteString(stream)
+	writer.stop(c)
+	assert.Nil(t, newCachedResponse(true, writer, usage))
+}
@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// responseConverter turns the openai output of the adaptors into the format of a non-openai inbound endpoint
+type responseConverter interface {
+	// ConvertChunk returns what to write for one openai stream chunk
+	ConvertChunk(chunk *openai.ChatCompletionsStreamResponse) []byte
+	// FinishStream returns what to write after the last chunk, usage is the one counted by the relay
+	FinishStream(usage *model.Usage) []byte
+	// ConvertResponse returns the body of a non-stream response
+	ConvertResponse(response *openai.TextResponse, usage *model.Usage) any
+}
+
+// relayConvertedText sends an inbound request converted to openai format through the channel adaptor,
+// what the adaptor writes is converted back by the converter
+func relayConvertedText(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest, converter responseConverter) (*model.Usage, *model.ErrorWithStatusCode) {
+	ctx := c.Request.Context()
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return nil, openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	// the adaptors build the upstream url from the path, they should see a chat completions request
+	meta.RequestURLPath = "/v1/chat/completions"
+	adaptor.Init(meta)
+
+	writer := &convertedResponseWriter{
+		ResponseWriter: c.Writer,
+		stream:         meta.IsStream,
+		converter:      converter,
+	}
+	c.Writer = writer
+	defer func() {
+		c.Writer = writer.ResponseWriter
+	}()
+
+	var resp *http.Response
+	if !meta.SelfImplement {
+		if meta.APIType == apitype.Gemini {
+			meta.TextRequest = textRequest
+		}
+		convertedRequest, err := convertRequest(c, adaptor, meta, textRequest)
+		if err != nil {
+			return nil, openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+		}
+		jsonData, err := json.Marshal(convertedRequest)
+		if err != nil {
+			return nil, openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+		}
+		logger.Debugf(ctx, "converted request: \n%s", string(jsonData))
+		requestBody, err := transformRequestBody(meta, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, openai.ErrorWrapper(err, "transform_request_failed", http.StatusInternalServerError)
+		}
+		resp, err = doRequest(c, adaptor, meta, requestBody)
+		if err != nil {
+			logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+			return nil, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+		}
+		if isErrorHappened(meta, resp) {
+			return nil, RelayErrorHandler(resp)
+		}
+	} else {
+		meta.TextRequest = textRequest
+	}
+
+	usage, respErr := doResponse(c, adaptor, resp, meta)
+	if respErr != nil {
+		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
+		return nil, respErr
+	}
+	writer.finish(usage)
+	return usage, nil
+}
+
+// convertedResponseWriter sits between the adaptors and the client,
+// stream chunks are converted line by line while a non-stream body is buffered until finish
+type convertedResponseWriter struct {
+	gin.ResponseWriter
+	stream    bool
+	converter responseConverter
+	buffer    bytes.Buffer
+	status    int
+}
+
+func (w *convertedResponseWriter) WriteHeader(code int) {
+	if w.stream {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *convertedResponseWriter) WriteHeaderNow() {
+	if w.stream {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *convertedResponseWriter) Flush() {
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *convertedResponseWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if w.stream {
+		w.convertLines()
+	}
+	return len(data), nil
+}
+
+func (w *convertedResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *convertedResponseWriter) convertLines() {
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// keep the incomplete line for the next write
+			w.buffer.WriteString(line)
+			return
+		}
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			continue
+		}
+		var chunk openai.ChatCompletionsStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			logger.SysError("error unmarshalling stream chunk: " + err.Error())
+			continue
+		}
+		w.writeConverted(w.converter.ConvertChunk(&chunk))
+	}
+}
+
+func (w *convertedResponseWriter) writeConverted(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	_, _ = w.ResponseWriter.Write(data)
+	w.ResponseWriter.Flush()
+}
+
+func (w *convertedResponseWriter) finish(usage *model.Usage) {
+	if w.stream {
+		w.buffer.WriteString("\n")
+		w.convertLines()
+		w.writeConverted(w.converter.FinishStream(usage))
+		return
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	var textResponse openai.TextResponse
+	if err := json.Unmarshal(w.buffer.Bytes(), &textResponse); err != nil || len(textResponse.Choices) == 0 {
+		// not a chat completion, pass it through rather than lose it
+		w.ResponseWriter.WriteHeader(status)
+		_, _ = w.ResponseWriter.Write(w.buffer.Bytes())
+		return
+	}
+	jsonData, err := json.Marshal(w.converter.ConvertResponse(&textResponse, usage))
+	if err != nil {
+		logger.SysError("error marshalling converted response: " + err.Error())
+		return
+	}
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(status)
+	_, _ = w.ResponseWriter.Write(jsonData)
+}
@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor/gemini"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/billing"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// RelayGeminiHelper serves the gemini-native generateContent and streamGenerateContent endpoints,
+// gemini channels get the request as is, the others get it converted to openai format and their response is converted back
+func RelayGeminiHelper(c *gin.Context) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	modelName, action := gemini.ParseModelAction(c.Param("action"))
+	if action != "generateContent" && action != "streamGenerateContent" {
+		return openai.ErrorWrapper(fmt.Errorf("unsupported action: %s", action), "invalid_gemini_request", http.StatusBadRequest)
+	}
+	generateRequest, rawRequest, err := getAndValidateGenerateContentRequest(c)
+	if err != nil {
+		logger.Errorf(ctx, "getAndValidateGenerateContentRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+	sse := c.Query("alt") == "sse"
+	textRequest := gemini.ConvertGenerateContentRequest(generateRequest, modelName, action == "streamGenerateContent")
+	meta.Mode = relaymode.ChatCompletions
+	meta.IsStream = textRequest.Stream
+
+	// map model name
+	meta.OriginModelName = textRequest.Model
+	textRequest.Model, _ = getMappedModelName(textRequest.Model, meta.ModelMapping)
+	meta.ActualModelName = textRequest.Model
+	native := isNativeGemini(meta) && !hasOutputFilter(c, meta.Group)
+	var systemPromptReset bool
+	if native {
+		if meta.SystemPrompt != "" {
+			rawRequest["systemInstruction"] = map[string]any{
+				"parts": []any{map[string]any{"text": meta.SystemPrompt}},
+			}
+			systemPromptReset = true
+		}
+	} else {
+		systemPromptReset = setSystemPrompt(ctx, textRequest, meta.SystemPrompt)
+	}
+	// get model ratio & group ratio
+	modelRatio := billingratio.GetModelRatio(textRequest.Model, meta.ChannelType, meta.Group)
+	groupRatio := billingratio.GetGroupRatio(meta.Group)
+	ratio := modelRatio * groupRatio
+	// pre-consume quota
+	promptTokens := 0
+	if meta.CalcPrompt {
+		promptTokens = billing.GetPromptTokens(textRequest, meta.Mode)
+	}
+	meta.PromptTokens = promptTokens
+	if meta.TpmLimit > 0 {
+		if promptTokens == 0 {
+			promptTokens = billing.GetPromptTokens(textRequest, meta.Mode)
+		}
+		if bizErr := billing.PreConsumeTPM(c, meta, billing.GetEstimatedTokens(textRequest, promptTokens)); bizErr != nil {
+			logger.Warnf(ctx, "preConsumeTPM failed: %+v", *bizErr)
+			return bizErr
+		}
+	}
+	preConsumedQuota, bizErr := billing.PreConsumeQuota(ctx, textRequest, meta.PromptTokens, ratio, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		billing.ReturnPreConsumedTPM(meta)
+		return bizErr
+	}
+
+	var usage *model.Usage
+	if native {
+		usage, bizErr = relayNativeGemini(c, meta, rawRequest, sse)
+	} else {
+		converter := &geminiConverter{
+			converter: &gemini.StreamConverter{Model: meta.OriginModelName},
+			writer:    &gemini.StreamWriter{SSE: sse},
+		}
+		usage, bizErr = relayConvertedText(c, meta, textRequest, converter)
+	}
+	if bizErr != nil {
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		billing.ReturnPreConsumedTPM(meta)
+		return bizErr
+	}
+	billing.PostConsumeTPM(meta, usage)
+	// post-consume quota
+	go func(c *gin.Context) {
+		billing.PostConsumeQuota(c, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset)
+	}(c.Copy())
+	return nil
+}
+
+func getAndValidateGenerateContentRequest(c *gin.Context) (*gemini.GenerateContentRequest, map[string]any, error) {
+	generateRequest := &gemini.GenerateContentRequest{}
+	err := common.UnmarshalBodyReusable(c, generateRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(generateRequest.Contents) == 0 {
+		return nil, nil, fmt.Errorf("contents is required")
+	}
+	// the raw request keeps the fields we don't know about for the native channels
+	rawRequest := make(map[string]any)
+	err = common.UnmarshalBodyReusable(c, &rawRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return generateRequest, rawRequest, nil
+}
+
+func isNativeGemini(meta *meta.Meta) bool {
+	switch meta.APIType {
+	case apitype.Gemini:
+		return true
+	case apitype.VertexAI:
+		return strings.HasPrefix(meta.ActualModelName, "gemini")
+	}
+	return false
+}
+
+func relayNativeGemini(c *gin.Context, meta *meta.Meta, rawRequest map[string]any, sse bool) (*model.Usage, *model.ErrorWithStatusCode) {
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return nil, openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+	jsonData, err := json.Marshal(rawRequest)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "marshal_request_failed", http.StatusInternalServerError)
+	}
+	// the upstream is always asked for server-sent events, the stream writer answers in the format the client asked for
+	resp, err := doRequest(c, adaptor, meta, bytes.NewBuffer(jsonData))
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "DoRequest failed: %s", err.Error())
+		return nil, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if isErrorHappened(meta, resp) {
+		return nil, RelayErrorHandler(resp)
+	}
+	var usage *model.Usage
+	var bizErr *model.ErrorWithStatusCode
+	if meta.IsStream {
+		bizErr, usage = gemini.NativeStreamHandler(c, resp, meta, sse)
+	} else {
+		bizErr, usage = gemini.NativeHandler(c, resp)
+	}
+	return usage, bizErr
+}
+
+// geminiConverter writes the gemini responses in the stream format the client asked for
+type geminiConverter struct {
+	converter *gemini.StreamConverter
+	writer    *gemini.StreamWriter
+	buffer    bytes.Buffer
+}
+
+func (g *geminiConverter) render(response *gemini.ChatResponse, last bool) []byte {
+	g.buffer.Reset()
+	if response != nil {
+		jsonData, err := json.Marshal(response)
+		if err != nil {
+			logger.SysError("error marshalling stream response: " + err.Error())
+			return nil
+		}
+		g.writer.Write(&g.buffer, jsonData)
+	}
+	if last {
+		g.writer.Close(&g.buffer)
+	}
+	return g.buffer.Bytes()
+}
+
+func (g *geminiConverter) ConvertChunk(chunk *openai.ChatCompletionsStreamResponse) []byte {
+	return g.render(g.converter.Convert(chunk), false)
+}
+
+func (g *geminiConverter) FinishStream(usage *model.Usage) []byte {
+	return g.render(g.converter.Finish(usage), true)
+}
+
+func (g *geminiConverter) ConvertResponse(response *openai.TextResponse, usage *model.Usage) any {
+	return gemini.ResponseOpenAI2Gemini(response, g.converter.Model, usage)
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/guardrail"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// startOutputFilter filters the chat and completion output with the rule set of the token, or of its group.
+// The adaptors write the openai format, also when the inbound request was converted from another format.
+func startOutputFilter(c *gin.Context, meta *meta.Meta) *guardrail.Writer {
+	_, converted := c.Writer.(*convertedResponseWriter)
+	if meta.Mode != relaymode.ChatCompletions && meta.Mode != relaymode.Completions && !converted {
+		return nil
+	}
+	ruleSetName := outputFilterRuleSet(c, meta.Group)
+	if ruleSetName == "" {
+		return nil
+	}
+	return guardrail.Start(c, ruleSetName)
+}
+
+// hasOutputFilter reports whether the output is filtered, the native passthroughs are not, a filtered request takes the converted path
+func hasOutputFilter(c *gin.Context, group string) bool {
+	ruleSetName := outputFilterRuleSet(c, group)
+	return ruleSetName != "" && guardrail.HasRuleSet(ruleSetName)
+}
+
+// outputFilterRuleSet returns the name of the output filter rule set of the token, or of its group
+func outputFilterRuleSet(c *gin.Context, group string) string {
+	if ruleSetName := c.GetString(ctxkey.OutputFilter); ruleSetName != "" {
+		return ruleSetName
+	}
+	if info, ok := dbmodel.GroupInfo[group]; ok {
+		return info.OutputFilter
+	}
+	return ""
+}
+
+func finishOutputFilter(c *gin.Context, writer *guardrail.Writer) {
+	if writer == nil {
+		return
+	}
+	writer.Finish(c)
+	summary := writer.Summary()
+	if summary == "" {
+		return
+	}
+	tokenName := c.GetString(ctxkey.TokenName)
+	logger.Warnf(c.Request.Context(), "output filter of token %s: %s", tokenName, summary)
+	dbmodel.RecordLog(c.GetInt(ctxkey.Id), dbmodel.LogTypeSystem, fmt.Sprintf("令牌 %s 的输出触发过滤规则：%s", tokenName, summary))
+}
@@ -11,15 +11,13 @@ import (
 	"net/http"
 	"runtime"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
-	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
-	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/meta"
@@ -223,6 +221,9 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		logger.Errorf(ctx, "getImageRequest failed: %s", err.Error())
 		return openai.ErrorWrapper(err, "invalid_image_request", http.StatusBadRequest)
 	}
+	if bizErr := moderateInput(c, []string{imageRequest.Prompt}); bizErr != nil {
+		return bizErr
+	}
 
 	// map model name
 	var isModelMapped bool
@@ -289,7 +290,6 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	groupRatio := billingratio.GetGroupRatio(meta.Group)
 	completionRatio := billingratio.GetCompletionRatio(imageModel, meta.ChannelType)
 	ratio := modelRatio * groupRatio
-	userQuota, _ := model.CacheGetUserQuota(ctx, meta.UserId)
 
 	var quota int64
 	switch meta.ChannelType {
@@ -299,32 +299,44 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	default:
 		quota = int64(ratio*imageCostRatio*1000) * int64(imageRequest.N)
 	}
+	// a pricing rule with a per image price replaces the ratios
+	imageCount := imageRequest.N
+	if meta.ChannelType == channeltype.Replicate {
+		imageCount = 1
+	}
+	if price := billingratio.GetModelPrice(imageRequest.Model, meta.ChannelType); price != nil && price.ImagePrice > 0 {
+		quota = int64(math.Ceil(price.GetFixedPrice(imageCount, 0) * groupRatio))
+	}
 
-	if userQuota-quota < 0 {
-		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+	preConsumedQuota, bizErr := billing.PreConsumeQuotaAmount(ctx, quota, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return bizErr
 	}
 
 	// do request
-	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	resp, err := doRequest(c, adaptor, meta, requestBody)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
 
 	// do response
-	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	usage, respErr := doResponse(c, adaptor, resp, meta)
 	if respErr != nil {
 		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 		return respErr
 	}
 
 	prompt := 0
 	completion := 0
-	useTimeSeconds := time.Now().Unix() - meta.StartTime.Unix()
 	defer func(ctx *gin.Context) {
 		if resp != nil &&
 			resp.StatusCode != http.StatusCreated && // replicate returns 201
 			resp.StatusCode != http.StatusOK {
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 			return
 		}
 		//有返回usage的, 按照它的计算
@@ -351,22 +363,11 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 				quota = int64(usage.TotalTokens)
 			}
 		}
-		err := model.PostConsumeTokenQuota(meta.TokenId, quota)
-		if err != nil {
-			logger.SysError("error consuming token remain quota: " + err.Error())
-		}
-		err = model.CacheUpdateUserQuota(ctx, meta.UserId)
-		if err != nil {
-			logger.SysError("error update user quota cache: " + err.Error())
-		}
-		if quota != 0 {
-			tokenName := c.GetString(ctxkey.TokenName)
-			logContent := fmt.Sprintf("model rate %.2f, size radio %.2f, group rate %.2f (%s-%s-%d)", modelRatio, imageCostRatio, groupRatio, imageRequest.Size, imageMode, imageRequest.N)
-			model.RecordConsumeLog(c, meta.IsStream, meta.FirstResponseTime, int(useTimeSeconds), meta.UserId, meta.ChannelId, prompt, completion, imageRequest.Model, tokenName, quota, logContent, meta.TokenId)
-			model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
-			channelId := c.GetInt(ctxkey.ChannelId)
-			model.UpdateChannelUsedQuota(channelId, quota)
+		if price := billingratio.GetModelPrice(imageRequest.Model, meta.ChannelType); price != nil && price.ImagePrice > 0 {
+			quota = int64(math.Ceil(price.GetFixedPrice(imageCount, 0) * groupRatio))
 		}
+		logContent := fmt.Sprintf("model rate %.2f, size radio %.2f, group rate %.2f (%s-%s-%d)", modelRatio, imageCostRatio, groupRatio, imageRequest.Size, imageMode, imageRequest.N)
+		billing.PostConsumeQuotaAmount(ctx, meta, quota, preConsumedQuota, prompt, completion, imageRequest.Model, logContent)
 	}(c)
 
 	return nil
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/aws"
+	awsclaude "github.com/songquanpeng/one-api/relay/adaptor/aws/claude"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/billing"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// RelayMessagesHelper serves the claude-native /v1/messages endpoint, claude channels get the request as is,
+// the others get it converted to openai format and their response is converted back
+func RelayMessagesHelper(c *gin.Context) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	messagesRequest, rawRequest, err := getAndValidateMessagesRequest(c)
+	if err != nil {
+		logger.Errorf(ctx, "getAndValidateMessagesRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "invalid_messages_request", http.StatusBadRequest)
+	}
+	textRequest := anthropic.ConvertMessagesRequest(messagesRequest)
+	meta.Mode = relaymode.ChatCompletions
+	meta.IsStream = textRequest.Stream
+
+	// map model name
+	meta.OriginModelName = textRequest.Model
+	textRequest.Model, _ = getMappedModelName(textRequest.Model, meta.ModelMapping)
+	meta.ActualModelName = textRequest.Model
+	native := isNativeClaude(meta) && !hasOutputFilter(c, meta.Group)
+	var systemPromptReset bool
+	if native {
+		if meta.SystemPrompt != "" {
+			rawRequest["system"] = meta.SystemPrompt
+			systemPromptReset = true
+		}
+	} else {
+		systemPromptReset = setSystemPrompt(ctx, textRequest, meta.SystemPrompt)
+	}
+	// get model ratio & group ratio
+	modelRatio := billingratio.GetModelRatio(textRequest.Model, meta.ChannelType, meta.Group)
+	groupRatio := billingratio.GetGroupRatio(meta.Group)
+	ratio := modelRatio * groupRatio
+	// pre-consume quota
+	promptTokens := 0
+	if meta.CalcPrompt {
+		promptTokens = billing.GetPromptTokens(textRequest, meta.Mode)
+	}
+	meta.PromptTokens = promptTokens
+	if meta.TpmLimit > 0 {
+		if promptTokens == 0 {
+			promptTokens = billing.GetPromptTokens(textRequest, meta.Mode)
+		}
+		if bizErr := billing.PreConsumeTPM(c, meta, billing.GetEstimatedTokens(textRequest, promptTokens)); bizErr != nil {
+			logger.Warnf(ctx, "preConsumeTPM failed: %+v", *bizErr)
+			return bizErr
+		}
+	}
+	preConsumedQuota, bizErr := billing.PreConsumeQuota(ctx, textRequest, meta.PromptTokens, ratio, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		billing.ReturnPreConsumedTPM(meta)
+		return bizErr
+	}
+
+	var usage *model.Usage
+	if native {
+		usage, bizErr = relayNativeMessages(c, meta, rawRequest)
+	} else {
+		converter := &messagesConverter{converter: &anthropic.StreamConverter{
+			Model:       meta.OriginModelName,
+			InputTokens: meta.PromptTokens,
+		}}
+		usage, bizErr = relayConvertedText(c, meta, textRequest, converter)
+	}
+	if bizErr != nil {
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		billing.ReturnPreConsumedTPM(meta)
+		return bizErr
+	}
+	billing.PostConsumeTPM(meta, usage)
+	// post-consume quota
+	go func(c *gin.Context) {
+		billing.PostConsumeQuota(c, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset)
+	}(c.Copy())
+	return nil
+}
+
+func getAndValidateMessagesRequest(c *gin.Context) (*anthropic.MessagesRequest, map[string]any, error) {
+	messagesRequest := &anthropic.MessagesRequest{}
+	err := common.UnmarshalBodyReusable(c, messagesRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if messagesRequest.Model == "" {
+		return nil, nil, errors.New("model is required")
+	}
+	if len(messagesRequest.Messages) == 0 {
+		return nil, nil, errors.New("messages is required")
+	}
+	// the raw request keeps the fields we don't know about for the native channels
+	rawRequest := make(map[string]any)
+	err = common.UnmarshalBodyReusable(c, &rawRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return messagesRequest, rawRequest, nil
+}
+
+func isNativeClaude(meta *meta.Meta) bool {
+	switch meta.APIType {
+	case apitype.Anthropic, apitype.AwsClaude:
+		return true
+	case apitype.VertexAI:
+		return strings.HasPrefix(meta.ActualModelName, "claude")
+	}
+	return false
+}
+
+func relayNativeMessages(c *gin.Context, meta *meta.Meta, rawRequest map[string]any) (*model.Usage, *model.ErrorWithStatusCode) {
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return nil, openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+
+	var usage *model.Usage
+	var bizErr *model.ErrorWithStatusCode
+	if meta.APIType == apitype.AwsClaude {
+		awsAdaptor, ok := adaptor.(*aws.Adaptor)
+		if !ok {
+			return nil, openai.ErrorWrapper(errors.New("unexpected aws adaptor"), "invalid_api_type", http.StatusInternalServerError)
+		}
+		if meta.IsStream {
+			bizErr, usage = awsclaude.NativeStreamHandler(c, awsAdaptor.AwsClient, meta, rawRequest)
+		} else {
+			bizErr, usage = awsclaude.NativeHandler(c, awsAdaptor.AwsClient, meta, rawRequest)
+		}
+		return usage, bizErr
+	}
+
+	if meta.APIType == apitype.VertexAI {
+		delete(rawRequest, "model")
+		rawRequest["anthropic_version"] = "vertex-2023-10-16"
+	} else {
+		rawRequest["model"] = meta.ActualModelName
+	}
+	jsonData, err := json.Marshal(rawRequest)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "marshal_request_failed", http.StatusInternalServerError)
+	}
+	resp, err := doRequest(c, adaptor, meta, bytes.NewBuffer(jsonData))
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "DoRequest failed: %s", err.Error())
+		return nil, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if isErrorHappened(meta, resp) {
+		return nil, RelayErrorHandler(resp)
+	}
+	if meta.IsStream {
+		bizErr, usage = anthropic.NativeStreamHandler(c, resp, meta)
+	} else {
+		bizErr, usage = anthropic.NativeHandler(c, resp)
+	}
+	return usage, bizErr
+}
+
+// messagesConverter frames the claude events as server-sent events
+type messagesConverter struct {
+	converter *anthropic.StreamConverter
+}
+
+func (m *messagesConverter) render(events []anthropic.StreamEvent) []byte {
+	var buffer bytes.Buffer
+	for _, event := range events {
+		jsonData, err := json.Marshal(event)
+		if err != nil {
+			logger.SysError("error marshalling stream event: " + err.Error())
+			continue
+		}
+		buffer.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, jsonData))
+	}
+	return buffer.Bytes()
+}
+
+func (m *messagesConverter) ConvertChunk(chunk *openai.ChatCompletionsStreamResponse) []byte {
+	return m.render(m.converter.Convert(chunk))
+}
+
+func (m *messagesConverter) FinishStream(usage *model.Usage) []byte {
+	return m.render(m.converter.Finish(usage))
+}
+
+func (m *messagesConverter) ConvertResponse(response *openai.TextResponse, usage *model.Usage) any {
+	claudeResponse := anthropic.ResponseOpenAI2Claude(response, m.converter.Model)
+	if usage != nil {
+		claudeResponse.Usage.InputTokens = usage.PromptTokens
+		claudeResponse.Usage.OutputTokens = usage.CompletionTokens
+	}
+	return claudeResponse
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/moderation"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// textModerationInput returns the content written by the user, the system prompt of the channel is not moderated
+func textModerationInput(relayMode int, textRequest *model.GeneralOpenAIRequest) []string {
+	var input []string
+	switch relayMode {
+	case relaymode.Moderations:
+		return nil
+	case relaymode.Embeddings:
+		return textRequest.ParseInput()
+	}
+	for _, message := range textRequest.Messages {
+		if message.Role == "user" || message.Role == "system" {
+			if content := message.StringContent(); content != "" {
+				input = append(input, content)
+			}
+		}
+	}
+	switch prompt := textRequest.Prompt.(type) {
+	case string:
+		input = append(input, prompt)
+	case []any:
+		for _, item := range prompt {
+			if str, ok := item.(string); ok {
+				input = append(input, str)
+			}
+		}
+	}
+	return input
+}
+
+// moderateInput sends the user content to the moderation channel when the token enables moderation.
+// A flagged request is counted against the token and rejected unless ModerationAction is flag.
+// The verdict is kept in the context, so the retries of the request are not moderated again.
+func moderateInput(c *gin.Context, input []string) *model.ErrorWithStatusCode {
+	input = slices.DeleteFunc(input, func(s string) bool {
+		return strings.TrimSpace(s) == ""
+	})
+	if !c.GetBool(ctxkey.ModerationsEnable) || config.ModerationChannelId == 0 || len(input) == 0 {
+		return nil
+	}
+	if verdict, ok := c.Get(ctxkey.ModerationVerdict); ok {
+		return moderationError(verdict.(*moderation.Verdict))
+	}
+	ctx := c.Request.Context()
+	channel, err := dbmodel.GetChannelById(config.ModerationChannelId, true)
+	if err != nil {
+		logger.Errorf(ctx, "failed to get moderation channel: %s", err.Error())
+		return nil
+	}
+	key := channel.Key
+	if channelKey := channel.SelectKey(); channelKey != nil {
+		key = channelKey.Key
+	}
+	verdict, err := moderation.Moderate(ctx, channel.GetBaseURL(), key, config.ModerationModel, input)
+	if err != nil {
+		// the moderation fails open, the request is not blocked by an outage of the moderation channel
+		logger.Errorf(ctx, "moderation failed: %s", err.Error())
+		return nil
+	}
+	c.Set(ctxkey.ModerationVerdict, verdict)
+	if !verdict.Flagged {
+		return nil
+	}
+	userId := c.GetInt(ctxkey.Id)
+	tokenName := c.GetString(ctxkey.TokenName)
+	logger.Warnf(ctx, "moderation of token %s: %s", tokenName, verdict.String())
+	token, disabled, err := dbmodel.RecordTokenViolation(c.GetInt(ctxkey.TokenId), config.ModerationMaxViolations)
+	if err != nil {
+		logger.Errorf(ctx, "failed to record the violation: %s", err.Error())
+	}
+	content := fmt.Sprintf("令牌 %s 的请求未通过内容审核（%s），处理方式：%s", tokenName, verdict.String(), config.ModerationAction)
+	if token != nil {
+		content += fmt.Sprintf("，累计违规 %d 次", token.ModerationsNum)
+	}
+	if disabled {
+		content += "，令牌已被禁用"
+	}
+	dbmodel.RecordLog(userId, dbmodel.LogTypeSystem, content)
+	return moderationError(verdict)
+}
+
+func moderationError(verdict *moderation.Verdict) *model.ErrorWithStatusCode {
+	if !verdict.Flagged || config.ModerationAction == "flag" {
+		return nil
+	}
+	err := errors.New("the request was rejected by the content moderation: " + strings.Join(verdict.Categories, ", "))
+	return openai.ErrorWrapper(err, "content_policy_violation", http.StatusBadRequest)
+}
@@ -3,16 +3,32 @@ package controller
 
 import (
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/relay"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/meta"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 )
 
+// countingReader counts the bytes of the request body sent upstream
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
 // RelayProxyHelper is a helper function to proxy the request to the upstream service
 func RelayProxyHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatusCode {
 	ctx := c.Request.Context()
@@ -24,18 +40,73 @@ func RelayProxyHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	}
 	adaptor.Init(meta)
 
-	resp, err := adaptor.DoRequest(c, meta, c.Request.Body)
+	// the flat price is known before the request, the rest is settled from the response
+	rule := meta.Config.ProxyBilling
+	groupRatio := billingratio.GetGroupRatio(meta.Group)
+	preConsumedQuota := int64(0)
+	if rule != nil {
+		preConsumedQuota = int64(math.Ceil(rule.RequestPrice * groupRatio))
+	}
+	preConsumedQuota, bizErr := billing.PreConsumeQuotaAmount(ctx, preConsumedQuota, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return bizErr
+	}
+
+	requestBody := &countingReader{Reader: c.Request.Body}
+	resp, err := doRequest(c, adaptor, meta, requestBody)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
 
 	// do response
-	_, respErr := adaptor.DoResponse(c, resp, meta)
+	usage, respErr := doResponse(c, adaptor, resp, meta)
 	if respErr != nil {
 		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 		return respErr
 	}
+	if resp.StatusCode/100 != 2 {
+		// the upstream error has been passed to the client as is, it is not charged
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return nil
+	}
 
+	modelName := meta.OriginModelName
+	if rule != nil && rule.Model != "" {
+		modelName = rule.Model
+	}
+	if modelName == "" {
+		modelName = "proxy"
+	}
+	responseBytes := int64(c.Writer.Size())
+	if responseBytes < 0 {
+		responseBytes = 0
+	}
+	var modelRatio, completionRatio float64
+	if rule != nil && rule.Mode == relaymodel.ProxyBillingUsage {
+		modelRatio = billingratio.GetModelRatio(modelName, meta.ChannelType, meta.Group)
+		completionRatio = billingratio.GetCompletionRatio(modelName, meta.ChannelType)
+	}
+	quota := int64(math.Ceil(billing.ProxyQuota(rule, requestBody.count, responseBytes, usage, modelRatio, completionRatio) * groupRatio))
+	logContent := proxyLogContent(rule, requestBody.count, responseBytes, modelRatio, groupRatio, completionRatio)
+	go func(c *gin.Context) {
+		billing.PostConsumeProxyQuota(c, meta, usage, modelName, quota, preConsumedQuota, logContent)
+	}(c.Copy())
 	return nil
 }
+
+func proxyLogContent(rule *relaymodel.ProxyBilling, requestBytes int64, responseBytes int64, modelRatio float64, groupRatio float64, completionRatio float64) string {
+	if rule == nil {
+		return "代理请求，渠道未配置计费规则"
+	}
+	switch rule.Mode {
+	case relaymodel.ProxyBillingPerByte:
+		return fmt.Sprintf("代理按字节计费，请求 %d 字节，响应 %d 字节，按次价格 %.2f，分组倍率 %.2f", requestBytes, responseBytes, rule.RequestPrice, groupRatio)
+	case relaymodel.ProxyBillingUsage:
+		return fmt.Sprintf("代理按用量计费，模型倍率 %.2f，分组倍率 %.2f，补全倍率 %.2f，按次价格 %.2f", modelRatio, groupRatio, completionRatio, rule.RequestPrice)
+	}
+	return fmt.Sprintf("代理按次计费，按次价格 %.2f，分组倍率 %.2f", rule.RequestPrice, groupRatio)
+}
@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// RelayResponsesHelper serves the /v1/responses endpoint, openai channels get the request as is,
+// the others get it converted to chat completions and their response is converted back.
+// previous_response_id is resolved here from the stored responses, so the chain works whatever channel served the previous turns
+func RelayResponsesHelper(c *gin.Context) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	responsesRequest, rawRequest, err := getAndValidateResponsesRequest(c)
+	if err != nil {
+		logger.Errorf(ctx, "getAndValidateResponsesRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "invalid_responses_request", http.StatusBadRequest)
+	}
+	var history []any
+	if responsesRequest.PreviousResponseId != "" {
+		history, err = dbmodel.GetResponseHistory(responsesRequest.PreviousResponseId, meta.UserId)
+		if err != nil {
+			logger.Warnf(ctx, "GetResponseHistory failed: %s", err.Error())
+			return openai.ErrorWrapper(fmt.Errorf("previous response with id '%s' not found", responsesRequest.PreviousResponseId), "previous_response_not_found", http.StatusBadRequest)
+		}
+	}
+	inputItems := responsesRequest.InputItems()
+	items := append(history, inputItems...)
+	textRequest := openai.ConvertResponsesRequest(responsesRequest, items)
+	meta.Mode = relaymode.ChatCompletions
+	meta.IsStream = textRequest.Stream
+
+	// map model name
+	meta.OriginModelName = textRequest.Model
+	textRequest.Model, _ = getMappedModelName(textRequest.Model, meta.ModelMapping)
+	meta.ActualModelName = textRequest.Model
+	native := meta.ChannelType == channeltype.OpenAI && !hasOutputFilter(c, meta.Group)
+	var systemPromptReset bool
+	if native {
+		if meta.SystemPrompt != "" {
+			rawRequest["instructions"] = meta.SystemPrompt
+			systemPromptReset = true
+		}
+	} else {
+		systemPromptReset = setSystemPrompt(ctx, textRequest, meta.SystemPrompt)
+	}
+	// get model ratio & group ratio
+	modelRatio := billingratio.GetModelRatio(textRequest.Model, meta.ChannelType, meta.Group)
+	groupRatio := billingratio.GetGroupRatio(meta.Group)
+	ratio := modelRatio * groupRatio
+	// pre-consume quota
+	promptTokens := 0
+	if meta.CalcPrompt {
+		promptTokens = billing.GetPromptTokens(textRequest, meta.Mode)
+	}
+	meta.PromptTokens = promptTokens
+	if meta.TpmLimit > 0 {
+		if promptTokens == 0 {
+			promptTokens = billing.GetPromptTokens(textRequest, meta.Mode)
+		}
+		if bizErr := billing.PreConsumeTPM(c, meta, billing.GetEstimatedTokens(textRequest, promptTokens)); bizErr != nil {
+			logger.Warnf(ctx, "preConsumeTPM failed: %+v", *bizErr)
+			return bizErr
+		}
+	}
+	preConsumedQuota, bizErr := billing.PreConsumeQuota(ctx, textRequest, meta.PromptTokens, ratio, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		billing.ReturnPreConsumedTPM(meta)
+		return bizErr
+	}
+
+	var usage *model.Usage
+	var response *openai.ResponsesResponse
+	if native {
+		rawRequest["input"] = append(openai.HistoryItems(history), inputItems...)
+		delete(rawRequest, "previous_response_id")
+		response, bizErr = relayNativeResponses(c, meta, rawRequest)
+		if response != nil && response.Usage != nil {
+			usage = response.Usage.Usage()
+		}
+	} else {
+		converter := &responsesConverter{converter: &openai.ResponsesStreamConverter{
+			Response: openai.NewResponsesResponse(responsesRequest, meta.OriginModelName),
+		}}
+		usage, bizErr = relayConvertedText(c, meta, textRequest, converter)
+		response = converter.converter.Response
+	}
+	if bizErr != nil {
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		billing.ReturnPreConsumedTPM(meta)
+		return bizErr
+	}
+	if usage == nil {
+		usage = &model.Usage{PromptTokens: meta.PromptTokens, TotalTokens: meta.PromptTokens}
+	}
+	if responsesRequest.ShouldStore() && response != nil {
+		storeResponse(ctx, meta, responsesRequest, inputItems, response)
+	}
+	billing.PostConsumeTPM(meta, usage)
+	// post-consume quota
+	go func(c *gin.Context) {
+		billing.PostConsumeQuota(c, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset)
+	}(c.Copy())
+	return nil
+}
+
+func getAndValidateResponsesRequest(c *gin.Context) (*openai.ResponsesRequest, map[string]any, error) {
+	responsesRequest := &openai.ResponsesRequest{}
+	err := common.UnmarshalBodyReusable(c, responsesRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if responsesRequest.Model == "" {
+		return nil, nil, errors.New("model is required")
+	}
+	if len(responsesRequest.InputItems()) == 0 {
+		return nil, nil, errors.New("input is required")
+	}
+	// the raw request keeps the fields we don't know about for the native channels
+	rawRequest := make(map[string]any)
+	err = common.UnmarshalBodyReusable(c, &rawRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return responsesRequest, rawRequest, nil
+}
+
+func relayNativeResponses(c *gin.Context, meta *meta.Meta, rawRequest map[string]any) (*openai.ResponsesResponse, *model.ErrorWithStatusCode) {
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return nil, openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+	rawRequest["model"] = meta.ActualModelName
+	// the conversation is kept here, the upstream doesn't need to keep it too
+	rawRequest["store"] = false
+	jsonData, err := json.Marshal(rawRequest)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "marshal_request_failed", http.StatusInternalServerError)
+	}
+	resp, err := doRequest(c, adaptor, meta, bytes.NewBuffer(jsonData))
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "DoRequest failed: %s", err.Error())
+		return nil, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if isErrorHappened(meta, resp) {
+		return nil, RelayErrorHandler(resp)
+	}
+	if meta.IsStream {
+		bizErr, response := openai.ResponsesStreamHandler(c, resp, meta)
+		return response, bizErr
+	}
+	bizErr, response := openai.ResponsesHandler(c, resp)
+	return response, bizErr
+}
+
+func storeResponse(ctx context.Context, meta *meta.Meta, request *openai.ResponsesRequest, inputItems []any, response *openai.ResponsesResponse) {
+	if response.Id == "" {
+		return
+	}
+	input, err := json.Marshal(inputItems)
+	if err != nil {
+		logger.Errorf(ctx, "error marshalling response input: %s", err.Error())
+		return
+	}
+	output, err := json.Marshal(response.Output)
+	if err != nil {
+		logger.Errorf(ctx, "error marshalling response output: %s", err.Error())
+		return
+	}
+	response.Store = true
+	if request.PreviousResponseId != "" {
+		response.PreviousResponseId = &request.PreviousResponseId
+	}
+	responseJson, err := json.Marshal(response)
+	if err != nil {
+		logger.Errorf(ctx, "error marshalling response: %s", err.Error())
+		return
+	}
+	storedResponse := &dbmodel.StoredResponse{
+		Id:                 response.Id,
+		UserId:             meta.UserId,
+		TokenId:            meta.TokenId,
+		Model:              meta.OriginModelName,
+		PreviousResponseId: request.PreviousResponseId,
+		Input:              string(input),
+		Output:             string(output),
+		Response:           string(responseJson),
+	}
+	if err := storedResponse.Insert(); err != nil {
+		logger.Errorf(ctx, "error storing response %s: %s", response.Id, err.Error())
+	}
+}
+
+// responsesConverter frames the responses events as server-sent events
+type responsesConverter struct {
+	converter *openai.ResponsesStreamConverter
+}
+
+func (r *responsesConverter) render(events []openai.ResponsesStreamEvent) []byte {
+	var buffer bytes.Buffer
+	for _, event := range events {
+		jsonData, err := json.Marshal(event)
+		if err != nil {
+			logger.SysError("error marshalling stream event: " + err.Error())
+			continue
+		}
+		buffer.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, jsonData))
+	}
+	return buffer.Bytes()
+}
+
+func (r *responsesConverter) ConvertChunk(chunk *openai.ChatCompletionsStreamResponse) []byte {
+	return r.render(r.converter.Convert(chunk))
+}
+
+func (r *responsesConverter) FinishStream(usage *model.Usage) []byte {
+	return r.render(r.converter.Finish(usage))
+}
+
+func (r *responsesConverter) ConvertResponse(response *openai.TextResponse, usage *model.Usage) any {
+	return openai.ResponseChat2Responses(response, r.converter.Response, usage)
+}
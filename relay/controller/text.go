@@ -8,6 +8,7 @@ import (
 	"net/http"
 
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/logger"
@@ -33,9 +34,18 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		return openai.ErrorWrapper(err, "invalid_text_request", http.StatusBadRequest)
 	}
 	meta.IsStream = textRequest.Stream
+	if bizErr := moderateInput(c, textModerationInput(meta.Mode, textRequest)); bizErr != nil {
+		return bizErr
+	}
 
 	// map model name
 	meta.OriginModelName = textRequest.Model
+	// the response cache is keyed on the request of the user, before it is changed for the channel
+	cacheTTL := responseCacheTTL(c, meta.Mode)
+	var cacheKey string
+	if cacheTTL > 0 {
+		cacheKey = responseCacheKey(meta, outputFilterRuleSet(c, meta.Group), textRequest)
+	}
 	textRequest.Model, _ = getMappedModelName(textRequest.Model, meta.ModelMapping)
 	meta.ActualModelName = textRequest.Model
 	// set system prompt if not empty
@@ -53,14 +63,43 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		promptTokens = billing.GetPromptTokens(textRequest, meta.Mode)
 	}
 	meta.PromptTokens = promptTokens
-	preConsumedQuota, bizErr := billing.PreConsumeQuota(ctx, textRequest, promptTokens, ratio, meta)
+	// reserve tokens per minute before consuming quota, so that a rejected request costs nothing
+	if meta.TpmLimit > 0 {
+		if promptTokens == 0 {
+			promptTokens = billing.GetPromptTokens(textRequest, meta.Mode)
+		}
+		if bizErr := billing.PreConsumeTPM(c, meta, billing.GetEstimatedTokens(textRequest, promptTokens)); bizErr != nil {
+			logger.Warnf(ctx, "preConsumeTPM failed: %+v", *bizErr)
+			return bizErr
+		}
+	}
+	preConsumedQuota, bizErr := billing.PreConsumeQuota(ctx, textRequest, meta.PromptTokens, ratio, meta)
 	if bizErr != nil {
 		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		billing.ReturnPreConsumedTPM(meta)
 		return bizErr
 	}
 
+	if cacheKey != "" && !hasCacheControl(c, "no-cache") {
+		if cached := getCachedResponse(ctx, cacheKey); cached != nil {
+			logger.Debugf(ctx, "response cache hit: %s", cacheKey)
+			meta.CacheHit = true
+			meta.ChannelId = 0
+			meta.ChannelKeyId = 0
+			c.Set(ctxkey.CacheHit, true)
+			replayCachedResponse(c, cached)
+			billing.PostConsumeTPM(meta, cached.Usage)
+			go func(c *gin.Context) {
+				billing.PostConsumeQuota(c, cached.Usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset)
+			}(c.Copy())
+			return nil
+		}
+	}
+
 	adaptor := relay.GetAdaptor(meta.APIType)
 	if adaptor == nil {
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		billing.ReturnPreConsumedTPM(meta)
 		return openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
 	}
 	adaptor.Init(meta)
@@ -74,17 +113,22 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		// get request body
 		requestBody, err := getRequestBody(c, meta, textRequest, adaptor)
 		if err != nil {
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			billing.ReturnPreConsumedTPM(meta)
 			return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
 		}
 
 		// do request
-		resp, err = adaptor.DoRequest(c, meta, requestBody)
+		resp, err = doRequest(c, adaptor, meta, requestBody)
 		if err != nil {
 			logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			billing.ReturnPreConsumedTPM(meta)
 			return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 		}
 		if !meta.SelfImplement && isErrorHappened(meta, resp) {
-			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			billing.ReturnPreConsumedTPM(meta)
 			return RelayErrorHandler(resp)
 		}
 	} else {
@@ -92,12 +136,24 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	}
 
 	// do response
-	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	var captureWriter *responseCaptureWriter
+	if cacheKey != "" && !hasCacheControl(c, "no-store") {
+		captureWriter = startResponseCapture(c)
+	}
+	usage, respErr := doResponse(c, adaptor, resp, meta)
+	if captureWriter != nil {
+		captureWriter.stop(c)
+	}
 	if respErr != nil {
 		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
-		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		billing.ReturnPreConsumedTPM(meta)
 		return respErr
 	}
+	if captureWriter != nil {
+		saveCachedResponse(ctx, cacheKey, cacheTTL, meta.IsStream, captureWriter, usage)
+	}
+	billing.PostConsumeTPM(meta, usage)
 	// post-consume quota
 	go func(c *gin.Context) {
 		billing.PostConsumeQuota(c, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset)
@@ -108,12 +164,12 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 func getRequestBody(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest, adaptor adaptor.Adaptor) (io.Reader, error) {
 	if !config.EnforceIncludeUsage && meta.APIType == apitype.OpenAI && meta.OriginModelName == meta.ActualModelName && !(meta.ChannelType == channeltype.Baichuan) {
 		// no need to convert request for openai
-		return c.Request.Body, nil
+		return transformRequestBody(meta, c.Request.Body)
 	}
 
 	// get request body
 	var requestBody io.Reader
-	convertedRequest, err := adaptor.ConvertRequest(c, meta.Mode, textRequest)
+	convertedRequest, err := convertRequest(c, adaptor, meta, textRequest)
 	if err != nil {
 		logger.Debugf(c.Request.Context(), "converted request failed: %s\n", err.Error())
 		return nil, err
@@ -125,5 +181,5 @@ func getRequestBody(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralO
 	}
 	logger.Debugf(c.Request.Context(), "converted request: \n%s", string(jsonData))
 	requestBody = bytes.NewBuffer(jsonData)
-	return requestBody, nil
+	return transformRequestBody(meta, requestBody)
 }
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/telemetry"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/transform"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// startRelaySpan starts a span of a step of the relay and puts it into c.Request while the step runs,
+// so the span of DoRequest is propagated to the upstream
+func startRelaySpan(c *gin.Context, name string, meta *meta.Meta) func(err error) {
+	parent := c.Request.Context()
+	ctx, span := telemetry.StartSpan(parent, name,
+		attribute.Int("channel_id", meta.ChannelId),
+		attribute.Int("channel_type", meta.ChannelType),
+		attribute.String("model", meta.ActualModelName),
+		attribute.Bool("stream", meta.IsStream),
+	)
+	c.Request = c.Request.WithContext(ctx)
+	return func(err error) {
+		c.Request = c.Request.WithContext(parent)
+		telemetry.EndSpan(span, err)
+	}
+}
+
+func convertRequest(c *gin.Context, a adaptor.Adaptor, meta *meta.Meta, request *model.GeneralOpenAIRequest) (any, error) {
+	end := startRelaySpan(c, "ConvertRequest", meta)
+	convertedRequest, err := a.ConvertRequest(c, meta.Mode, request)
+	end(err)
+	return convertedRequest, err
+}
+
+func doRequest(c *gin.Context, a adaptor.Adaptor, meta *meta.Meta, requestBody io.Reader) (*http.Response, error) {
+	if c.GetBool(ctxkey.AuditEnabled) && requestBody != nil {
+		// the converted request is kept for the audit capture
+		data, err := io.ReadAll(requestBody)
+		if err != nil {
+			return nil, err
+		}
+		c.Set(ctxkey.AuditRequest, string(data))
+		requestBody = bytes.NewReader(data)
+	}
+	end := startRelaySpan(c, "DoRequest", meta)
+	resp, err := a.DoRequest(c, meta, requestBody)
+	end(err)
+	return resp, err
+}
+
+func doResponse(c *gin.Context, a adaptor.Adaptor, resp *http.Response, meta *meta.Meta) (*model.Usage, *model.ErrorWithStatusCode) {
+	end := startRelaySpan(c, "DoResponse", meta)
+	filter := startOutputFilter(c, meta)
+	rewriter := transform.Start(c, meta.Config.Transform)
+	usage, respErr := a.DoResponse(c, resp, meta)
+	if rewriter != nil {
+		rewriter.Finish(c)
+	}
+	finishOutputFilter(c, filter)
+	if respErr != nil {
+		end(errors.New(respErr.Message))
+	} else {
+		end(nil)
+	}
+	return usage, respErr
+}
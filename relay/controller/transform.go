@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/transform"
+)
+
+// transformRequestBody applies the request rules of the channel to the body sent upstream
+func transformRequestBody(meta *meta.Meta, requestBody io.Reader) (io.Reader, error) {
+	rules := meta.Config.Transform
+	if rules == nil || len(rules.Request) == 0 {
+		return requestBody, nil
+	}
+	data, err := io.ReadAll(requestBody)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(transform.Apply(rules.Request, data)), nil
+}
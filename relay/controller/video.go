@@ -7,18 +7,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"runtime"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
-	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
-	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/meta"
@@ -39,10 +38,7 @@ func getVideoRequest(c *gin.Context, _ int) (*relaymodel.VideoRequest, error) {
 		if err != nil {
 			return nil, err
 		}
-		logger.SysLogf("转换成功, %s, %s, %d, %s", videoFormRequest.Model, videoFormRequest.Prompt, videoFormRequest.Size)
-		videoFormRequest.Model = videoFormRequest.Model
-		videoFormRequest.Prompt = videoFormRequest.Prompt
-		videoFormRequest.Size = videoFormRequest.Size
+		logger.SysLogf("转换成功, %s, %s, %s", videoFormRequest.Model, videoFormRequest.Prompt, videoFormRequest.Size)
 		//将上传图片转为b64
 		file, err := videoFormRequest.Image.Open()
 		if err != nil {
@@ -101,6 +97,9 @@ func RelayVideoHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		logger.Errorf(ctx, "getVideoRequest failed: %s", err.Error())
 		return openai.ErrorWrapper(err, "invalid_video_request", http.StatusBadRequest)
 	}
+	if bizErr := moderateInput(c, []string{videoRequest.Prompt}); bizErr != nil {
+		return bizErr
+	}
 
 	// map model name
 	var isModelMapped bool
@@ -165,7 +164,6 @@ func RelayVideoHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	modelRatio := billingratio.GetModelRatio(videoModel, meta.ChannelType, meta.Group)
 	groupRatio := billingratio.GetGroupRatio(meta.Group)
 	ratio := modelRatio * groupRatio
-	userQuota, _ := model.CacheGetUserQuota(ctx, meta.UserId)
 
 	var quota int64
 	switch meta.ChannelType {
@@ -175,32 +173,41 @@ func RelayVideoHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		//按秒计费, 默认5秒
 		quota = int64(ratio * 5)
 	}
+	// a pricing rule with a per second price replaces the ratios
+	price := billingratio.GetModelPrice(videoRequest.Model, meta.ChannelType)
+	if price != nil && price.SecondPrice > 0 {
+		quota = int64(math.Ceil(price.GetFixedPrice(0, 5) * groupRatio))
+	}
 
-	if userQuota-quota < 0 {
-		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+	preConsumedQuota, bizErr := billing.PreConsumeQuotaAmount(ctx, quota, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return bizErr
 	}
 
 	// do request
-	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	resp, err := doRequest(c, adaptor, meta, requestBody)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
 
 	// do response
-	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	usage, respErr := doResponse(c, adaptor, resp, meta)
 	if respErr != nil {
 		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 		return respErr
 	}
 
 	prompt := 0
 	completion := 0
-	useTimeSeconds := time.Now().Unix() - meta.StartTime.Unix()
 	defer func(ctx *gin.Context) {
 		if resp != nil &&
 			resp.StatusCode != http.StatusCreated && // replicate returns 201
 			resp.StatusCode != http.StatusOK {
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 			return
 		}
 		extendLog := ""
@@ -216,23 +223,13 @@ func RelayVideoHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 			if usage.TotalTokens > int(quota) {
 				quota = int64(usage.TotalTokens)
 			}
+			if price != nil && price.SecondPrice > 0 && usage.VideoTokens > 0 {
+				quota = int64(math.Ceil(price.GetFixedPrice(0, usage.VideoTokens) * groupRatio))
+				extendLog = fmt.Sprintf("视频长度: %ds, ", usage.VideoTokens)
+			}
 		}
-		err := model.PostConsumeTokenQuota(meta.TokenId, quota)
-		if err != nil {
-			logger.SysError("error consuming token remain quota: " + err.Error())
-		}
-		err = model.CacheUpdateUserQuota(ctx, meta.UserId)
-		if err != nil {
-			logger.SysError("error update user quota cache: " + err.Error())
-		}
-		if quota != 0 {
-			tokenName := c.GetString(ctxkey.TokenName)
-			logContent := fmt.Sprintf("%s模型倍率 %.2f，分组倍率 %.2f", extendLog, modelRatio, groupRatio)
-			model.RecordConsumeLog(c, meta.IsStream, meta.FirstResponseTime, int(useTimeSeconds), meta.UserId, meta.ChannelId, prompt, completion, videoRequest.Model, tokenName, quota, logContent, meta.TokenId)
-			model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
-			channelId := c.GetInt(ctxkey.ChannelId)
-			model.UpdateChannelUsedQuota(channelId, quota)
-		}
+		logContent := fmt.Sprintf("%s模型倍率 %.2f，分组倍率 %.2f", extendLog, modelRatio, groupRatio)
+		billing.PostConsumeQuotaAmount(ctx, meta, quota, preConsumedQuota, prompt, completion, videoRequest.Model, logContent)
 	}(c)
 
 	return nil
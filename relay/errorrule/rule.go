@@ -0,0 +1,259 @@
+package errorrule
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// actions of a rule
+const (
+	// ActionRetry retries another channel, the error counts as a failure of the channel
+	ActionRetry = "retry"
+	// ActionSleep puts the model of the channel to sleep for SleepSeconds (doubled on every sleep) and retries
+	ActionSleep = "sleep"
+	// ActionDisable disables the channel when automatic disabling is enabled and retries
+	ActionDisable = "disable"
+	// ActionFail returns the error to the client, it is not a failure of the channel
+	ActionFail = "fail"
+)
+
+// Rule classifies a relay error, every non empty condition must match, the first matching rule wins
+type Rule struct {
+	Name                string   `json:"name,omitempty"`
+	StatusCodes         []int    `json:"status_codes,omitempty"`
+	ChannelTypes        []int    `json:"channel_types,omitempty"`
+	ExcludeChannelTypes []int    `json:"exclude_channel_types,omitempty"`
+	Types               []string `json:"types,omitempty"`   // error.type
+	Codes               []string `json:"codes,omitempty"`   // error.code
+	Message             string   `json:"message,omitempty"` // case insensitive regexp on error.message
+	Action              string   `json:"action"`
+	SleepSeconds        int64    `json:"sleep_seconds,omitempty"`
+	// DisableAfter is the number of matches of a channel in a minute before the disable action applies, the channel is retried before
+	DisableAfter int64 `json:"disable_after,omitempty"`
+
+	message *regexp.Regexp
+}
+
+const disableWindowSeconds = 60
+
+const accountUnavailableMessage = `your access was terminated|violation of our policies|your credit balance is too low|` +
+	`you have reached your specified api usage limits|organization has been disabled|credit|balance|` +
+	`permission denied|organization has been restricted|已欠费|无效的令牌|` +
+	`quota exceeded for quota metric 'generate content api requests per minute'|` +
+	`api key not found\. please pass a valid api key|api key expired\. please renew the api key|` +
+	`generative language api has not been used`
+
+var DefaultRules = []*Rule{
+	{
+		// the quota of the user is checked before the request reaches the channel
+		Name:  "user quota",
+		Types: []string{"guoguo_api_error"},
+		Codes: []string{"insufficient_user_quota", "insufficient_organization_quota", "pre_consume_token_quota_failed",
+			"get_user_quota_failed", "decrease_user_quota_failed"},
+		Action: ActionFail,
+	},
+	{
+		Name:                "unauthorized",
+		StatusCodes:         []int{401},
+		ExcludeChannelTypes: []int{channeltype.Custom},
+		Action:              ActionDisable,
+	},
+	{
+		Name:   "invalid account",
+		Types:  []string{"insufficient_quota", "authentication_error", "permission_error", "forbidden"},
+		Action: ActionDisable,
+	},
+	{
+		Name:   "invalid api key",
+		Codes:  []string{"invalid_api_key", "account_deactivated"},
+		Action: ActionDisable,
+	},
+	{
+		Name:                "rate limited",
+		ExcludeChannelTypes: []int{channeltype.Custom},
+		Message:             `resource has been exhauste|e\.g\. check quota`,
+		Action:              ActionSleep,
+		SleepSeconds:        600,
+	},
+	{
+		// the channel test reports the errors with the status code -1
+		Name:         "gemini quota exceeded on test",
+		StatusCodes:  []int{-1},
+		ChannelTypes: []int{channeltype.Gemini},
+		Message:      `you exceeded your current quota`,
+		Action:       ActionDisable,
+	},
+	{
+		Name:         "gemini quota exceeded",
+		ChannelTypes: []int{channeltype.Gemini},
+		Message:      `you exceeded your current quota`,
+		Action:       ActionSleep,
+		SleepSeconds: 600,
+	},
+	{
+		// a custom channel is usually another relay which passes on the errors of its own channels
+		Name:         "custom channel account unavailable",
+		ChannelTypes: []int{channeltype.Custom},
+		Message:      accountUnavailableMessage,
+		Action:       ActionDisable,
+		DisableAfter: 10,
+	},
+	{
+		Name:                "account unavailable",
+		ExcludeChannelTypes: []int{channeltype.Custom},
+		Message:             accountUnavailableMessage,
+		Action:              ActionDisable,
+	},
+	{
+		// the other unauthorized errors of a custom channel are retried without disabling it
+		Name:         "custom channel unauthorized",
+		StatusCodes:  []int{401},
+		ChannelTypes: []int{channeltype.Custom},
+		Action:       ActionRetry,
+	},
+	{
+		Name:        "bad request",
+		StatusCodes: []int{400},
+		Action:      ActionFail,
+	},
+}
+
+var rules = DefaultRules
+var rulesLock sync.RWMutex
+
+func init() {
+	if err := compile(DefaultRules); err != nil {
+		panic(err)
+	}
+}
+
+func compile(rules []*Rule) error {
+	for i, rule := range rules {
+		switch rule.Action {
+		case ActionRetry, ActionDisable, ActionFail:
+		case ActionSleep:
+			if rule.SleepSeconds <= 0 {
+				return fmt.Errorf("rule %d: sleep_seconds must be positive", i)
+			}
+		default:
+			return fmt.Errorf("rule %d: unknown action %q", i, rule.Action)
+		}
+		if rule.DisableAfter < 0 || (rule.DisableAfter > 0 && rule.Action != ActionDisable) {
+			return fmt.Errorf("rule %d: disable_after must be positive and is only for the disable action", i)
+		}
+		if rule.Message != "" {
+			message, err := regexp.Compile("(?i)" + rule.Message)
+			if err != nil {
+				return fmt.Errorf("rule %d: %s", i, err.Error())
+			}
+			rule.message = message
+		}
+	}
+	return nil
+}
+
+func Rules2JSONString() string {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	jsonBytes, err := json.Marshal(rules)
+	if err != nil {
+		logger.SysError("error marshalling error rules: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// ParseRules parses and checks the rules saved in the ChannelErrorRules option
+func ParseRules(jsonStr string) ([]*Rule, error) {
+	var rules []*Rule
+	if err := json.Unmarshal([]byte(jsonStr), &rules); err != nil {
+		return nil, err
+	}
+	return rules, compile(rules)
+}
+
+func UpdateRulesByJSONString(jsonStr string) error {
+	newRules, err := ParseRules(jsonStr)
+	if err != nil {
+		return err
+	}
+	rulesLock.Lock()
+	rules = newRules
+	rulesLock.Unlock()
+	return nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value any) bool {
+	for _, v := range values {
+		if v == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *Rule) Match(channelType int, statusCode int, err *model.Error) bool {
+	if len(rule.StatusCodes) > 0 && !containsInt(rule.StatusCodes, statusCode) {
+		return false
+	}
+	if len(rule.ChannelTypes) > 0 && !containsInt(rule.ChannelTypes, channelType) {
+		return false
+	}
+	if containsInt(rule.ExcludeChannelTypes, channelType) {
+		return false
+	}
+	if len(rule.Types) > 0 && !containsString(rule.Types, err.Type) {
+		return false
+	}
+	if len(rule.Codes) > 0 && (err.Code == nil || !containsString(rule.Codes, err.Code)) {
+		return false
+	}
+	if rule.message != nil && !rule.message.MatchString(err.Message) {
+		return false
+	}
+	return true
+}
+
+// ShouldDisable counts a match of the disable rule for the channel and reports whether the channel should be disabled,
+// strikes is the number of matches in the window of a rule with disable_after, 0 for the other rules
+func (rule *Rule) ShouldDisable(channelId int) (disable bool, strikes int64) {
+	if rule.Action != ActionDisable {
+		return false, 0
+	}
+	if rule.DisableAfter <= 0 {
+		return true, 0
+	}
+	strikes, _, _ = common.WindowReserve(fmt.Sprintf("channel_fail:%d", channelId), 1, math.MaxInt64, disableWindowSeconds)
+	return strikes >= rule.DisableAfter, strikes
+}
+
+// Classify returns the first rule matching the error, or nil
+func Classify(channelType int, statusCode int, err *model.Error) *Rule {
+	if err == nil {
+		return nil
+	}
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	for _, rule := range rules {
+		if rule.Match(channelType, statusCode, err) {
+			return rule
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package errorrule
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyDefaultRules(t *testing.T) {
+	unauthorized := &model.Error{Message: "Incorrect API key provided"}
+	assert.Equal(t, ActionDisable, Classify(channeltype.OpenAI, 401, unauthorized).Action)
+	assert.Equal(t, ActionRetry, Classify(channeltype.Custom, 401, unauthorized).Action)
+	// the types, codes and messages of an invalid account disable a custom channel too
+	assert.Equal(t, ActionDisable, Classify(channeltype.Custom, 401, &model.Error{Code: "invalid_api_key"}).Action)
+	assert.Equal(t, ActionDisable, Classify(channeltype.Custom, 401, &model.Error{Type: "authentication_error"}).Action)
+	assert.Equal(t, "custom channel account unavailable", Classify(channeltype.Custom, 401, &model.Error{Message: "无效的令牌"}).Name)
+
+	assert.Equal(t, ActionDisable, Classify(channeltype.OpenAI, 429, &model.Error{Code: "invalid_api_key"}).Action)
+	assert.Equal(t, ActionDisable, Classify(channeltype.OpenAI, 403, &model.Error{Message: "Your Credit Balance is too low"}).Action)
+	assert.Equal(t, "custom channel account unavailable", Classify(channeltype.Custom, 403, &model.Error{Message: "Your Credit Balance is too low"}).Name)
+
+	sleep := Classify(channeltype.Gemini, 429, &model.Error{Message: "You exceeded your current quota"})
+	assert.Equal(t, ActionSleep, sleep.Action)
+	assert.Equal(t, int64(600), sleep.SleepSeconds)
+	// the channel test disables a gemini channel which exceeded its quota
+	assert.Equal(t, ActionDisable, Classify(channeltype.Gemini, -1, &model.Error{Message: "You exceeded your current quota"}).Action)
+
+	assert.Equal(t, ActionFail, Classify(channeltype.OpenAI, 400, &model.Error{Message: "invalid messages"}).Action)
+	// a user without quota is not a failure of the channel
+	assert.Equal(t, ActionFail, Classify(channeltype.OpenAI, 403, &model.Error{Type: "guoguo_api_error", Code: "insufficient_user_quota"}).Action)
+	assert.Equal(t, ActionFail, Classify(channeltype.Custom, 403, &model.Error{Type: "guoguo_api_error", Code: "insufficient_organization_quota"}).Action)
+	assert.Nil(t, Classify(channeltype.OpenAI, 500, &model.Error{Message: "internal error"}))
+}
+
+func TestDisableAfter(t *testing.T) {
+	common.RedisEnabled = false
+	err := &model.Error{Message: "Your Credit Balance is too low"}
+	rule := Classify(channeltype.Custom, 403, err)
+	for i := int64(1); i < 10; i++ {
+		disable, strikes := rule.ShouldDisable(1001)
+		assert.False(t, disable)
+		assert.Equal(t, i, strikes)
+	}
+	disable, strikes := rule.ShouldDisable(1001)
+	assert.True(t, disable)
+	assert.Equal(t, int64(10), strikes)
+	disable, _ = rule.ShouldDisable(1002)
+	assert.False(t, disable)
+	disable, strikes = Classify(channeltype.OpenAI, 403, err).ShouldDisable(1003)
+	assert.True(t, disable)
+	assert.Equal(t, int64(0), strikes)
+	disable, _ = Classify(channeltype.Gemini, 429, &model.Error{Message: "You exceeded your current quota"}).ShouldDisable(1003)
+	assert.False(t, disable)
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(`[{"status_codes":[503],"message":"overloaded","action":"sleep","sleep_seconds":30}]`)
+	assert.NoError(t, err)
+	assert.True(t, rules[0].Match(channeltype.Anthropic, 503, &model.Error{Message: "Overloaded"}))
+	assert.False(t, rules[0].Match(channeltype.Anthropic, 500, &model.Error{Message: "Overloaded"}))
+
+	_, err = ParseRules(`[{"action":"sleep"}]`)
+	assert.Error(t, err)
+	_, err = ParseRules(`[{"action":"explode"}]`)
+	assert.Error(t, err)
+	_, err = ParseRules(`[{"message":"(","action":"retry"}]`)
+	assert.Error(t, err)
+	_, err = ParseRules(`[{"action":"retry","disable_after":3}]`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,68 @@
+package guardrail
+
+// Filter applies a rule set to the text of one choice, the text may arrive in pieces.
+// The last runes are held back until the next piece, so that a match split across pieces is caught.
+type Filter struct {
+	set        *ruleSet
+	pending    string
+	violations map[string]int
+	stopped    string
+}
+
+func newFilter(set *ruleSet, violations map[string]int) *Filter {
+	return &Filter{set: set, violations: violations}
+}
+
+// Push adds a piece of text and returns the text which can be sent,
+// action is truncate or abort when a match ended the output
+func (f *Filter) Push(text string) (out string, action string) {
+	return f.process(f.pending+text, false)
+}
+
+// Flush returns the text held back at the end of the output
+func (f *Filter) Flush() (out string, action string) {
+	return f.process(f.pending, true)
+}
+
+func (f *Filter) process(text string, final bool) (string, string) {
+	f.pending = ""
+	if f.stopped != "" || text == "" {
+		return "", f.stopped
+	}
+	// the earliest match of a rule ending the output wins
+	stop := -1
+	var stopRule *compiledRule
+	for _, rule := range f.set.rules {
+		if rule.Action == ActionMask {
+			continue
+		}
+		if loc := rule.re.FindStringIndex(text); loc != nil && (stop < 0 || loc[0] < stop) {
+			stop, stopRule = loc[0], rule
+		}
+	}
+	if stopRule != nil {
+		text = text[:stop]
+		f.violations[stopRule.Name]++
+		f.stopped = stopRule.Action
+	}
+	for _, rule := range f.set.rules {
+		if rule.Action != ActionMask {
+			continue
+		}
+		if matches := rule.re.FindAllStringIndex(text, -1); len(matches) > 0 {
+			f.violations[rule.Name] += len(matches)
+			text = rule.re.ReplaceAllLiteralString(text, rule.Replacement)
+		}
+	}
+	if final || f.stopped != "" {
+		return text, f.stopped
+	}
+	// hold back the window, the cut must not split a character
+	runes := []rune(text)
+	if len(runes) <= f.set.window {
+		f.pending = text
+		return "", ""
+	}
+	f.pending = string(runes[len(runes)-f.set.window:])
+	return string(runes[:len(runes)-f.set.window]), ""
+}
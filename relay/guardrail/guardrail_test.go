@@ -0,0 +1,85 @@
+package guardrail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/relay/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRules = `{"test":[
+	{"name":"secret","keywords":["password"],"action":"mask"},
+	{"name":"card","pattern":"\\d{4}-\\d{4}","action":"truncate"},
+	{"name":"bomb","keywords":["bomb"],"action":"abort"}
+]}`
+
+func TestFilter(t *testing.T) {
+	assert.Nil(t, UpdateRuleSetsByJSONString(testRules))
+	violations := map[string]int{}
+	f := newFilter(getRuleSet("test"), violations)
+	var out strings.Builder
+	for _, piece := range []string{"my pass", "WORD is ", "fine"} {
+		text, action := f.Push(piece)
+		assert.Empty(t, action)
+		out.WriteString(text)
+	}
+	text, _ := f.Flush()
+	out.WriteString(text)
+	assert.Equal(t, "my *** is fine", out.String())
+	assert.Equal(t, 1, violations["secret"])
+
+	f = newFilter(getRuleSet("test"), violations)
+	text, action := f.process("card 1234-5678 here", true)
+	assert.Equal(t, ActionTruncate, action)
+	assert.Equal(t, "card ", text)
+
+	_, err := ParseRuleSets(`{"bad":[{"name":"x","keywords":["a"],"action":"drop"}]}`)
+	assert.NotNil(t, err)
+	assert.Nil(t, getRuleSet("missing"))
+}
+
+func newTestContext(contentType string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Header("Content-Type", contentType)
+	return c, recorder
+}
+
+func TestWriterStream(t *testing.T) {
+	assert.Nil(t, UpdateRuleSetsByJSONString(testRules))
+	c, recorder := newTestContext("text/event-stream")
+	writer := Start(c, "test")
+	chunks := []string{"Card 12", "34-", "5678 and more"}
+	for _, chunk := range chunks {
+		_, _ = c.Writer.WriteString("data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"" + chunk + "\"},\"finish_reason\":null}]}\n\n")
+	}
+	_, _ = c.Writer.WriteString("data: [DONE]\n\n")
+	writer.Finish(c)
+
+	body := recorder.Body.String()
+	assert.Equal(t, "Card ", audit.AssembleStream(body))
+	assert.Contains(t, body, `"finish_reason":"content_filter"`)
+	assert.Equal(t, 1, strings.Count(body, "[DONE]"))
+	assert.Equal(t, "card x 1", writer.Summary())
+}
+
+func TestWriterBody(t *testing.T) {
+	assert.Nil(t, UpdateRuleSetsByJSONString(testRules))
+	c, recorder := newTestContext("application/json")
+	writer := Start(c, "test")
+	c.JSON(http.StatusOK, gin.H{"choices": []gin.H{{"index": 0, "message": gin.H{"role": "assistant", "content": "the password"}}}})
+	writer.Finish(c)
+	assert.Contains(t, recorder.Body.String(), `"content":"the ***"`)
+
+	c, recorder = newTestContext("application/json")
+	writer = Start(c, "test")
+	c.JSON(http.StatusOK, gin.H{"choices": []gin.H{{"index": 0, "message": gin.H{"content": "a bomb"}}}})
+	writer.Finish(c)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "content_filter")
+}
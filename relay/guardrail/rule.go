@@ -0,0 +1,146 @@
+package guardrail
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// actions of a rule, truncate and abort end the output at the first match
+const (
+	ActionMask     = "mask"
+	ActionTruncate = "truncate"
+	ActionAbort    = "abort"
+)
+
+const defaultPatternWindow = 64
+
+// Rule matches the keywords, case insensitive, or the regular expression in the output of the model
+type Rule struct {
+	Name        string   `json:"name"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Action      string   `json:"action"`
+	Replacement string   `json:"replacement,omitempty"` // used by mask, *** by default
+}
+
+// RuleSets 是输出过滤规则集，令牌或分组通过名称选择规则集
+var RuleSets = map[string][]Rule{}
+
+type ruleSet struct {
+	rules  []*compiledRule
+	window int // runes held back so that a match across chunks is caught
+}
+
+type compiledRule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+var (
+	compiledRuleSets = map[string]*ruleSet{}
+	ruleSetsLock     sync.RWMutex
+)
+
+func compileRuleSet(rules []Rule) (*ruleSet, error) {
+	set := &ruleSet{}
+	for _, rule := range rules {
+		var pattern string
+		var window int
+		switch {
+		case rule.Pattern != "" && len(rule.Keywords) > 0:
+			return nil, fmt.Errorf("%s: keywords and pattern cannot be used together", rule.Name)
+		case rule.Pattern != "":
+			pattern = rule.Pattern
+			window = defaultPatternWindow
+		case len(rule.Keywords) > 0:
+			quoted := make([]string, 0, len(rule.Keywords))
+			for _, keyword := range rule.Keywords {
+				if keyword == "" {
+					return nil, fmt.Errorf("%s: empty keyword", rule.Name)
+				}
+				quoted = append(quoted, regexp.QuoteMeta(keyword))
+				window = max(window, utf8.RuneCountInString(keyword)-1)
+			}
+			pattern = "(?i)" + strings.Join(quoted, "|")
+		default:
+			return nil, fmt.Errorf("%s: keywords or pattern is required", rule.Name)
+		}
+		switch rule.Action {
+		case ActionMask:
+			if rule.Replacement == "" {
+				rule.Replacement = "***"
+			}
+		case ActionTruncate, ActionAbort:
+		default:
+			return nil, fmt.Errorf("%s: unknown action %q", rule.Name, rule.Action)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.Name, err)
+		}
+		set.rules = append(set.rules, &compiledRule{Rule: rule, re: re})
+		set.window = max(set.window, window)
+	}
+	return set, nil
+}
+
+// ParseRuleSets parses and checks the rule sets saved in the OutputFilterRules option
+func ParseRuleSets(jsonStr string) (map[string][]Rule, error) {
+	ruleSets := make(map[string][]Rule)
+	if err := json.Unmarshal([]byte(jsonStr), &ruleSets); err != nil {
+		return nil, err
+	}
+	for name, rules := range ruleSets {
+		if _, err := compileRuleSet(rules); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return ruleSets, nil
+}
+
+func RuleSets2JSONString() string {
+	ruleSetsLock.RLock()
+	defer ruleSetsLock.RUnlock()
+	jsonBytes, err := json.Marshal(RuleSets)
+	if err != nil {
+		logger.SysError("error marshalling output filter rules: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateRuleSetsByJSONString(jsonStr string) error {
+	ruleSets, err := ParseRuleSets(jsonStr)
+	if err != nil {
+		return err
+	}
+	compiled := make(map[string]*ruleSet, len(ruleSets))
+	for name, rules := range ruleSets {
+		compiled[name], _ = compileRuleSet(rules)
+	}
+	ruleSetsLock.Lock()
+	defer ruleSetsLock.Unlock()
+	RuleSets = ruleSets
+	compiledRuleSets = compiled
+	return nil
+}
+
+// HasRuleSet reports whether the rule set exists and has rules
+func HasRuleSet(name string) bool {
+	return getRuleSet(name) != nil
+}
+
+func getRuleSet(name string) *ruleSet {
+	ruleSetsLock.RLock()
+	defer ruleSetsLock.RUnlock()
+	set := compiledRuleSets[name]
+	if set == nil || len(set.rules) == 0 {
+		return nil
+	}
+	return set
+}
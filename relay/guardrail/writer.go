@@ -0,0 +1,360 @@
+package guardrail
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Writer filters the openai chat and completion responses written by the adaptors,
+// a stream is filtered event by event and any other response is filtered when it is finished
+type Writer struct {
+	gin.ResponseWriter
+	set        *ruleSet
+	filters    map[int]*Filter
+	violations map[string]int
+	buffer     bytes.Buffer
+	status     int
+	stream     bool
+	started    bool
+	done       bool // the output was ended by truncate or abort
+	lastChunk  map[string]any
+}
+
+// Start puts a Writer in front of the writer of the context, it returns nil when the rule set does not exist
+func Start(c *gin.Context, ruleSetName string) *Writer {
+	set := getRuleSet(ruleSetName)
+	if set == nil {
+		return nil
+	}
+	writer := &Writer{
+		ResponseWriter: c.Writer,
+		set:            set,
+		filters:        make(map[int]*Filter),
+		violations:     make(map[string]int),
+		status:         http.StatusOK,
+	}
+	c.Writer = writer
+	return writer
+}
+
+// Violations returns the number of matches of each rule
+func (w *Writer) Violations() map[string]int {
+	return w.violations
+}
+
+// Summary describes the violations, it is empty when there is none
+func (w *Writer) Summary() string {
+	names := make([]string, 0, len(w.violations))
+	for name := range w.violations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var summary strings.Builder
+	for _, name := range names {
+		if summary.Len() > 0 {
+			summary.WriteString(", ")
+		}
+		summary.WriteString(fmt.Sprintf("%s x %d", name, w.violations[name]))
+	}
+	return summary.String()
+}
+
+func (w *Writer) filter(index int) *Filter {
+	f, ok := w.filters[index]
+	if !ok {
+		f = newFilter(w.set, w.violations)
+		w.filters[index] = f
+	}
+	return f
+}
+
+func (w *Writer) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *Writer) WriteHeaderNow() {}
+
+// Flush passes through only for a stream, the other responses are written when they are finished
+func (w *Writer) Flush() {
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *Writer) Status() int {
+	return w.status
+}
+
+func (w *Writer) Written() bool {
+	return w.started || w.ResponseWriter.Written()
+}
+
+func (w *Writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.stream = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+		// the filtered body has another length
+		w.Header().Del("Content-Length")
+		if w.stream {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+	}
+	if w.done {
+		// the adaptor keeps reading the upstream for the usage, the rest is dropped
+		return len(data), nil
+	}
+	w.buffer.Write(data)
+	if !w.stream {
+		return len(data), nil
+	}
+	for {
+		event, ok := w.nextEvent()
+		if !ok {
+			break
+		}
+		if err := w.writeEvent(event); err != nil {
+			return 0, err
+		}
+		if w.done {
+			w.buffer.Reset()
+			break
+		}
+	}
+	return len(data), nil
+}
+
+func (w *Writer) nextEvent() (string, bool) {
+	data := w.buffer.Bytes()
+	end := bytes.Index(data, []byte("\n\n"))
+	if end < 0 {
+		return "", false
+	}
+	event := string(data[:end+2])
+	w.buffer.Next(end + 2)
+	return event, true
+}
+
+// Finish writes what is left in the buffer and restores the original writer of the context
+func (w *Writer) Finish(c *gin.Context) {
+	c.Writer = w.ResponseWriter
+	if !w.started {
+		if w.status != http.StatusOK {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+		return
+	}
+	if !w.stream {
+		w.finishBody()
+		return
+	}
+	if w.done {
+		return
+	}
+	if w.buffer.Len() > 0 {
+		_ = w.writeEvent(w.buffer.String())
+		w.buffer.Reset()
+	}
+	if !w.done {
+		_, _ = w.ResponseWriter.WriteString(w.flushAll())
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *Writer) finishBody() {
+	body := w.buffer.Bytes()
+	response, ok := decode(body)
+	if ok && w.status == http.StatusOK {
+		choices, _ := response["choices"].([]any)
+		for _, item := range choices {
+			choice, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			text, set := choiceText(choice, "message")
+			if set == nil {
+				continue
+			}
+			f := newFilter(w.set, w.violations)
+			out, action := f.process(text, true)
+			if action == ActionAbort {
+				body, _ = json.Marshal(abortError())
+				w.status = http.StatusBadRequest
+				break
+			}
+			set(out)
+			if action == ActionTruncate {
+				choice["finish_reason"] = "content_filter"
+			}
+		}
+		if w.status == http.StatusOK {
+			body, _ = json.Marshal(response)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+func (w *Writer) writeEvent(event string) error {
+	lines := strings.Split(event, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			_, err := w.ResponseWriter.WriteString(w.flushAll() + event)
+			return err
+		}
+		chunk, ok := decode([]byte(data))
+		if !ok {
+			break
+		}
+		replaced, action := w.filterChunk(chunk)
+		if !replaced {
+			break
+		}
+		jsonBytes, _ := json.Marshal(chunk)
+		lines[i] = "data: " + string(jsonBytes)
+		event = strings.Join(lines, "\n")
+		switch action {
+		case ActionTruncate:
+			event += "data: [DONE]\n\n"
+			w.done = true
+		case ActionAbort:
+			jsonBytes, _ = json.Marshal(abortError())
+			event = "data: " + string(jsonBytes) + "\n\ndata: [DONE]\n\n"
+			w.done = true
+		}
+		break
+	}
+	_, err := w.ResponseWriter.WriteString(event)
+	return err
+}
+
+// filterChunk filters the text deltas of the choices in a chunk of the stream
+func (w *Writer) filterChunk(chunk map[string]any) (replaced bool, action string) {
+	choices, _ := chunk["choices"].([]any)
+	for _, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		index := 0
+		if number, ok := choice["index"].(json.Number); ok {
+			if n, err := number.Int64(); err == nil {
+				index = int(n)
+			}
+		}
+		finished := choice["finish_reason"] != nil
+		text, set := choiceText(choice, "delta")
+		if set == nil && !finished {
+			continue
+		}
+		f := w.filter(index)
+		out, stop := f.Push(text)
+		if finished && stop == "" {
+			var rest string
+			rest, stop = f.Flush()
+			out += rest
+		}
+		if set == nil {
+			if out == "" && stop == "" {
+				continue
+			}
+			set = setDelta(choice)
+		}
+		set(out)
+		replaced = true
+		if stop != "" {
+			choice["finish_reason"] = "content_filter"
+			action = stop
+			break
+		}
+	}
+	if replaced {
+		w.lastChunk = chunk
+	}
+	return replaced, action
+}
+
+// flushAll returns an event with the text still held back, the stream ended without a finish reason
+func (w *Writer) flushAll() string {
+	if w.lastChunk == nil {
+		return ""
+	}
+	var choices []any
+	for index, f := range w.filters {
+		out, _ := f.Flush()
+		if out == "" {
+			continue
+		}
+		choices = append(choices, map[string]any{
+			"index":         index,
+			"delta":         map[string]any{"content": out},
+			"finish_reason": nil,
+		})
+	}
+	if len(choices) == 0 {
+		return ""
+	}
+	chunk := make(map[string]any, len(w.lastChunk))
+	for key, value := range w.lastChunk {
+		chunk[key] = value
+	}
+	chunk["choices"] = choices
+	jsonBytes, _ := json.Marshal(chunk)
+	return "data: " + string(jsonBytes) + "\n\n"
+}
+
+func decode(data []byte) (map[string]any, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value map[string]any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// choiceText returns the text of a chat choice in field, or of a completion choice, and a setter of it
+func choiceText(choice map[string]any, field string) (string, func(string)) {
+	if message, ok := choice[field].(map[string]any); ok {
+		if text, ok := message["content"].(string); ok {
+			return text, func(s string) { message["content"] = s }
+		}
+		return "", nil
+	}
+	if text, ok := choice["text"].(string); ok {
+		return text, func(s string) { choice["text"] = s }
+	}
+	return "", nil
+}
+
+func setDelta(choice map[string]any) func(string) {
+	delta, ok := choice["delta"].(map[string]any)
+	if !ok {
+		delta = map[string]any{}
+		choice["delta"] = delta
+	}
+	return func(s string) { delta["content"] = s }
+}
+
+func abortError() map[string]any {
+	return map[string]any{
+		"error": map[string]any{
+			"message": "the output was blocked by the content filter",
+			"type":    "one_api_error",
+			"code":    "content_filter",
+		},
+	}
+}
@@ -50,6 +50,22 @@ type Meta struct {
 	StartTime         time.Time
 	FirstResponseTime time.Time
 	Provider    string
+	// TpmLimit is the tokens per minute limit of the token, TpmReserved is the amount reserved in the window starting at TpmWindow
+	TpmLimit    int
+	TpmReserved int64
+	TpmWindow   int64
+	// ChannelTpmLimit is the tokens per minute limit of the channel, the usage is counted in the window of the channel
+	ChannelTpmLimit int
+	// DiscountRatio is applied on top of the model and group ratios, 0 means no discount
+	DiscountRatio float64
+	// CacheHit means the response is replayed from the response cache, it is billed at the cache ratio
+	CacheHit bool
+	// ReservationId is the quota reservation of the request, settled or released exactly once
+	ReservationId string
+	// ChannelKeyId is the key of a multi key channel, 0 for a single key channel
+	ChannelKeyId int
+	// OrgId is the organization whose pool pays for the request, 0 means the user pays
+	OrgId int
 }
 
 func GetByContext(c *gin.Context) *Meta {
@@ -71,6 +87,11 @@ func GetByContext(c *gin.Context) *Meta {
 		CalcPrompt:        c.GetBool(ctxkey.CalcPrompt),
 		StartTime:         c.GetTime(ctxkey.RequestStartTime),
 		FirstResponseTime: c.GetTime(ctxkey.RequestStartTime).Add(-time.Second),
+		TpmLimit:          c.GetInt(ctxkey.TpmLimit),
+		ChannelTpmLimit:   c.GetInt(ctxkey.ChannelTpmLimit),
+		DiscountRatio:     c.GetFloat64(ctxkey.DiscountRatio),
+		ChannelKeyId:      c.GetInt(ctxkey.ChannelKeyId),
+		OrgId:             c.GetInt(ctxkey.OrgId),
 	}
 	cfg, ok := c.Get(ctxkey.Config)
 	if ok {
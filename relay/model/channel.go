@@ -1,13 +1,67 @@
 package model
 
 type ChannelConfig struct {
-	Region            string `json:"region,omitempty"`
-	SK                string `json:"sk,omitempty"`
-	AK                string `json:"ak,omitempty"`
-	UserID            string `json:"user_id,omitempty"`
-	APIVersion        string `json:"api_version,omitempty"`
-	LibraryID         string `json:"library_id,omitempty"`
-	Plugin            string `json:"plugin,omitempty"`
-	VertexAIProjectID string `json:"vertex_ai_project_id,omitempty"`
-	VertexAIADC       string `json:"vertex_ai_adc,omitempty"`
+	Region            string        `json:"region,omitempty"`
+	SK                string        `json:"sk,omitempty"`
+	AK                string        `json:"ak,omitempty"`
+	UserID            string        `json:"user_id,omitempty"`
+	APIVersion        string        `json:"api_version,omitempty"`
+	LibraryID         string        `json:"library_id,omitempty"`
+	Plugin            string        `json:"plugin,omitempty"`
+	VertexAIProjectID string        `json:"vertex_ai_project_id,omitempty"`
+	VertexAIADC       string        `json:"vertex_ai_adc,omitempty"`
+	ProxyBilling      *ProxyBilling `json:"proxy_billing,omitempty"`
+	Transform         *Transform    `json:"transform,omitempty"`
+}
+
+const (
+	ProxyBillingPerRequest = "per_request"
+	ProxyBillingPerByte    = "per_byte"
+	ProxyBillingUsage      = "usage"
+)
+
+// ProxyBilling is the pricing rule of a proxy channel, prices are in quota and multiplied by the group ratio
+type ProxyBilling struct {
+	Mode string `json:"mode"`
+	// RequestPrice is charged for every successful request, whatever the mode
+	RequestPrice float64 `json:"request_price,omitempty"`
+	// per_byte: the request and response bodies are charged separately
+	RequestBytePrice  float64 `json:"request_byte_price,omitempty"`
+	ResponseBytePrice float64 `json:"response_byte_price,omitempty"`
+	// usage: dotted paths of the token counts in the upstream response (or in any event of a stream),
+	// such as usage.prompt_tokens, the tokens are charged with the ratios of Model
+	PromptTokensPath     string `json:"prompt_tokens_path,omitempty"`
+	CompletionTokensPath string `json:"completion_tokens_path,omitempty"`
+	Model                string `json:"model,omitempty"`
+}
+
+const (
+	TransformSet    = "set"
+	TransformRemove = "remove"
+	TransformRename = "rename"
+	TransformClamp  = "clamp"
+)
+
+// Transform rewrites the requests sent to a channel and the responses of it
+type Transform struct {
+	Request  []TransformOp     `json:"request,omitempty"`
+	Headers  *TransformHeaders `json:"headers,omitempty"`
+	Response []TransformOp     `json:"response,omitempty"`
+}
+
+// TransformOp changes the json value at a dotted path such as stream_options.include_usage,
+// array elements are selected by index or all of them by *: messages.*.name
+type TransformOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"` // set
+	To    string `json:"to,omitempty"`    // rename: the new key in the same object
+	// clamp: the bounds of a number, a missing bound is not checked
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+type TransformHeaders struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
 }
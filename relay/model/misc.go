@@ -6,8 +6,44 @@ type Usage struct {
 	InputTokens      int `json:"input_tokens,omitempty"`
 	OutputTokens     int `json:"output_tokens,omitempty"`
 	ThoughtsTokens   int `json:"thoughts_tokens,omitempty"`
-	VideoTokens      int `json:"video_tokens"`
+	VideoTokens      int `json:"video_tokens"`          // seconds of generated video
+	ImageCount       int `json:"image_count,omitempty"` // number of generated images
 	TotalTokens      int `json:"total_tokens"`
+	// the details are parts of the prompt and completion tokens which are billed at their own ratios
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+	// the responses and images apis name the details after input_tokens and output_tokens
+	InputTokensDetails  *PromptTokensDetails     `json:"input_tokens_details,omitempty"`
+	OutputTokensDetails *CompletionTokensDetails `json:"output_tokens_details,omitempty"`
+}
+
+// GetPromptTokensDetails returns the details of the prompt tokens, or nil
+func (u *Usage) GetPromptTokensDetails() *PromptTokensDetails {
+	if u.PromptTokensDetails != nil {
+		return u.PromptTokensDetails
+	}
+	return u.InputTokensDetails
+}
+
+// GetCompletionTokensDetails returns the details of the completion tokens, or nil
+func (u *Usage) GetCompletionTokensDetails() *CompletionTokensDetails {
+	if u.CompletionTokensDetails != nil {
+		return u.CompletionTokensDetails
+	}
+	return u.OutputTokensDetails
+}
+
+type PromptTokensDetails struct {
+	CachedTokens        int `json:"cached_tokens"`
+	CacheCreationTokens int `json:"cache_creation_tokens,omitempty"` // claude cache writes
+	AudioTokens         int `json:"audio_tokens"`
+	ImageTokens         int `json:"image_tokens,omitempty"`
+}
+
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+	AudioTokens     int `json:"audio_tokens"`
+	ImageTokens     int `json:"image_tokens,omitempty"`
 }
 
 type Error struct {
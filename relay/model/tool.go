@@ -2,7 +2,8 @@ package model
 
 type Tool struct {
 	Id       string   `json:"id,omitempty"`
-	Type     string   `json:"type,omitempty"` // when splicing claude tools stream messages, it is empty
+	Type     string   `json:"type,omitempty"`  // when splicing claude tools stream messages, it is empty
+	Index    *int     `json:"index,omitempty"` // only in stream deltas
 	Function Function `json:"function"`
 }
 
@@ -0,0 +1,151 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Thresholds 是各分类的分数阈值，超过阈值即违规，为空时使用上游返回的 flagged
+var Thresholds = map[string]float64{}
+
+func Thresholds2JSONString() string {
+	jsonBytes, err := json.Marshal(Thresholds)
+	if err != nil {
+		logger.SysError("error marshalling moderation thresholds: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// ParseThresholds parses and checks the thresholds saved in the ModerationThresholds option
+func ParseThresholds(jsonStr string) (map[string]float64, error) {
+	thresholds := make(map[string]float64)
+	if err := json.Unmarshal([]byte(jsonStr), &thresholds); err != nil {
+		return nil, err
+	}
+	for category, threshold := range thresholds {
+		if threshold < 0 || threshold > 1 {
+			return nil, fmt.Errorf("%s: threshold must be between 0 and 1", category)
+		}
+	}
+	return thresholds, nil
+}
+
+func UpdateThresholdsByJSONString(jsonStr string) error {
+	thresholds, err := ParseThresholds(jsonStr)
+	if err != nil {
+		return err
+	}
+	Thresholds = thresholds
+	return nil
+}
+
+type Request struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type Result struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+type Response struct {
+	Id      string   `json:"id"`
+	Model   string   `json:"model"`
+	Results []Result `json:"results"`
+	Error   *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Verdict is the decision on all the inputs of a request
+type Verdict struct {
+	Flagged    bool
+	Categories []string           // violated categories
+	Scores     map[string]float64 // highest score of each violated category
+}
+
+func (v *Verdict) String() string {
+	if !v.Flagged {
+		return "passed"
+	}
+	parts := make([]string, 0, len(v.Categories))
+	for _, category := range v.Categories {
+		parts = append(parts, fmt.Sprintf("%s(%.2f)", category, v.Scores[category]))
+	}
+	return "flagged: " + strings.Join(parts, ", ")
+}
+
+// Judge applies the thresholds to the results, the categories without a threshold follow the upstream
+func Judge(results []Result, thresholds map[string]float64) *Verdict {
+	verdict := &Verdict{Scores: make(map[string]float64)}
+	violate := func(category string, score float64) {
+		if _, ok := verdict.Scores[category]; !ok {
+			verdict.Categories = append(verdict.Categories, category)
+		}
+		if score > verdict.Scores[category] {
+			verdict.Scores[category] = score
+		}
+	}
+	for _, result := range results {
+		if len(thresholds) == 0 {
+			for category, flagged := range result.Categories {
+				if flagged {
+					violate(category, result.CategoryScores[category])
+				}
+			}
+			if result.Flagged && len(verdict.Categories) == 0 {
+				violate("flagged", 1)
+			}
+			continue
+		}
+		for category, score := range result.CategoryScores {
+			threshold, ok := thresholds[category]
+			if (ok && score >= threshold) || (!ok && result.Categories[category]) {
+				violate(category, score)
+			}
+		}
+	}
+	sort.Strings(verdict.Categories)
+	verdict.Flagged = len(verdict.Categories) > 0
+	return verdict
+}
+
+// Moderate sends the inputs to the moderation endpoint of an openai compatible channel
+func Moderate(ctx context.Context, baseURL string, key string, modelName string, input []string) (*Verdict, error) {
+	jsonData, err := json.Marshal(Request{Model: modelName, Input: input})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/v1/moderations", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := client.ImpatientHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var response Response
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("moderation response: %w", err)
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("moderation error: %s", response.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation status code: %d", resp.StatusCode)
+	}
+	return Judge(response.Results, Thresholds), nil
+}
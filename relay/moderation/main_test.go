@@ -0,0 +1,50 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJudge(t *testing.T) {
+	results := []Result{
+		{Flagged: false, Categories: map[string]bool{"violence": false}, CategoryScores: map[string]float64{"violence": 0.3, "hate": 0.01}},
+		{Flagged: true, Categories: map[string]bool{"hate": true}, CategoryScores: map[string]float64{"violence": 0.1, "hate": 0.9}},
+	}
+	verdict := Judge(results, nil)
+	assert.True(t, verdict.Flagged)
+	assert.Equal(t, []string{"hate"}, verdict.Categories)
+	assert.Equal(t, "flagged: hate(0.90)", verdict.String())
+
+	// a threshold replaces the decision of the upstream for its category
+	verdict = Judge(results, map[string]float64{"violence": 0.2, "hate": 0.95})
+	assert.Equal(t, []string{"violence"}, verdict.Categories)
+	assert.Equal(t, 0.3, verdict.Scores["violence"])
+
+	assert.False(t, Judge(results[:1], nil).Flagged)
+	_, err := ParseThresholds(`{"violence": 1.5}`)
+	assert.NotNil(t, err)
+}
+
+func TestModerate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/moderations", r.URL.Path)
+		assert.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"))
+		var request Request
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, []string{"hello"}, request.Input)
+		_ = json.NewEncoder(w).Encode(Response{Results: []Result{{Flagged: true, Categories: map[string]bool{"harassment": true}, CategoryScores: map[string]float64{"harassment": 0.8}}}})
+	}))
+	defer server.Close()
+	client.ImpatientHTTPClient = server.Client()
+
+	verdict, err := Moderate(context.Background(), server.URL+"/", "sk-test", "omni-moderation-latest", []string{"hello"})
+	assert.Nil(t, err)
+	assert.True(t, verdict.Flagged)
+	assert.Equal(t, []string{"harassment"}, verdict.Categories)
+}
@@ -15,4 +15,10 @@ const (
 	Proxy
 	ImagesEdit
 	VideoGenerations
+	// AnthropicMessages is the claude-native /v1/messages endpoint
+	AnthropicMessages
+	// GeminiGenerateContent is the gemini-native /v1beta/models/{model}:generateContent endpoint
+	GeminiGenerateContent
+	// Responses is the openai /v1/responses endpoint
+	Responses
 )
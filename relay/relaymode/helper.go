@@ -28,6 +28,12 @@ func GetByPath(path string) int {
 		relayMode = AudioTranscription
 	} else if strings.HasPrefix(path, "/v1/audio/translations") {
 		relayMode = AudioTranslation
+	} else if strings.HasPrefix(path, "/v1/messages") {
+		relayMode = AnthropicMessages
+	} else if strings.HasPrefix(path, "/v1/responses") {
+		relayMode = Responses
+	} else if strings.HasPrefix(path, "/v1beta/models/") {
+		relayMode = GeminiGenerateContent
 	} else if strings.HasPrefix(path, "/v1/oneapi/proxy") {
 		relayMode = Proxy
 	}
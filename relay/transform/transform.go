@@ -0,0 +1,160 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// Validate checks the operations of the rules
+func Validate(rules *model.Transform) error {
+	if rules == nil {
+		return nil
+	}
+	for _, ops := range [][]model.TransformOp{rules.Request, rules.Response} {
+		for _, op := range ops {
+			if err := validateOp(op); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateOp(op model.TransformOp) error {
+	if op.Path == "" {
+		return fmt.Errorf("%s: path is required", op.Op)
+	}
+	switch op.Op {
+	case model.TransformSet, model.TransformRemove:
+	case model.TransformRename:
+		if op.To == "" || strings.Contains(op.To, ".") {
+			return fmt.Errorf("rename %s: to must be a key", op.Path)
+		}
+	case model.TransformClamp:
+		if op.Min == nil && op.Max == nil {
+			return fmt.Errorf("clamp %s: min or max is required", op.Path)
+		}
+	default:
+		return fmt.Errorf("unknown op %q", op.Op)
+	}
+	return nil
+}
+
+// Apply runs the operations on a json body, a body which is not a json object is returned as it is
+func Apply(ops []model.TransformOp, body []byte) []byte {
+	if len(ops) == 0 {
+		return body
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var data map[string]any
+	if err := decoder.Decode(&data); err != nil {
+		return body
+	}
+	for _, op := range ops {
+		applyOp(data, strings.Split(op.Path, "."), op)
+	}
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return body
+	}
+	return jsonBytes
+}
+
+// ApplyHeaders sets and removes the headers of an upstream request
+func ApplyHeaders(headers *model.TransformHeaders, header http.Header) {
+	if headers == nil {
+		return
+	}
+	for _, key := range headers.Remove {
+		header.Del(key)
+	}
+	for key, value := range headers.Set {
+		header.Set(key, value)
+	}
+}
+
+// applyOp walks the path down to the parent of the last key, creating the objects missing for set
+func applyOp(node any, keys []string, op model.TransformOp) {
+	key := keys[0]
+	last := len(keys) == 1
+	switch n := node.(type) {
+	case map[string]any:
+		if last {
+			applyToObject(n, key, op)
+			return
+		}
+		child, ok := n[key]
+		if !ok {
+			if op.Op != model.TransformSet {
+				return
+			}
+			child = map[string]any{}
+			n[key] = child
+		}
+		applyOp(child, keys[1:], op)
+	case []any:
+		for i := range n {
+			if key != "*" && key != strconv.Itoa(i) {
+				continue
+			}
+			if last {
+				if op.Op == model.TransformSet {
+					n[i] = op.Value
+				} else if op.Op == model.TransformClamp {
+					n[i] = clamp(n[i], op)
+				}
+				continue
+			}
+			applyOp(n[i], keys[1:], op)
+		}
+	}
+}
+
+func applyToObject(object map[string]any, key string, op model.TransformOp) {
+	value, ok := object[key]
+	switch op.Op {
+	case model.TransformSet:
+		object[key] = op.Value
+	case model.TransformRemove:
+		delete(object, key)
+	case model.TransformRename:
+		if ok {
+			delete(object, key)
+			object[op.To] = value
+		}
+	case model.TransformClamp:
+		if ok {
+			object[key] = clamp(value, op)
+		}
+	}
+}
+
+func clamp(value any, op model.TransformOp) any {
+	var number float64
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return value
+		}
+		number = f
+	case float64:
+		number = v
+	default:
+		return value
+	}
+	if op.Min != nil && number < *op.Min {
+		return *op.Min
+	}
+	if op.Max != nil && number > *op.Max {
+		return *op.Max
+	}
+	return value
+}
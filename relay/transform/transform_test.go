@@ -0,0 +1,64 @@
+package transform
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRules(t *testing.T) *model.Transform {
+	rules := &model.Transform{}
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"request": [
+			{"op":"remove","path":"parallel_tool_calls"},
+			{"op":"rename","path":"max_tokens","to":"max_completion_tokens"},
+			{"op":"clamp","path":"temperature","min":0,"max":1},
+			{"op":"set","path":"stream_options.include_usage","value":true},
+			{"op":"remove","path":"messages.*.name"}
+		],
+		"headers": {"set":{"X-Region":"eu"},"remove":["Accept"]},
+		"response": [{"op":"set","path":"model","value":"my-model"}]
+	}`), rules))
+	assert.Nil(t, Validate(rules))
+	return rules
+}
+
+func TestApply(t *testing.T) {
+	rules := testRules(t)
+	body := Apply(rules.Request, []byte(`{"model":"gpt-4o","parallel_tool_calls":true,"max_tokens":100,"temperature":1.7,"messages":[{"role":"user","name":"a","content":"hi"}]}`))
+	assert.JSONEq(t, `{"model":"gpt-4o","max_completion_tokens":100,"temperature":1,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`, string(body))
+	// a body which is not a json object is kept
+	assert.Equal(t, "not json", string(Apply(rules.Request, []byte("not json"))))
+
+	header := http.Header{"Accept": {"text/event-stream"}}
+	ApplyHeaders(rules.Headers, header)
+	assert.Equal(t, "", header.Get("Accept"))
+	assert.Equal(t, "eu", header.Get("X-Region"))
+
+	assert.NotNil(t, Validate(&model.Transform{Request: []model.TransformOp{{Op: "rename", Path: "a", To: "b.c"}}}))
+	assert.NotNil(t, Validate(&model.Transform{Request: []model.TransformOp{{Op: "drop", Path: "a"}}}))
+}
+
+func TestWriter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Header("Content-Type", "text/event-stream")
+	writer := Start(c, testRules(t))
+	_, _ = c.Writer.WriteString("data: {\"model\":\"gpt-4o\",\"choices\":[]}\n\ndata: [DO")
+	_, _ = c.Writer.WriteString("NE]\n\n")
+	writer.Finish(c)
+	assert.Equal(t, "data: {\"choices\":[],\"model\":\"my-model\"}\n\ndata: [DONE]\n\n", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(recorder)
+	writer = Start(c, testRules(t))
+	c.JSON(http.StatusOK, gin.H{"model": "gpt-4o"})
+	writer.Finish(c)
+	assert.JSONEq(t, `{"model":"my-model"}`, recorder.Body.String())
+}
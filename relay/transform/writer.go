@@ -0,0 +1,119 @@
+package transform
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// Writer rewrites the responses written by the adaptors, the data lines of a stream are rewritten one by one
+// and any other response is rewritten when it is finished
+type Writer struct {
+	gin.ResponseWriter
+	ops     []model.TransformOp
+	buffer  bytes.Buffer
+	status  int
+	stream  bool
+	started bool
+}
+
+// Start puts a Writer in front of the writer of the context, it returns nil when there is no response rule
+func Start(c *gin.Context, rules *model.Transform) *Writer {
+	if rules == nil || len(rules.Response) == 0 {
+		return nil
+	}
+	writer := &Writer{ResponseWriter: c.Writer, ops: rules.Response, status: http.StatusOK}
+	c.Writer = writer
+	return writer
+}
+
+func (w *Writer) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *Writer) WriteHeaderNow() {}
+
+// Flush passes through only for a stream, the other responses are written when they are finished
+func (w *Writer) Flush() {
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *Writer) Status() int {
+	return w.status
+}
+
+func (w *Writer) Written() bool {
+	return w.started || w.ResponseWriter.Written()
+}
+
+func (w *Writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.stream = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+		// the rewritten body has another length
+		w.Header().Del("Content-Length")
+		if w.stream {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+	}
+	w.buffer.Write(data)
+	if !w.stream {
+		return len(data), nil
+	}
+	for {
+		end := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if end < 0 {
+			// keep the incomplete line for the next write
+			break
+		}
+		line := string(w.buffer.Next(end + 1))
+		if _, err := w.ResponseWriter.WriteString(w.rewriteLine(line)); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *Writer) rewriteLine(line string) string {
+	data, ok := strings.CutPrefix(line, "data:")
+	if !ok {
+		return line
+	}
+	data = strings.TrimSpace(data)
+	if !strings.HasPrefix(data, "{") {
+		return line
+	}
+	return "data: " + string(Apply(w.ops, []byte(data))) + "\n"
+}
+
+// Finish writes what is left in the buffer and restores the original writer of the context
+func (w *Writer) Finish(c *gin.Context) {
+	c.Writer = w.ResponseWriter
+	if !w.started {
+		if w.status != http.StatusOK {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+		return
+	}
+	if w.stream {
+		if w.buffer.Len() > 0 {
+			_, _ = w.ResponseWriter.WriteString(w.rewriteLine(w.buffer.String()))
+		}
+		return
+	}
+	body := w.buffer.Bytes()
+	if w.status/100 == 2 {
+		body = Apply(w.ops, body)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(body)
+}
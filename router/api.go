@@ -48,6 +48,8 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.GET("/aff", controller.GetAffCode)
 				selfRoute.POST("/topup", controller.TopUp)
 				selfRoute.GET("/available_models", controller.GetUserAvailableModels)
+				selfRoute.GET("/self/ledger", controller.GetSelfLedger)
+				selfRoute.GET("/self/budget", controller.GetSelfBudget)
 			}
 
 			adminRoute := userRoute.Group("/")
@@ -55,11 +57,15 @@ func SetApiRouter(router *gin.Engine) {
 			{
 				adminRoute.GET("/", controller.GetAllUsers)
 				adminRoute.GET("/search", controller.SearchUsers)
+				adminRoute.GET("/ledger/check", controller.CheckQuotaLedger)
 				adminRoute.GET("/:id", controller.GetUser)
 				adminRoute.POST("/", controller.CreateUser)
 				adminRoute.POST("/manage", controller.ManageUser)
 				adminRoute.PUT("/", controller.UpdateUser)
 				adminRoute.DELETE("/:id", controller.DeleteUser)
+				adminRoute.GET("/:id/budget", controller.GetUserBudget)
+				adminRoute.PUT("/:id/budget", controller.UpdateUserBudget)
+				adminRoute.DELETE("/:id/budget", controller.DeleteUserBudget)
 			}
 		}
 		optionRoute := apiRouter.Group("/option")
@@ -67,6 +73,7 @@ func SetApiRouter(router *gin.Engine) {
 		{
 			optionRoute.GET("/", controller.GetOptions)
 			optionRoute.PUT("/", controller.UpdateOption)
+			optionRoute.POST("/notify/test", controller.TestNotifyTarget)
 		}
 		channelRoute := apiRouter.Group("/channel")
 		channelRoute.Use(middleware.AdminAuth())
@@ -82,8 +89,18 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/", controller.AddChannel)
 			channelRoute.PUT("/", controller.UpdateChannel)
 			channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
+			channelRoute.GET("/sleep", controller.GetChannelSleeps)
+			channelRoute.DELETE("/sleep", controller.ClearChannelSleeps)
+			channelRoute.GET("/breaker", controller.GetChannelBreakers)
+			channelRoute.DELETE("/breaker", controller.ResetChannelBreakers)
 			channelRoute.DELETE("/:id", controller.DeleteChannel)
 			channelRoute.POST("/update_abilities", controller.UpdateChannelsAbilities)
+			channelRoute.POST("/transform/:id", controller.TransformChannelRequest)
+			channelRoute.GET("/keys/:id", controller.GetChannelKeys)
+			channelRoute.POST("/keys/:id", controller.AddChannelKeys)
+			channelRoute.PUT("/keys/:id/:key_id", controller.UpdateChannelKey)
+			channelRoute.DELETE("/keys/:id/:key_id", controller.DeleteChannelKey)
+			channelRoute.GET("/reveal/:id", middleware.RootAuth(), controller.RevealChannelSecrets)
 		}
 		tokenRoute := apiRouter.Group("/token")
 		tokenRoute.Use(middleware.UserAuth())
@@ -94,8 +111,29 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
+			tokenRoute.GET("/:id/budget", controller.GetTokenBudget)
+			tokenRoute.PUT("/:id/budget", controller.UpdateTokenBudget)
+			tokenRoute.DELETE("/:id/budget", controller.DeleteTokenBudget)
 			tokenRoute.POST("/alert", controller.TokenAlert)
 		}
+		orgRoute := apiRouter.Group("/org")
+		orgRoute.Use(middleware.UserAuth())
+		{
+			orgRoute.GET("/", controller.GetOrganizations)
+			orgRoute.POST("/", controller.CreateOrganization)
+			orgRoute.POST("/invite/accept", controller.AcceptOrganizationInvitation)
+			orgRoute.GET("/:id", controller.GetOrganization)
+			orgRoute.PUT("/:id", controller.UpdateOrganization)
+			orgRoute.DELETE("/:id", controller.DeleteOrganization)
+			orgRoute.GET("/:id/member", controller.GetOrganizationMembers)
+			orgRoute.PUT("/:id/member", controller.UpdateOrganizationMember)
+			orgRoute.DELETE("/:id/member/:user_id", controller.RemoveOrganizationMember)
+			orgRoute.GET("/:id/invite", controller.GetOrganizationInvitations)
+			orgRoute.POST("/:id/invite", controller.InviteOrganizationMember)
+			orgRoute.DELETE("/:id/invite/:invitation_id", controller.RevokeOrganizationInvitation)
+			orgRoute.POST("/:id/quota", controller.TransferOrganizationQuota)
+			orgRoute.PUT("/:id/quota", middleware.AdminAuth(), controller.AdjustOrganizationQuota)
+		}
 		redemptionRoute := apiRouter.Group("/redemption")
 		redemptionRoute.Use(middleware.AdminAuth())
 		{
@@ -111,7 +149,10 @@ func SetApiRouter(router *gin.Engine) {
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
+		logRoute.GET("/org/:id", middleware.UserAuth(), controller.GetOrgLogs)
+		logRoute.GET("/org/:id/stat", middleware.UserAuth(), controller.GetLogsOrgStat)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
+		logRoute.GET("/audit/:request_id", middleware.AdminAuth(), controller.GetAuditCaptures)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
 		groupRoute := apiRouter.Group("/group")
@@ -17,12 +17,39 @@ func SetRelayRouter(router *gin.Engine) {
 		modelsRouter.GET("", controller.ListModels)
 		modelsRouter.GET("/:model", controller.RetrieveModel)
 	}
+	// stored responses are served from the database, no channel is needed
+	responsesRouter := router.Group("/v1/responses")
+	responsesRouter.Use(middleware.TokenAuth(), middleware.RalayRPMRateLimit())
+	{
+		responsesRouter.GET("/:id", controller.GetResponse)
+		responsesRouter.DELETE("/:id", controller.DeleteResponse)
+	}
+	// files and batches are served from the database, the batch requests are relayed later by the batch worker
+	filesRouter := router.Group("/v1/files")
+	filesRouter.Use(middleware.TokenAuth(), middleware.RalayRPMRateLimit())
+	{
+		filesRouter.GET("", controller.ListFiles)
+		filesRouter.POST("", controller.UploadFile)
+		filesRouter.GET("/:id", controller.RetrieveFile)
+		filesRouter.DELETE("/:id", controller.DeleteFile)
+		filesRouter.GET("/:id/content", controller.RetrieveFileContent)
+	}
+	batchesRouter := router.Group("/v1/batches")
+	batchesRouter.Use(middleware.TokenAuth(), middleware.RalayRPMRateLimit())
+	{
+		batchesRouter.GET("", controller.ListBatches)
+		batchesRouter.POST("", controller.CreateBatch)
+		batchesRouter.GET("/:id", controller.RetrieveBatch)
+		batchesRouter.POST("/:id/cancel", controller.CancelBatch)
+	}
 	relayV1Router := router.Group("/v1")
-	relayV1Router.Use(middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.RalayRPMRateLimit(), middleware.Distribute())
+	relayV1Router.Use(middleware.RelayPanicRecover(), middleware.Tracing(), middleware.TokenAuth(), middleware.RalayRPMRateLimit(), middleware.RelayDPMRateLimit(), middleware.Distribute())
 	{
 		relayV1Router.Any("/oneapi/proxy/:channelid/*target", controller.Relay)
 		relayV1Router.POST("/completions", controller.Relay)
 		relayV1Router.POST("/chat/completions", controller.Relay)
+		relayV1Router.POST("/messages", controller.Relay)
+		relayV1Router.POST("/responses", controller.Relay)
 		relayV1Router.POST("/edits", controller.Relay)
 		relayV1Router.POST("/images/generations", controller.Relay)
 		relayV1Router.POST("/video/generations", controller.Relay)
@@ -33,11 +60,6 @@ func SetRelayRouter(router *gin.Engine) {
 		relayV1Router.POST("/audio/transcriptions", controller.Relay)
 		relayV1Router.POST("/audio/translations", controller.Relay)
 		relayV1Router.POST("/audio/speech", controller.Relay)
-		relayV1Router.GET("/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/files", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id/content", controller.RelayNotImplemented)
 		relayV1Router.POST("/fine_tuning/jobs", controller.RelayNotImplemented)
 		relayV1Router.GET("/fine_tuning/jobs", controller.RelayNotImplemented)
 		relayV1Router.GET("/fine_tuning/jobs/:id", controller.RelayNotImplemented)
@@ -72,4 +94,10 @@ func SetRelayRouter(router *gin.Engine) {
 		relayV1Router.GET("/threads/:id/runs/:runsId/steps/:stepId", controller.RelayNotImplemented)
 		relayV1Router.GET("/threads/:id/runs/:runsId/steps", controller.RelayNotImplemented)
 	}
+	// https://ai.google.dev/api/generate-content
+	relayV1BetaRouter := router.Group("/v1beta")
+	relayV1BetaRouter.Use(middleware.RelayPanicRecover(), middleware.Tracing(), middleware.TokenAuth(), middleware.RalayRPMRateLimit(), middleware.RelayDPMRateLimit(), middleware.Distribute())
+	{
+		relayV1BetaRouter.POST("/models/*action", controller.Relay)
+	}
 }
@@ -29,7 +29,7 @@ func RenderMessage(msg string, id string) string {
 			}
 			subject := fmt.Sprintf("渠道id[%d] 返回错误: %s", channelId, prefix)
 			content := fmt.Sprintf("渠道id[%d] 返回错误: %s", channelId, msg)
-			message.SendMailToAdmin(subject, content)
+			message.NotifyAdmin(message.EventChannelError, subject, content, map[string]any{"channel_id": channelId})
 			//3. 消息脱敏
 			pattern := `".+?"\s*:\s*dial tcp\s+[\d\.]+:\d+`
 			re := regexp.MustCompile(pattern)
@@ -48,6 +48,10 @@ const OperationSetting = () => {
         if (item.value === '{}') {
           item.value = '';
         }
+        if (item.key === 'PoolMode' && item.value === 'polling') {
+          // polling is the former name of round_robin
+          item.value = 'round_robin';
+        }
         newInputs[item.key] = item.value;
       });
       setInputs(newInputs);
@@ -183,7 +187,11 @@ const OperationSetting = () => {
   
   const poolOptions = [
     { key: "random", text: '普通随机模式', value: 'random' },
-    { key: "polling", text: '最优轮询模式', value: 'polling' },
+    { key: "weighted", text: '按权重随机模式', value: 'weighted' },
+    { key: "round_robin", text: '轮询模式', value: 'round_robin' },
+    { key: "least_inflight", text: '最少并发模式', value: 'least_inflight' },
+    { key: "lowest_latency", text: '最低延迟模式', value: 'lowest_latency' },
+    { key: "lowest_cost", text: '最低成本模式', value: 'lowest_cost' },
   ]
 
   return (
@@ -232,7 +232,7 @@ const EditToken = () => {
             <Form.Input
               label='TPM'
               name='tpm_limit'
-              placeholder={'请输入TPM限制'}
+              placeholder={'请输入TPM限制，只统计文本请求的 token'}
               onChange={handleInputChange}
               value={tpm_limit}
               type='number'